@@ -16,11 +16,15 @@ import (
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"syscall"
 	"time"
 
 	"github.com/Ilshidur/bluepicast/internal/audio"
 	"github.com/Ilshidur/bluepicast/internal/bluetooth"
+	"github.com/Ilshidur/bluepicast/internal/devicemeta"
+	"github.com/Ilshidur/bluepicast/internal/runner"
+	"github.com/Ilshidur/bluepicast/internal/schedule"
 	"github.com/Ilshidur/bluepicast/internal/snapcast"
 	"github.com/Ilshidur/bluepicast/internal/web"
 )
@@ -29,6 +33,15 @@ func main() {
 	port := flag.Int("port", 80, "HTTP server port")
 	enableSnapclient := flag.Bool("enable-systemd-snapclient", false, "Enable Snapclient integration for managing Snapcast client")
 	enableHTTPS := flag.Bool("https", false, "Enable HTTPS with a self-signed certificate")
+	dataDir := flag.String("data-dir", "/var/lib/bluepicast", "Directory for persistent BluePiCast data")
+	deviceTTL := flag.Duration("device-ttl", 10*time.Minute, "Forget unpaired devices not seen for this long (0 to keep them)")
+	snapclientSupervisor := flag.String("snapclient-supervisor", snapcast.SupervisorSystemd, "How to run snapclient: \"systemd\" user units, or \"process\" to run it directly (containers, systems without systemd)")
+	recordCommands := flag.String("record-commands", "", "Save the commands run for Snapclient and audio (except the level meter) and their outputs to this JSON file on exit, to capture test fixtures")
+	preferredSoundcard := flag.String("preferred-soundcard", "", "Soundcard ID (as in /proc/asound/cards) to switch the default Snapclient instance to when it is plugged in")
+	levelMeterPCM := flag.String("level-meter-pcm", "", "ALSA capture PCM tapping the output, e.g. the capture side of snd-aloop (\"hw:Loopback,1\"), to show levels and detect silence")
+	silenceThreshold := flag.Float64("silence-threshold", -60, "Peak level in dBFS below which the output is silent")
+	silenceAlertAfter := flag.Duration("silence-alert-after", 30*time.Second, "Alert when the output stays silent this long while Snapclient is playing")
+	snapserverHost := flag.String("snapserver", "", "Snapserver host for speaker media controls (defaults to the server configured for Snapclient)")
 	flag.Parse()
 
 	log.Println("BluePiCast")
@@ -40,22 +53,55 @@ func main() {
 		log.Fatalf("Failed to initialize Bluetooth adapter: %v", err)
 	}
 	defer adapter.Close()
+	adapter.SetStaleAfter(*deviceTTL)
 
 	log.Println("Bluetooth adapter initialized successfully")
 
 	// Initialize audio manager for ALSA routing
 	audioManager := audio.NewManager()
+	if err := audioManager.LoadFallbackChain(filepath.Join(*dataDir, "fallback.json")); err != nil {
+		log.Printf("Warning: Failed to load fallback outputs: %v", err)
+	}
+
+	// Load per-device metadata (room, preferred volume, auto-connect...)
+	metaStore := devicemeta.NewStore(filepath.Join(*dataDir, "devices.json"))
+	if err := metaStore.Load(); err != nil {
+		log.Printf("Warning: Failed to load device metadata: %v", err)
+	}
 
 	// Initialize Snapclient manager if enabled
 	snapclientManager := snapcast.NewManager(*enableSnapclient)
+	if err := snapclientManager.SetSupervisor(*snapclientSupervisor); err != nil {
+		log.Fatalf("Invalid --snapclient-supervisor: %v", err)
+	}
+	defer snapclientManager.Close()
+	if *recordCommands != "" {
+		recorder := runner.NewRecorder(runner.Exec{})
+		snapclientManager.SetRunner(recorder)
+		audioManager.SetRunner(recorder)
+		defer func() {
+			if err := recorder.Save(*recordCommands); err != nil {
+				log.Printf("Warning: Failed to save recorded commands: %v", err)
+			}
+		}()
+	}
+	snapclientManager.SetDataDir(*dataDir)
+	snapclientManager.SetPreferredSoundcard(*preferredSoundcard)
 	if *enableSnapclient {
-		log.Println("Snapclient integration enabled")
+		log.Printf("Snapclient integration enabled (%s)", *snapclientSupervisor)
+		snapclientManager.AutoStart()
 	}
 
 	// Create context with cancellation
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Push Snapclient status changes to the UI
+	go snapclientManager.Watch(ctx)
+
+	// Restart or switch Snapclient when soundcards are plugged in
+	go snapclientManager.WatchSoundcards(ctx)
+
 	// Handle shutdown signals
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
@@ -78,7 +124,42 @@ func main() {
 	}
 
 	// Start web server
-	server := web.NewServer(adapter, audioManager, snapclientManager, *port, tlsConfig)
+	server := web.NewServer(adapter, audioManager, snapclientManager, metaStore, *port, tlsConfig)
+
+	// Map speaker buttons (AVRCP) to Snapserver controls
+	controlHost := *snapserverHost
+	if controlHost == "" && *enableSnapclient {
+		if config, err := snapclientManager.GetConfig(snapcast.DefaultInstance); err == nil {
+			controlHost = config.Host
+		}
+	}
+	if controlHost != "" {
+		control := snapcast.NewControlClient(snapcast.ControlAddress(controlHost))
+		server.SetSnapserverControl(control)
+		go control.Run(ctx)
+
+		if err := adapter.RegisterMediaPlayer(); err != nil {
+			log.Printf("Warning: Failed to register media player, speaker buttons will not work: %v", err)
+		}
+	} else {
+		log.Println("No Snapserver configured, speaker media controls disabled")
+	}
+
+	// Run scheduled actions
+	scheduler := schedule.NewScheduler(filepath.Join(*dataDir, "schedules.json"))
+	if err := scheduler.Load(); err != nil {
+		log.Printf("Warning: Failed to load schedules: %v", err)
+	}
+	server.SetScheduler(scheduler)
+	go scheduler.Run(ctx)
+
+	// Measure output levels and watch for silence
+	if *levelMeterPCM != "" {
+		meter := audio.NewMeter(*levelMeterPCM, *silenceThreshold, *silenceAlertAfter, server.OutputPlaying)
+		server.SetLevelMeter(meter)
+		go meter.Run(ctx)
+	}
+
 	if err := server.Start(ctx); err != nil {
 		if err != context.Canceled && err.Error() != "http: Server closed" {
 			log.Fatalf("Server error: %v", err)
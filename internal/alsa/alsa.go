@@ -0,0 +1,221 @@
+// Package alsa reads the soundcards of the system from /proc/asound and
+// sysfs, and their mixer elements through the control device ioctls, without
+// cgo or the alsa-utils commands. A System can also be loaded from a fixture
+// directory holding the same files, so that tests do not need real hardware.
+package alsa
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Card is a soundcard
+type Card struct {
+	Index    int    `json:"index"`
+	ID       string `json:"id"`       // Name used in "hw:<ID>", e.g. "Headphones"
+	Driver   string `json:"driver"`   // e.g. "USB-Audio"
+	Name     string `json:"name"`     // e.g. "USB Audio"
+	LongName string `json:"longName"` // e.g. "Generic USB Audio at usb-0000:01:00.0-1.3, high speed"
+	PCMs     []PCM  `json:"pcms"`
+}
+
+// PCM is a device of a soundcard
+type PCM struct {
+	Card     int    `json:"card"`
+	Device   int    `json:"device"`
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Playback bool   `json:"playback"`
+	Capture  bool   `json:"capture"`
+}
+
+// System gives access to the soundcards of a machine
+type System struct {
+	root    string                          // Prefix of /proc, /sys and /dev, "/" except for fixtures
+	open    func(card int) (Control, error) // Opens the control device of a card
+	uevents func() (io.ReadCloser, error)   // Listens to kernel uevents, nil to only poll in Watch
+}
+
+// New returns the soundcards of this machine
+func New() *System {
+	s := &System{root: "/", uevents: openUevents}
+	s.open = s.openDevice
+	return s
+}
+
+// Available reports whether the ALSA proc or sysfs files can be read
+func (s *System) Available() bool {
+	for _, dir := range []string{"proc/asound", "sys/class/sound"} {
+		if _, err := os.Stat(s.path(dir)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *System) path(name string) string {
+	return filepath.Join(s.root, name)
+}
+
+var (
+	// cardRegex matches the first line of a card in /proc/asound/cards,
+	// " 2 [Audio          ]: USB-Audio - USB Audio"
+	cardRegex = regexp.MustCompile(`^\s*(\d+) \[(\S+)\s*\]: (\S+) - (.*)$`)
+	// pcmRegex matches a line of /proc/asound/pcm,
+	// "02-00: USB Audio : USB Audio : playback 1 : capture 1"
+	pcmRegex = regexp.MustCompile(`^(\d+)-(\d+): (.*?) : (.*?)((?: : (?:playback|capture) \d+)*)$`)
+)
+
+// parseCards parses /proc/asound/cards
+func parseCards(content string) []Card {
+	var cards []Card
+	for _, line := range strings.Split(content, "\n") {
+		if match := cardRegex.FindStringSubmatch(line); match != nil {
+			index, _ := strconv.Atoi(match[1])
+			cards = append(cards, Card{Index: index, ID: match[2], Driver: match[3], Name: strings.TrimSpace(match[4])})
+			continue
+		}
+		// The long name is on the line following the card
+		if len(cards) > 0 && cards[len(cards)-1].LongName == "" && strings.TrimSpace(line) != "" {
+			cards[len(cards)-1].LongName = strings.TrimSpace(line)
+		}
+	}
+	return cards
+}
+
+// parsePCMs parses /proc/asound/pcm
+func parsePCMs(content string) []PCM {
+	var pcms []PCM
+	for _, line := range strings.Split(content, "\n") {
+		match := pcmRegex.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		card, _ := strconv.Atoi(match[1])
+		device, _ := strconv.Atoi(match[2])
+		pcms = append(pcms, PCM{
+			Card:     card,
+			Device:   device,
+			ID:       match[3],
+			Name:     match[4],
+			Playback: strings.Contains(match[5], "playback"),
+			Capture:  strings.Contains(match[5], "capture"),
+		})
+	}
+	return pcms
+}
+
+// Cards returns the soundcards with their PCM devices, sorted by index
+func (s *System) Cards() ([]Card, error) {
+	var cards []Card
+	if content, err := os.ReadFile(s.path("proc/asound/cards")); err == nil {
+		cards = parseCards(string(content))
+	} else {
+		// Without procfs, sysfs still has the card IDs
+		sysCards, sysErr := s.sysfsCards()
+		if sysErr != nil {
+			return nil, fmt.Errorf("failed to read soundcards: %w", err)
+		}
+		cards = sysCards
+	}
+
+	var pcms []PCM
+	if content, err := os.ReadFile(s.path("proc/asound/pcm")); err == nil {
+		pcms = parsePCMs(string(content))
+	} else {
+		pcms = s.sysfsPCMs()
+	}
+	for i := range cards {
+		for _, pcm := range pcms {
+			if pcm.Card == cards[i].Index {
+				cards[i].PCMs = append(cards[i].PCMs, pcm)
+			}
+		}
+	}
+
+	sort.Slice(cards, func(i, j int) bool { return cards[i].Index < cards[j].Index })
+	return cards, nil
+}
+
+// sysfsCards lists the cards from /sys/class/sound/card<N>
+func (s *System) sysfsCards() ([]Card, error) {
+	paths, err := filepath.Glob(s.path("sys/class/sound/card*"))
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, os.ErrNotExist
+	}
+
+	var cards []Card
+	for _, path := range paths {
+		index, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(path), "card"))
+		if err != nil {
+			continue
+		}
+		card := Card{Index: index, ID: readSysfs(filepath.Join(path, "id"))}
+		card.Name = card.ID
+		cards = append(cards, card)
+	}
+	return cards, nil
+}
+
+// pcmDeviceRegex matches the PCM device nodes in sysfs, "pcmC2D0p"
+var pcmDeviceRegex = regexp.MustCompile(`^pcmC(\d+)D(\d+)([pc])$`)
+
+// sysfsPCMs lists the PCMs from /sys/class/sound/pcmC<N>D<M><p|c>
+func (s *System) sysfsPCMs() []PCM {
+	entries, _ := os.ReadDir(s.path("sys/class/sound"))
+	var pcms []PCM
+	for _, entry := range entries {
+		match := pcmDeviceRegex.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		card, _ := strconv.Atoi(match[1])
+		device, _ := strconv.Atoi(match[2])
+
+		i := 0
+		for i < len(pcms) && (pcms[i].Card != card || pcms[i].Device != device) {
+			i++
+		}
+		if i == len(pcms) {
+			name := readSysfs(filepath.Join(s.path("sys/class/sound"), entry.Name(), "pcm_class"))
+			pcms = append(pcms, PCM{Card: card, Device: device, ID: name, Name: name})
+		}
+		if match[3] == "p" {
+			pcms[i].Playback = true
+		} else {
+			pcms[i].Capture = true
+		}
+	}
+	return pcms
+}
+
+func readSysfs(path string) string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// FindCard returns the card with the given ID or index, as in "hw:<card>"
+func (s *System) FindCard(name string) (Card, error) {
+	cards, err := s.Cards()
+	if err != nil {
+		return Card{}, err
+	}
+	for _, card := range cards {
+		if card.ID == name || strconv.Itoa(card.Index) == name {
+			return card, nil
+		}
+	}
+	return Card{}, fmt.Errorf("soundcard %q not found", name)
+}
@@ -0,0 +1,73 @@
+package alsa
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCardsFixture(t *testing.T) {
+	system := LoadFixture("testdata/pi-usb-dac")
+	if !system.Available() {
+		t.Fatal("Available() = false, want true")
+	}
+
+	cards, err := system.Cards()
+	if err != nil {
+		t.Fatalf("Cards failed: %v", err)
+	}
+	if len(cards) != 3 {
+		t.Fatalf("Cards() returned %d cards, want 3: %+v", len(cards), cards)
+	}
+
+	usb := cards[2]
+	if usb.Index != 2 || usb.ID != "Audio" || usb.Driver != "USB-Audio" || usb.Name != "USB Audio" {
+		t.Errorf("card 2 = %+v", usb)
+	}
+	if usb.LongName != "Generic USB Audio at usb-0000:01:00.0-1.3, high speed" {
+		t.Errorf("card 2 long name = %q", usb.LongName)
+	}
+	expected := []PCM{{Card: 2, Device: 0, ID: "USB Audio", Name: "USB Audio", Playback: true, Capture: true}}
+	if !reflect.DeepEqual(usb.PCMs, expected) {
+		t.Errorf("card 2 PCMs = %+v, want %+v", usb.PCMs, expected)
+	}
+	if pcms := cards[0].PCMs; len(pcms) != 1 || !pcms[0].Playback || pcms[0].Capture || pcms[0].Name != "bcm2835 Headphones" {
+		t.Errorf("card 0 PCMs = %+v", pcms)
+	}
+}
+
+func TestCardsSysfs(t *testing.T) {
+	cards, err := LoadFixture("testdata/sysfs-only").Cards()
+	if err != nil {
+		t.Fatalf("Cards failed: %v", err)
+	}
+	expected := []Card{{
+		Index: 0,
+		ID:    "Device",
+		Name:  "Device",
+		PCMs:  []PCM{{Card: 0, Device: 0, ID: "generic", Name: "generic", Playback: true, Capture: true}},
+	}}
+	if !reflect.DeepEqual(cards, expected) {
+		t.Errorf("Cards() = %+v, want %+v", cards, expected)
+	}
+
+	if LoadFixture(t.TempDir()).Available() {
+		t.Error("Available() = true without proc nor sysfs files")
+	}
+	if _, err := LoadFixture(t.TempDir()).Cards(); err == nil {
+		t.Error("Cards should fail without proc nor sysfs files")
+	}
+}
+
+func TestFindCard(t *testing.T) {
+	system := LoadFixture("testdata/pi-usb-dac")
+
+	for _, name := range []string{"Audio", "2"} {
+		card, err := system.FindCard(name)
+		if err != nil || card.Index != 2 {
+			t.Errorf("FindCard(%q) = %+v, %v, want card 2", name, card, err)
+		}
+	}
+	if _, err := system.FindCard("Device"); err == nil {
+		t.Error("FindCard should fail for a missing card")
+	}
+}
@@ -0,0 +1,330 @@
+package alsa
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// ElemType is the type of the values of a control element
+type ElemType uint32
+
+// Element types, SNDRV_CTL_ELEM_TYPE_* in <sound/asound.h>
+const (
+	ElemTypeBoolean    ElemType = 1
+	ElemTypeInteger    ElemType = 2
+	ElemTypeEnumerated ElemType = 3
+	ElemTypeBytes      ElemType = 4
+	ElemTypeIEC958     ElemType = 5
+	ElemTypeInteger64  ElemType = 6
+)
+
+// Element interfaces and access flags, SNDRV_CTL_ELEM_IFACE_* and SNDRV_CTL_ELEM_ACCESS_*
+const (
+	IfaceMixer = 2
+
+	AccessRead    = 1 << 0
+	AccessWrite   = 1 << 1
+	AccessTLVRead = 1 << 4
+)
+
+// ElemID identifies a control element, struct snd_ctl_elem_id
+type ElemID struct {
+	NumID     uint32
+	Iface     uint32
+	Device    uint32
+	Subdevice uint32
+	Name      string // e.g. "PCM Playback Volume"
+	Index     uint32
+}
+
+// ElemInfo describes a control element, struct snd_ctl_elem_info
+type ElemInfo struct {
+	ID     ElemID
+	Type   ElemType
+	Access uint32
+	Count  int   // Number of values, one per channel for volumes and switches
+	Min    int64 // Range of integer elements
+	Max    int64
+	Step   int64
+}
+
+// Control is the control device of a card, /dev/snd/controlC<N>
+type Control interface {
+	// Elements lists the elements of the card
+	Elements() ([]ElemID, error)
+	// Info describes an element
+	Info(id ElemID) (ElemInfo, error)
+	// Read returns the values of a boolean, integer or enumerated element
+	Read(info ElemInfo) ([]int64, error)
+	// Write sets the values of a boolean, integer or enumerated element
+	Write(info ElemInfo, values []int64) error
+	// TLV returns the metadata of an element, such as its dB scale
+	TLV(id ElemID) ([]uint32, error)
+	Close() error
+}
+
+// Layout of the structures of <sound/asound.h>. The ones holding a long or a
+// pointer depend on the word size; the offsets are those of arm, arm64 and amd64.
+const (
+	elemIDSize   = 64
+	elemNameSize = 44
+	elemInfoSize = 272
+	valuesOffset = 72 // Offset of the values in struct snd_ctl_elem_value
+	tlvBufSize   = 4096
+)
+
+var (
+	longSize      = strconv.IntSize / 8
+	elemListSize  = align(16+int(unsafe.Sizeof(uintptr(0)))+50, int(unsafe.Sizeof(uintptr(0))))
+	elemValueSize = valuesOffset + max(512, 128*longSize) + 128
+)
+
+func align(n, to int) int {
+	return (n + to - 1) / to * to
+}
+
+// ioctl requests, SNDRV_CTL_IOCTL_*
+var (
+	ioctlElemList  = iowr(0x10, elemListSize)
+	ioctlElemInfo  = iowr(0x11, elemInfoSize)
+	ioctlElemRead  = iowr(0x12, elemValueSize)
+	ioctlElemWrite = iowr(0x13, elemValueSize)
+	ioctlTLVRead   = iowr(0x1a, 8)
+)
+
+// iowr returns the _IOWR('U', nr, size) request number
+func iowr(nr, size int) uintptr {
+	return uintptr(3<<30 | size<<16 | 'U'<<8 | nr)
+}
+
+// device is a control device opened from /dev/snd
+type device struct {
+	file *os.File
+}
+
+// Control opens the control device of a card. With LoadFixture, it is the
+// *FakeControl shared by all the openings of the card.
+func (s *System) Control(card int) (Control, error) {
+	return s.open(card)
+}
+
+func (s *System) openDevice(card int) (Control, error) {
+	file, err := os.OpenFile(s.path(fmt.Sprintf("dev/snd/controlC%d", card)), os.O_RDWR, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open control device: %w", err)
+	}
+	return &device{file: file}, nil
+}
+
+func (d *device) Close() error {
+	return d.file.Close()
+}
+
+func (d *device) ioctl(request uintptr, buf []byte) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, d.file.Fd(), request, uintptr(unsafe.Pointer(&buf[0])))
+	runtime.KeepAlive(buf)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func (d *device) Elements() ([]ElemID, error) {
+	// A first call with no space returns the number of elements
+	list := make([]byte, elemListSize)
+	if err := d.ioctl(ioctlElemList, list); err != nil {
+		return nil, fmt.Errorf("failed to list control elements: %w", err)
+	}
+	count := binary.NativeEndian.Uint32(list[12:])
+	if count == 0 {
+		return nil, nil
+	}
+
+	ids := make([]byte, int(count)*elemIDSize)
+	binary.NativeEndian.PutUint32(list[4:], count) // space
+	putPointer(list[16:], uintptr(unsafe.Pointer(&ids[0])))
+	err := d.ioctl(ioctlElemList, list)
+	runtime.KeepAlive(ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list control elements: %w", err)
+	}
+
+	used := int(binary.NativeEndian.Uint32(list[8:]))
+	elements := make([]ElemID, used)
+	for i := range elements {
+		elements[i] = decodeElemID(ids[i*elemIDSize:])
+	}
+	return elements, nil
+}
+
+func (d *device) Info(id ElemID) (ElemInfo, error) {
+	buf := make([]byte, elemInfoSize)
+	encodeElemID(buf, id)
+	if err := d.ioctl(ioctlElemInfo, buf); err != nil {
+		return ElemInfo{}, fmt.Errorf("failed to get info of %s: %w", id.Name, err)
+	}
+	return decodeElemInfo(buf), nil
+}
+
+func (d *device) Read(info ElemInfo) ([]int64, error) {
+	buf := make([]byte, elemValueSize)
+	encodeElemID(buf, info.ID)
+	if err := d.ioctl(ioctlElemRead, buf); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", info.ID.Name, err)
+	}
+	return decodeValues(buf, info)
+}
+
+func (d *device) Write(info ElemInfo, values []int64) error {
+	buf := make([]byte, elemValueSize)
+	encodeElemID(buf, info.ID)
+	if err := encodeValues(buf, info, values); err != nil {
+		return err
+	}
+	if err := d.ioctl(ioctlElemWrite, buf); err != nil {
+		return fmt.Errorf("failed to write %s: %w", info.ID.Name, err)
+	}
+	return nil
+}
+
+func (d *device) TLV(id ElemID) ([]uint32, error) {
+	buf := make([]byte, 8+tlvBufSize)
+	binary.NativeEndian.PutUint32(buf[0:], id.NumID)
+	binary.NativeEndian.PutUint32(buf[4:], tlvBufSize)
+	if err := d.ioctl(ioctlTLVRead, buf); err != nil {
+		return nil, fmt.Errorf("failed to read TLV of %s: %w", id.Name, err)
+	}
+	// The first TLV is its type and length in bytes, followed by its data
+	length := 2 + int(binary.NativeEndian.Uint32(buf[12:]))/4
+	if length > tlvBufSize/4 {
+		length = tlvBufSize / 4
+	}
+	tlv := make([]uint32, length)
+	for i := range tlv {
+		tlv[i] = binary.NativeEndian.Uint32(buf[8+4*i:])
+	}
+	return tlv, nil
+}
+
+func putPointer(buf []byte, p uintptr) {
+	if unsafe.Sizeof(p) == 8 {
+		binary.NativeEndian.PutUint64(buf, uint64(p))
+	} else {
+		binary.NativeEndian.PutUint32(buf, uint32(p))
+	}
+}
+
+func getLong(buf []byte) int64 {
+	if longSize == 8 {
+		return int64(binary.NativeEndian.Uint64(buf))
+	}
+	return int64(int32(binary.NativeEndian.Uint32(buf)))
+}
+
+func putLong(buf []byte, v int64) {
+	if longSize == 8 {
+		binary.NativeEndian.PutUint64(buf, uint64(v))
+	} else {
+		binary.NativeEndian.PutUint32(buf, uint32(int32(v)))
+	}
+}
+
+// encodeElemID writes a struct snd_ctl_elem_id at the start of buf
+func encodeElemID(buf []byte, id ElemID) {
+	binary.NativeEndian.PutUint32(buf[0:], id.NumID)
+	binary.NativeEndian.PutUint32(buf[4:], id.Iface)
+	binary.NativeEndian.PutUint32(buf[8:], id.Device)
+	binary.NativeEndian.PutUint32(buf[12:], id.Subdevice)
+	name := buf[16 : 16+elemNameSize]
+	for i := range name {
+		name[i] = 0
+	}
+	copy(name[:elemNameSize-1], id.Name)
+	binary.NativeEndian.PutUint32(buf[60:], id.Index)
+}
+
+// decodeElemID reads a struct snd_ctl_elem_id at the start of buf
+func decodeElemID(buf []byte) ElemID {
+	name := buf[16 : 16+elemNameSize]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+	return ElemID{
+		NumID:     binary.NativeEndian.Uint32(buf[0:]),
+		Iface:     binary.NativeEndian.Uint32(buf[4:]),
+		Device:    binary.NativeEndian.Uint32(buf[8:]),
+		Subdevice: binary.NativeEndian.Uint32(buf[12:]),
+		Name:      string(name),
+		Index:     binary.NativeEndian.Uint32(buf[60:]),
+	}
+}
+
+// decodeElemInfo reads a struct snd_ctl_elem_info
+func decodeElemInfo(buf []byte) ElemInfo {
+	info := ElemInfo{
+		ID:     decodeElemID(buf),
+		Type:   ElemType(binary.NativeEndian.Uint32(buf[64:])),
+		Access: binary.NativeEndian.Uint32(buf[68:]),
+		Count:  int(binary.NativeEndian.Uint32(buf[72:])),
+	}
+	const union = 80
+	switch info.Type {
+	case ElemTypeInteger:
+		info.Min = getLong(buf[union:])
+		info.Max = getLong(buf[union+longSize:])
+		info.Step = getLong(buf[union+2*longSize:])
+	case ElemTypeInteger64:
+		info.Min = int64(binary.NativeEndian.Uint64(buf[union:]))
+		info.Max = int64(binary.NativeEndian.Uint64(buf[union+8:]))
+		info.Step = int64(binary.NativeEndian.Uint64(buf[union+16:]))
+	case ElemTypeBoolean:
+		info.Max = 1
+	case ElemTypeEnumerated:
+		info.Max = int64(binary.NativeEndian.Uint32(buf[union:])) - 1
+	}
+	return info
+}
+
+// decodeValues reads the values of a struct snd_ctl_elem_value
+func decodeValues(buf []byte, info ElemInfo) ([]int64, error) {
+	values := make([]int64, info.Count)
+	for i := range values {
+		switch info.Type {
+		case ElemTypeBoolean, ElemTypeInteger:
+			values[i] = getLong(buf[valuesOffset+i*longSize:])
+		case ElemTypeInteger64:
+			values[i] = int64(binary.NativeEndian.Uint64(buf[valuesOffset+i*8:]))
+		case ElemTypeEnumerated:
+			values[i] = int64(binary.NativeEndian.Uint32(buf[valuesOffset+i*4:]))
+		default:
+			return nil, fmt.Errorf("unsupported type %d of %s", info.Type, info.ID.Name)
+		}
+	}
+	return values, nil
+}
+
+// encodeValues writes the values of a struct snd_ctl_elem_value
+func encodeValues(buf []byte, info ElemInfo, values []int64) error {
+	if len(values) != info.Count {
+		return fmt.Errorf("%s has %d values, got %d", info.ID.Name, info.Count, len(values))
+	}
+	for i, v := range values {
+		switch info.Type {
+		case ElemTypeBoolean, ElemTypeInteger:
+			putLong(buf[valuesOffset+i*longSize:], v)
+		case ElemTypeInteger64:
+			binary.NativeEndian.PutUint64(buf[valuesOffset+i*8:], uint64(v))
+		case ElemTypeEnumerated:
+			binary.NativeEndian.PutUint32(buf[valuesOffset+i*4:], uint32(v))
+		default:
+			return fmt.Errorf("unsupported type %d of %s", info.Type, info.ID.Name)
+		}
+	}
+	return nil
+}
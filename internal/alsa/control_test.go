@@ -0,0 +1,97 @@
+package alsa
+
+import (
+	"encoding/binary"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestElemIDRoundTrip(t *testing.T) {
+	id := ElemID{NumID: 7, Iface: IfaceMixer, Device: 1, Subdevice: 2, Name: "PCM Playback Volume", Index: 3}
+	buf := make([]byte, elemIDSize)
+	encodeElemID(buf, id)
+	if decoded := decodeElemID(buf); decoded != id {
+		t.Errorf("decodeElemID = %+v, want %+v", decoded, id)
+	}
+
+	// Names are truncated to fit with their terminating zero
+	id.Name = "A very long control name that does not fit in the structure"
+	encodeElemID(buf, id)
+	if decoded := decodeElemID(buf); decoded.Name != id.Name[:elemNameSize-1] || decoded.Index != 3 {
+		t.Errorf("decodeElemID = %+v", decoded)
+	}
+}
+
+func TestDecodeElemInfo(t *testing.T) {
+	buf := make([]byte, elemInfoSize)
+	encodeElemID(buf, ElemID{NumID: 4, Iface: IfaceMixer, Name: "PCM Playback Volume"})
+	binary.NativeEndian.PutUint32(buf[64:], uint32(ElemTypeInteger))
+	binary.NativeEndian.PutUint32(buf[68:], AccessRead|AccessWrite|AccessTLVRead)
+	binary.NativeEndian.PutUint32(buf[72:], 2)
+	putLong(buf[80:], -10239)
+	putLong(buf[80+longSize:], 400)
+	putLong(buf[80+2*longSize:], 1)
+
+	info := decodeElemInfo(buf)
+	expected := ElemInfo{
+		ID:     ElemID{NumID: 4, Iface: IfaceMixer, Name: "PCM Playback Volume"},
+		Type:   ElemTypeInteger,
+		Access: AccessRead | AccessWrite | AccessTLVRead,
+		Count:  2,
+		Min:    -10239,
+		Max:    400,
+		Step:   1,
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("decodeElemInfo = %+v, want %+v", info, expected)
+	}
+}
+
+func TestValuesRoundTrip(t *testing.T) {
+	for _, info := range []ElemInfo{
+		{Type: ElemTypeBoolean, Count: 2},
+		{Type: ElemTypeInteger, Count: 2},
+		{Type: ElemTypeInteger64, Count: 2},
+		{Type: ElemTypeEnumerated, Count: 2},
+	} {
+		buf := make([]byte, elemValueSize)
+		values := []int64{1, 0}
+		if info.Type == ElemTypeInteger || info.Type == ElemTypeInteger64 {
+			values = []int64{-2000, 28}
+		}
+		if err := encodeValues(buf, info, values); err != nil {
+			t.Fatalf("encodeValues(type %d) failed: %v", info.Type, err)
+		}
+		decoded, err := decodeValues(buf, info)
+		if err != nil || !reflect.DeepEqual(decoded, values) {
+			t.Errorf("decodeValues(type %d) = %v, %v, want %v", info.Type, decoded, err, values)
+		}
+	}
+
+	if err := encodeValues(make([]byte, elemValueSize), ElemInfo{Type: ElemTypeInteger, Count: 2}, []int64{1}); err == nil {
+		t.Error("encodeValues should fail with the wrong number of values")
+	}
+	if _, err := decodeValues(make([]byte, elemValueSize), ElemInfo{Type: ElemTypeBytes, Count: 1}); err == nil {
+		t.Error("decodeValues should fail for bytes elements")
+	}
+}
+
+func TestIoctlRequests(t *testing.T) {
+	if strconv.IntSize != 64 {
+		t.Skip("request numbers checked against the 64-bit kernel headers")
+	}
+	// Values of SNDRV_CTL_IOCTL_* on arm64 and amd64
+	requests := map[string][2]uintptr{
+		"ELEM_LIST":  {ioctlElemList, 0xc0505510},
+		"ELEM_INFO":  {ioctlElemInfo, 0xc1105511},
+		"ELEM_READ":  {ioctlElemRead, 0xc4c85512},
+		"ELEM_WRITE": {ioctlElemWrite, 0xc4c85513},
+		"TLV_READ":   {ioctlTLVRead, 0xc008551a},
+	}
+	for name, request := range requests {
+		if request[0] != request[1] {
+			t.Errorf("SNDRV_CTL_IOCTL_%s = %#x, want %#x", name, request[0], request[1])
+		}
+	}
+}
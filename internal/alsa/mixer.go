@@ -0,0 +1,303 @@
+package alsa
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// TLV types, SNDRV_CTL_TLVT_* in <sound/tlv.h>
+const (
+	tlvContainer   = 0
+	tlvDBScale     = 1
+	tlvDBLinear    = 2
+	tlvDBRange     = 3
+	tlvDBMinMax    = 4
+	tlvDBMinMaxMut = 5
+
+	tlvDBGainMute = -9999999 // SNDRV_CTL_TLVD_DB_GAIN_MUTE, in 0.01dB
+)
+
+// Element is a simple mixer element, grouping the playback volume and switch
+// controls sharing a name as alsa-lib does: "PCM Playback Volume" and "PCM
+// Playback Switch" make the "PCM" element.
+type Element struct {
+	Name      string
+	Index     int
+	HasVolume bool
+	HasSwitch bool
+	Volume    int  // Percentage of the first channel
+	Muted     bool // The switch of the first channel is off
+	DB        float64
+	HasDB     bool
+	MinDB     float64 // Range of the volume, if HasDB
+	MaxDB     float64
+
+	volume *ElemInfo
+	sw     *ElemInfo
+}
+
+// Playback control suffixes, in the order they are checked
+var (
+	volumeSuffixes = []string{" Playback Volume", " Volume"}
+	switchSuffixes = []string{" Playback Switch", " Switch"}
+)
+
+// simpleName returns the element name of a control, and whether it is a volume
+// or a switch. Capture controls are not playback elements.
+func simpleName(name string) (string, bool, bool) {
+	if strings.Contains(name, "Capture") {
+		return "", false, false
+	}
+	for _, suffix := range volumeSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix), true, false
+		}
+	}
+	for _, suffix := range switchSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return strings.TrimSuffix(name, suffix), false, true
+		}
+	}
+	return "", false, false
+}
+
+// Mixer returns the playback elements of a card, in the order of their controls
+func (s *System) Mixer(card int) ([]Element, error) {
+	control, err := s.open(card)
+	if err != nil {
+		return nil, err
+	}
+	defer control.Close()
+	return mixerElements(control)
+}
+
+func mixerElements(control Control) ([]Element, error) {
+	ids, err := control.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	var elements []Element
+	find := func(name string, index uint32) *Element {
+		for i := range elements {
+			if elements[i].Name == name && elements[i].Index == int(index) {
+				return &elements[i]
+			}
+		}
+		elements = append(elements, Element{Name: name, Index: int(index)})
+		return &elements[len(elements)-1]
+	}
+
+	for _, id := range ids {
+		if id.Iface != IfaceMixer {
+			continue
+		}
+		name, isVolume, isSwitch := simpleName(id.Name)
+		if !isVolume && !isSwitch {
+			continue
+		}
+		info, err := control.Info(id)
+		if err != nil {
+			return nil, err
+		}
+		if info.Access&AccessRead == 0 || info.Count == 0 {
+			continue
+		}
+		values, err := control.Read(info)
+		if err != nil {
+			return nil, err
+		}
+
+		element := find(name, id.Index)
+		switch {
+		case isVolume && info.Type == ElemTypeInteger && element.volume == nil:
+			element.HasVolume = true
+			element.volume = &info
+			element.Volume = percent(values[0], info.Min, info.Max)
+			if info.Access&AccessTLVRead != 0 {
+				if tlv, err := control.TLV(id); err == nil {
+					element.MinDB, element.HasDB = tlvToDB(tlv, info.Min, info.Max, info.Min)
+					if element.MinDB <= float64(tlvDBGainMute)/100 && info.Max > info.Min {
+						// The lowest step mutes, the range starts above it
+						element.MinDB, _ = tlvToDB(tlv, info.Min, info.Max, info.Min+1)
+					}
+					element.MaxDB, _ = tlvToDB(tlv, info.Min, info.Max, info.Max)
+					element.DB, _ = tlvToDB(tlv, info.Min, info.Max, values[0])
+				}
+			}
+		case isSwitch && info.Type == ElemTypeBoolean && element.sw == nil:
+			element.HasSwitch = true
+			element.sw = &info
+			element.Muted = values[0] == 0
+		}
+	}
+
+	playback := elements[:0]
+	for _, element := range elements {
+		if element.HasVolume || element.HasSwitch {
+			playback = append(playback, element)
+		}
+	}
+	return playback, nil
+}
+
+// percent converts a raw volume to a percentage, rounded as amixer does
+func percent(value, min, max int64) int {
+	if max <= min {
+		return 0
+	}
+	return int(math.Round(float64(value-min) * 100 / float64(max-min)))
+}
+
+// rawVolume converts a percentage to a raw volume, rounded up as amixer does
+func rawVolume(percent int, min, max int64) int64 {
+	return min + int64(math.Ceil(float64(percent)*float64(max-min)*0.01))
+}
+
+// findElement returns the playback element with the given name and index
+func findElement(control Control, name string, index int) (Element, error) {
+	elements, err := mixerElements(control)
+	if err != nil {
+		return Element{}, err
+	}
+	for _, element := range elements {
+		if element.Name == name && element.Index == index {
+			return element, nil
+		}
+	}
+	return Element{}, fmt.Errorf("mixer element %q not found", name)
+}
+
+// SetVolume sets all the channels of a playback element to a percentage
+func (s *System) SetVolume(card int, name string, index int, volume int) error {
+	if volume < 0 || volume > 100 {
+		return fmt.Errorf("volume must be between 0 and 100, got %d", volume)
+	}
+	control, err := s.open(card)
+	if err != nil {
+		return err
+	}
+	defer control.Close()
+
+	element, err := findElement(control, name, index)
+	if err != nil {
+		return err
+	}
+	if !element.HasVolume {
+		return fmt.Errorf("mixer element %s has no playback volume", name)
+	}
+
+	info := *element.volume
+	values := make([]int64, info.Count)
+	for i := range values {
+		values[i] = rawVolume(volume, info.Min, info.Max)
+	}
+	return control.Write(info, values)
+}
+
+// SetMute turns off or on all the channels of the switch of a playback element
+func (s *System) SetMute(card int, name string, index int, muted bool) error {
+	control, err := s.open(card)
+	if err != nil {
+		return err
+	}
+	defer control.Close()
+
+	element, err := findElement(control, name, index)
+	if err != nil {
+		return err
+	}
+	if !element.HasSwitch {
+		return fmt.Errorf("mixer element %s has no playback switch", name)
+	}
+
+	info := *element.sw
+	values := make([]int64, info.Count)
+	if !muted {
+		for i := range values {
+			values[i] = 1
+		}
+	}
+	return control.Write(info, values)
+}
+
+// tlvToDB converts a raw volume to dB with the TLV of its element, as
+// snd_tlv_convert_to_dB does
+func tlvToDB(tlv []uint32, min, max, value int64) (float64, bool) {
+	if len(tlv) < 2 {
+		return 0, false
+	}
+	kind, data := tlv[0], tlv[2:]
+	if length := int(tlv[1]) / 4; length < len(data) {
+		data = data[:length]
+	}
+
+	switch kind {
+	case tlvContainer:
+		for len(data) >= 2 {
+			size := 2 + (int(data[1])+3)/4
+			if size > len(data) {
+				break
+			}
+			if db, ok := tlvToDB(data[:size], min, max, value); ok {
+				return db, true
+			}
+			data = data[size:]
+		}
+	case tlvDBScale:
+		if len(data) < 2 {
+			break
+		}
+		minDB := int32(data[0])
+		step := int32(data[1] & 0xffff)
+		mute := data[1]&0x10000 != 0
+		if mute && value <= min {
+			return float64(tlvDBGainMute) / 100, true
+		}
+		return float64(int64(minDB)+(value-min)*int64(step)) / 100, true
+	case tlvDBMinMax, tlvDBMinMaxMut:
+		if len(data) < 2 {
+			break
+		}
+		minDB, maxDB := float64(int32(data[0])), float64(int32(data[1]))
+		if kind == tlvDBMinMaxMut && value <= min {
+			return float64(tlvDBGainMute) / 100, true
+		}
+		if max <= min {
+			return minDB / 100, true
+		}
+		return (minDB + (maxDB-minDB)*float64(value-min)/float64(max-min)) / 100, true
+	case tlvDBLinear:
+		if len(data) < 2 {
+			break
+		}
+		minDB, maxDB := float64(int32(data[0])), float64(int32(data[1]))
+		switch {
+		case value <= min || max <= min:
+			return minDB / 100, true
+		case value >= max:
+			return maxDB / 100, true
+		}
+		ratio := float64(value-min) / float64(max-min)
+		if minDB <= tlvDBGainMute {
+			return 20 * math.Log10(ratio*math.Pow(10, maxDB/2000)), true
+		}
+		lmin, lmax := math.Pow(10, minDB/2000), math.Pow(10, maxDB/2000)
+		return 20 * math.Log10((lmax-lmin)*ratio+lmin), true
+	case tlvDBRange:
+		// Each range is its raw bounds followed by the TLV used within them
+		for len(data) >= 4 {
+			rangeMin, rangeMax := int64(int32(data[0])), int64(int32(data[1]))
+			size := 4 + (int(data[3])+3)/4
+			if size > len(data) {
+				break
+			}
+			if value >= rangeMin && value <= rangeMax {
+				return tlvToDB(data[2:size], rangeMin, rangeMax, value)
+			}
+			data = data[size:]
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,130 @@
+package alsa
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestMixerFixture(t *testing.T) {
+	system := LoadFixture("testdata/pi-usb-dac")
+
+	elements, err := system.Mixer(2)
+	if err != nil {
+		t.Fatalf("Mixer failed: %v", err)
+	}
+	// Capture and non-mixer controls are not playback elements
+	if len(elements) != 1 {
+		t.Fatalf("Mixer(2) returned %d elements, want 1: %+v", len(elements), elements)
+	}
+	pcm := elements[0]
+	if pcm.Name != "PCM" || !pcm.HasVolume || !pcm.HasSwitch || pcm.Volume != 76 || pcm.Muted {
+		t.Errorf("PCM element = %+v", pcm)
+	}
+	if !pcm.HasDB || pcm.DB != -9 || pcm.MinDB != -37 || pcm.MaxDB != 0 {
+		t.Errorf("PCM dB = %v (%v to %v), want -9 (-37 to 0)", pcm.DB, pcm.MinDB, pcm.MaxDB)
+	}
+
+	elements, err = system.Mixer(0)
+	if err != nil {
+		t.Fatalf("Mixer failed: %v", err)
+	}
+	if len(elements) != 1 || elements[0].Volume != 77 || elements[0].DB != -20 {
+		t.Fatalf("Mixer(0) = %+v", elements)
+	}
+	// The lowest step of the Headphones scale mutes
+	if elements[0].MinDB != -102.38 || elements[0].MaxDB != 4 {
+		t.Errorf("Headphones range = %v to %v, want -102.38 to 4", elements[0].MinDB, elements[0].MaxDB)
+	}
+
+	if _, err := system.Mixer(1); err == nil {
+		t.Error("Mixer should fail for a card without control fixture")
+	}
+}
+
+func TestSetVolumeFixture(t *testing.T) {
+	system := LoadFixture("testdata/pi-usb-dac")
+
+	if err := system.SetVolume(2, "PCM", 0, 40); err != nil {
+		t.Fatalf("SetVolume failed: %v", err)
+	}
+	control, _ := system.Control(2)
+	if values := control.(*FakeControl).Values("PCM Playback Volume", 0); !reflect.DeepEqual(values, []int64{15, 15}) {
+		t.Errorf("PCM Playback Volume = %v, want [15 15]", values)
+	}
+	if elements, _ := system.Mixer(2); elements[0].Volume != 41 {
+		t.Errorf("volume after SetVolume(40) = %d%%, want 41%% as amixer", elements[0].Volume)
+	}
+
+	if err := system.SetMute(2, "PCM", 0, true); err != nil {
+		t.Fatalf("SetMute failed: %v", err)
+	}
+	if elements, _ := system.Mixer(2); !elements[0].Muted {
+		t.Error("PCM should be muted")
+	}
+	if values := control.(*FakeControl).Values("PCM Playback Switch", 0); !reflect.DeepEqual(values, []int64{0, 0}) {
+		t.Errorf("PCM Playback Switch = %v, want [0 0]", values)
+	}
+
+	if err := system.SetVolume(2, "Mic", 0, 40); err == nil {
+		t.Error("SetVolume should fail for a capture control")
+	}
+	if err := system.SetVolume(2, "PCM", 0, 101); err == nil {
+		t.Error("SetVolume should refuse volumes over 100%")
+	}
+}
+
+func TestSimpleName(t *testing.T) {
+	tests := []struct {
+		control  string
+		name     string
+		isVolume bool
+		isSwitch bool
+	}{
+		{"PCM Playback Volume", "PCM", true, false},
+		{"Speaker Playback Switch", "Speaker", false, true},
+		{"Master Volume", "Master", true, false},
+		{"Mic Capture Volume", "", false, false},
+		{"PCM Playback Route", "", false, false},
+	}
+	for _, tt := range tests {
+		name, isVolume, isSwitch := simpleName(tt.control)
+		if name != tt.name || isVolume != tt.isVolume || isSwitch != tt.isSwitch {
+			t.Errorf("simpleName(%q) = %q, %v, %v", tt.control, name, isVolume, isSwitch)
+		}
+	}
+}
+
+func TestTLVToDB(t *testing.T) {
+	minus := func(v int32) uint32 { return uint32(v) }
+
+	tests := []struct {
+		name  string
+		tlv   []uint32
+		min   int64
+		max   int64
+		value int64
+		db    float64
+		ok    bool
+	}{
+		{"scale", []uint32{tlvDBScale, 8, minus(-2300), 150}, 0, 35, 12, -5, true},
+		{"scale muted", []uint32{tlvDBScale, 8, minus(-2300), 0x10000 | 150}, 0, 35, 0, -99999.99, true},
+		{"minmax", []uint32{tlvDBMinMax, 8, minus(-3700), 0}, 0, 37, 28, -9, true},
+		{"linear", []uint32{tlvDBLinear, 8, minus(-4000), 0}, 0, 100, 10, -19.2515, true},
+		{"linear max", []uint32{tlvDBLinear, 8, minus(-4000), 0}, 0, 100, 100, 0, true},
+		{"range", []uint32{tlvDBRange, 48,
+			0, 10, tlvDBScale, 8, minus(-6000), 200,
+			11, 20, tlvDBScale, 8, minus(-3800), 100}, 0, 20, 15, -34, true},
+		{"container", []uint32{tlvContainer, 16, tlvDBMinMax, 8, minus(-3700), 0}, 0, 37, 37, 0, true},
+		{"unknown", []uint32{42, 4, 0}, 0, 37, 37, 0, false},
+		{"truncated", []uint32{tlvDBScale}, 0, 37, 37, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, ok := tlvToDB(tt.tlv, tt.min, tt.max, tt.value)
+			if ok != tt.ok || math.Abs(db-tt.db) > 0.001 {
+				t.Errorf("tlvToDB = %v, %v, want %v, %v", db, ok, tt.db, tt.ok)
+			}
+		})
+	}
+}
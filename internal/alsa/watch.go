@@ -0,0 +1,128 @@
+package alsa
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ueventSettle is how long to wait after a sound uevent before reading the
+// cards, a card sends several of them while it is set up
+const ueventSettle = 500 * time.Millisecond
+
+// CardEvent reports a soundcard plugged in or removed
+type CardEvent struct {
+	Card  Card `json:"card"`
+	Added bool `json:"added"`
+}
+
+// openUevents listens to the kernel uevents broadcast on netlink
+func openUevents() (io.ReadCloser, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: 1}); err != nil {
+		syscall.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	// The socket is non-blocking, so reads go through the runtime poller and
+	// Close interrupts them
+	return os.NewFile(uintptr(fd), "uevent"), nil
+}
+
+// readUevents signals trigger for each uevent of the sound subsystem, until
+// events is closed
+func readUevents(events io.Reader, trigger chan<- struct{}) {
+	buf := make([]byte, 8192)
+	for {
+		n, err := events.Read(buf)
+		if err != nil {
+			return
+		}
+		// "add@/devices/.../sound/card2\0ACTION=add\0...\0SUBSYSTEM=sound\0..."
+		if bytes.Contains(buf[:n], []byte("\x00SUBSYSTEM=sound\x00")) {
+			select {
+			case trigger <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// diffCards returns the cards of current missing from previous as added, and
+// the cards of previous missing from current as removed. A card is the same
+// if it keeps its index and ID.
+func diffCards(previous, current []Card) []CardEvent {
+	contains := func(cards []Card, card Card) bool {
+		for _, c := range cards {
+			if c.Index == card.Index && c.ID == card.ID {
+				return true
+			}
+		}
+		return false
+	}
+
+	var events []CardEvent
+	for _, card := range previous {
+		if !contains(current, card) {
+			events = append(events, CardEvent{Card: card})
+		}
+	}
+	for _, card := range current {
+		if !contains(previous, card) {
+			events = append(events, CardEvent{Card: card, Added: true})
+		}
+	}
+	return events
+}
+
+// Watch calls onEvent for each soundcard plugged in or removed, until ctx is
+// done. The cards are read on kernel uevents of the sound subsystem, and
+// every interval in case they cannot be received.
+func (s *System) Watch(ctx context.Context, interval time.Duration, onEvent func(CardEvent)) {
+	cards, err := s.Cards()
+	if err != nil {
+		log.Printf("Warning: Failed to read soundcards: %v", err)
+	}
+
+	trigger := make(chan struct{}, 1)
+	if s.uevents != nil {
+		events, err := s.uevents()
+		if err != nil {
+			log.Printf("Warning: Cannot receive sound uevents, polling soundcards every %v: %v", interval, err)
+		} else {
+			defer events.Close()
+			go readUevents(events, trigger)
+		}
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-trigger:
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(ueventSettle):
+			}
+		}
+
+		current, err := s.Cards()
+		if err != nil {
+			continue
+		}
+		for _, event := range diffCards(cards, current) {
+			onEvent(event)
+		}
+		cards = current
+	}
+}
@@ -0,0 +1,87 @@
+package alsa
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDiffCards(t *testing.T) {
+	headphones := Card{Index: 0, ID: "Headphones"}
+	usb := Card{Index: 1, ID: "Audio"}
+	other := Card{Index: 1, ID: "Device"}
+
+	events := diffCards([]Card{headphones, usb}, []Card{headphones, other})
+	expected := []CardEvent{{Card: usb}, {Card: other, Added: true}}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("diffCards = %+v, want %+v", events, expected)
+	}
+	if events := diffCards([]Card{headphones}, []Card{headphones}); len(events) != 0 {
+		t.Errorf("diffCards of the same cards = %+v, want none", events)
+	}
+}
+
+func TestReadUevents(t *testing.T) {
+	uevents := "add@/devices/platform/usb/sound/card2\x00ACTION=add\x00SUBSYSTEM=sound\x00"
+	trigger := make(chan struct{}, 1)
+	readUevents(strings.NewReader(uevents), trigger)
+	select {
+	case <-trigger:
+	default:
+		t.Error("a sound uevent should trigger a scan")
+	}
+
+	readUevents(strings.NewReader("add@/devices/usb1/1-1\x00ACTION=add\x00SUBSYSTEM=usb\x00"), trigger)
+	select {
+	case <-trigger:
+		t.Error("a usb uevent should not trigger a scan")
+	default:
+	}
+}
+
+func TestWatchFixture(t *testing.T) {
+	dir := t.TempDir()
+	cardsPath := filepath.Join(dir, "proc/asound/cards")
+	if err := os.MkdirAll(filepath.Dir(cardsPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile("testdata/pi-usb-dac/proc/asound/cards")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Start without the USB card, the last one
+	withoutUSB := strings.Join(strings.Split(string(content), "\n")[:4], "\n") + "\n"
+	if err := os.WriteFile(cardsPath, []byte(withoutUSB), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan CardEvent, 4)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go LoadFixture(dir).Watch(ctx, 10*time.Millisecond, func(event CardEvent) { events <- event })
+
+	next := func() CardEvent {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(2 * time.Second):
+			t.Fatal("no soundcard event")
+			return CardEvent{}
+		}
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	os.WriteFile(cardsPath, content, 0644)
+	if event := next(); !event.Added || event.Card.ID != "Audio" || event.Card.LongName == "" {
+		t.Errorf("event = %+v, want Audio added", event)
+	}
+
+	os.WriteFile(cardsPath, []byte(withoutUSB), 0644)
+	if event := next(); event.Added || event.Card.ID != "Audio" {
+		t.Errorf("event = %+v, want Audio removed", event)
+	}
+}
@@ -9,6 +9,9 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+
+	"github.com/Ilshidur/bluepicast/internal/alsa"
+	"github.com/Ilshidur/bluepicast/internal/runner"
 )
 
 // macAddressPattern validates MAC address format (XX:XX:XX:XX:XX:XX)
@@ -39,18 +42,58 @@ ctl.!default {
 }
 `, address)
 
-	// Write to user's .asoundrc file
+	return writeAsoundrc(asoundConfig, "Bluetooth device "+address)
+}
+
+// SetDefaultLEAudioSink sets an LE Audio device as the default audio output.
+// bluez-alsa cannot stream LC3 over BAP, so the PipeWire ALSA plugin is used
+// and the stream is sent to the Bluetooth node PipeWire created for the device.
+func SetDefaultLEAudioSink(address string) error {
+	// Validate MAC address format to prevent injection in the configuration
+	if !macAddressPattern.MatchString(address) {
+		return fmt.Errorf("invalid MAC address format: %s", address)
+	}
+
+	asoundConfig := fmt.Sprintf(`# Bluetooth LE Audio device configuration (auto-generated)
+pcm.!default {
+    type pipewire
+    playback_node "%s"
+}
+
+ctl.!default {
+    type pipewire
+}
+`, PipeWireNodeName(address))
+
+	return writeAsoundrc(asoundConfig, "Bluetooth device "+address)
+}
+
+// PipeWireNodeName returns the name of the PipeWire sink node of a Bluetooth device
+func PipeWireNodeName(address string) string {
+	return "bluez_output." + strings.ReplaceAll(strings.ToUpper(address), ":", "_") + ".1"
+}
+
+// asoundrcPath returns the path of the user's .asoundrc file
+func asoundrcPath() (string, error) {
 	homeDir, err := os.UserHomeDir()
 	if err != nil {
-		return fmt.Errorf("failed to get home directory: %w", err)
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".asoundrc"), nil
+}
+
+// writeAsoundrc writes the ALSA configuration to the user's .asoundrc file
+func writeAsoundrc(asoundConfig, output string) error {
+	path, err := asoundrcPath()
+	if err != nil {
+		return err
 	}
 
-	asoundrcPath := filepath.Join(homeDir, ".asoundrc")
-	if err := os.WriteFile(asoundrcPath, []byte(asoundConfig), 0644); err != nil {
+	if err := os.WriteFile(path, []byte(asoundConfig), 0644); err != nil {
 		return fmt.Errorf("failed to write ALSA configuration: %w", err)
 	}
 
-	log.Printf("ALSA configuration written to %s for Bluetooth device %s", asoundrcPath, address)
+	log.Printf("ALSA configuration written to %s for %s", path, output)
 	return nil
 }
 
@@ -74,12 +117,27 @@ func IsAudioDevice(icon string) bool {
 
 // Manager handles ALSA audio routing configuration
 type Manager struct {
-	mu sync.RWMutex
+	mu        sync.RWMutex
+	chain     []Output     // Fallback chain, in order of preference
+	chainPath string       // File the fallback chain is saved to
+	alsa      *alsa.System // Soundcards of the chain, nil when ALSA is not available
+	reroutes  Reroutes     // Instances moved off bluealsa by the fallback chain
+	runner    runner.Runner
 }
 
 // NewManager creates a new audio manager
 func NewManager() *Manager {
-	return &Manager{}
+	m := &Manager{runner: runner.Exec{}}
+	if system := alsa.New(); system.Available() {
+		m.alsa = system
+	}
+	return m
+}
+
+// SetRunner replaces the runner of the external commands, e.g. with a
+// runner.Recorder to capture their outputs
+func (m *Manager) SetRunner(r runner.Runner) {
+	m.runner = r
 }
 
 // GetCurrentDevice returns the MAC address of the current default Bluetooth device, if any
@@ -87,13 +145,12 @@ func (m *Manager) GetCurrentDevice() (string, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	homeDir, err := os.UserHomeDir()
+	path, err := asoundrcPath()
 	if err != nil {
-		return "", fmt.Errorf("failed to get home directory: %w", err)
+		return "", err
 	}
 
-	asoundrcPath := filepath.Join(homeDir, ".asoundrc")
-	file, err := os.Open(asoundrcPath)
+	file, err := os.Open(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return "", nil // No config file, no default device
@@ -105,12 +162,16 @@ func (m *Manager) GetCurrentDevice() (string, error) {
 	// Parse the .asoundrc file to find the device MAC address
 	scanner := bufio.NewScanner(file)
 	deviceRegex := regexp.MustCompile(`device\s+"([0-9A-Fa-f:]+)"`)
+	nodeRegex := regexp.MustCompile(`playback_node\s+"bluez_output\.([0-9A-Fa-f_]{17})`)
 
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 		if matches := deviceRegex.FindStringSubmatch(line); len(matches) >= 2 {
 			return matches[1], nil
 		}
+		if matches := nodeRegex.FindStringSubmatch(line); len(matches) >= 2 {
+			return strings.ReplaceAll(matches[1], "_", ":"), nil
+		}
 	}
 
 	if err := scanner.Err(); err != nil {
@@ -132,3 +193,16 @@ func (m *Manager) SetDefaultDevice(address string) error {
 	log.Printf("Set Bluetooth device %s as default ALSA output", address)
 	return nil
 }
+
+// SetDefaultLEAudioDevice sets an LE Audio device as the default audio output
+func (m *Manager) SetDefaultLEAudioDevice(address string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := SetDefaultLEAudioSink(address); err != nil {
+		return err
+	}
+
+	log.Printf("Set LE Audio device %s as default ALSA output (via PipeWire)", address)
+	return nil
+}
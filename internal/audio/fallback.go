@@ -0,0 +1,358 @@
+package audio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Ilshidur/bluepicast/internal/alsa"
+	"github.com/Ilshidur/bluepicast/internal/jsonfile"
+)
+
+// Kinds of outputs of the fallback chain
+const (
+	OutputBluetooth = "bluetooth"
+	OutputSoundcard = "soundcard"
+	OutputMute      = "mute"
+)
+
+// Output is a step of the fallback chain
+type Output struct {
+	Type    string `json:"type"`              // OutputBluetooth, OutputSoundcard or OutputMute
+	Address string `json:"address,omitempty"` // MAC address of the Bluetooth device
+	Card    string `json:"card,omitempty"`    // Soundcard ID, as in /proc/asound/cards
+}
+
+// String returns a readable name of the output for logs and messages
+func (o Output) String() string {
+	switch o.Type {
+	case OutputBluetooth:
+		return "Bluetooth device " + o.Address
+	case OutputSoundcard:
+		return "soundcard " + o.Card
+	default:
+		return "mute"
+	}
+}
+
+// validate checks an output before it is saved or written to .asoundrc
+func (o Output) validate() error {
+	switch o.Type {
+	case OutputBluetooth:
+		if !macAddressPattern.MatchString(o.Address) {
+			return fmt.Errorf("invalid MAC address format: %s", o.Address)
+		}
+	case OutputSoundcard:
+		if !cardIDPattern.MatchString(o.Card) {
+			return fmt.Errorf("invalid soundcard ID: %q", o.Card)
+		}
+	case OutputMute:
+	default:
+		return fmt.Errorf("unknown output type %q", o.Type)
+	}
+	return nil
+}
+
+var (
+	// cardIDPattern validates soundcard IDs to prevent injection in the configuration
+	cardIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+	// soundcardOutputRegex finds the card of a soundcard .asoundrc
+	soundcardOutputRegex = regexp.MustCompile(`slave\.pcm\s+"hw:CARD=([A-Za-z0-9_-]+)"`)
+	// muteOutputRegex finds the null PCM of a muted .asoundrc
+	muteOutputRegex = regexp.MustCompile(`(?m)^\s*type null\s*$`)
+)
+
+// SetDefaultSoundcard sets a soundcard as the default audio output
+func SetDefaultSoundcard(card string) error {
+	asoundConfig := fmt.Sprintf(`# Soundcard configuration (auto-generated)
+pcm.!default {
+    type plug
+    slave.pcm "hw:CARD=%s"
+}
+
+ctl.!default {
+    type hw
+    card "%s"
+}
+`, card, card)
+
+	return writeAsoundrc(asoundConfig, "soundcard "+card)
+}
+
+// SetDefaultMute discards the audio played to the default output, so that
+// snapclient keeps running while nothing can play it
+func SetDefaultMute() error {
+	asoundConfig := `# Muted output configuration (auto-generated)
+pcm.!default {
+    type null
+}
+`
+	return writeAsoundrc(asoundConfig, "mute")
+}
+
+// reroutesFile is the file next to the fallback chain keeping the instances
+// moved off bluealsa, see Reroutes
+const reroutesFile = "fallback-moved.json"
+
+// LoadFallbackChain reads the fallback chain saved in path, which is also
+// where changes are saved. A missing file is an empty chain. The instances
+// moved off bluealsa by an earlier run are read from the same directory.
+func (m *Manager) LoadFallbackChain(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.chainPath = path
+	if err := m.reroutes.load(filepath.Join(filepath.Dir(path), reroutesFile)); err != nil {
+		log.Printf("Warning: Failed to load the Snapclient instances moved by the fallback chain: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read fallback chain: %w", err)
+	}
+	var chain []Output
+	if err := json.Unmarshal(data, &chain); err != nil {
+		return fmt.Errorf("failed to parse fallback chain: %w", err)
+	}
+	m.chain = chain
+	return nil
+}
+
+// Reroutes returns the Snapclient instances moved off bluealsa by the fallback chain
+func (m *Manager) Reroutes() *Reroutes {
+	return &m.reroutes
+}
+
+// FallbackChain returns the outputs to play to, in order of preference
+func (m *Manager) FallbackChain() []Output {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]Output(nil), m.chain...)
+}
+
+// SetFallbackChain replaces and saves the fallback chain. An empty chain
+// disables the fallback.
+func (m *Manager) SetFallbackChain(chain []Output) error {
+	for _, output := range chain {
+		if err := output.validate(); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.chainPath != "" {
+		if err := jsonfile.Write(m.chainPath, chain, "fallback chain"); err != nil {
+			return err
+		}
+	}
+	m.chain = append([]Output(nil), chain...)
+	return nil
+}
+
+// SelectOutput returns the first output of the chain that can play: a
+// connected Bluetooth device, a soundcard plugged in, or mute. It returns
+// false when the chain is empty or nothing in it can play.
+func (m *Manager) SelectOutput(connected func(address string) bool) (Output, bool) {
+	for _, output := range m.FallbackChain() {
+		switch output.Type {
+		case OutputBluetooth:
+			if connected(output.Address) {
+				return output, true
+			}
+		case OutputSoundcard:
+			if m.soundcardPresent(output.Card) {
+				return output, true
+			}
+		case OutputMute:
+			return output, true
+		}
+	}
+	return Output{}, false
+}
+
+// soundcardPresent reports whether a soundcard is plugged in
+func (m *Manager) soundcardPresent(card string) bool {
+	if m.alsa == nil {
+		return false
+	}
+	_, err := m.alsa.FindCard(card)
+	return err == nil
+}
+
+// Soundcards returns the soundcards that can be added to the chain
+func (m *Manager) Soundcards() []alsa.Card {
+	if m.alsa == nil {
+		return nil
+	}
+	cards, err := m.alsa.Cards()
+	if err != nil {
+		log.Printf("Warning: Failed to list soundcards: %v", err)
+		return nil
+	}
+	return cards
+}
+
+// CurrentOutput returns the output .asoundrc routes to, false when it was not
+// written by bluepicast
+func (m *Manager) CurrentOutput() (Output, bool) {
+	if address, err := m.GetCurrentDevice(); err == nil && address != "" {
+		return Output{Type: OutputBluetooth, Address: address}, true
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	path, err := asoundrcPath()
+	if err != nil {
+		return Output{}, false
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return Output{}, false
+	}
+	if match := soundcardOutputRegex.FindSubmatch(content); match != nil {
+		return Output{Type: OutputSoundcard, Card: string(match[1])}, true
+	}
+	if muteOutputRegex.Match(content) {
+		return Output{Type: OutputMute}, true
+	}
+	return Output{}, false
+}
+
+// SetDefaultOutput routes the default output to a soundcard or mutes it.
+// Bluetooth devices go through SetDefaultDevice or SetDefaultLEAudioDevice.
+func (m *Manager) SetDefaultOutput(output Output) error {
+	if err := output.validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch output.Type {
+	case OutputSoundcard:
+		return SetDefaultSoundcard(output.Card)
+	case OutputMute:
+		return SetDefaultMute()
+	default:
+		return fmt.Errorf("cannot route to %s without its Bluetooth profile", output)
+	}
+}
+
+// sameOutput reports whether two outputs play to the same place
+func sameOutput(a, b Output) bool {
+	return a.Type == b.Type && strings.EqualFold(a.Address, b.Address) && a.Card == b.Card
+}
+
+// IsCurrentOutput reports whether .asoundrc already routes to an output
+func (m *Manager) IsCurrentOutput(output Output) bool {
+	current, ok := m.CurrentOutput()
+	return ok && sameOutput(current, output)
+}
+
+// Reroutes keeps the soundcards of the Snapclient instances moved off
+// bluealsa, which cannot play to a soundcard, while the fallback chain routes
+// elsewhere. They are restored when their Bluetooth device is the output
+// again. The options files only point to the default output while the
+// fallback lasts, so the moves are saved to survive a restart.
+type Reroutes struct {
+	mu    sync.Mutex
+	moved map[string]string // Soundcard played to before the move, by instance
+	path  string            // File the moves are saved to, empty to not keep them
+}
+
+// load reads the moves saved in path, which is also where changes are saved.
+// A missing file is no move.
+func (r *Reroutes) load(path string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.path = path
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read moved instances: %w", err)
+	}
+	var moved map[string]string
+	if err := json.Unmarshal(data, &moved); err != nil {
+		return fmt.Errorf("failed to parse moved instances: %w", err)
+	}
+	r.moved = moved
+	return nil
+}
+
+// save writes the moves, with r.mu held
+func (r *Reroutes) save() {
+	if r.path == "" {
+		return
+	}
+	if err := jsonfile.Write(r.path, r.moved, "moved instances"); err != nil {
+		log.Printf("Warning: Failed to save the Snapclient instances moved by the fallback chain: %v", err)
+	}
+}
+
+// Plan returns the soundcard each instance has to switch to when the chain
+// routes to output, given the soundcards the instances play to. Instances
+// playing to bluealsa move to the default output, and get their soundcard
+// back when output is the device they played to.
+func (r *Reroutes) Plan(output Output, soundcards map[string]string) map[string]string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.moved == nil {
+		r.moved = make(map[string]string)
+	}
+	changes := make(map[string]string)
+	forgotten := false
+	for instance, original := range r.moved {
+		// Instances set to another soundcard since the move are left alone
+		if !strings.EqualFold(soundcards[instance], "default") {
+			delete(r.moved, instance)
+			forgotten = true
+			continue
+		}
+		if output.Type == OutputBluetooth && playsToDevice(original, output.Address) {
+			changes[instance] = original
+			delete(r.moved, instance)
+		}
+	}
+
+	// bluealsa plays to Bluetooth outputs itself
+	if output.Type != OutputBluetooth {
+		for instance, soundcard := range soundcards {
+			if isBluealsa(soundcard) {
+				changes[instance] = "default"
+				r.moved[instance] = soundcard
+			}
+		}
+	}
+
+	if forgotten || len(changes) > 0 {
+		r.save()
+	}
+	return changes
+}
+
+// isBluealsa reports whether a Snapclient soundcard plays through bluealsa
+func isBluealsa(soundcard string) bool {
+	return strings.Contains(strings.ToLower(soundcard), "bluealsa")
+}
+
+// playsToDevice reports whether a bluealsa soundcard plays to a device: it
+// names the device, or names none and plays to the default one
+func playsToDevice(soundcard, address string) bool {
+	soundcard = strings.ToUpper(soundcard)
+	return !strings.Contains(soundcard, "DEV=") || strings.Contains(soundcard, strings.ToUpper(address))
+}
@@ -0,0 +1,161 @@
+package audio
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/Ilshidur/bluepicast/internal/alsa"
+)
+
+const speaker = "AA:BB:CC:DD:EE:FF"
+
+var (
+	speakerOutput = Output{Type: OutputBluetooth, Address: speaker}
+	usbOutput     = Output{Type: OutputSoundcard, Card: "Audio"}
+)
+
+func TestSelectOutput(t *testing.T) {
+	m := &Manager{alsa: alsa.LoadFixture(alsa.FixtureDir("pi-usb-dac"))}
+	if err := m.SetFallbackChain([]Output{speakerOutput, {Type: OutputSoundcard, Card: "Device"}, usbOutput, {Type: OutputMute}}); err != nil {
+		t.Fatalf("SetFallbackChain failed: %v", err)
+	}
+
+	connected := false
+	isConnected := func(address string) bool { return connected && address == speaker }
+
+	// The speaker is off and the Device card unplugged
+	if output, ok := m.SelectOutput(isConnected); !ok || output != usbOutput {
+		t.Errorf("SelectOutput() = %v, %v, want the USB soundcard", output, ok)
+	}
+	connected = true
+	if output, ok := m.SelectOutput(isConnected); !ok || output != speakerOutput {
+		t.Errorf("SelectOutput() = %v, %v, want the speaker", output, ok)
+	}
+
+	if err := m.SetFallbackChain(nil); err != nil {
+		t.Fatalf("SetFallbackChain failed: %v", err)
+	}
+	if _, ok := m.SelectOutput(isConnected); ok {
+		t.Error("an empty chain should select nothing")
+	}
+}
+
+func TestFallbackChainPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "fallback.json")
+	m := &Manager{}
+	if err := m.LoadFallbackChain(path); err != nil {
+		t.Fatalf("LoadFallbackChain of a missing file failed: %v", err)
+	}
+	chain := []Output{speakerOutput, usbOutput}
+	if err := m.SetFallbackChain(chain); err != nil {
+		t.Fatalf("SetFallbackChain failed: %v", err)
+	}
+
+	// Invalid outputs are refused and leave the chain alone
+	for _, output := range []Output{
+		{Type: OutputBluetooth, Address: "speaker"},
+		{Type: OutputSoundcard, Card: `Audio"; pcm.x {`},
+		{Type: "hdmi"},
+	} {
+		if err := m.SetFallbackChain([]Output{output}); err == nil {
+			t.Errorf("SetFallbackChain(%v) succeeded", output)
+		}
+	}
+
+	loaded := &Manager{}
+	if err := loaded.LoadFallbackChain(path); err != nil {
+		t.Fatalf("LoadFallbackChain failed: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.FallbackChain(), chain) {
+		t.Errorf("loaded chain = %v, want %v", loaded.FallbackChain(), chain)
+	}
+
+	if err := os.WriteFile(path, []byte("["), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Manager{}).LoadFallbackChain(path); err == nil {
+		t.Error("LoadFallbackChain of an invalid file succeeded")
+	}
+}
+
+func TestReroutes(t *testing.T) {
+	var r Reroutes
+	soundcards := map[string]string{
+		"default": "bluealsa",
+		"patio":   "bluealsa:DEV=" + speaker + ",PROFILE=a2dp",
+		"kitchen": "hw:CARD=Audio,DEV=0",
+	}
+
+	// The speaker disconnects, its instances move to the default output
+	changes := r.Plan(usbOutput, soundcards)
+	expected := map[string]string{"default": "default", "patio": "default"}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Plan(soundcard) = %v, want %v", changes, expected)
+	}
+	soundcards["default"], soundcards["patio"] = "default", "default"
+
+	// Falling back further changes nothing
+	if changes := r.Plan(Output{Type: OutputMute}, soundcards); len(changes) != 0 {
+		t.Errorf("Plan(mute) = %v, want no change", changes)
+	}
+
+	// Another speaker only gets the instance playing to the default device
+	other := Output{Type: OutputBluetooth, Address: "11:22:33:44:55:66"}
+	if changes := r.Plan(other, soundcards); !reflect.DeepEqual(changes, map[string]string{"default": "bluealsa"}) {
+		t.Errorf("Plan(other speaker) = %v, want the default instance back on bluealsa", changes)
+	}
+	soundcards["default"] = "bluealsa"
+
+	// The speaker is back, patio gets its device back
+	changes = r.Plan(speakerOutput, soundcards)
+	if !reflect.DeepEqual(changes, map[string]string{"patio": "bluealsa:DEV=" + speaker + ",PROFILE=a2dp"}) {
+		t.Errorf("Plan(speaker) = %v, want patio back on its speaker", changes)
+	}
+}
+
+func TestReroutesForgetsChangedInstances(t *testing.T) {
+	var r Reroutes
+	soundcards := map[string]string{"default": "bluealsa"}
+	r.Plan(usbOutput, soundcards)
+
+	// The user picked a soundcard while the speaker was away
+	soundcards["default"] = "hw:CARD=Audio,DEV=0"
+	if changes := r.Plan(speakerOutput, soundcards); len(changes) != 0 {
+		t.Errorf("Plan(speaker) = %v, want the user's choice kept", changes)
+	}
+}
+
+func TestReroutesPersistence(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "fallback.json")
+	m := &Manager{}
+	if err := m.LoadFallbackChain(path); err != nil {
+		t.Fatalf("LoadFallbackChain failed: %v", err)
+	}
+	soundcards := map[string]string{"patio": "bluealsa:DEV=" + speaker + ",PROFILE=a2dp"}
+	m.Reroutes().Plan(usbOutput, soundcards)
+
+	// BluePiCast restarts while the fallback lasts
+	restarted := &Manager{}
+	if err := restarted.LoadFallbackChain(path); err != nil {
+		t.Fatalf("LoadFallbackChain failed: %v", err)
+	}
+	changes := restarted.Reroutes().Plan(speakerOutput, map[string]string{"patio": "default"})
+	if !reflect.DeepEqual(changes, soundcards) {
+		t.Errorf("Plan(speaker) after a restart = %v, want patio back on its speaker", changes)
+	}
+
+	// The restore is saved too, nothing is left to restore after another restart
+	restarted = &Manager{}
+	if err := restarted.LoadFallbackChain(path); err != nil {
+		t.Fatalf("LoadFallbackChain failed: %v", err)
+	}
+	if changes := restarted.Reroutes().Plan(speakerOutput, soundcards); len(changes) != 0 {
+		t.Errorf("Plan(speaker) = %v, want no change once restored", changes)
+	}
+	if _, err := os.Stat(filepath.Join(dir, reroutesFile+".tmp")); !os.IsNotExist(err) {
+		t.Error("the temporary file was left behind")
+	}
+}
@@ -0,0 +1,241 @@
+package audio
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Ilshidur/bluepicast/internal/runner"
+)
+
+const (
+	meterWindow        = 100 * time.Millisecond // Audio measured for each level update
+	meterRetryInterval = 5 * time.Second        // Wait before capturing again after arecord exits
+	playingCheckPeriod = 5 * time.Second        // How often the playing state is checked while silent
+
+	// SilenceFloor is the level reported for digital silence, in dBFS
+	SilenceFloor = -96.0
+)
+
+// Levels are the levels of the output over the last window, in dBFS
+type Levels struct {
+	RMS  [toneChannels]float64 `json:"rms"`
+	Peak [toneChannels]float64 `json:"peak"`
+}
+
+// loudest returns the highest peak of all channels
+func (l Levels) loudest() float64 {
+	peak := SilenceFloor
+	for _, p := range l.Peak {
+		peak = math.Max(peak, p)
+	}
+	return peak
+}
+
+// SilenceAlert reports that the output went silent while its stream plays,
+// or that the sound came back
+type SilenceAlert struct {
+	Silent bool      `json:"silent"`
+	Since  time.Time `json:"since"` // Start of the silence
+}
+
+// Meter measures the levels of the output from an ALSA capture PCM tapping
+// it, such as the capture side of snd-aloop or a dsnoop of the output, and
+// raises an alert when it stays silent while the Snapserver stream plays
+type Meter struct {
+	pcm        string
+	threshold  float64       // Peak level below which the output is silent, in dBFS
+	alertAfter time.Duration // Silence lasting this long raises an alert
+	playing    func() bool   // Reports whether the output should be playing
+	checkEvery time.Duration // How often playing is called while silent
+	runner     runner.Runner
+
+	mu        sync.Mutex
+	onLevels  func(levels Levels)
+	onSilence func(alert SilenceAlert)
+	silence   *SilenceAlert // Last alert raised, nil when the output plays
+}
+
+// NewMeter creates a meter capturing from pcm. playing is called while the
+// output is silent to tell an expected silence from a broken output.
+func NewMeter(pcm string, threshold float64, alertAfter time.Duration, playing func() bool) *Meter {
+	return &Meter{
+		pcm:        pcm,
+		threshold:  threshold,
+		alertAfter: alertAfter,
+		playing:    playing,
+		checkEvery: playingCheckPeriod,
+		runner:     runner.Exec{},
+	}
+}
+
+// SetRunner replaces the runner of arecord, e.g. with a runner.Fake in tests.
+// A runner.Recorder is not suited: it would keep the endless capture in memory.
+func (m *Meter) SetRunner(r runner.Runner) {
+	m.runner = r
+}
+
+// SetOnLevels sets the callback for levels, called every 100 ms
+func (m *Meter) SetOnLevels(callback func(levels Levels)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onLevels = callback
+}
+
+// SetOnSilence sets the callback for silence alerts and their end
+func (m *Meter) SetOnSilence(callback func(alert SilenceAlert)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.onSilence = callback
+}
+
+// Silence returns the silence alert in progress, if any
+func (m *Meter) Silence() (SilenceAlert, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.silence == nil {
+		return SilenceAlert{}, false
+	}
+	return *m.silence, true
+}
+
+// Run captures and measures the output until ctx is done
+func (m *Meter) Run(ctx context.Context) {
+	log.Printf("Measuring output levels from %s", m.pcm)
+	for {
+		if err := m.capture(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Warning: Level meter capture stopped: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(meterRetryInterval):
+		}
+	}
+}
+
+// capture runs arecord on the tap PCM and measures what it records
+func (m *Meter) capture(ctx context.Context) error {
+	output, err := m.runner.Stream(ctx, "arecord", "-q", "-D", m.pcm, "-t", "raw", "-f", "S16_LE",
+		"-r", strconv.Itoa(toneRate), "-c", strconv.Itoa(toneChannels))
+	if err != nil {
+		return fmt.Errorf("failed to start arecord: %w", err)
+	}
+
+	measureErr := m.measure(output)
+	// The error carries what ALSA reported, such as "No such device"
+	if err := output.Close(); err != nil {
+		return fmt.Errorf("arecord exited: %w", err)
+	}
+	return measureErr
+}
+
+// measure reads S16_LE stereo samples until r ends and reports their levels
+func (m *Meter) measure(r io.Reader) error {
+	frames := int(meterWindow.Seconds() * toneRate)
+	window := make([]byte, frames*toneChannels*2)
+
+	var silentSince, lastCheck time.Time
+	for {
+		if _, err := io.ReadFull(r, window); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			return fmt.Errorf("failed to read samples: %w", err)
+		}
+		levels := measureLevels(window)
+
+		m.mu.Lock()
+		onLevels := m.onLevels
+		m.mu.Unlock()
+		if onLevels != nil {
+			onLevels(levels)
+		}
+
+		now := time.Now()
+		if levels.loudest() >= m.threshold {
+			silentSince = time.Time{}
+			m.setSilence(nil)
+			continue
+		}
+		if silentSince.IsZero() {
+			silentSince = now
+		}
+		// Only ask whether the stream plays once the silence is long enough,
+		// then now and again, it is not free
+		if now.Sub(silentSince) < m.alertAfter || now.Sub(lastCheck) < m.checkEvery {
+			continue
+		}
+		lastCheck = now
+		if m.playing() {
+			m.setSilence(&SilenceAlert{Silent: true, Since: silentSince})
+		} else {
+			// Nothing is expected to play, a later silence is a new one
+			silentSince = now
+			m.setSilence(nil)
+		}
+	}
+}
+
+// setSilence records the silence in progress, nil when it ended, and calls
+// the callback when it changes
+func (m *Meter) setSilence(alert *SilenceAlert) {
+	m.mu.Lock()
+	previous := m.silence
+	if (previous == nil) == (alert == nil) {
+		m.mu.Unlock()
+		return
+	}
+	m.silence = alert
+	callback := m.onSilence
+	m.mu.Unlock()
+
+	if alert != nil {
+		log.Printf("Warning: Output silent since %s while the stream is playing", alert.Since.Format(time.TimeOnly))
+	} else {
+		log.Printf("Output playing again")
+		alert = &SilenceAlert{Silent: false, Since: previous.Since}
+	}
+	if callback != nil {
+		callback(*alert)
+	}
+}
+
+// measureLevels returns the RMS and peak levels of S16_LE stereo samples
+func measureLevels(samples []byte) Levels {
+	var sums, peaks [toneChannels]float64
+	frames := len(samples) / (toneChannels * 2)
+	for n := 0; n < frames; n++ {
+		for c := 0; c < toneChannels; c++ {
+			value := float64(int16(binary.LittleEndian.Uint16(samples[(n*toneChannels+c)*2:]))) / -math.MinInt16
+			sums[c] += value * value
+			peaks[c] = math.Max(peaks[c], math.Abs(value))
+		}
+	}
+
+	var levels Levels
+	for c := 0; c < toneChannels; c++ {
+		rms := 0.0
+		if frames > 0 {
+			rms = math.Sqrt(sums[c] / float64(frames))
+		}
+		levels.RMS[c] = toDBFS(rms)
+		levels.Peak[c] = toDBFS(peaks[c])
+	}
+	return levels
+}
+
+// toDBFS converts a linear level, 1 being full scale, to dBFS
+func toDBFS(level float64) float64 {
+	if level <= 0 {
+		return SilenceFloor
+	}
+	db := 20 * math.Log10(level)
+	return math.Round(math.Max(db, SilenceFloor)*10) / 10
+}
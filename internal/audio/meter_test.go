@@ -0,0 +1,135 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Ilshidur/bluepicast/internal/runner"
+)
+
+// window returns one meter window of S16_LE stereo samples, a sine at the
+// given amplitude on the left channel and silence on the right
+func window(amplitude float64) []byte {
+	frames := int(meterWindow.Seconds() * toneRate)
+	samples := make([]byte, frames*toneChannels*2)
+	for n := 0; n < frames; n++ {
+		value := amplitude * math.MaxInt16 * math.Sin(2*math.Pi*1000*float64(n)/toneRate)
+		binary.LittleEndian.PutUint16(samples[n*toneChannels*2:], uint16(int16(value)))
+	}
+	return samples
+}
+
+func TestMeasureLevels(t *testing.T) {
+	levels := measureLevels(window(1))
+	// A full scale sine peaks at 0 dBFS with an RMS 3 dB lower
+	if levels.Peak[0] != 0 || levels.RMS[0] != -3 {
+		t.Errorf("left = %v peak, %v RMS, want 0 and -3", levels.Peak[0], levels.RMS[0])
+	}
+	if levels.Peak[1] != SilenceFloor || levels.RMS[1] != SilenceFloor {
+		t.Errorf("right = %v peak, %v RMS, want the silence floor", levels.Peak[1], levels.RMS[1])
+	}
+
+	if levels := measureLevels(window(0.1)); levels.Peak[0] != -20 {
+		t.Errorf("peak = %v, want -20", levels.Peak[0])
+	}
+	if levels := measureLevels(nil); levels.loudest() != SilenceFloor {
+		t.Errorf("levels of no samples = %+v", levels)
+	}
+}
+
+// newTestMeter returns a meter checking playing on every silent window
+func newTestMeter(playing func() bool) *Meter {
+	m := NewMeter("hw:Loopback,1", -60, 0, playing)
+	m.checkEvery = 0
+	return m
+}
+
+func TestMeterSilenceAlert(t *testing.T) {
+	playing := true
+	m := newTestMeter(func() bool { return playing })
+	var levels []Levels
+	var alerts []SilenceAlert
+	m.SetOnLevels(func(l Levels) { levels = append(levels, l) })
+	m.SetOnSilence(func(alert SilenceAlert) { alerts = append(alerts, alert) })
+
+	var samples bytes.Buffer
+	for _, amplitude := range []float64{0.5, 0, 0, 0.5} {
+		samples.Write(window(amplitude))
+	}
+	if err := m.measure(&samples); err != nil {
+		t.Fatalf("measure failed: %v", err)
+	}
+
+	if len(levels) != 4 {
+		t.Errorf("got %d levels, want one per window", len(levels))
+	}
+	// One alert when the stream plays into silence, one when sound is back
+	if len(alerts) != 2 || !alerts[0].Silent || alerts[1].Silent || !alerts[1].Since.Equal(alerts[0].Since) {
+		t.Fatalf("alerts = %+v, want a silence and its end", alerts)
+	}
+	if _, ok := m.Silence(); ok {
+		t.Error("the silence should be over")
+	}
+}
+
+func TestMeterExpectedSilence(t *testing.T) {
+	checks := 0
+	m := newTestMeter(func() bool {
+		checks++
+		return false
+	})
+	m.SetOnSilence(func(alert SilenceAlert) { t.Errorf("unexpected alert %+v", alert) })
+
+	// Nothing plays, the output is expected to be silent
+	if err := m.measure(bytes.NewReader(append(window(0), window(0)...))); err != nil {
+		t.Fatalf("measure failed: %v", err)
+	}
+	if checks != 2 {
+		t.Errorf("playing checked %d times, want 2", checks)
+	}
+}
+
+func TestMeterWaitsBeforeAlerting(t *testing.T) {
+	m := NewMeter("hw:Loopback,1", -60, time.Minute, func() bool {
+		t.Error("playing checked before the silence lasted")
+		return true
+	})
+
+	if err := m.measure(bytes.NewReader(window(0))); err != nil {
+		t.Fatalf("measure failed: %v", err)
+	}
+	if _, ok := m.Silence(); ok {
+		t.Error("a short silence raised an alert")
+	}
+}
+
+func TestMeterCapture(t *testing.T) {
+	const arecord = "arecord -q -D hw:Loopback,1 -t raw -f S16_LE -r 48000 -c 2"
+	fake := runner.NewFake(runner.Call{Command: arecord, Stdout: string(window(0.5)) + string(window(0.1))})
+	m := newTestMeter(func() bool { return true })
+	m.SetRunner(fake)
+	var levels []Levels
+	m.SetOnLevels(func(l Levels) { levels = append(levels, l) })
+
+	if err := m.capture(context.Background()); err != nil {
+		t.Fatalf("capture failed: %v", err)
+	}
+	if len(levels) != 2 || levels[1].Peak[0] != -20 {
+		t.Errorf("levels = %+v, want one per window recorded", levels)
+	}
+
+	// arecord failing reports what ALSA printed
+	fake.Add(runner.Call{
+		Command:  arecord,
+		Stderr:   "arecord: main:850: audio open error: No such device\n",
+		ExitCode: 1,
+	})
+	if err := m.capture(context.Background()); err == nil || !strings.Contains(err.Error(), "No such device") {
+		t.Errorf("capture() = %v, want the ALSA error", err)
+	}
+}
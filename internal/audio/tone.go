@@ -0,0 +1,81 @@
+package audio
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Format of the generated tones, S16_LE interleaved stereo
+const (
+	toneRate     = 48000
+	toneChannels = 2
+)
+
+// chimeNotes are the frequencies of the identification chime, an ascending
+// C major arpeggio that stands out from music
+var chimeNotes = []float64{1046.50, 1318.51, 1567.98, 2093.00}
+
+// Chime returns a short chime as S16_LE stereo samples at 48 kHz
+func Chime() []byte {
+	const (
+		noteLength = 0.18 // Seconds between notes
+		ringLength = 0.6  // Seconds each note rings for
+		amplitude  = 0.25 // Per note, so that overlapping notes do not clip
+	)
+
+	total := int((noteLength*float64(len(chimeNotes)-1) + ringLength) * toneRate)
+	samples := make([]float64, total)
+	for i, frequency := range chimeNotes {
+		start := int(noteLength * float64(i) * toneRate)
+		ring := int(ringLength * toneRate)
+		for n := 0; n < ring && start+n < total; n++ {
+			t := float64(n) / toneRate
+			// Short attack, then exponential decay like a bell
+			envelope := math.Min(1, t/0.005) * math.Exp(-t*6)
+			samples[start+n] += amplitude * envelope * math.Sin(2*math.Pi*frequency*t)
+		}
+	}
+
+	buf := make([]byte, total*toneChannels*2)
+	for n, sample := range samples {
+		value := uint16(int16(math.Max(-1, math.Min(1, sample)) * math.MaxInt16))
+		for c := 0; c < toneChannels; c++ {
+			binary.LittleEndian.PutUint16(buf[(n*toneChannels+c)*2:], value)
+		}
+	}
+	return buf
+}
+
+// BluetoothPCM returns the ALSA PCM playing to a Bluetooth device, through
+// PipeWire for LE Audio devices and bluez-alsa for the others
+func BluetoothPCM(address string, leAudio bool) (string, error) {
+	if !macAddressPattern.MatchString(address) {
+		return "", fmt.Errorf("invalid MAC address format: %s", address)
+	}
+	if leAudio {
+		return "pipewire:NODE=" + PipeWireNodeName(address), nil
+	}
+	return "bluealsa:DEV=" + address + ",PROFILE=a2dp", nil
+}
+
+// PlayTone plays S16_LE stereo samples at 48 kHz on an ALSA PCM with aplay.
+// The error carries what ALSA reported, such as "Device or resource busy".
+func (m *Manager) PlayTone(ctx context.Context, pcm string, samples []byte) error {
+	output, err := m.runner.Input(ctx, bytes.NewReader(samples), "aplay", "-q", "-D", pcm, "-t", "raw", "-f", "S16_LE",
+		"-r", strconv.Itoa(toneRate), "-c", strconv.Itoa(toneChannels), "-")
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if message := strings.TrimSpace(string(output)); message != "" {
+			return fmt.Errorf("%s (%w)", message, err)
+		}
+		return fmt.Errorf("failed to run aplay: %w", err)
+	}
+	return nil
+}
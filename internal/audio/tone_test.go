@@ -0,0 +1,89 @@
+package audio
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/Ilshidur/bluepicast/internal/runner"
+)
+
+func TestChime(t *testing.T) {
+	samples := Chime()
+
+	// Four notes 180 ms apart, the last one ringing for 600 ms
+	const frames = (3*0.18 + 0.6) * toneRate
+	if len(samples) != frames*toneChannels*2 {
+		t.Fatalf("chime is %d bytes, want %d frames of S16_LE stereo", len(samples), int(frames))
+	}
+
+	var peak int16
+	for n := 0; n < len(samples)/(toneChannels*2); n++ {
+		left := int16(binary.LittleEndian.Uint16(samples[n*toneChannels*2:]))
+		right := int16(binary.LittleEndian.Uint16(samples[n*toneChannels*2+2:]))
+		if left != right {
+			t.Fatalf("frame %d differs between channels: %d and %d", n, left, right)
+		}
+		if left < 0 {
+			left = -left
+		}
+		if left > peak {
+			peak = left
+		}
+	}
+	// Overlapping notes must be audible without clipping
+	if peak < 1<<13 || peak == 1<<15-1 {
+		t.Errorf("chime peaks at %d", peak)
+	}
+	if levels := measureLevels(samples[:4]); levels.loudest() != SilenceFloor {
+		t.Errorf("chime starts at %v dBFS, want the attack to start from silence", levels.loudest())
+	}
+}
+
+func TestBluetoothPCM(t *testing.T) {
+	tests := []struct {
+		address string
+		leAudio bool
+		want    string
+	}{
+		{"AA:BB:CC:DD:EE:FF", false, "bluealsa:DEV=AA:BB:CC:DD:EE:FF,PROFILE=a2dp"},
+		{"aa:bb:cc:dd:ee:ff", true, "pipewire:NODE=bluez_output.AA_BB_CC_DD_EE_FF.1"},
+	}
+	for _, tt := range tests {
+		if pcm, err := BluetoothPCM(tt.address, tt.leAudio); err != nil || pcm != tt.want {
+			t.Errorf("BluetoothPCM(%q, %v) = %q, %v, want %q", tt.address, tt.leAudio, pcm, err, tt.want)
+		}
+	}
+
+	// The address ends up in an ALSA device string
+	if _, err := BluetoothPCM("AA:BB:CC:DD:EE:FF,PROFILE=sco", false); err == nil {
+		t.Error("BluetoothPCM accepted an invalid address")
+	}
+}
+
+func TestPlayTone(t *testing.T) {
+	const aplay = "aplay -q -D hw:CARD=Audio,DEV=0 -t raw -f S16_LE -r 48000 -c 2 -"
+	fake := runner.NewFake(runner.Call{Command: aplay})
+	m := &Manager{runner: fake}
+
+	if err := m.PlayTone(context.Background(), "hw:CARD=Audio,DEV=0", Chime()); err != nil {
+		t.Errorf("PlayTone failed: %v", err)
+	}
+	if !fake.Ran(aplay) {
+		t.Errorf("ran %q, want aplay on the soundcard", fake.History())
+	}
+
+	// ALSA errors are reported as aplay printed them
+	fake.Add(runner.Call{
+		Command:  aplay,
+		Stderr:   "aplay: main:831: audio open error: Device or resource busy\n",
+		ExitCode: 1,
+	})
+	err := m.PlayTone(context.Background(), "hw:CARD=Audio,DEV=0", Chime())
+	var exitErr *runner.ExitError
+	if !errors.As(err, &exitErr) || !strings.Contains(err.Error(), "Device or resource busy") {
+		t.Errorf("PlayTone() = %v, want the ALSA error", err)
+	}
+}
@@ -6,42 +6,101 @@ import (
 	"fmt"
 	"log"
 	"os/exec"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
 )
 
 // Device represents a discovered Bluetooth device
 type Device struct {
 	Address   string `json:"address"`
 	Name      string `json:"name"`
+	Alias     string `json:"alias"` // Local name set by the user, defaults to Name in BlueZ
 	Paired    bool   `json:"paired"`
 	Connected bool   `json:"connected"`
 	Trusted   bool   `json:"trusted"`
+	Blocked   bool   `json:"blocked"`
 	RSSI      int16  `json:"rssi"`
 	Icon      string `json:"icon"`
+	Busy      string `json:"busy,omitempty"` // Operation in progress on the device ("pair", "connect", ...)
+
+	AddressType string    `json:"addressType"`     // "public" or "random"
+	Class       uint32    `json:"class,omitempty"` // Class of Device, only reported by classic (BR/EDR) devices
+	LastSeen    time.Time `json:"lastSeen"`
+
+	UUIDs     []string        `json:"uuids,omitempty"`     // Service UUIDs advertised by the device
+	LEAudio   bool            `json:"leAudio"`             // Device supports LE Audio (BAP/LC3)
+	Transport *AudioTransport `json:"transport,omitempty"` // Current audio stream, if any
+
+	RemoteControl bool          `json:"remoteControl"`    // AVRCP is connected, the device buttons reach us
+	Player        *RemotePlayer `json:"player,omitempty"` // Player exposed by the device, if any
+}
+
+// AdapterInfo represents the state of the local Bluetooth adapter
+type AdapterInfo struct {
+	Address             string `json:"address"`
+	Name                string `json:"name"`
+	Alias               string `json:"alias"`
+	Powered             bool   `json:"powered"`
+	Discoverable        bool   `json:"discoverable"`
+	DiscoverableTimeout uint32 `json:"discoverableTimeout"` // Seconds, 0 means discoverable until turned off
+	Pairable            bool   `json:"pairable"`
+	Discovering         bool   `json:"discovering"`
+	Available           bool   `json:"available"` // False while bluetoothd is down or the adapter is unplugged
+	LEAudio             bool   `json:"leAudio"`   // The audio stack can stream to LE Audio devices
 }
 
 // Adapter manages Bluetooth operations via BlueZ D-Bus API
 type Adapter struct {
-	conn        *dbus.Conn
-	adapterPath dbus.ObjectPath
-	mu          sync.RWMutex
-	devices     map[string]*Device
-	onChange    func(devices []*Device)
-	onConnect   func(device *Device)
-	scanning    bool
-	stopSignals chan struct{}
+	conn            *dbus.Conn
+	adapterPath     dbus.ObjectPath
+	mu              sync.RWMutex
+	devices         map[string]*Device
+	transports      map[string]*AudioTransport // Audio streams by object path
+	endpoints       map[string]bool            // Object paths of the LE Audio endpoints of devices
+	info            AdapterInfo
+	onChange        func(devices []*Device)
+	changed         chan struct{} // Signals notifyLoop that the device list changed
+	onConnect       func(device *Device)
+	onAdapterChange func(info AdapterInfo)
+	adapterChanged  chan struct{} // Signals notifyLoop that the adapter state changed
+	onScanChange    func(scanning bool)
+	scanning        bool
+	scanSessions    map[*scanSession]struct{}
+	filter          DiscoveryFilter // Merged filter of the scan sessions, applied to BlueZ
+	scanMu          sync.Mutex      // Serializes starting and stopping discovery
+	showUnnamed     bool
+	staleAfter      time.Duration
+	reconnecting    bool
+	deviceOps       map[string][]*deviceOp // Queued operations by device address, running one first
+	deviceOpsMu     sync.Mutex
+	stopSignals     chan struct{}
+
+	onDeviceOpQueued func(kind string, joined bool) // Called once a request is queued or joins another, for tests
+
+	onMediaCommand   func(cmd MediaCommand)
+	nowPlaying       NowPlaying
+	playerProps      *prop.Properties // Properties of the exported media player, nil until registered
+	playerRegistered bool
 }
 
 const (
 	bluezService        = "org.bluez"
+	dbusService         = "org.freedesktop.DBus"
 	bluezAdapterIface   = "org.bluez.Adapter1"
 	bluezDeviceIface    = "org.bluez.Device1"
 	dbusPropertiesIface = "org.freedesktop.DBus.Properties"
 	dbusObjectManager   = "org.freedesktop.DBus.ObjectManager"
+
+	// AudioSinkUUID is the A2DP Audio Sink service class UUID, advertised by speakers and headphones
+	AudioSinkUUID = "0000110b-0000-1000-8000-00805f9b34fb"
+
+	// maxAliasLength is the maximum length of a Bluetooth device name (in bytes)
+	maxAliasLength = 248
 )
 
 // NewAdapter creates a new Bluetooth adapter manager
@@ -52,9 +111,13 @@ func NewAdapter() (*Adapter, error) {
 	}
 
 	adapter := &Adapter{
-		conn:        conn,
-		devices:     make(map[string]*Device),
-		stopSignals: make(chan struct{}),
+		conn:           conn,
+		devices:        make(map[string]*Device),
+		scanSessions:   make(map[*scanSession]struct{}),
+		staleAfter:     defaultStaleAfter,
+		changed:        make(chan struct{}, 1),
+		adapterChanged: make(chan struct{}, 1),
+		stopSignals:    make(chan struct{}),
 	}
 
 	// Find the default adapter (usually hci0)
@@ -64,21 +127,34 @@ func NewAdapter() (*Adapter, error) {
 		return nil, err
 	}
 	adapter.adapterPath = adapterPath
+	adapter.info.Available = true
 
 	// Ensure the adapter is powered on
 	if err := adapter.ensurePoweredOn(); err != nil {
 		log.Printf("Warning: Failed to power on adapter: %v", err)
 	}
 
+	// Load the adapter properties (name, discoverable state, ...)
+	adapter.refreshAdapterProperties()
+
 	// Set up signal handling for device changes
-	if err := adapter.setupSignals(); err != nil {
+	if err := adapter.setupSignals(conn); err != nil {
 		conn.Close()
 		return nil, err
 	}
 
+	// Deliver device list and adapter changes one at a time, in order
+	go adapter.notifyLoop()
+
 	// Load existing paired/connected devices at startup
 	adapter.loadExistingDevices()
 
+	// Periodically forget devices that went out of range
+	go adapter.pruneLoop()
+
+	// Reattach to BlueZ if bluetoothd restarts or the adapter is replugged
+	go adapter.recoveryLoop()
+
 	return adapter, nil
 }
 
@@ -89,6 +165,49 @@ func (a *Adapter) SetOnChange(fn func(devices []*Device)) {
 	a.onChange = fn
 }
 
+// notifyChange tells the change listener that the device list changed.
+// Changes that arrive while the listener is busy are merged into one call.
+func (a *Adapter) notifyChange() {
+	select {
+	case a.changed <- struct{}{}:
+	default:
+	}
+}
+
+// notifyAdapterChange tells the adapter listener that the adapter state changed.
+// Changes that arrive while the listener is busy are merged into one call.
+func (a *Adapter) notifyAdapterChange() {
+	select {
+	case a.adapterChanged <- struct{}{}:
+	default:
+	}
+}
+
+// notifyLoop calls the change listeners with a snapshot taken after the latest
+// change. Running it from a single goroutine keeps snapshots in order.
+func (a *Adapter) notifyLoop() {
+	for {
+		select {
+		case <-a.stopSignals:
+			return
+		case <-a.changed:
+			a.mu.RLock()
+			onChange := a.onChange
+			a.mu.RUnlock()
+			if onChange != nil {
+				onChange(a.GetDevices())
+			}
+		case <-a.adapterChanged:
+			a.mu.RLock()
+			onAdapterChange := a.onAdapterChange
+			a.mu.RUnlock()
+			if onAdapterChange != nil {
+				onAdapterChange(a.GetAdapterInfo())
+			}
+		}
+	}
+}
+
 // SetOnConnect sets the callback for when a device connects
 func (a *Adapter) SetOnConnect(fn func(device *Device)) {
 	a.mu.Lock()
@@ -96,9 +215,23 @@ func (a *Adapter) SetOnConnect(fn func(device *Device)) {
 	a.onConnect = fn
 }
 
+// SetOnScanChange sets the callback for when discovery starts or stops
+func (a *Adapter) SetOnScanChange(fn func(scanning bool)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onScanChange = fn
+}
+
+// SetOnAdapterChange sets the callback for adapter property changes
+func (a *Adapter) SetOnAdapterChange(fn func(info AdapterInfo)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onAdapterChange = fn
+}
+
 // ensurePoweredOn makes sure the Bluetooth adapter is powered on
 func (a *Adapter) ensurePoweredOn() error {
-	adapter := a.conn.Object(bluezService, a.adapterPath)
+	adapter := a.adapterObject()
 
 	// Check current power state
 	variant, err := adapter.GetProperty(bluezAdapterIface + ".Powered")
@@ -167,7 +300,7 @@ func tryUnblockBluetoothRfkill() error {
 }
 
 func (a *Adapter) findAdapter() (dbus.ObjectPath, error) {
-	obj := a.conn.Object(bluezService, "/")
+	obj := a.bus().Object(bluezService, "/")
 	var result map[dbus.ObjectPath]map[string]map[string]dbus.Variant
 
 	err := obj.Call(dbusObjectManager+".GetManagedObjects", 0).Store(&result)
@@ -184,31 +317,43 @@ func (a *Adapter) findAdapter() (dbus.ObjectPath, error) {
 	return "", fmt.Errorf("no Bluetooth adapter found")
 }
 
-func (a *Adapter) setupSignals() error {
-	if err := a.conn.AddMatchSignal(
+func (a *Adapter) setupSignals(conn *dbus.Conn) error {
+	if err := conn.AddMatchSignal(
 		dbus.WithMatchInterface(dbusObjectManager),
 	); err != nil {
 		return err
 	}
 
-	if err := a.conn.AddMatchSignal(
+	if err := conn.AddMatchSignal(
 		dbus.WithMatchInterface(dbusPropertiesIface),
 		dbus.WithMatchMember("PropertiesChanged"),
 	); err != nil {
 		return err
 	}
 
+	// Get notified when bluetoothd leaves or joins the bus
+	if err := conn.AddMatchSignal(
+		dbus.WithMatchSender(dbusService),
+		dbus.WithMatchInterface(dbusService),
+		dbus.WithMatchMember("NameOwnerChanged"),
+		dbus.WithMatchArg(0, bluezService),
+	); err != nil {
+		return err
+	}
+
 	signals := make(chan *dbus.Signal, 10)
-	a.conn.Signal(signals)
+	conn.Signal(signals)
 
 	go func() {
 		for {
 			select {
 			case <-a.stopSignals:
-				a.conn.RemoveSignal(signals)
+				conn.RemoveSignal(signals)
 				return
 			case signal, ok := <-signals:
 				if !ok {
+					// The channel is closed along with the connection
+					a.handleBusLost(conn)
 					return
 				}
 				a.handleSignal(signal)
@@ -234,6 +379,11 @@ func (a *Adapter) handleSignal(signal *dbus.Signal) {
 			if props, ok := ifaces[bluezDeviceIface]; ok {
 				a.updateDevice(path, props)
 			}
+			a.applyMediaObject(path, ifaces)
+			if _, ok := ifaces[bluezAdapterIface]; ok && !a.IsAvailable() {
+				log.Printf("Bluetooth adapter %s appeared", path)
+				go a.reconnect()
+			}
 		}
 	case dbusObjectManager + ".InterfacesRemoved":
 		if len(signal.Body) >= 2 {
@@ -241,26 +391,69 @@ func (a *Adapter) handleSignal(signal *dbus.Signal) {
 			if !ok {
 				return
 			}
-			a.removeDevice(path)
+			ifaces, _ := signal.Body[1].([]string)
+			a.removeInterfaces(path, ifaces)
 		}
+	case dbusService + ".NameOwnerChanged":
+		a.handleNameOwnerChanged(signal.Body)
 	case dbusPropertiesIface + ".PropertiesChanged":
 		if len(signal.Body) >= 2 {
 			iface, ok := signal.Body[0].(string)
-			if !ok || iface != bluezDeviceIface {
+			if !ok {
 				return
 			}
 			props, ok := signal.Body[1].(map[string]dbus.Variant)
 			if !ok {
 				return
 			}
-			a.updateDevice(signal.Path, props)
+			switch iface {
+			case bluezDeviceIface:
+				a.updateDevice(signal.Path, props)
+			case bluezAdapterIface:
+				if signal.Path == a.path() {
+					a.updateAdapter(props)
+				}
+			case bluezTransportIface:
+				a.updateTransport(signal.Path, props)
+			case bluezMediaIface:
+				if signal.Path == a.path() {
+					a.updateMedia(props)
+				}
+			case bluezMediaControlIface:
+				a.updateMediaControl(signal.Path, props)
+			case bluezMediaPlayerIface:
+				a.updateRemotePlayer(signal.Path, props)
+			}
+		}
+	}
+}
+
+// removeInterfaces handles interfaces removed from a BlueZ object.
+// Devices lose some interfaces, such as MediaControl1 when AVRCP drops,
+// while staying known: they are only forgotten once Device1 is removed.
+func (a *Adapter) removeInterfaces(path dbus.ObjectPath, ifaces []string) {
+	for _, iface := range ifaces {
+		switch {
+		case iface == bluezAdapterIface && path == a.path():
+			a.markUnavailable("adapter removed")
+			return
+		case iface == bluezDeviceIface:
+			a.removeDevice(path)
+		case iface == bluezTransportIface:
+			a.removeTransport(path)
+		case iface == bluezEndpointIface:
+			a.removeEndpoint(path)
+		case iface == bluezMediaControlIface:
+			a.updateMediaControl(path, map[string]dbus.Variant{"Connected": dbus.MakeVariant(false)})
+		case iface == bluezMediaPlayerIface:
+			a.removeRemotePlayer(path)
 		}
 	}
 }
 
 func (a *Adapter) updateDevice(path dbus.ObjectPath, props map[string]dbus.Variant) {
 	pathStr := string(path)
-	if !strings.HasPrefix(pathStr, string(a.adapterPath)+"/dev_") {
+	if !strings.HasPrefix(pathStr, string(a.path())+"/dev_") {
 		return
 	}
 
@@ -272,19 +465,35 @@ func (a *Adapter) updateDevice(path dbus.ObjectPath, props map[string]dbus.Varia
 		a.devices[pathStr] = device
 	}
 
+	// A device forgotten by pruneStaleDevices may come back through a
+	// PropertiesChanged signal carrying only a few properties (e.g. RSSI)
+	_, hasAddress := props["Address"]
+	needsRefresh := !exists && !hasAddress
+
 	// Track previous connection state to detect new connections
 	wasConnected := device.Connected
 
+	// Any signal about the device means BlueZ heard from it recently
+	device.LastSeen = time.Now()
+
 	for key, val := range props {
 		switch key {
 		case "Address":
 			if v, ok := val.Value().(string); ok {
 				device.Address = v
 			}
-		case "Name", "Alias":
+		case "Name":
 			if v, ok := val.Value().(string); ok && v != "" {
 				device.Name = v
 			}
+		case "Alias":
+			if v, ok := val.Value().(string); ok {
+				device.Alias = v
+			}
+		case "Blocked":
+			if v, ok := val.Value().(bool); ok {
+				device.Blocked = v
+			}
 		case "Paired":
 			if v, ok := val.Value().(bool); ok {
 				device.Paired = v
@@ -305,9 +514,23 @@ func (a *Adapter) updateDevice(path dbus.ObjectPath, props map[string]dbus.Varia
 			if v, ok := val.Value().(string); ok {
 				device.Icon = v
 			}
+		case "AddressType":
+			if v, ok := val.Value().(string); ok {
+				device.AddressType = v
+			}
+		case "Class":
+			if v, ok := val.Value().(uint32); ok {
+				device.Class = v
+			}
+		case "UUIDs":
+			if v, ok := val.Value().([]string); ok {
+				device.UUIDs = v
+			}
 		}
 	}
 
+	device.LEAudio = a.isLEAudioLocked(pathStr, device)
+
 	// Check if device just connected (was not connected before, now is connected)
 	justConnected := !wasConnected && device.Connected
 	onConnectCallback := a.onConnect
@@ -317,40 +540,184 @@ func (a *Adapter) updateDevice(path dbus.ObjectPath, props map[string]dbus.Varia
 
 	a.mu.Unlock()
 
-	if a.onChange != nil {
-		go a.onChange(a.GetDevices())
+	if needsRefresh {
+		go a.refreshDeviceProperties(pathStr)
 	}
 
+	a.notifyChange()
+
 	// Trigger onConnect callback if the device just connected
 	if justConnected && onConnectCallback != nil {
 		go onConnectCallback(&deviceCopy)
 	}
 }
 
+func (a *Adapter) updateAdapter(props map[string]dbus.Variant) {
+	a.mu.Lock()
+
+	for key, val := range props {
+		switch key {
+		case "Address":
+			if v, ok := val.Value().(string); ok {
+				a.info.Address = v
+			}
+		case "Name":
+			if v, ok := val.Value().(string); ok {
+				a.info.Name = v
+			}
+		case "Alias":
+			if v, ok := val.Value().(string); ok {
+				a.info.Alias = v
+			}
+		case "Powered":
+			if v, ok := val.Value().(bool); ok {
+				a.info.Powered = v
+			}
+		case "Discoverable":
+			if v, ok := val.Value().(bool); ok {
+				a.info.Discoverable = v
+			}
+		case "DiscoverableTimeout":
+			if v, ok := val.Value().(uint32); ok {
+				a.info.DiscoverableTimeout = v
+			}
+		case "Pairable":
+			if v, ok := val.Value().(bool); ok {
+				a.info.Pairable = v
+			}
+		case "Discovering":
+			if v, ok := val.Value().(bool); ok {
+				a.info.Discovering = v
+			}
+		}
+	}
+
+	a.mu.Unlock()
+
+	a.notifyAdapterChange()
+}
+
+// refreshAdapterProperties fetches all adapter properties from D-Bus
+// and updates the internal adapter state
+func (a *Adapter) refreshAdapterProperties() {
+	adapter := a.adapterObject()
+
+	var props map[string]dbus.Variant
+	err := adapter.Call(dbusPropertiesIface+".GetAll", 0, bluezAdapterIface).Store(&props)
+	if err != nil {
+		log.Printf("Failed to refresh adapter properties: %v", err)
+		return
+	}
+
+	a.updateAdapter(props)
+	a.refreshMediaProperties()
+}
+
+// RefreshAdapterInfo reads the adapter properties from BlueZ, rather than
+// waiting for their PropertiesChanged signals, and returns the adapter state
+func (a *Adapter) RefreshAdapterInfo() AdapterInfo {
+	a.refreshAdapterProperties()
+	return a.GetAdapterInfo()
+}
+
+// GetAdapterInfo returns the current state of the local adapter
+func (a *Adapter) GetAdapterInfo() AdapterInfo {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.info
+}
+
+// setAdapterProperty writes a single Adapter1 property
+func (a *Adapter) setAdapterProperty(name string, value interface{}) error {
+	adapter := a.adapterObject()
+	call := adapter.Call(dbusPropertiesIface+".Set", 0, bluezAdapterIface, name, dbus.MakeVariant(value))
+	if call.Err != nil {
+		log.Printf("Failed to set adapter property %s: %v", name, call.Err)
+		return fmt.Errorf("failed to set %s: %w", name, call.Err)
+	}
+	return nil
+}
+
+// SetAdapterAlias renames the local adapter as seen by other devices
+func (a *Adapter) SetAdapterAlias(alias string) error {
+	alias = strings.TrimSpace(alias)
+	if alias == "" {
+		return fmt.Errorf("adapter name cannot be empty")
+	}
+	if len(alias) > maxAliasLength {
+		return fmt.Errorf("adapter name must be at most %d bytes", maxAliasLength)
+	}
+
+	log.Printf("Renaming Bluetooth adapter to %q", alias)
+	return a.setAdapterProperty("Alias", alias)
+}
+
+// SetPowered powers the adapter on or off
+func (a *Adapter) SetPowered(powered bool) error {
+	if powered {
+		return a.ensurePoweredOn()
+	}
+
+	log.Println("Powering off Bluetooth adapter...")
+	return a.setAdapterProperty("Powered", false)
+}
+
+// SetDiscoverable makes the adapter visible to other devices, for the
+// DiscoverableTimeout of the adapter
+func (a *Adapter) SetDiscoverable(discoverable bool) error {
+	if discoverable {
+		log.Println("Making Bluetooth adapter discoverable")
+	} else {
+		log.Println("Making Bluetooth adapter non-discoverable")
+	}
+	return a.setAdapterProperty("Discoverable", discoverable)
+}
+
+// SetDiscoverableTimeout sets how long the adapter stays discoverable, in
+// seconds; 0 keeps it discoverable until turned off. BlueZ starts the
+// countdown when Discoverable becomes true, so it must be set before.
+func (a *Adapter) SetDiscoverableTimeout(timeout uint32) error {
+	log.Printf("Setting Bluetooth adapter discoverable timeout: %ds", timeout)
+	return a.setAdapterProperty("DiscoverableTimeout", timeout)
+}
+
+// SetPairable allows or refuses incoming pairing requests
+func (a *Adapter) SetPairable(pairable bool) error {
+	log.Printf("Setting Bluetooth adapter pairable: %v", pairable)
+	return a.setAdapterProperty("Pairable", pairable)
+}
+
 func (a *Adapter) removeDevice(path dbus.ObjectPath) {
 	a.mu.Lock()
 	_, exists := a.devices[string(path)]
 	if exists {
 		delete(a.devices, string(path))
 	}
-	onChange := a.onChange
 	a.mu.Unlock()
 
-	// Only trigger onChange if the device was actually in our map
-	if exists && onChange != nil {
-		go onChange(a.GetDevices())
+	// Only notify if the device was actually in our map
+	if exists {
+		a.notifyChange()
 	}
 }
 
-// GetDevices returns all discovered devices
+// GetDevices returns all discovered devices,
+// sorted with connected devices first, then paired ones, then by signal strength.
+// Nameless Low Energy devices are left out unless SetShowUnnamed(true) was called.
 func (a *Adapter) GetDevices() []*Device {
 	a.mu.RLock()
 	defer a.mu.RUnlock()
 
 	devices := make([]*Device, 0, len(a.devices))
 	for _, d := range a.devices {
-		devices = append(devices, d)
+		if !a.showUnnamed && d.isHidden() {
+			continue
+		}
+		// Return copies so callers never race with D-Bus signal updates
+		device := *d
+		devices = append(devices, &device)
 	}
+	sortDevices(devices)
 	return devices
 }
 
@@ -362,57 +729,267 @@ func (a *Adapter) GetPairedDevices() []*Device {
 	devices := make([]*Device, 0)
 	for _, d := range a.devices {
 		if d.Paired || d.Connected {
-			devices = append(devices, d)
+			device := *d
+			devices = append(devices, &device)
 		}
 	}
+	sortDevices(devices)
 	return devices
 }
 
-// StartDiscovery begins scanning for Bluetooth devices
-func (a *Adapter) StartDiscovery(ctx context.Context) error {
-	a.mu.Lock()
-	if a.scanning {
-		a.mu.Unlock()
-		log.Println("Discovery already in progress")
+// DiscoveryFilter restricts the devices reported during discovery
+type DiscoveryFilter struct {
+	Transport string   `json:"transport,omitempty"` // "auto" (default), "bredr" or "le"
+	RSSI      *int16   `json:"rssi,omitempty"`      // Minimum RSSI in dBm, nil for no threshold
+	UUIDs     []string `json:"uuids,omitempty"`     // Only report devices advertising one of these services
+}
+
+// scanSession represents one caller interested in discovery results.
+// Discovery keeps running as long as at least one session is active.
+type scanSession struct {
+	filter DiscoveryFilter
+}
+
+// validate checks the values BlueZ accepts
+func (f DiscoveryFilter) validate() error {
+	switch f.Transport {
+	case "", "auto", "bredr", "le":
 		return nil
 	}
-	a.scanning = true
+	return fmt.Errorf("invalid discovery transport: %s", f.Transport)
+}
+
+// equal reports whether two filters select the same devices
+func (f DiscoveryFilter) equal(other DiscoveryFilter) bool {
+	if f.Transport != other.Transport || (f.RSSI == nil) != (other.RSSI == nil) || len(f.UUIDs) != len(other.UUIDs) {
+		return false
+	}
+	if f.RSSI != nil && *f.RSSI != *other.RSSI {
+		return false
+	}
+	for i := range f.UUIDs {
+		if f.UUIDs[i] != other.UUIDs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// mergeDiscoveryFilters returns the narrowest filter that still reports every
+// device one of the filters accepts, the way BlueZ merges the filters of its
+// clients
+func mergeDiscoveryFilters(filters []DiscoveryFilter) DiscoveryFilter {
+	if len(filters) == 0 {
+		return DiscoveryFilter{}
+	}
+
+	merged := DiscoveryFilter{Transport: filters[0].Transport, RSSI: filters[0].RSSI}
+	anyUUID := false
+	seen := make(map[string]bool)
+	for _, filter := range filters {
+		if filter.Transport != merged.Transport {
+			merged.Transport = "auto"
+		}
+		if filter.RSSI == nil || (merged.RSSI != nil && *filter.RSSI < *merged.RSSI) {
+			merged.RSSI = filter.RSSI
+		}
+		if len(filter.UUIDs) == 0 {
+			anyUUID = true
+		}
+		for _, uuid := range filter.UUIDs {
+			uuid = strings.ToLower(uuid)
+			if !seen[uuid] {
+				seen[uuid] = true
+				merged.UUIDs = append(merged.UUIDs, uuid)
+			}
+		}
+	}
+	if anyUUID {
+		merged.UUIDs = nil
+	}
+	sort.Strings(merged.UUIDs)
+	return merged
+}
+
+// sessionFilterLocked returns the filter of all active sessions merged.
+// a.mu must be held.
+func (a *Adapter) sessionFilterLocked() DiscoveryFilter {
+	filters := make([]DiscoveryFilter, 0, len(a.scanSessions))
+	for session := range a.scanSessions {
+		filters = append(filters, session.filter)
+	}
+	return mergeDiscoveryFilters(filters)
+}
+
+// GetDiscoveryFilter returns the discovery filter applied for the active
+// scan sessions
+func (a *Adapter) GetDiscoveryFilter() DiscoveryFilter {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.filter
+}
+
+func (a *Adapter) applyDiscoveryFilter(filter DiscoveryFilter) error {
+	args := make(map[string]dbus.Variant)
+	if filter.Transport != "" {
+		args["Transport"] = dbus.MakeVariant(filter.Transport)
+	}
+	if filter.RSSI != nil {
+		args["RSSI"] = dbus.MakeVariant(*filter.RSSI)
+	}
+	if len(filter.UUIDs) > 0 {
+		args["UUIDs"] = dbus.MakeVariant(filter.UUIDs)
+	}
+
+	adapter := a.adapterObject()
+	call := adapter.Call(bluezAdapterIface+".SetDiscoveryFilter", 0, args)
+	if call.Err != nil {
+		log.Printf("Failed to set discovery filter: %v", call.Err)
+		return fmt.Errorf("failed to set discovery filter: %w", call.Err)
+	}
+	return nil
+}
+
+// updateDiscoveryFilter applies the merged filter of the active sessions when
+// it changed. a.scanMu must be held.
+func (a *Adapter) updateDiscoveryFilter() {
+	a.mu.Lock()
+	filter := a.sessionFilterLocked()
+	changed := !filter.equal(a.filter)
+	a.filter = filter
 	a.mu.Unlock()
 
+	if !changed {
+		return
+	}
+	if err := a.applyDiscoveryFilter(filter); err != nil {
+		log.Printf("Warning: keeping the previous discovery filter: %v", err)
+	}
+}
+
+// StartDiscovery begins scanning for Bluetooth devices.
+// Each call opens a scan session bound to ctx: the session ends when ctx is
+// done, and discovery stops once no session is left. Callers must cancel ctx
+// (or give it a deadline) to avoid leaving discovery running forever.
+// While sessions overlap, discovery reports the devices any of their filters
+// accepts; an empty filter reports every device.
+func (a *Adapter) StartDiscovery(ctx context.Context, filter DiscoveryFilter) error {
+	if err := filter.validate(); err != nil {
+		return err
+	}
+	if !a.IsAvailable() {
+		return ErrUnavailable
+	}
+
+	session := &scanSession{filter: filter}
+
+	// Starts and stops run one at a time, so that a session never joins a
+	// scan that is being stopped
+	a.scanMu.Lock()
+	defer a.scanMu.Unlock()
+
+	a.mu.Lock()
+	a.scanSessions[session] = struct{}{}
+	alreadyScanning := a.scanning
+	a.mu.Unlock()
+
+	// End the session when the caller is done with it
+	go func() {
+		<-ctx.Done()
+		a.endScanSession(session)
+	}()
+
+	if alreadyScanning {
+		log.Println("Discovery already in progress, joining existing scan")
+		a.updateDiscoveryFilter()
+		return nil
+	}
+
 	// Ensure adapter is powered on before starting discovery
 	// This is intentionally called again (also at init) because the adapter
 	// might have been powered off by rfkill or another process since startup
 	if err := a.ensurePoweredOn(); err != nil {
-		a.mu.Lock()
-		a.scanning = false
-		a.mu.Unlock()
+		a.abortScanSession(session)
 		log.Printf("Failed to power on adapter: %v", err)
 		return fmt.Errorf("failed to power on adapter: %w", err)
 	}
 
-	adapter := a.conn.Object(bluezService, a.adapterPath)
+	adapter := a.adapterObject()
 
 	// Refresh device list to catch any devices registered by BlueZ since startup
 	a.loadExistingDevices()
 
+	a.mu.Lock()
+	a.filter = a.sessionFilterLocked()
+	applied := a.filter
+	a.mu.Unlock()
+	if err := a.applyDiscoveryFilter(applied); err != nil {
+		log.Printf("Warning: starting discovery without filter: %v", err)
+	}
+
 	log.Println("Starting Bluetooth discovery...")
 	call := adapter.Call(bluezAdapterIface+".StartDiscovery", 0)
 	if call.Err != nil {
-		a.mu.Lock()
-		a.scanning = false
-		a.mu.Unlock()
+		a.abortScanSession(session)
 		log.Printf("Failed to start discovery: %v", call.Err)
 		return fmt.Errorf("failed to start discovery: %w", call.Err)
 	}
 
+	a.mu.Lock()
+	a.scanning = true
+	a.mu.Unlock()
+
 	log.Println("Bluetooth discovery started successfully")
+	a.notifyScanChange(true)
 	return nil
 }
 
-// StopDiscovery stops scanning for Bluetooth devices
+// abortScanSession drops the session of a failed start. Sessions waiting to
+// join try to start discovery themselves.
+func (a *Adapter) abortScanSession(session *scanSession) {
+	a.mu.Lock()
+	delete(a.scanSessions, session)
+	a.mu.Unlock()
+}
+
+// endScanSession removes a session and stops discovery if it was the last one
+func (a *Adapter) endScanSession(session *scanSession) {
+	a.scanMu.Lock()
+	defer a.scanMu.Unlock()
+
+	a.mu.Lock()
+	if _, ok := a.scanSessions[session]; !ok {
+		a.mu.Unlock()
+		return
+	}
+	delete(a.scanSessions, session)
+	remaining := len(a.scanSessions)
+	a.mu.Unlock()
+
+	if remaining > 0 {
+		log.Printf("Scan session ended, %d session(s) still active", remaining)
+		a.updateDiscoveryFilter()
+		return
+	}
+
+	if err := a.stopDiscovery(); err != nil {
+		log.Printf("Failed to stop discovery after last scan session ended: %v", err)
+	}
+}
+
+// StopDiscovery stops scanning for Bluetooth devices, ending all scan sessions
 func (a *Adapter) StopDiscovery() error {
+	a.scanMu.Lock()
+	defer a.scanMu.Unlock()
+	return a.stopDiscovery()
+}
+
+// stopDiscovery stops scanning and ends the active sessions. a.scanMu must be
+// held, so that no session joins meanwhile.
+func (a *Adapter) stopDiscovery() error {
 	a.mu.Lock()
 	if !a.scanning {
+		a.scanSessions = make(map[*scanSession]struct{})
 		a.mu.Unlock()
 		log.Println("Discovery not in progress")
 		return nil
@@ -420,13 +997,17 @@ func (a *Adapter) StopDiscovery() error {
 	a.mu.Unlock()
 
 	log.Println("Stopping Bluetooth discovery...")
-	adapter := a.conn.Object(bluezService, a.adapterPath)
+	adapter := a.adapterObject()
 	call := adapter.Call(bluezAdapterIface+".StopDiscovery", 0)
 
 	a.mu.Lock()
 	a.scanning = false
+	a.scanSessions = make(map[*scanSession]struct{})
+	a.filter = DiscoveryFilter{}
 	a.mu.Unlock()
 
+	a.notifyScanChange(false)
+
 	if call.Err != nil {
 		// Ignore error if discovery was not started - check for D-Bus error
 		// BlueZ returns "org.bluez.Error.Failed" with message "No discovery started"
@@ -446,6 +1027,16 @@ func (a *Adapter) StopDiscovery() error {
 	return nil
 }
 
+func (a *Adapter) notifyScanChange(scanning bool) {
+	a.mu.RLock()
+	onScanChange := a.onScanChange
+	a.mu.RUnlock()
+
+	if onScanChange != nil {
+		go onScanChange(scanning)
+	}
+}
+
 // IsScanning returns whether discovery is active
 func (a *Adapter) IsScanning() bool {
 	a.mu.RLock()
@@ -454,7 +1045,7 @@ func (a *Adapter) IsScanning() bool {
 }
 
 func (a *Adapter) loadExistingDevices() {
-	obj := a.conn.Object(bluezService, "/")
+	obj := a.bus().Object(bluezService, "/")
 	var result map[dbus.ObjectPath]map[string]map[string]dbus.Variant
 
 	err := obj.Call(dbusObjectManager+".GetManagedObjects", 0).Store(&result)
@@ -462,17 +1053,13 @@ func (a *Adapter) loadExistingDevices() {
 		return
 	}
 
-	for path, ifaces := range result {
-		if props, ok := ifaces[bluezDeviceIface]; ok {
-			a.updateDevice(path, props)
-		}
-	}
+	a.applyManagedObjects(result)
 }
 
 // refreshDeviceProperties fetches all properties for a specific device from D-Bus
 // and updates the internal device state
 func (a *Adapter) refreshDeviceProperties(devicePath string) {
-	device := a.conn.Object(bluezService, dbus.ObjectPath(devicePath))
+	device := a.bus().Object(bluezService, dbus.ObjectPath(devicePath))
 
 	var props map[string]dbus.Variant
 	err := device.Call(dbusPropertiesIface+".GetAll", 0, bluezDeviceIface).Store(&props)
@@ -485,16 +1072,68 @@ func (a *Adapter) refreshDeviceProperties(devicePath string) {
 	log.Printf("Refreshed properties for device: %s", devicePath)
 }
 
+// setDeviceProperty writes a single Device1 property and refreshes the device state
+func (a *Adapter) setDeviceProperty(address, name string, value interface{}) error {
+	return a.runDeviceOp(context.Background(), address, opUpdate, func(ctx context.Context) error {
+		return a.writeDeviceProperty(address, name, value)
+	})
+}
+
+func (a *Adapter) writeDeviceProperty(address, name string, value interface{}) error {
+	devicePath := a.getDevicePath(address)
+	if devicePath == "" {
+		log.Printf("Device not found: %s", address)
+		return fmt.Errorf("%w: %s", ErrDeviceNotFound, address)
+	}
+
+	device := a.bus().Object(bluezService, dbus.ObjectPath(devicePath))
+	call := device.Call(dbusPropertiesIface+".Set", 0, bluezDeviceIface, name, dbus.MakeVariant(value))
+	if call.Err != nil {
+		log.Printf("Failed to set %s on %s: %v", name, address, call.Err)
+		return fmt.Errorf("failed to set %s: %w", name, call.Err)
+	}
+
+	// Refresh device properties to ensure we have the updated state
+	a.refreshDeviceProperties(devicePath)
+
+	return nil
+}
+
+// SetAlias renames a device locally. An empty alias restores the name
+// advertised by the device.
+func (a *Adapter) SetAlias(address, alias string) error {
+	alias = strings.TrimSpace(alias)
+	if len(alias) > maxAliasLength {
+		return fmt.Errorf("device name must be at most %d bytes", maxAliasLength)
+	}
+
+	log.Printf("Renaming device %s to %q", address, alias)
+	return a.setDeviceProperty(address, "Alias", alias)
+}
+
+// SetBlocked blocks or unblocks a device. BlueZ disconnects blocked devices
+// and rejects their incoming connections.
+func (a *Adapter) SetBlocked(address string, blocked bool) error {
+	log.Printf("Setting device %s blocked: %v", address, blocked)
+	return a.setDeviceProperty(address, "Blocked", blocked)
+}
+
 // Trust sets a device as trusted
 func (a *Adapter) Trust(address string) error {
+	return a.runDeviceOp(context.Background(), address, opTrust, func(ctx context.Context) error {
+		return a.trust(address)
+	})
+}
+
+func (a *Adapter) trust(address string) error {
 	log.Printf("Trusting device: %s", address)
 	devicePath := a.getDevicePath(address)
 	if devicePath == "" {
 		log.Printf("Device not found: %s", address)
-		return fmt.Errorf("device not found: %s", address)
+		return fmt.Errorf("%w: %s", ErrDeviceNotFound, address)
 	}
 
-	device := a.conn.Object(bluezService, dbus.ObjectPath(devicePath))
+	device := a.bus().Object(bluezService, dbus.ObjectPath(devicePath))
 	call := device.Call(dbusPropertiesIface+".Set", 0, bluezDeviceIface, "Trusted", dbus.MakeVariant(true))
 	if call.Err != nil {
 		log.Printf("Failed to trust %s: %v", address, call.Err)
@@ -509,18 +1148,33 @@ func (a *Adapter) Trust(address string) error {
 	return nil
 }
 
-// Pair initiates pairing with a device
-func (a *Adapter) Pair(address string) error {
+// Pair initiates pairing with a device.
+// Canceling ctx aborts the pairing attempt.
+func (a *Adapter) Pair(ctx context.Context, address string) error {
+	return a.runDeviceOp(ctx, address, opPair, func(ctx context.Context) error {
+		return a.pair(ctx, address)
+	})
+}
+
+func (a *Adapter) pair(ctx context.Context, address string) error {
 	log.Printf("Pairing with device: %s", address)
 	devicePath := a.getDevicePath(address)
 	if devicePath == "" {
 		log.Printf("Device not found: %s", address)
-		return fmt.Errorf("device not found: %s", address)
+		return fmt.Errorf("%w: %s", ErrDeviceNotFound, address)
 	}
 
-	device := a.conn.Object(bluezService, dbus.ObjectPath(devicePath))
-	call := device.Call(bluezDeviceIface+".Pair", 0)
+	device := a.bus().Object(bluezService, dbus.ObjectPath(devicePath))
+	call := device.CallWithContext(ctx, bluezDeviceIface+".Pair", 0)
 	if call.Err != nil {
+		if ctx.Err() != nil {
+			// BlueZ keeps pairing unless told otherwise
+			log.Printf("Pairing with %s canceled", address)
+			if err := device.Call(bluezDeviceIface+".CancelPairing", 0).Err; err != nil {
+				log.Printf("Failed to cancel pairing with %s: %v", address, err)
+			}
+			return fmt.Errorf("pairing canceled: %w", ctx.Err())
+		}
 		log.Printf("Failed to pair with %s: %v", address, call.Err)
 		return fmt.Errorf("failed to pair: %w", call.Err)
 	}
@@ -533,24 +1187,39 @@ func (a *Adapter) Pair(address string) error {
 	return nil
 }
 
-// Connect connects to a paired device and trusts it
-func (a *Adapter) Connect(address string) error {
+// Connect connects to a paired device and trusts it.
+// Canceling ctx aborts the connection attempt.
+func (a *Adapter) Connect(ctx context.Context, address string) error {
+	return a.runDeviceOp(ctx, address, opConnect, func(ctx context.Context) error {
+		return a.connect(ctx, address)
+	})
+}
+
+func (a *Adapter) connect(ctx context.Context, address string) error {
 	log.Printf("Connecting to device: %s", address)
 	devicePath := a.getDevicePath(address)
 	if devicePath == "" {
 		log.Printf("Device not found: %s", address)
-		return fmt.Errorf("device not found: %s", address)
+		return fmt.Errorf("%w: %s", ErrDeviceNotFound, address)
 	}
 
 	// Trust the device before connecting
-	if err := a.Trust(address); err != nil {
+	if err := a.trust(address); err != nil {
 		log.Printf("Warning: Failed to trust device before connecting: %v", err)
 		// Continue with connection even if trust fails
 	}
 
-	device := a.conn.Object(bluezService, dbus.ObjectPath(devicePath))
-	call := device.Call(bluezDeviceIface+".Connect", 0)
+	device := a.bus().Object(bluezService, dbus.ObjectPath(devicePath))
+	call := device.CallWithContext(ctx, bluezDeviceIface+".Connect", 0)
 	if call.Err != nil {
+		if ctx.Err() != nil {
+			// Tear down whatever profiles BlueZ managed to connect meanwhile
+			log.Printf("Connection to %s canceled", address)
+			if err := device.Call(bluezDeviceIface+".Disconnect", 0).Err; err != nil {
+				log.Printf("Failed to abort connection to %s: %v", address, err)
+			}
+			return fmt.Errorf("connection canceled: %w", ctx.Err())
+		}
 		log.Printf("Failed to connect to %s: %v", address, call.Err)
 		return fmt.Errorf("failed to connect: %w", call.Err)
 	}
@@ -564,15 +1233,21 @@ func (a *Adapter) Connect(address string) error {
 }
 
 // Disconnect disconnects from a device
-func (a *Adapter) Disconnect(address string) error {
+func (a *Adapter) Disconnect(ctx context.Context, address string) error {
+	return a.runDeviceOp(ctx, address, opDisconnect, func(ctx context.Context) error {
+		return a.disconnect(address)
+	})
+}
+
+func (a *Adapter) disconnect(address string) error {
 	log.Printf("Disconnecting from device: %s", address)
 	devicePath := a.getDevicePath(address)
 	if devicePath == "" {
 		log.Printf("Device not found: %s", address)
-		return fmt.Errorf("device not found: %s", address)
+		return fmt.Errorf("%w: %s", ErrDeviceNotFound, address)
 	}
 
-	device := a.conn.Object(bluezService, dbus.ObjectPath(devicePath))
+	device := a.bus().Object(bluezService, dbus.ObjectPath(devicePath))
 	call := device.Call(bluezDeviceIface+".Disconnect", 0)
 	if call.Err != nil {
 		log.Printf("Failed to disconnect from %s: %v", address, call.Err)
@@ -588,34 +1263,39 @@ func (a *Adapter) Disconnect(address string) error {
 }
 
 // Remove unpairs and removes a device
-func (a *Adapter) Remove(address string) error {
+func (a *Adapter) Remove(ctx context.Context, address string) error {
+	return a.runDeviceOp(ctx, address, opRemove, func(ctx context.Context) error {
+		return a.remove(address)
+	})
+}
+
+func (a *Adapter) remove(address string) error {
 	log.Printf("Removing device: %s", address)
 	devicePath := a.getDevicePath(address)
 	if devicePath == "" {
 		log.Printf("Device not found: %s", address)
-		return fmt.Errorf("device not found: %s", address)
+		return fmt.Errorf("%w: %s", ErrDeviceNotFound, address)
 	}
 
-	adapter := a.conn.Object(bluezService, a.adapterPath)
+	adapter := a.adapterObject()
 	call := adapter.Call(bluezAdapterIface+".RemoveDevice", 0, dbus.ObjectPath(devicePath))
 	if call.Err != nil {
 		log.Printf("Failed to remove device %s: %v", address, call.Err)
 		return fmt.Errorf("failed to remove device: %w", call.Err)
 	}
 
-	// Manually remove the device from the internal map and trigger onChange
+	// Manually remove the device from the internal map and notify listeners
 	// This ensures the UI updates immediately without waiting for the D-Bus signal
-	// Using a check to avoid duplicate onChange calls if the D-Bus signal arrives first
+	// Using a check to avoid duplicate notifications if the D-Bus signal arrives first
 	a.mu.Lock()
 	_, exists := a.devices[devicePath]
 	if exists {
 		delete(a.devices, devicePath)
 	}
-	onChange := a.onChange
 	a.mu.Unlock()
 
-	if exists && onChange != nil {
-		go onChange(a.GetDevices())
+	if exists {
+		a.notifyChange()
 	}
 
 	log.Printf("Successfully removed device: %s", address)
@@ -625,7 +1305,7 @@ func (a *Adapter) Remove(address string) error {
 func (a *Adapter) getDevicePath(address string) string {
 	// Convert address format: XX:XX:XX:XX:XX:XX -> dev_XX_XX_XX_XX_XX_XX
 	devAddr := "dev_" + strings.ReplaceAll(address, ":", "_")
-	return string(a.adapterPath) + "/" + devAddr
+	return string(a.path()) + "/" + devAddr
 }
 
 // Close cleans up resources
@@ -636,21 +1316,20 @@ func (a *Adapter) Close() error {
 	// Stop the signal handling goroutine
 	close(a.stopSignals)
 
-	err := a.conn.Close()
+	err := a.bus().Close()
 	log.Println("Bluetooth adapter closed")
 	return err
 }
 
 // ScanFor scans for devices for the specified duration
 func (a *Adapter) ScanFor(ctx context.Context, duration time.Duration) error {
-	if err := a.StartDiscovery(ctx); err != nil {
-		return err
-	}
+	scanCtx, cancel := context.WithTimeout(ctx, duration)
+	defer cancel()
 
-	select {
-	case <-time.After(duration):
-	case <-ctx.Done():
+	if err := a.StartDiscovery(scanCtx, DiscoveryFilter{}); err != nil {
+		return err
 	}
 
-	return a.StopDiscovery()
+	<-scanCtx.Done()
+	return nil
 }
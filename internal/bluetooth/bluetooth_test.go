@@ -0,0 +1,156 @@
+package bluetooth
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestMergeDiscoveryFilters(t *testing.T) {
+	rssi := func(v int16) *int16 { return &v }
+	audio := []string{AudioSinkUUID}
+	const heartRate = "0000180d-0000-1000-8000-00805f9b34fb"
+
+	tests := []struct {
+		name    string
+		filters []DiscoveryFilter
+		want    DiscoveryFilter
+	}{
+		{"none", nil, DiscoveryFilter{}},
+		{
+			"single",
+			[]DiscoveryFilter{{Transport: "le", RSSI: rssi(-70), UUIDs: audio}},
+			DiscoveryFilter{Transport: "le", RSSI: rssi(-70), UUIDs: audio},
+		},
+		{
+			"weakest threshold and all services",
+			[]DiscoveryFilter{{RSSI: rssi(-60), UUIDs: audio}, {RSSI: rssi(-80), UUIDs: []string{heartRate}}},
+			DiscoveryFilter{RSSI: rssi(-80), UUIDs: []string{AudioSinkUUID, heartRate}},
+		},
+		{
+			"unfiltered session wins",
+			[]DiscoveryFilter{{Transport: "le", RSSI: rssi(-60), UUIDs: audio}, {}},
+			DiscoveryFilter{Transport: "auto"},
+		},
+		{
+			"different transports",
+			[]DiscoveryFilter{{Transport: "le"}, {Transport: "bredr"}},
+			DiscoveryFilter{Transport: "auto"},
+		},
+	}
+	for _, tt := range tests {
+		got := mergeDiscoveryFilters(tt.filters)
+		if !got.equal(tt.want) {
+			t.Errorf("%s: merged = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	// Merging must not depend on the order of the sessions
+	a := mergeDiscoveryFilters([]DiscoveryFilter{{UUIDs: []string{heartRate}}, {UUIDs: audio}})
+	b := mergeDiscoveryFilters([]DiscoveryFilter{{UUIDs: audio}, {UUIDs: []string{heartRate}}})
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("merge depends on order: %+v != %+v", a, b)
+	}
+}
+
+func TestEndScanSessionIgnoresEndedSessions(t *testing.T) {
+	a := &Adapter{scanSessions: make(map[*scanSession]struct{})}
+	current := &scanSession{}
+	a.scanSessions[current] = struct{}{}
+	a.scanning = true
+
+	// A session already ended by StopDiscovery must not stop a newer one
+	a.endScanSession(&scanSession{})
+
+	if _, ok := a.scanSessions[current]; !ok || !a.IsScanning() {
+		t.Error("ending a stale session ended the current scan")
+	}
+}
+
+func TestNotifyChangeCoalesces(t *testing.T) {
+	a := &Adapter{
+		devices:     map[string]*Device{"/org/bluez/hci0/dev_01": {Address: "01", Name: "Speaker", Class: 0x240414}},
+		changed:     make(chan struct{}, 1),
+		stopSignals: make(chan struct{}),
+	}
+	calls := make(chan []*Device, 10)
+	release := make(chan struct{})
+	a.SetOnChange(func(devices []*Device) {
+		calls <- devices
+		<-release
+	})
+	go a.notifyLoop()
+	defer close(a.stopSignals)
+
+	// The first change is being delivered while more arrive
+	a.notifyChange()
+	<-calls
+	a.mu.Lock()
+	a.devices["/org/bluez/hci0/dev_02"] = &Device{Address: "02", Name: "Headphones", Class: 0x240404}
+	a.mu.Unlock()
+	for i := 0; i < 5; i++ {
+		a.notifyChange()
+	}
+	release <- struct{}{}
+
+	// They are merged into one call with the latest devices
+	select {
+	case devices := <-calls:
+		if len(devices) != 2 {
+			t.Errorf("snapshot has %d device(s), want 2", len(devices))
+		}
+	case <-time.After(time.Second):
+		t.Fatal("merged change not delivered")
+	}
+	release <- struct{}{}
+
+	select {
+	case <-calls:
+		t.Error("changes were not merged")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestAdapterChangesDeliveredInOrder(t *testing.T) {
+	a := &Adapter{
+		changed:        make(chan struct{}, 1),
+		adapterChanged: make(chan struct{}, 1),
+		stopSignals:    make(chan struct{}),
+	}
+	calls := make(chan AdapterInfo, 10)
+	release := make(chan struct{})
+	a.SetOnAdapterChange(func(info AdapterInfo) {
+		calls <- info
+		<-release
+	})
+	go a.notifyLoop()
+	defer close(a.stopSignals)
+
+	// Discoverable turns on, then times out while the first change is delivered
+	a.updateAdapter(map[string]dbus.Variant{"Discoverable": dbus.MakeVariant(true)})
+	if info := <-calls; !info.Discoverable {
+		t.Fatal("first change is not discoverable")
+	}
+	a.updateAdapter(map[string]dbus.Variant{"Discoverable": dbus.MakeVariant(false)})
+	a.updateAdapter(map[string]dbus.Variant{"Alias": dbus.MakeVariant("Living room")})
+	release <- struct{}{}
+
+	// The listener ends with the latest state, not an older snapshot
+	select {
+	case info := <-calls:
+		if info.Discoverable || info.Alias != "Living room" {
+			t.Errorf("latest change = %+v, want not discoverable and renamed", info)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("merged change not delivered")
+	}
+	release <- struct{}{}
+
+	select {
+	case <-calls:
+		t.Error("adapter changes were not merged")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
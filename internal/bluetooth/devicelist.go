@@ -0,0 +1,199 @@
+package bluetooth
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	defaultStaleAfter = 10 * time.Minute // Unpaired devices not seen for this long are forgotten
+	pruneInterval     = 30 * time.Second // How often stale devices are looked for
+	unknownRSSI       = -100             // RSSI used for sorting devices that did not report one
+)
+
+// isLEOnly reports whether the device was only seen over Bluetooth Low Energy.
+// Classic (BR/EDR) devices always report a Class of Device, and random
+// addresses are only used over LE.
+func (d *Device) isLEOnly() bool {
+	return d.AddressType == "random" || d.Class == 0
+}
+
+// hasName reports whether the device advertises a name or was renamed by the
+// user. BlueZ defaults the alias of a nameless device to its address with dashes.
+func (d *Device) hasName() bool {
+	if d.Name != "" {
+		return true
+	}
+	return d.Alias != "" && !strings.EqualFold(d.Alias, strings.ReplaceAll(d.Address, ":", "-"))
+}
+
+// isHidden reports whether the device is left out of the device list by default:
+// nameless LE-only devices (beacons, trackers, ...) that are neither paired nor connected
+func (d *Device) isHidden() bool {
+	return !d.hasName() && d.isLEOnly() && !d.Paired && !d.Connected
+}
+
+// isRenamed reports whether the user set an alias for the device.
+// BlueZ defaults the alias to the device name, or to its address with dashes.
+func (d *Device) isRenamed() bool {
+	return d.Alias != "" && d.Alias != d.Name && !strings.EqualFold(d.Alias, strings.ReplaceAll(d.Address, ":", "-"))
+}
+
+// isStale reports whether an unpaired device has not been seen since before cutoff.
+// Blocked, trusted and renamed devices are kept: removing them from BlueZ
+// would lose the settings the user made. So are devices an operation runs on.
+func (d *Device) isStale(cutoff time.Time) bool {
+	if d.Paired || d.Connected || d.Blocked || d.Trusted || d.isRenamed() || d.Busy != "" {
+		return false
+	}
+	return d.LastSeen.Before(cutoff)
+}
+
+// sortDevices orders devices with connected devices first, then paired ones,
+// then by signal strength. Name and address break ties so the order is stable.
+func sortDevices(devices []*Device) {
+	sort.SliceStable(devices, func(i, j int) bool {
+		a, b := devices[i], devices[j]
+		if a.Connected != b.Connected {
+			return a.Connected
+		}
+		if a.Paired != b.Paired {
+			return a.Paired
+		}
+		if rssiA, rssiB := sortRSSI(a), sortRSSI(b); rssiA != rssiB {
+			return rssiA > rssiB
+		}
+		if nameA, nameB := strings.ToLower(a.displayName()), strings.ToLower(b.displayName()); nameA != nameB {
+			return nameA < nameB
+		}
+		return a.Address < b.Address
+	})
+}
+
+func sortRSSI(d *Device) int16 {
+	if d.RSSI == 0 {
+		return unknownRSSI
+	}
+	return d.RSSI
+}
+
+func (d *Device) displayName() string {
+	if d.Alias != "" {
+		return d.Alias
+	}
+	return d.Name
+}
+
+// SetShowUnnamed includes or excludes nameless Low Energy devices from GetDevices
+func (a *Adapter) SetShowUnnamed(show bool) {
+	a.mu.Lock()
+	a.showUnnamed = show
+	a.mu.Unlock()
+
+	a.notifyChange()
+}
+
+// ShowUnnamed returns whether nameless Low Energy devices are listed
+func (a *Adapter) ShowUnnamed() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.showUnnamed
+}
+
+// HiddenDeviceCount returns the number of devices left out of GetDevices
+func (a *Adapter) HiddenDeviceCount() int {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	if a.showUnnamed {
+		return 0
+	}
+	count := 0
+	for _, d := range a.devices {
+		if d.isHidden() {
+			count++
+		}
+	}
+	return count
+}
+
+// SetStaleAfter sets how long unpaired devices are kept after they were last seen.
+// A zero duration keeps them forever.
+func (a *Adapter) SetStaleAfter(d time.Duration) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.staleAfter = d
+}
+
+func (a *Adapter) pruneLoop() {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stopSignals:
+			return
+		case now := <-ticker.C:
+			a.pruneStaleDevices(now)
+		}
+	}
+}
+
+// pruneStaleDevices forgets unpaired devices that have not been seen for a while,
+// unless the user blocked, trusted or renamed them.
+// They are removed from BlueZ too, otherwise loadExistingDevices would list
+// them again on the next scan or start.
+func (a *Adapter) pruneStaleDevices(now time.Time) {
+	a.mu.Lock()
+	if a.staleAfter <= 0 {
+		a.mu.Unlock()
+		return
+	}
+
+	staleAfter := a.staleAfter
+	cutoff := now.Add(-staleAfter)
+	pruned := make(map[string]string) // Address by path
+	for path, d := range a.devices {
+		if d.isStale(cutoff) {
+			delete(a.devices, path)
+			pruned[path] = d.Address
+		}
+	}
+	available := a.info.Available
+	a.mu.Unlock()
+
+	if len(pruned) == 0 {
+		return
+	}
+
+	log.Printf("Forgot %d device(s) not seen for %s", len(pruned), staleAfter)
+	if available {
+		for path, address := range pruned {
+			go a.removeFromBlueZ(path, address)
+		}
+	}
+	a.notifyChange()
+}
+
+// removeFromBlueZ deletes a forgotten device from the BlueZ device cache. It
+// is queued with the other operations on the device, so that it never runs
+// during a pairing, and skipped if BlueZ reported the device again meanwhile.
+func (a *Adapter) removeFromBlueZ(path, address string) {
+	err := a.runDeviceOp(context.Background(), address, opRemove, func(ctx context.Context) error {
+		a.mu.RLock()
+		_, back := a.devices[path]
+		a.mu.RUnlock()
+		if back {
+			return nil
+		}
+		return a.adapterObject().CallWithContext(ctx, bluezAdapterIface+".RemoveDevice", 0, dbus.ObjectPath(path)).Err
+	})
+	if err != nil {
+		log.Printf("Warning: Failed to remove stale device %s from BlueZ: %v", path, err)
+	}
+}
@@ -0,0 +1,163 @@
+package bluetooth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSortDevices(t *testing.T) {
+	devices := []*Device{
+		{Address: "00:00:00:00:00:01", Name: "Far", RSSI: -90},
+		{Address: "00:00:00:00:00:02", Name: "Paired", Paired: true, RSSI: -80},
+		{Address: "00:00:00:00:00:03", Name: "Unknown signal"},
+		{Address: "00:00:00:00:00:04", Name: "Connected", Paired: true, Connected: true},
+		{Address: "00:00:00:00:00:05", Name: "Near", RSSI: -40},
+		{Address: "00:00:00:00:00:06", Name: "beta", RSSI: -60},
+		{Address: "00:00:00:00:00:07", Alias: "Alpha", Name: "zeta", RSSI: -60},
+	}
+
+	sortDevices(devices)
+
+	expected := []string{
+		"00:00:00:00:00:04",
+		"00:00:00:00:00:02",
+		"00:00:00:00:00:05",
+		"00:00:00:00:00:07",
+		"00:00:00:00:00:06",
+		"00:00:00:00:00:01",
+		"00:00:00:00:00:03",
+	}
+	for i, address := range expected {
+		if devices[i].Address != address {
+			t.Errorf("devices[%d] = %s, want %s", i, devices[i].Address, address)
+		}
+	}
+}
+
+func TestDeviceIsHidden(t *testing.T) {
+	tests := []struct {
+		name     string
+		device   Device
+		expected bool
+	}{
+		{
+			name:     "nameless LE beacon",
+			device:   Device{AddressType: "random"},
+			expected: true,
+		},
+		{
+			name:     "nameless device without class",
+			device:   Device{AddressType: "public"},
+			expected: true,
+		},
+		{
+			name:     "named LE device",
+			device:   Device{Name: "Tracker", AddressType: "random"},
+			expected: false,
+		},
+		{
+			name:     "nameless LE device with the default alias",
+			device:   Device{Address: "AA:BB:CC:DD:EE:01", Alias: "AA-BB-CC-DD-EE-01", AddressType: "random"},
+			expected: true,
+		},
+		{
+			name:     "nameless LE device renamed by the user",
+			device:   Device{Address: "AA:BB:CC:DD:EE:01", Alias: "Desk tag", AddressType: "random"},
+			expected: false,
+		},
+		{
+			name:     "nameless classic device",
+			device:   Device{AddressType: "public", Class: 0x240404},
+			expected: false,
+		},
+		{
+			name:     "nameless paired LE device",
+			device:   Device{AddressType: "random", Paired: true},
+			expected: false,
+		},
+		{
+			name:     "nameless connected LE device",
+			device:   Device{AddressType: "random", Connected: true},
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.device.isHidden(); result != tt.expected {
+				t.Errorf("isHidden() = %v, want %v", result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestPruneStaleDevices(t *testing.T) {
+	now := time.Now()
+	adapter := &Adapter{
+		staleAfter: 10 * time.Minute,
+		devices: map[string]*Device{
+			"/org/bluez/hci0/dev_01": {Address: "01", LastSeen: now.Add(-time.Minute)},
+			"/org/bluez/hci0/dev_02": {Address: "02", LastSeen: now.Add(-time.Hour)},
+			"/org/bluez/hci0/dev_03": {Address: "03", LastSeen: now.Add(-time.Hour), Paired: true},
+			"/org/bluez/hci0/dev_04": {Address: "04", LastSeen: now.Add(-time.Hour), Connected: true},
+			"/org/bluez/hci0/dev_05": {Address: "05", LastSeen: now.Add(-time.Hour), Blocked: true},
+			"/org/bluez/hci0/dev_06": {Address: "06", LastSeen: now.Add(-time.Hour), Trusted: true},
+			"/org/bluez/hci0/dev_07": {Address: "07", LastSeen: now.Add(-time.Hour), Name: "Speaker", Alias: "Neighbour"},
+			"/org/bluez/hci0/dev_08": {Address: "08", LastSeen: now.Add(-time.Hour), Name: "Speaker", Alias: "Speaker"},
+			"/org/bluez/hci0/dev_09": {Address: "09", LastSeen: now.Add(-time.Hour), Busy: opPair},
+		},
+	}
+
+	adapter.pruneStaleDevices(now)
+
+	for _, path := range []string{"/org/bluez/hci0/dev_02", "/org/bluez/hci0/dev_08"} {
+		if _, ok := adapter.devices[path]; ok {
+			t.Errorf("stale unpaired device %s should have been pruned", path)
+		}
+	}
+	for _, path := range []string{
+		"/org/bluez/hci0/dev_01", "/org/bluez/hci0/dev_03", "/org/bluez/hci0/dev_04",
+		"/org/bluez/hci0/dev_05", "/org/bluez/hci0/dev_06", "/org/bluez/hci0/dev_07",
+		"/org/bluez/hci0/dev_09",
+	} {
+		if _, ok := adapter.devices[path]; !ok {
+			t.Errorf("%s should have been kept", path)
+		}
+	}
+}
+
+func TestPruneStaleDevicesDisabled(t *testing.T) {
+	now := time.Now()
+	adapter := &Adapter{
+		devices: map[string]*Device{
+			"/org/bluez/hci0/dev_01": {Address: "01", LastSeen: now.Add(-24 * time.Hour)},
+		},
+	}
+
+	adapter.pruneStaleDevices(now)
+
+	if len(adapter.devices) != 1 {
+		t.Error("devices should be kept when stale pruning is disabled")
+	}
+}
+
+func TestGetDevicesHidesUnnamed(t *testing.T) {
+	adapter := &Adapter{
+		devices: map[string]*Device{
+			"/org/bluez/hci0/dev_01": {Address: "01", Name: "Speaker", Class: 0x240414},
+			"/org/bluez/hci0/dev_02": {Address: "02", AddressType: "random"},
+		},
+	}
+
+	if devices := adapter.GetDevices(); len(devices) != 1 || devices[0].Address != "01" {
+		t.Errorf("GetDevices() should only return the named device, got %d device(s)", len(devices))
+	}
+	if count := adapter.HiddenDeviceCount(); count != 1 {
+		t.Errorf("HiddenDeviceCount() = %d, want 1", count)
+	}
+
+	adapter.showUnnamed = true
+	if devices := adapter.GetDevices(); len(devices) != 2 {
+		t.Errorf("GetDevices() = %d device(s), want 2 when unnamed devices are shown", len(devices))
+	}
+}
@@ -0,0 +1,181 @@
+package bluetooth
+
+import (
+	"context"
+	"log"
+	"strings"
+)
+
+// Kinds of device operations, reported in Device.Busy
+const (
+	opPair       = "pair"
+	opConnect    = "connect"
+	opDisconnect = "disconnect"
+	opRemove     = "remove"
+	opTrust      = "trust"
+	opUpdate     = "update" // Property change (alias, blocked...)
+)
+
+// mergeableOps lists the operations for which a duplicate request joins the
+// one already queued instead of running again. Property updates carry a
+// value and are never merged.
+var mergeableOps = map[string]bool{
+	opPair:       true,
+	opConnect:    true,
+	opDisconnect: true,
+	opRemove:     true,
+	opTrust:      true,
+}
+
+// deviceOp is an operation queued on a device
+type deviceOp struct {
+	kind    string
+	ctx     context.Context // Canceled once every request waiting for the operation is
+	cancel  context.CancelFunc
+	waiters int           // Requests still waiting for the result, guarded by deviceOpsMu
+	ready   chan struct{} // Closed when the operation reaches the head of the queue
+	done    chan struct{} // Closed when the operation finished, err is set by then
+	err     error
+}
+
+// runDeviceOp runs fn once every operation queued before it on the same
+// device has finished, so operations on one device never overlap.
+// A request for the operation at the end of the queue, running or not,
+// waits for it and returns its result instead of running fn. An operation
+// with another one queued after it is never joined: its result would be
+// outdated by the time the caller gets it.
+//
+// Canceling ctx only gives up on the result: the operation itself is
+// canceled once every request waiting for it was.
+func (a *Adapter) runDeviceOp(ctx context.Context, address, kind string, fn func(ctx context.Context) error) error {
+	address = strings.ToUpper(address)
+
+	a.deviceOpsMu.Lock()
+	if a.deviceOps == nil {
+		a.deviceOps = make(map[string][]*deviceOp)
+	}
+	queue := a.deviceOps[address]
+	// An operation nobody waits for anymore is being canceled and cannot be joined
+	if n := len(queue); n > 0 && mergeableOps[kind] && queue[n-1].kind == kind && queue[n-1].waiters > 0 {
+		last := queue[n-1]
+		last.waiters++
+		onQueued := a.onDeviceOpQueued
+		a.deviceOpsMu.Unlock()
+		log.Printf("Joining %s operation already in progress for %s", kind, address)
+		if onQueued != nil {
+			onQueued(kind, true)
+		}
+		return a.waitDeviceOp(ctx, last)
+	}
+
+	opCtx, cancel := context.WithCancel(context.Background())
+	op := &deviceOp{
+		kind:    kind,
+		ctx:     opCtx,
+		cancel:  cancel,
+		waiters: 1,
+		ready:   make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	a.deviceOps[address] = append(queue, op)
+	if len(queue) == 0 {
+		close(op.ready)
+	} else {
+		log.Printf("Queued %s operation for %s behind %d other(s)", kind, address, len(queue))
+	}
+	onQueued := a.onDeviceOpQueued
+	a.deviceOpsMu.Unlock()
+
+	if onQueued != nil {
+		onQueued(kind, false)
+	}
+
+	go a.execDeviceOp(address, op, fn)
+	return a.waitDeviceOp(ctx, op)
+}
+
+// execDeviceOp runs op once it reaches the head of the queue, unless every
+// request for it was canceled meanwhile
+func (a *Adapter) execDeviceOp(address string, op *deviceOp, fn func(ctx context.Context) error) {
+	select {
+	case <-op.ready:
+	case <-op.ctx.Done():
+	}
+	if err := op.ctx.Err(); err != nil {
+		a.finishDeviceOp(address, op, err)
+		return
+	}
+
+	a.setBusy(address, op.kind)
+	err := fn(op.ctx)
+	a.finishDeviceOp(address, op, err)
+}
+
+// waitDeviceOp returns the result of op, or the error of ctx if it is
+// canceled first. The last request to give up cancels the operation.
+func (a *Adapter) waitDeviceOp(ctx context.Context, op *deviceOp) error {
+	select {
+	case <-op.done:
+		return op.err
+	case <-ctx.Done():
+	}
+
+	a.deviceOpsMu.Lock()
+	op.waiters--
+	if op.waiters == 0 {
+		op.cancel()
+	}
+	a.deviceOpsMu.Unlock()
+	return ctx.Err()
+}
+
+// finishDeviceOp removes op from the queue of the device and lets the next
+// operation start
+func (a *Adapter) finishDeviceOp(address string, op *deviceOp, err error) {
+	a.deviceOpsMu.Lock()
+	queue := a.deviceOps[address]
+	wasRunning := len(queue) > 0 && queue[0] == op
+
+	remaining := make([]*deviceOp, 0, len(queue))
+	for _, queued := range queue {
+		if queued != op {
+			remaining = append(remaining, queued)
+		}
+	}
+	if len(remaining) == 0 {
+		delete(a.deviceOps, address)
+	} else {
+		a.deviceOps[address] = remaining
+		if wasRunning {
+			close(remaining[0].ready)
+		}
+	}
+
+	a.deviceOpsMu.Unlock()
+
+	// The device is idle again by the time requests get the result
+	if wasRunning && len(remaining) == 0 {
+		a.setBusy(address, "")
+	}
+
+	op.err = err
+	close(op.done)
+	op.cancel()
+}
+
+// setBusy records the operation running on a device and notifies listeners
+func (a *Adapter) setBusy(address, kind string) {
+	a.mu.Lock()
+	changed := false
+	for _, d := range a.devices {
+		if strings.EqualFold(d.Address, address) && d.Busy != kind {
+			d.Busy = kind
+			changed = true
+		}
+	}
+	a.mu.Unlock()
+
+	if changed {
+		a.notifyChange()
+	}
+}
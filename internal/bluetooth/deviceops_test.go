@@ -0,0 +1,363 @@
+package bluetooth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testAddress = "AA:BB:CC:DD:EE:FF"
+
+func newTestAdapter() *Adapter {
+	return &Adapter{
+		devices: map[string]*Device{
+			"/org/bluez/hci0/dev_AA_BB_CC_DD_EE_FF": {Address: testAddress, Name: "Speaker"},
+		},
+	}
+}
+
+// queueEvent is a request that was queued, or joined another one
+type queueEvent struct {
+	kind   string
+	joined bool
+}
+
+// watchQueue returns the requests queued on adapter as they are queued
+func watchQueue(adapter *Adapter) <-chan queueEvent {
+	events := make(chan queueEvent, 10)
+	adapter.onDeviceOpQueued = func(kind string, joined bool) {
+		events <- queueEvent{kind, joined}
+	}
+	return events
+}
+
+// expectQueued waits until the next request is queued
+func expectQueued(t *testing.T, events <-chan queueEvent, want queueEvent) {
+	t.Helper()
+	select {
+	case got := <-events:
+		if got != want {
+			t.Fatalf("queued %+v, want %+v", got, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("%+v was not queued", want)
+	}
+}
+
+func TestRunDeviceOpSerializes(t *testing.T) {
+	adapter := newTestAdapter()
+	events := watchQueue(adapter)
+
+	firstStarted := make(chan struct{})
+	releaseFirst := make(chan struct{})
+	var order []string
+	var mu sync.Mutex
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		adapter.runDeviceOp(context.Background(), testAddress, opPair, func(ctx context.Context) error {
+			close(firstStarted)
+			<-releaseFirst
+			mu.Lock()
+			order = append(order, opPair)
+			mu.Unlock()
+			return nil
+		})
+	}()
+
+	<-firstStarted
+	expectQueued(t, events, queueEvent{opPair, false})
+	go func() {
+		defer wg.Done()
+		adapter.runDeviceOp(context.Background(), "aa:bb:cc:dd:ee:ff", opRemove, func(ctx context.Context) error {
+			mu.Lock()
+			order = append(order, opRemove)
+			mu.Unlock()
+			return nil
+		})
+	}()
+
+	// The second operation must not start while the first one runs
+	expectQueued(t, events, queueEvent{opRemove, false})
+	mu.Lock()
+	if len(order) != 0 {
+		t.Fatalf("operation ran before the previous one finished: %v", order)
+	}
+	mu.Unlock()
+
+	close(releaseFirst)
+	wg.Wait()
+
+	if len(order) != 2 || order[0] != opPair || order[1] != opRemove {
+		t.Errorf("operations ran in order %v, want [pair remove]", order)
+	}
+}
+
+func TestRunDeviceOpMergesDuplicates(t *testing.T) {
+	adapter := newTestAdapter()
+	events := watchQueue(adapter)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	errConnect := errors.New("connection failed")
+	var runs int32
+
+	results := make(chan error, 2)
+	go func() {
+		results <- adapter.runDeviceOp(context.Background(), testAddress, opConnect, func(ctx context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			close(started)
+			<-release
+			return errConnect
+		})
+	}()
+
+	<-started
+	go func() {
+		results <- adapter.runDeviceOp(context.Background(), testAddress, opConnect, func(ctx context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		})
+	}()
+
+	expectQueued(t, events, queueEvent{opConnect, false})
+	expectQueued(t, events, queueEvent{opConnect, true})
+	close(release)
+
+	for i := 0; i < 2; i++ {
+		if err := <-results; !errors.Is(err, errConnect) {
+			t.Errorf("result %d = %v, want %v", i, err, errConnect)
+		}
+	}
+	if runs != 1 {
+		t.Errorf("connect ran %d times, want 1", runs)
+	}
+}
+
+func TestRunDeviceOpDoesNotJoinBehindConflicts(t *testing.T) {
+	adapter := newTestAdapter()
+	events := watchQueue(adapter)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var order []string
+	var mu sync.Mutex
+	run := func(kind string) func(ctx context.Context) error {
+		return func(ctx context.Context) error {
+			mu.Lock()
+			order = append(order, kind)
+			mu.Unlock()
+			return nil
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		adapter.runDeviceOp(context.Background(), testAddress, opConnect, func(ctx context.Context) error {
+			close(started)
+			<-release
+			return run(opConnect)(ctx)
+		})
+	}()
+	<-started
+	expectQueued(t, events, queueEvent{opConnect, false})
+
+	go func() {
+		defer wg.Done()
+		adapter.runDeviceOp(context.Background(), testAddress, opRemove, run(opRemove))
+	}()
+	expectQueued(t, events, queueEvent{opRemove, false})
+
+	// The running connect is followed by a remove: connecting again must
+	// run after it rather than report the first connect
+	go func() {
+		defer wg.Done()
+		adapter.runDeviceOp(context.Background(), testAddress, opConnect, run(opConnect))
+	}()
+	expectQueued(t, events, queueEvent{opConnect, false})
+
+	close(release)
+	wg.Wait()
+
+	if len(order) != 3 || order[0] != opConnect || order[1] != opRemove || order[2] != opConnect {
+		t.Errorf("operations ran in order %v, want [connect remove connect]", order)
+	}
+}
+
+func TestRunDeviceOpDoesNotMergeUpdates(t *testing.T) {
+	adapter := newTestAdapter()
+
+	var runs int32
+	for i := 0; i < 2; i++ {
+		adapter.runDeviceOp(context.Background(), testAddress, opUpdate, func(ctx context.Context) error {
+			atomic.AddInt32(&runs, 1)
+			return nil
+		})
+	}
+	if runs != 2 {
+		t.Errorf("update ran %d times, want 2", runs)
+	}
+}
+
+func TestRunDeviceOpJoinerOutlivesCanceledRequester(t *testing.T) {
+	adapter := newTestAdapter()
+	events := watchQueue(adapter)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	opCanceled := make(chan error, 1)
+	first, cancelFirst := context.WithCancel(context.Background())
+	firstResult := make(chan error, 1)
+	go func() {
+		firstResult <- adapter.runDeviceOp(first, testAddress, opPair, func(ctx context.Context) error {
+			close(started)
+			select {
+			case <-release:
+				return nil
+			case <-ctx.Done():
+				opCanceled <- ctx.Err()
+				return ctx.Err()
+			}
+		})
+	}()
+	<-started
+	expectQueued(t, events, queueEvent{opPair, false})
+
+	second, cancelSecond := context.WithCancel(context.Background())
+	defer cancelSecond()
+	secondResult := make(chan error, 1)
+	go func() {
+		secondResult <- adapter.runDeviceOp(second, testAddress, opPair, func(ctx context.Context) error {
+			t.Error("joined operation should not run again")
+			return nil
+		})
+	}()
+	expectQueued(t, events, queueEvent{opPair, true})
+
+	// The first requester gives up: only its own request fails
+	cancelFirst()
+	if err := <-firstResult; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled request returned %v, want %v", err, context.Canceled)
+	}
+	select {
+	case err := <-opCanceled:
+		t.Fatalf("operation canceled with %v while a request still waits for it", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The request that joined gets the result of the operation
+	close(release)
+	if err := <-secondResult; err != nil {
+		t.Errorf("joined request returned %v, want the operation result", err)
+	}
+}
+
+func TestRunDeviceOpCanceledByAllRequesters(t *testing.T) {
+	adapter := newTestAdapter()
+	events := watchQueue(adapter)
+
+	started := make(chan struct{})
+	opCanceled := make(chan struct{})
+	first, cancelFirst := context.WithCancel(context.Background())
+	results := make(chan error, 2)
+	go func() {
+		results <- adapter.runDeviceOp(first, testAddress, opConnect, func(ctx context.Context) error {
+			close(started)
+			<-ctx.Done()
+			close(opCanceled)
+			return ctx.Err()
+		})
+	}()
+	<-started
+	expectQueued(t, events, queueEvent{opConnect, false})
+
+	second, cancelSecond := context.WithCancel(context.Background())
+	go func() {
+		results <- adapter.runDeviceOp(second, testAddress, opConnect, func(ctx context.Context) error {
+			return nil
+		})
+	}()
+	expectQueued(t, events, queueEvent{opConnect, true})
+
+	cancelFirst()
+	cancelSecond()
+	select {
+	case <-opCanceled:
+	case <-time.After(time.Second):
+		t.Fatal("operation not canceled once every request was")
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-results; !errors.Is(err, context.Canceled) {
+			t.Errorf("result %d = %v, want %v", i, err, context.Canceled)
+		}
+	}
+}
+
+func TestRunDeviceOpCanceledWhileQueued(t *testing.T) {
+	adapter := newTestAdapter()
+	events := watchQueue(adapter)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go adapter.runDeviceOp(context.Background(), testAddress, opPair, func(ctx context.Context) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+	expectQueued(t, events, queueEvent{opPair, false})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := make(chan error, 1)
+	go func() {
+		result <- adapter.runDeviceOp(ctx, testAddress, opDisconnect, func(ctx context.Context) error {
+			t.Error("canceled operation should not run")
+			return nil
+		})
+	}()
+
+	expectQueued(t, events, queueEvent{opDisconnect, false})
+	cancel()
+	if err := <-result; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled operation returned %v, want %v", err, context.Canceled)
+	}
+
+	close(release)
+
+	// The queue must be usable again once the first operation finished
+	done := make(chan struct{})
+	go func() {
+		adapter.runDeviceOp(context.Background(), testAddress, opConnect, func(ctx context.Context) error {
+			return nil
+		})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("queue is stuck after a canceled operation")
+	}
+}
+
+func TestRunDeviceOpReportsBusy(t *testing.T) {
+	adapter := newTestAdapter()
+
+	adapter.runDeviceOp(context.Background(), testAddress, opConnect, func(ctx context.Context) error {
+		devices := adapter.GetDevices()
+		if len(devices) != 1 || devices[0].Busy != opConnect {
+			t.Errorf("device should be busy with %q while the operation runs", opConnect)
+		}
+		return nil
+	})
+
+	if devices := adapter.GetDevices(); devices[0].Busy != "" {
+		t.Errorf("Busy = %q after the operation finished, want empty", devices[0].Busy)
+	}
+}
@@ -0,0 +1,91 @@
+package bluetooth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/godbus/dbus/v5"
+)
+
+var (
+	// ErrUnavailable is returned when bluetoothd is not running or the adapter is gone
+	ErrUnavailable = errors.New("bluetooth adapter unavailable")
+
+	// ErrDeviceNotFound is returned when the address does not match a known device
+	ErrDeviceNotFound = errors.New("device not found")
+
+	// ErrNoAbsoluteVolume is returned for devices that do not stream or do
+	// not support absolute volume
+	ErrNoAbsoluteVolume = errors.New("device has no absolute volume")
+)
+
+// Error codes reported to clients, independent of the BlueZ error names
+const (
+	CodeCanceled         = "canceled"
+	CodeTimeout          = "timeout"
+	CodeUnavailable      = "unavailable"
+	CodeNotFound         = "not_found"
+	CodeAuthFailed       = "auth_failed"
+	CodeAuthRejected     = "auth_rejected"
+	CodeAuthCanceled     = "auth_canceled"
+	CodeAuthTimeout      = "auth_timeout"
+	CodeConnectionFailed = "connection_failed"
+	CodeAlreadyExists    = "already_exists"
+	CodeAlreadyConnected = "already_connected"
+	CodeInProgress       = "in_progress"
+	CodeNotReady         = "not_ready"
+	CodeNotSupported     = "not_supported"
+	CodeInvalidArguments = "invalid_arguments"
+	CodePermissionDenied = "permission_denied"
+	CodeFailed           = "failed"
+)
+
+// bluezErrorCodes maps D-Bus error names to client error codes
+var bluezErrorCodes = map[string]string{
+	"org.bluez.Error.AuthenticationFailed":      CodeAuthFailed,
+	"org.bluez.Error.AuthenticationRejected":    CodeAuthRejected,
+	"org.bluez.Error.AuthenticationCanceled":    CodeAuthCanceled,
+	"org.bluez.Error.AuthenticationTimeout":     CodeAuthTimeout,
+	"org.bluez.Error.ConnectionAttemptFailed":   CodeConnectionFailed,
+	"org.bluez.Error.AlreadyExists":             CodeAlreadyExists,
+	"org.bluez.Error.AlreadyConnected":          CodeAlreadyConnected,
+	"org.bluez.Error.InProgress":                CodeInProgress,
+	"org.bluez.Error.NotReady":                  CodeNotReady,
+	"org.bluez.Error.DoesNotExist":              CodeNotFound,
+	"org.bluez.Error.NotAvailable":              CodeNotSupported,
+	"org.bluez.Error.NotSupported":              CodeNotSupported,
+	"org.bluez.Error.InvalidArguments":          CodeInvalidArguments,
+	"org.bluez.Error.NotAuthorized":             CodePermissionDenied,
+	"org.bluez.Error.NotPermitted":              CodePermissionDenied,
+	"org.bluez.Error.Failed":                    CodeFailed,
+	"org.freedesktop.DBus.Error.NoReply":        CodeTimeout,
+	"org.freedesktop.DBus.Error.Timeout":        CodeTimeout,
+	"org.freedesktop.DBus.Error.ServiceUnknown": CodeUnavailable,
+	"org.freedesktop.DBus.Error.UnknownObject":  CodeNotFound,
+	"org.freedesktop.DBus.Error.AccessDenied":   CodePermissionDenied,
+}
+
+// ErrorCode returns a stable code describing err, suitable for clients
+// to act on without parsing messages
+func ErrorCode(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, context.Canceled):
+		return CodeCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return CodeTimeout
+	case errors.Is(err, ErrUnavailable):
+		return CodeUnavailable
+	case errors.Is(err, ErrDeviceNotFound):
+		return CodeNotFound
+	}
+
+	var dbusErr dbus.Error
+	if errors.As(err, &dbusErr) {
+		if code, ok := bluezErrorCodes[dbusErr.Name]; ok {
+			return code
+		}
+	}
+	return CodeFailed
+}
@@ -0,0 +1,77 @@
+package bluetooth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{
+			name:     "nil error",
+			err:      nil,
+			expected: "",
+		},
+		{
+			name:     "canceled context",
+			err:      fmt.Errorf("pairing canceled: %w", context.Canceled),
+			expected: CodeCanceled,
+		},
+		{
+			name:     "deadline exceeded",
+			err:      context.DeadlineExceeded,
+			expected: CodeTimeout,
+		},
+		{
+			name:     "device not found",
+			err:      fmt.Errorf("%w: AA:BB:CC:DD:EE:FF", ErrDeviceNotFound),
+			expected: CodeNotFound,
+		},
+		{
+			name:     "adapter unavailable",
+			err:      ErrUnavailable,
+			expected: CodeUnavailable,
+		},
+		{
+			name:     "wrapped BlueZ authentication failure",
+			err:      fmt.Errorf("failed to pair: %w", dbus.Error{Name: "org.bluez.Error.AuthenticationFailed"}),
+			expected: CodeAuthFailed,
+		},
+		{
+			name:     "BlueZ connection attempt failed",
+			err:      dbus.Error{Name: "org.bluez.Error.ConnectionAttemptFailed"},
+			expected: CodeConnectionFailed,
+		},
+		{
+			name:     "D-Bus no reply",
+			err:      dbus.Error{Name: "org.freedesktop.DBus.Error.NoReply"},
+			expected: CodeTimeout,
+		},
+		{
+			name:     "unknown D-Bus error",
+			err:      dbus.Error{Name: "org.bluez.Error.SomethingNew"},
+			expected: CodeFailed,
+		},
+		{
+			name:     "plain error",
+			err:      errors.New("boom"),
+			expected: CodeFailed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := ErrorCode(tt.err); result != tt.expected {
+				t.Errorf("ErrorCode(%v) = %q, want %q", tt.err, result, tt.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package bluetooth
+
+import (
+	"log"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	bluezMediaIface     = "org.bluez.Media1"
+	bluezEndpointIface  = "org.bluez.MediaEndpoint1"
+	bluezTransportIface = "org.bluez.MediaTransport1"
+)
+
+// Service and characteristic UUIDs used to recognize the audio profile of a device
+const (
+	A2DPSourceUUID = "0000110a-0000-1000-8000-00805f9b34fb"
+	// AudioSinkUUID (A2DP sink) is declared in bluetooth.go
+
+	PACSUUID = "00001850-0000-1000-8000-00805f9b34fb" // Published Audio Capabilities Service
+	ASCSUUID = "0000184e-0000-1000-8000-00805f9b34fb" // Audio Stream Control Service (unicast)
+	BASSUUID = "0000184f-0000-1000-8000-00805f9b34fb" // Broadcast Audio Scan Service
+	CASUUID  = "00001853-0000-1000-8000-00805f9b34fb" // Common Audio Service
+	TMASUUID = "00001855-0000-1000-8000-00805f9b34fb" // Telephony and Media Audio Service
+	HASUUID  = "00001854-0000-1000-8000-00805f9b34fb" // Hearing Access Service
+
+	PACSinkUUID   = "00002bc9-0000-1000-8000-00805f9b34fb" // Sink PAC, used by BAP endpoints and transports
+	PACSourceUUID = "00002bcb-0000-1000-8000-00805f9b34fb" // Source PAC
+	BAASourceUUID = "00001852-0000-1000-8000-00805f9b34fb" // Broadcast Audio Announcement source
+	BAASinkUUID   = "00001851-0000-1000-8000-00805f9b34fb" // Broadcast Audio Announcement sink
+)
+
+// Audio profiles of a transport
+const (
+	ProfileA2DP    = "a2dp"
+	ProfileLEAudio = "le-audio"
+)
+
+// leAudioServiceUUIDs are advertised by LE Audio capable devices
+var leAudioServiceUUIDs = []string{PACSUUID, ASCSUUID, BASSUUID, CASUUID, TMASUUID, HASUUID}
+
+// AudioTransport is an audio stream set up by BlueZ between the adapter and a device
+type AudioTransport struct {
+	Profile string `json:"profile"`          // "a2dp" or "le-audio"
+	Codec   string `json:"codec"`            // "sbc", "aac", "lc3"...
+	State   string `json:"state"`            // "idle", "pending", "broadcasting" or "active"
+	Volume  *int   `json:"volume,omitempty"` // 0-127, nil when the device has no absolute volume
+}
+
+// hasLEAudioUUID reports whether uuids contain an LE Audio service
+func hasLEAudioUUID(uuids []string) bool {
+	for _, uuid := range uuids {
+		for _, leUUID := range leAudioServiceUUIDs {
+			if strings.EqualFold(uuid, leUUID) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isPACUUID reports whether uuid identifies a BAP (LE Audio) endpoint or transport
+func isPACUUID(uuid string) bool {
+	switch strings.ToLower(uuid) {
+	case PACSinkUUID, PACSourceUUID, BAASourceUUID, BAASinkUUID:
+		return true
+	}
+	return false
+}
+
+// transportProfile returns the audio profile matching the UUID of a transport
+func transportProfile(uuid string) string {
+	if isPACUUID(uuid) {
+		return ProfileLEAudio
+	}
+	switch strings.ToLower(uuid) {
+	case AudioSinkUUID, A2DPSourceUUID:
+		return ProfileA2DP
+	}
+	return ""
+}
+
+// codecName returns the name of a codec identifier. A2DP and BAP codec IDs
+// overlap, so the profile is needed to tell them apart.
+func codecName(profile string, codec byte) string {
+	if profile == ProfileLEAudio {
+		if codec == 0x06 {
+			return "lc3"
+		}
+		return "vendor"
+	}
+	switch codec {
+	case 0x00:
+		return "sbc"
+	case 0x01:
+		return "mpeg"
+	case 0x02:
+		return "aac"
+	case 0xff:
+		return "vendor" // aptX, LDAC... are vendor codecs
+	}
+	return "unknown"
+}
+
+// devicePathOf returns the path of the device owning an endpoint or transport
+// object (e.g. /org/bluez/hci0/dev_XX/pac_sink0/fd0 -> /org/bluez/hci0/dev_XX)
+func devicePathOf(path dbus.ObjectPath) string {
+	parts := strings.Split(string(path), "/")
+	for i, part := range parts {
+		if strings.HasPrefix(part, "dev_") {
+			return strings.Join(parts[:i+1], "/")
+		}
+	}
+	return ""
+}
+
+// applyManagedObjects updates the state from the result of GetManagedObjects.
+// Devices are handled first so that endpoints and transports find their device.
+func (a *Adapter) applyManagedObjects(objects map[dbus.ObjectPath]map[string]map[string]dbus.Variant) {
+	for path, ifaces := range objects {
+		if props, ok := ifaces[bluezDeviceIface]; ok {
+			a.updateDevice(path, props)
+		}
+	}
+	for path, ifaces := range objects {
+		a.applyMediaObject(path, ifaces)
+	}
+}
+
+// applyMediaObject handles the media interfaces of an object
+func (a *Adapter) applyMediaObject(path dbus.ObjectPath, ifaces map[string]map[string]dbus.Variant) {
+	if props, ok := ifaces[bluezEndpointIface]; ok {
+		a.updateEndpoint(path, props)
+	}
+	if props, ok := ifaces[bluezTransportIface]; ok {
+		a.updateTransport(path, props)
+	}
+	if props, ok := ifaces[bluezMediaIface]; ok && path == a.path() {
+		a.updateMedia(props)
+	}
+	if props, ok := ifaces[bluezMediaControlIface]; ok {
+		a.updateMediaControl(path, props)
+	}
+	if props, ok := ifaces[bluezMediaPlayerIface]; ok {
+		a.updateRemotePlayer(path, props)
+	}
+}
+
+// isLEAudioLocked reports whether a device supports LE Audio: it advertises an
+// LE Audio service, or BlueZ found a BAP endpoint or an LE Audio stream on it.
+// a.mu must be held.
+func (a *Adapter) isLEAudioLocked(devicePath string, device *Device) bool {
+	if hasLEAudioUUID(device.UUIDs) {
+		return true
+	}
+	for path := range a.endpoints {
+		if devicePathOf(dbus.ObjectPath(path)) == devicePath {
+			return true
+		}
+	}
+	for path, transport := range a.transports {
+		if transport.Profile == ProfileLEAudio && devicePathOf(dbus.ObjectPath(path)) == devicePath {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshLEAudioLocked updates the LE Audio support of the device owning path.
+// a.mu must be held. It reports whether the device changed.
+func (a *Adapter) refreshLEAudioLocked(path dbus.ObjectPath) bool {
+	devicePath := devicePathOf(path)
+	device, ok := a.devices[devicePath]
+	if !ok {
+		return false
+	}
+	leAudio := a.isLEAudioLocked(devicePath, device)
+	changed := device.LEAudio != leAudio
+	device.LEAudio = leAudio
+	return changed
+}
+
+// updateEndpoint marks a device as LE Audio capable when BlueZ found a BAP endpoint on it
+func (a *Adapter) updateEndpoint(path dbus.ObjectPath, props map[string]dbus.Variant) {
+	uuid, _ := props["UUID"].Value().(string)
+	if !isPACUUID(uuid) {
+		return
+	}
+
+	a.mu.Lock()
+	if a.endpoints == nil {
+		a.endpoints = make(map[string]bool)
+	}
+	a.endpoints[string(path)] = true
+	changed := a.refreshLEAudioLocked(path)
+	a.mu.Unlock()
+
+	if changed {
+		a.notifyChange()
+	}
+}
+
+// removeEndpoint forgets a BAP endpoint of a device
+func (a *Adapter) removeEndpoint(path dbus.ObjectPath) {
+	a.mu.Lock()
+	if !a.endpoints[string(path)] {
+		a.mu.Unlock()
+		return
+	}
+	delete(a.endpoints, string(path))
+	changed := a.refreshLEAudioLocked(path)
+	a.mu.Unlock()
+
+	if changed {
+		a.notifyChange()
+	}
+}
+
+// updateTransport tracks the audio stream of a device
+func (a *Adapter) updateTransport(path dbus.ObjectPath, props map[string]dbus.Variant) {
+	a.mu.Lock()
+	if a.transports == nil {
+		a.transports = make(map[string]*AudioTransport)
+	}
+	transport, exists := a.transports[string(path)]
+	if !exists {
+		transport = &AudioTransport{}
+		a.transports[string(path)] = transport
+	}
+
+	var codec *byte
+	for key, val := range props {
+		switch key {
+		case "UUID":
+			if v, ok := val.Value().(string); ok {
+				transport.Profile = transportProfile(v)
+			}
+		case "Codec":
+			if v, ok := val.Value().(byte); ok {
+				codec = &v
+			}
+		case "State":
+			if v, ok := val.Value().(string); ok {
+				transport.State = v
+			}
+		case "Volume":
+			if v, ok := val.Value().(uint16); ok {
+				volume := int(v)
+				transport.Volume = &volume
+			}
+		}
+	}
+	if codec != nil {
+		transport.Codec = codecName(transport.Profile, *codec)
+	}
+
+	device, ok := a.devices[devicePathOf(path)]
+	if ok {
+		t := *transport
+		device.Transport = &t
+		a.refreshLEAudioLocked(path)
+	}
+	a.mu.Unlock()
+
+	if ok {
+		a.notifyChange()
+	}
+}
+
+// removeTransport forgets the audio stream of a device
+func (a *Adapter) removeTransport(path dbus.ObjectPath) {
+	a.mu.Lock()
+	if _, exists := a.transports[string(path)]; !exists {
+		a.mu.Unlock()
+		return
+	}
+	delete(a.transports, string(path))
+
+	device, ok := a.devices[devicePathOf(path)]
+	if ok {
+		device.Transport = nil
+		a.refreshLEAudioLocked(path)
+	}
+	a.mu.Unlock()
+
+	if ok {
+		a.notifyChange()
+	}
+}
+
+// updateMedia records whether the audio stack registered LE Audio endpoints.
+// PipeWire registers them when built with LC3 support; bluez-alsa does not.
+func (a *Adapter) updateMedia(props map[string]dbus.Variant) {
+	variant, ok := props["SupportedUUIDs"]
+	if !ok {
+		return
+	}
+	uuids, _ := variant.Value().([]string)
+	supported := false
+	for _, uuid := range uuids {
+		if isPACUUID(uuid) {
+			supported = true
+			break
+		}
+	}
+
+	a.mu.Lock()
+	changed := a.info.LEAudio != supported
+	a.info.LEAudio = supported
+	a.mu.Unlock()
+
+	if changed {
+		log.Printf("LE Audio support of the audio stack: %v", supported)
+		a.notifyAdapterChange()
+	}
+}
+
+// refreshMediaProperties reads the Media1 properties of the adapter
+func (a *Adapter) refreshMediaProperties() {
+	var props map[string]dbus.Variant
+	err := a.adapterObject().Call(dbusPropertiesIface+".GetAll", 0, bluezMediaIface).Store(&props)
+	if err != nil {
+		// Older BlueZ versions have no SupportedUUIDs, LE Audio is then unsupported
+		return
+	}
+	a.updateMedia(props)
+}
+
+// LEAudioSupported reports whether audio can be routed to LE Audio devices
+func (a *Adapter) LEAudioSupported() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.info.LEAudio
+}
@@ -0,0 +1,235 @@
+package bluetooth
+
+import (
+	"testing"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// fakeObjectTree returns what BlueZ reports from GetManagedObjects for an
+// adapter with a classic A2DP speaker and LE Audio earbuds, both streaming
+func fakeObjectTree() map[dbus.ObjectPath]map[string]map[string]dbus.Variant {
+	return map[dbus.ObjectPath]map[string]map[string]dbus.Variant{
+		"/org/bluez/hci0": {
+			bluezAdapterIface: {
+				"Address": dbus.MakeVariant("00:11:22:33:44:55"),
+			},
+			bluezMediaIface: {
+				"SupportedUUIDs": dbus.MakeVariant([]string{AudioSinkUUID, PACSinkUUID, PACSourceUUID}),
+			},
+		},
+		"/org/bluez/hci0/dev_AA_AA_AA_AA_AA_AA": {
+			bluezDeviceIface: {
+				"Address": dbus.MakeVariant("AA:AA:AA:AA:AA:AA"),
+				"Name":    dbus.MakeVariant("Kitchen speaker"),
+				"Class":   dbus.MakeVariant(uint32(0x240414)),
+				"UUIDs":   dbus.MakeVariant([]string{AudioSinkUUID}),
+			},
+		},
+		"/org/bluez/hci0/dev_AA_AA_AA_AA_AA_AA/sep1": {
+			bluezEndpointIface: {
+				"UUID":  dbus.MakeVariant(AudioSinkUUID),
+				"Codec": dbus.MakeVariant(byte(0x00)),
+			},
+		},
+		"/org/bluez/hci0/dev_AA_AA_AA_AA_AA_AA/sep1/fd0": {
+			bluezTransportIface: {
+				"Device": dbus.MakeVariant(dbus.ObjectPath("/org/bluez/hci0/dev_AA_AA_AA_AA_AA_AA")),
+				"UUID":   dbus.MakeVariant(AudioSinkUUID),
+				"Codec":  dbus.MakeVariant(byte(0x02)),
+				"State":  dbus.MakeVariant("active"),
+				"Volume": dbus.MakeVariant(uint16(100)),
+			},
+		},
+		"/org/bluez/hci0/dev_BB_BB_BB_BB_BB_BB": {
+			bluezDeviceIface: {
+				"Address":     dbus.MakeVariant("BB:BB:BB:BB:BB:BB"),
+				"Name":        dbus.MakeVariant("Earbuds"),
+				"AddressType": dbus.MakeVariant("random"),
+				"UUIDs":       dbus.MakeVariant([]string{CASUUID}),
+			},
+		},
+		"/org/bluez/hci0/dev_BB_BB_BB_BB_BB_BB/pac_sink0": {
+			bluezEndpointIface: {
+				"UUID":  dbus.MakeVariant(PACSinkUUID),
+				"Codec": dbus.MakeVariant(byte(0x06)),
+			},
+		},
+		"/org/bluez/hci0/dev_BB_BB_BB_BB_BB_BB/pac_sink0/fd0": {
+			bluezTransportIface: {
+				"Device": dbus.MakeVariant(dbus.ObjectPath("/org/bluez/hci0/dev_BB_BB_BB_BB_BB_BB")),
+				"UUID":   dbus.MakeVariant(PACSinkUUID),
+				"Codec":  dbus.MakeVariant(byte(0x06)),
+				"State":  dbus.MakeVariant("idle"),
+			},
+		},
+		"/org/bluez/hci0/dev_CC_CC_CC_CC_CC_CC": {
+			bluezDeviceIface: {
+				"Address":     dbus.MakeVariant("CC:CC:CC:CC:CC:CC"),
+				"Name":        dbus.MakeVariant("Hearing aid"),
+				"AddressType": dbus.MakeVariant("random"),
+				"UUIDs":       dbus.MakeVariant([]string{"0000180f-0000-1000-8000-00805f9b34fb", HASUUID}),
+			},
+		},
+	}
+}
+
+func newFakeTreeAdapter() *Adapter {
+	adapter := &Adapter{
+		adapterPath: "/org/bluez/hci0",
+		devices:     make(map[string]*Device),
+	}
+	adapter.applyManagedObjects(fakeObjectTree())
+	return adapter
+}
+
+func findDevice(t *testing.T, adapter *Adapter, address string) *Device {
+	t.Helper()
+	for _, d := range adapter.GetDevices() {
+		if d.Address == address {
+			return d
+		}
+	}
+	t.Fatalf("device %s not found", address)
+	return nil
+}
+
+func TestLEAudioDetection(t *testing.T) {
+	adapter := newFakeTreeAdapter()
+
+	tests := []struct {
+		address  string
+		expected bool
+	}{
+		{address: "AA:AA:AA:AA:AA:AA", expected: false}, // A2DP only
+		{address: "BB:BB:BB:BB:BB:BB", expected: true},  // BAP endpoint found by BlueZ
+		{address: "CC:CC:CC:CC:CC:CC", expected: true},  // Advertises the Hearing Access Service
+	}
+
+	for _, tt := range tests {
+		if device := findDevice(t, adapter, tt.address); device.LEAudio != tt.expected {
+			t.Errorf("%s LEAudio = %v, want %v", tt.address, device.LEAudio, tt.expected)
+		}
+	}
+}
+
+func TestTransports(t *testing.T) {
+	adapter := newFakeTreeAdapter()
+
+	speaker := findDevice(t, adapter, "AA:AA:AA:AA:AA:AA")
+	if speaker.Transport == nil {
+		t.Fatal("speaker should have a transport")
+	}
+	if speaker.Transport.Profile != ProfileA2DP || speaker.Transport.Codec != "aac" || speaker.Transport.State != "active" {
+		t.Errorf("speaker transport = %+v, want active A2DP with AAC", *speaker.Transport)
+	}
+	if speaker.Transport.Volume == nil || *speaker.Transport.Volume != 100 {
+		t.Errorf("speaker transport volume = %v, want 100", speaker.Transport.Volume)
+	}
+
+	earbuds := findDevice(t, adapter, "BB:BB:BB:BB:BB:BB")
+	if earbuds.Transport == nil {
+		t.Fatal("earbuds should have a transport")
+	}
+	if earbuds.Transport.Profile != ProfileLEAudio || earbuds.Transport.Codec != "lc3" {
+		t.Errorf("earbuds transport = %+v, want LE Audio with LC3", *earbuds.Transport)
+	}
+	if earbuds.Transport.Volume != nil {
+		t.Errorf("earbuds transport volume = %d, want none", *earbuds.Transport.Volume)
+	}
+
+	// The stream going away clears the transport
+	adapter.removeTransport("/org/bluez/hci0/dev_BB_BB_BB_BB_BB_BB/pac_sink0/fd0")
+	if earbuds := findDevice(t, adapter, "BB:BB:BB:BB:BB:BB"); earbuds.Transport != nil {
+		t.Error("earbuds transport should be cleared after removal")
+	}
+	if earbuds := findDevice(t, adapter, "BB:BB:BB:BB:BB:BB"); !earbuds.LEAudio {
+		t.Error("earbuds should stay LE Audio capable after the transport is removed")
+	}
+}
+
+func TestLEAudioClearedWithItsSources(t *testing.T) {
+	adapter := newFakeTreeAdapter()
+	const devicePath = "/org/bluez/hci0/dev_DD_DD_DD_DD_DD_DD"
+	adapter.updateDevice(devicePath, map[string]dbus.Variant{
+		"Address": dbus.MakeVariant("DD:DD:DD:DD:DD:DD"),
+		"Name":    dbus.MakeVariant("Headset"),
+	})
+
+	// Only the LE Audio stream tells the device supports it
+	adapter.updateTransport(devicePath+"/pac_sink0/fd0", map[string]dbus.Variant{
+		"UUID":  dbus.MakeVariant(PACSinkUUID),
+		"Codec": dbus.MakeVariant(byte(0x06)),
+	})
+	if !findDevice(t, adapter, "DD:DD:DD:DD:DD:DD").LEAudio {
+		t.Fatal("headset should be LE Audio capable while it streams over LE Audio")
+	}
+	adapter.removeTransport(devicePath + "/pac_sink0/fd0")
+	if findDevice(t, adapter, "DD:DD:DD:DD:DD:DD").LEAudio {
+		t.Error("headset should not stay LE Audio capable once its LE Audio transport is gone")
+	}
+
+	// Same for a BAP endpoint
+	adapter.updateEndpoint(devicePath+"/pac_sink0", map[string]dbus.Variant{
+		"UUID": dbus.MakeVariant(PACSinkUUID),
+	})
+	if !findDevice(t, adapter, "DD:DD:DD:DD:DD:DD").LEAudio {
+		t.Fatal("headset should be LE Audio capable with a BAP endpoint")
+	}
+	adapter.removeEndpoint(devicePath + "/pac_sink0")
+	if findDevice(t, adapter, "DD:DD:DD:DD:DD:DD").LEAudio {
+		t.Error("headset should not stay LE Audio capable once its endpoint is gone")
+	}
+}
+
+func TestLEAudioSupported(t *testing.T) {
+	adapter := newFakeTreeAdapter()
+	if !adapter.LEAudioSupported() {
+		t.Error("LE Audio should be supported when the stack registered PAC endpoints")
+	}
+
+	adapter.updateMedia(map[string]dbus.Variant{
+		"SupportedUUIDs": dbus.MakeVariant([]string{AudioSinkUUID}),
+	})
+	if adapter.LEAudioSupported() {
+		t.Error("LE Audio should not be supported with A2DP endpoints only")
+	}
+}
+
+func TestDevicePathOf(t *testing.T) {
+	tests := []struct {
+		path     dbus.ObjectPath
+		expected string
+	}{
+		{path: "/org/bluez/hci0/dev_AA_AA_AA_AA_AA_AA/sep1/fd0", expected: "/org/bluez/hci0/dev_AA_AA_AA_AA_AA_AA"},
+		{path: "/org/bluez/hci0/dev_AA_AA_AA_AA_AA_AA/pac_sink0", expected: "/org/bluez/hci0/dev_AA_AA_AA_AA_AA_AA"},
+		{path: "/org/bluez/hci0/dev_AA_AA_AA_AA_AA_AA", expected: "/org/bluez/hci0/dev_AA_AA_AA_AA_AA_AA"},
+		{path: "/org/bluez/hci0", expected: ""},
+	}
+
+	for _, tt := range tests {
+		if result := devicePathOf(tt.path); result != tt.expected {
+			t.Errorf("devicePathOf(%q) = %q, want %q", tt.path, result, tt.expected)
+		}
+	}
+}
+
+func TestCodecName(t *testing.T) {
+	tests := []struct {
+		profile  string
+		codec    byte
+		expected string
+	}{
+		{profile: ProfileA2DP, codec: 0x00, expected: "sbc"},
+		{profile: ProfileA2DP, codec: 0x02, expected: "aac"},
+		{profile: ProfileA2DP, codec: 0xff, expected: "vendor"},
+		{profile: ProfileLEAudio, codec: 0x06, expected: "lc3"},
+		{profile: ProfileLEAudio, codec: 0xff, expected: "vendor"},
+	}
+
+	for _, tt := range tests {
+		if result := codecName(tt.profile, tt.codec); result != tt.expected {
+			t.Errorf("codecName(%q, %#x) = %q, want %q", tt.profile, tt.codec, result, tt.expected)
+		}
+	}
+}
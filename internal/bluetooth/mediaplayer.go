@@ -0,0 +1,257 @@
+package bluetooth
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/godbus/dbus/v5/prop"
+)
+
+const (
+	bluezMediaControlIface = "org.bluez.MediaControl1"
+	bluezMediaPlayerIface  = "org.bluez.MediaPlayer1"
+	mprisPlayerIface       = "org.mpris.MediaPlayer2.Player"
+
+	// playerPath is where the local player is exported on the system bus
+	playerPath = dbus.ObjectPath("/org/bluepicast/player")
+)
+
+// MediaCommand is a playback command sent by a device, e.g. when a
+// button is pressed on a speaker (AVRCP)
+type MediaCommand string
+
+// Media commands forwarded by BlueZ
+const (
+	MediaPlay      MediaCommand = "play"
+	MediaPause     MediaCommand = "pause"
+	MediaPlayPause MediaCommand = "play_pause"
+	MediaStop      MediaCommand = "stop"
+	MediaNext      MediaCommand = "next"
+	MediaPrevious  MediaCommand = "previous"
+)
+
+// NowPlaying describes the current track, shown by devices with an AVRCP display
+type NowPlaying struct {
+	Title    string  `json:"title"`
+	Artist   string  `json:"artist"`
+	Album    string  `json:"album"`
+	Duration float64 `json:"duration"` // Seconds, 0 when unknown
+	Playing  bool    `json:"playing"`
+	Stream   string  `json:"stream"` // Name of the Snapcast stream
+}
+
+// RemotePlayer is a media player exposed by a device (e.g. a phone streaming to us)
+type RemotePlayer struct {
+	Status string `json:"status"` // "playing", "paused", "stopped"...
+	Title  string `json:"title"`
+	Artist string `json:"artist"`
+}
+
+// mediaPlayer is exported as an MPRIS player. BlueZ forwards the AVRCP
+// commands of connected devices to it and reads the current track from it.
+type mediaPlayer struct {
+	adapter *Adapter
+}
+
+func (p *mediaPlayer) Play() *dbus.Error {
+	p.adapter.dispatchMediaCommand(MediaPlay)
+	return nil
+}
+
+func (p *mediaPlayer) Pause() *dbus.Error {
+	p.adapter.dispatchMediaCommand(MediaPause)
+	return nil
+}
+
+func (p *mediaPlayer) PlayPause() *dbus.Error {
+	p.adapter.dispatchMediaCommand(MediaPlayPause)
+	return nil
+}
+
+func (p *mediaPlayer) Stop() *dbus.Error {
+	p.adapter.dispatchMediaCommand(MediaStop)
+	return nil
+}
+
+func (p *mediaPlayer) Next() *dbus.Error {
+	p.adapter.dispatchMediaCommand(MediaNext)
+	return nil
+}
+
+func (p *mediaPlayer) Previous() *dbus.Error {
+	p.adapter.dispatchMediaCommand(MediaPrevious)
+	return nil
+}
+
+// SetOnMediaCommand sets the callback for media commands sent by devices
+func (a *Adapter) SetOnMediaCommand(fn func(cmd MediaCommand)) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.onMediaCommand = fn
+}
+
+func (a *Adapter) dispatchMediaCommand(cmd MediaCommand) {
+	a.mu.RLock()
+	onMediaCommand := a.onMediaCommand
+	a.mu.RUnlock()
+
+	log.Printf("Received media command from device: %s", cmd)
+	if onMediaCommand != nil {
+		go onMediaCommand(cmd)
+	}
+}
+
+// RegisterMediaPlayer exports the local player and registers it with BlueZ so
+// that devices can send playback commands. It is registered again when
+// BlueZ comes back after a restart.
+func (a *Adapter) RegisterMediaPlayer() error {
+	conn := a.bus()
+
+	if err := conn.Export(&mediaPlayer{adapter: a}, playerPath, mprisPlayerIface); err != nil {
+		return fmt.Errorf("failed to export media player: %w", err)
+	}
+
+	a.mu.Lock()
+	nowPlaying := a.nowPlaying
+	a.mu.Unlock()
+
+	props, err := prop.Export(conn, playerPath, prop.Map{
+		mprisPlayerIface: {
+			"PlaybackStatus": {Value: playbackStatus(nowPlaying), Emit: prop.EmitTrue},
+			"Metadata":       {Value: metadataVariant(nowPlaying), Emit: prop.EmitTrue},
+			"LoopStatus":     {Value: "None", Emit: prop.EmitTrue},
+			"Shuffle":        {Value: false, Emit: prop.EmitTrue},
+			"Position":       {Value: int64(0), Emit: prop.EmitFalse},
+			"Rate":           {Value: 1.0, Emit: prop.EmitFalse},
+			"MinimumRate":    {Value: 1.0, Emit: prop.EmitFalse},
+			"MaximumRate":    {Value: 1.0, Emit: prop.EmitFalse},
+			"CanGoNext":      {Value: true, Emit: prop.EmitFalse},
+			"CanGoPrevious":  {Value: true, Emit: prop.EmitFalse},
+			"CanPlay":        {Value: true, Emit: prop.EmitFalse},
+			"CanPause":       {Value: true, Emit: prop.EmitFalse},
+			"CanSeek":        {Value: false, Emit: prop.EmitFalse},
+			"CanControl":     {Value: true, Emit: prop.EmitFalse},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export media player properties: %w", err)
+	}
+
+	initial, _ := props.GetAll(mprisPlayerIface)
+	call := a.adapterObject().Call(bluezMediaIface+".RegisterPlayer", 0, playerPath, initial)
+	if call.Err != nil {
+		return fmt.Errorf("failed to register media player: %w", call.Err)
+	}
+
+	a.mu.Lock()
+	a.playerProps = props
+	a.playerRegistered = true
+	a.mu.Unlock()
+
+	log.Println("Media player registered, devices can now send playback commands")
+	return nil
+}
+
+// SetNowPlaying updates the track shown by devices with an AVRCP display
+func (a *Adapter) SetNowPlaying(nowPlaying NowPlaying) {
+	a.mu.Lock()
+	a.nowPlaying = nowPlaying
+	props := a.playerProps
+	a.mu.Unlock()
+
+	if props == nil {
+		return
+	}
+	props.SetMust(mprisPlayerIface, "Metadata", metadataVariant(nowPlaying))
+	props.SetMust(mprisPlayerIface, "PlaybackStatus", playbackStatus(nowPlaying))
+}
+
+func playbackStatus(nowPlaying NowPlaying) string {
+	if nowPlaying.Playing {
+		return "Playing"
+	}
+	return "Paused"
+}
+
+// metadataVariant converts the track to MPRIS metadata
+func metadataVariant(nowPlaying NowPlaying) map[string]dbus.Variant {
+	title := nowPlaying.Title
+	if title == "" {
+		title = nowPlaying.Stream
+	}
+
+	metadata := map[string]dbus.Variant{
+		"mpris:trackid": dbus.MakeVariant(dbus.ObjectPath("/org/bluepicast/track")),
+		"xesam:title":   dbus.MakeVariant(title),
+		"xesam:album":   dbus.MakeVariant(nowPlaying.Album),
+	}
+	if nowPlaying.Artist != "" {
+		metadata["xesam:artist"] = dbus.MakeVariant([]string{nowPlaying.Artist})
+	}
+	if nowPlaying.Duration > 0 {
+		metadata["mpris:length"] = dbus.MakeVariant(int64(nowPlaying.Duration * 1e6))
+	}
+	return metadata
+}
+
+// updateMediaControl tracks whether the remote control (AVRCP) channel of a device is up
+func (a *Adapter) updateMediaControl(path dbus.ObjectPath, props map[string]dbus.Variant) {
+	connected, ok := props["Connected"].Value().(bool)
+	if !ok {
+		return
+	}
+
+	a.mu.Lock()
+	device, exists := a.devices[devicePathOf(path)]
+	changed := exists && device.RemoteControl != connected
+	if changed {
+		device.RemoteControl = connected
+	}
+	a.mu.Unlock()
+
+	if changed {
+		a.notifyChange()
+	}
+}
+
+// updateRemotePlayer tracks the player exposed by a device
+func (a *Adapter) updateRemotePlayer(path dbus.ObjectPath, props map[string]dbus.Variant) {
+	a.mu.Lock()
+	device, exists := a.devices[devicePathOf(path)]
+	if !exists {
+		a.mu.Unlock()
+		return
+	}
+
+	player := RemotePlayer{}
+	if device.Player != nil {
+		player = *device.Player
+	}
+	if v, ok := props["Status"].Value().(string); ok {
+		player.Status = v
+	}
+	if track, ok := props["Track"].Value().(map[string]dbus.Variant); ok {
+		player.Title, _ = track["Title"].Value().(string)
+		player.Artist, _ = track["Artist"].Value().(string)
+	}
+	device.Player = &player
+	a.mu.Unlock()
+
+	a.notifyChange()
+}
+
+// removeRemotePlayer forgets the player of a device
+func (a *Adapter) removeRemotePlayer(path dbus.ObjectPath) {
+	a.mu.Lock()
+	device, exists := a.devices[devicePathOf(path)]
+	changed := exists && device.Player != nil
+	if changed {
+		device.Player = nil
+	}
+	a.mu.Unlock()
+
+	if changed {
+		a.notifyChange()
+	}
+}
@@ -0,0 +1,138 @@
+package bluetooth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestMetadataVariant(t *testing.T) {
+	metadata := metadataVariant(NowPlaying{
+		Title:    "Song",
+		Artist:   "Band",
+		Album:    "Album",
+		Duration: 215.5,
+		Stream:   "radio",
+	})
+
+	if title := metadata["xesam:title"].Value(); title != "Song" {
+		t.Errorf("xesam:title = %v, want Song", title)
+	}
+	if artist, _ := metadata["xesam:artist"].Value().([]string); len(artist) != 1 || artist[0] != "Band" {
+		t.Errorf("xesam:artist = %v, want [Band]", artist)
+	}
+	if length := metadata["mpris:length"].Value(); length != int64(215500000) {
+		t.Errorf("mpris:length = %v, want 215500000", length)
+	}
+
+	// Streams without metadata show the stream name
+	metadata = metadataVariant(NowPlaying{Stream: "radio"})
+	if title := metadata["xesam:title"].Value(); title != "radio" {
+		t.Errorf("xesam:title = %v, want the stream name", title)
+	}
+	if _, ok := metadata["mpris:length"]; ok {
+		t.Error("mpris:length should be left out when the duration is unknown")
+	}
+}
+
+func TestRemoteControlTracking(t *testing.T) {
+	objects := fakeObjectTree()
+	objects["/org/bluez/hci0/dev_AA_AA_AA_AA_AA_AA"][bluezMediaControlIface] = map[string]dbus.Variant{
+		"Connected": dbus.MakeVariant(true),
+	}
+	objects["/org/bluez/hci0/dev_AA_AA_AA_AA_AA_AA/player0"] = map[string]map[string]dbus.Variant{
+		bluezMediaPlayerIface: {
+			"Status": dbus.MakeVariant("playing"),
+			"Track": dbus.MakeVariant(map[string]dbus.Variant{
+				"Title":  dbus.MakeVariant("Song"),
+				"Artist": dbus.MakeVariant("Band"),
+			}),
+		},
+	}
+
+	adapter := &Adapter{
+		adapterPath: "/org/bluez/hci0",
+		devices:     make(map[string]*Device),
+	}
+	adapter.applyManagedObjects(objects)
+
+	speaker := findDevice(t, adapter, "AA:AA:AA:AA:AA:AA")
+	if !speaker.RemoteControl {
+		t.Error("speaker should have remote control")
+	}
+	if speaker.Player == nil || speaker.Player.Status != "playing" || speaker.Player.Title != "Song" {
+		t.Errorf("speaker player = %+v, want Song playing", speaker.Player)
+	}
+
+	adapter.updateRemotePlayer("/org/bluez/hci0/dev_AA_AA_AA_AA_AA_AA/player0", map[string]dbus.Variant{
+		"Status": dbus.MakeVariant("paused"),
+	})
+	if speaker := findDevice(t, adapter, "AA:AA:AA:AA:AA:AA"); speaker.Player.Status != "paused" || speaker.Player.Title != "Song" {
+		t.Errorf("speaker player = %+v, want Song paused", speaker.Player)
+	}
+
+	adapter.removeRemotePlayer("/org/bluez/hci0/dev_AA_AA_AA_AA_AA_AA/player0")
+	if speaker := findDevice(t, adapter, "AA:AA:AA:AA:AA:AA"); speaker.Player != nil {
+		t.Error("speaker player should be cleared after removal")
+	}
+
+	if earbuds := findDevice(t, adapter, "BB:BB:BB:BB:BB:BB"); earbuds.RemoteControl {
+		t.Error("earbuds should not have remote control")
+	}
+}
+
+func TestRemoteControlInterfaceRemoved(t *testing.T) {
+	objects := fakeObjectTree()
+	speakerPath := dbus.ObjectPath("/org/bluez/hci0/dev_AA_AA_AA_AA_AA_AA")
+	objects[speakerPath][bluezMediaControlIface] = map[string]dbus.Variant{
+		"Connected": dbus.MakeVariant(true),
+	}
+
+	adapter := &Adapter{
+		adapterPath: "/org/bluez/hci0",
+		devices:     make(map[string]*Device),
+	}
+	adapter.applyManagedObjects(objects)
+	removed := func(path dbus.ObjectPath, ifaces ...string) {
+		adapter.handleSignal(&dbus.Signal{
+			Name: dbusObjectManager + ".InterfacesRemoved",
+			Body: []interface{}{path, ifaces},
+		})
+	}
+
+	// AVRCP dropping removes MediaControl1 from the device itself
+	removed(speakerPath, bluezMediaControlIface)
+	speaker := findDevice(t, adapter, "AA:AA:AA:AA:AA:AA")
+	if speaker.RemoteControl {
+		t.Error("remote control should be cleared once MediaControl1 is removed")
+	}
+
+	// The device is only forgotten once BlueZ removes it
+	removed(speakerPath, bluezDeviceIface, dbusPropertiesIface)
+	for _, d := range adapter.GetDevices() {
+		if d.Address == "AA:AA:AA:AA:AA:AA" {
+			t.Error("device should be removed with its Device1 interface")
+		}
+	}
+}
+
+func TestMediaCommandDispatch(t *testing.T) {
+	adapter := &Adapter{}
+	commands := make(chan MediaCommand, 1)
+	adapter.SetOnMediaCommand(func(cmd MediaCommand) { commands <- cmd })
+
+	player := &mediaPlayer{adapter: adapter}
+	if err := player.Next(); err != nil {
+		t.Fatalf("Next returned %v", err)
+	}
+
+	select {
+	case cmd := <-commands:
+		if cmd != MediaNext {
+			t.Errorf("command = %q, want %q", cmd, MediaNext)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("media command not dispatched")
+	}
+}
@@ -0,0 +1,190 @@
+package bluetooth
+
+import (
+	"log"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// recoveryInterval is how often reattaching to BlueZ is retried while the
+// adapter is unavailable, in case the signal announcing its return was missed
+const recoveryInterval = 10 * time.Second
+
+// bus returns the current system bus connection
+func (a *Adapter) bus() *dbus.Conn {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.conn
+}
+
+// path returns the D-Bus object path of the adapter in use
+func (a *Adapter) path() dbus.ObjectPath {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.adapterPath
+}
+
+func (a *Adapter) adapterObject() dbus.BusObject {
+	return a.bus().Object(bluezService, a.path())
+}
+
+// IsAvailable reports whether BlueZ and the adapter are reachable
+func (a *Adapter) IsAvailable() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.info.Available
+}
+
+func (a *Adapter) handleNameOwnerChanged(body []interface{}) {
+	if len(body) < 3 {
+		return
+	}
+	name, _ := body[0].(string)
+	newOwner, _ := body[2].(string)
+	if name != bluezService {
+		return
+	}
+
+	if newOwner == "" {
+		a.markUnavailable("bluetoothd left the system bus")
+		return
+	}
+
+	log.Println("bluetoothd joined the system bus")
+	go a.reconnect()
+}
+
+// handleBusLost is called when the signal channel of conn is closed
+func (a *Adapter) handleBusLost(conn *dbus.Conn) {
+	select {
+	case <-a.stopSignals:
+		return // Closed by Close()
+	default:
+	}
+
+	// A replaced connection closing is expected
+	if a.bus() != conn {
+		return
+	}
+	a.markUnavailable("lost connection to the system bus")
+}
+
+// markUnavailable drops all state tied to the adapter and notifies listeners.
+// Everything is reloaded from BlueZ by reconnect.
+func (a *Adapter) markUnavailable(reason string) {
+	a.mu.Lock()
+	if !a.info.Available {
+		a.mu.Unlock()
+		return
+	}
+	a.info.Available = false
+	a.info.Powered = false
+	a.info.Discoverable = false
+	a.info.Discovering = false
+	wasScanning := a.scanning
+	a.scanning = false
+	a.scanSessions = make(map[*scanSession]struct{})
+	a.filter = DiscoveryFilter{}
+	a.devices = make(map[string]*Device)
+	a.transports = make(map[string]*AudioTransport)
+	a.endpoints = make(map[string]bool)
+	a.mu.Unlock()
+
+	log.Printf("Bluetooth adapter unavailable: %s", reason)
+
+	a.notifyAdapterChange()
+	a.notifyChange()
+	if wasScanning {
+		a.notifyScanChange(false)
+	}
+}
+
+// reconnect reattaches to the first adapter found on BlueZ and reloads its state
+func (a *Adapter) reconnect() {
+	a.mu.Lock()
+	if a.reconnecting || a.info.Available {
+		a.mu.Unlock()
+		return
+	}
+	a.reconnecting = true
+	a.mu.Unlock()
+
+	defer func() {
+		a.mu.Lock()
+		a.reconnecting = false
+		a.mu.Unlock()
+	}()
+
+	if !a.bus().Connected() {
+		if err := a.reconnectBus(); err != nil {
+			log.Printf("Failed to reconnect to the system bus: %v", err)
+			return
+		}
+	}
+
+	adapterPath, err := a.findAdapter()
+	if err != nil {
+		log.Printf("Bluetooth adapter still unavailable: %v", err)
+		return
+	}
+
+	a.mu.Lock()
+	a.adapterPath = adapterPath
+	a.info.Available = true
+	a.mu.Unlock()
+
+	if err := a.ensurePoweredOn(); err != nil {
+		log.Printf("Warning: Failed to power on adapter: %v", err)
+	}
+	a.refreshAdapterProperties()
+	a.loadExistingDevices()
+
+	a.mu.RLock()
+	playerRegistered := a.playerRegistered
+	a.mu.RUnlock()
+	if playerRegistered {
+		if err := a.RegisterMediaPlayer(); err != nil {
+			log.Printf("Warning: Failed to register media player again: %v", err)
+		}
+	}
+
+	log.Printf("Bluetooth adapter %s available again", adapterPath)
+}
+
+// reconnectBus opens a new system bus connection and subscribes to signals on it
+func (a *Adapter) reconnectBus() error {
+	conn, err := dbus.ConnectSystemBus()
+	if err != nil {
+		return err
+	}
+	if err := a.setupSignals(conn); err != nil {
+		conn.Close()
+		return err
+	}
+
+	a.mu.Lock()
+	old := a.conn
+	a.conn = conn
+	a.mu.Unlock()
+
+	old.Close()
+	log.Println("Reconnected to the system bus")
+	return nil
+}
+
+func (a *Adapter) recoveryLoop() {
+	ticker := time.NewTicker(recoveryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.stopSignals:
+			return
+		case <-ticker.C:
+			if !a.IsAvailable() {
+				a.reconnect()
+			}
+		}
+	}
+}
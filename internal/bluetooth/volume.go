@@ -0,0 +1,58 @@
+package bluetooth
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// maxTransportVolume is the absolute volume of a transport at 100%, as
+// defined by AVRCP and used by BlueZ for VCP too
+const maxTransportVolume = 127
+
+// transportOf returns the path and state of the transport of a device
+func (a *Adapter) transportOf(address string) (dbus.ObjectPath, AudioTransport, bool) {
+	devicePath := a.getDevicePath(strings.ToUpper(address))
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for path, transport := range a.transports {
+		if devicePathOf(dbus.ObjectPath(path)) == devicePath {
+			return dbus.ObjectPath(path), *transport, true
+		}
+	}
+	return "", AudioTransport{}, false
+}
+
+// Volume returns the absolute volume of a device in percent
+func (a *Adapter) Volume(address string) (int, error) {
+	_, transport, ok := a.transportOf(address)
+	if !ok || transport.Volume == nil {
+		return 0, fmt.Errorf("%w: %s", ErrNoAbsoluteVolume, address)
+	}
+	return int(math.Round(float64(*transport.Volume) * 100 / maxTransportVolume)), nil
+}
+
+// SetVolume sets the absolute volume of a device in percent. The device
+// applies it, so that the stream keeps its full resolution.
+func (a *Adapter) SetVolume(address string, volume int) error {
+	if volume < 0 || volume > 100 {
+		return fmt.Errorf("volume must be between 0 and 100, got %d", volume)
+	}
+	path, transport, ok := a.transportOf(address)
+	if !ok || transport.Volume == nil {
+		return fmt.Errorf("%w: %s", ErrNoAbsoluteVolume, address)
+	}
+
+	value := uint16(math.Round(float64(volume) * maxTransportVolume / 100))
+	object := a.bus().Object(bluezService, path)
+	call := object.Call(dbusPropertiesIface+".Set", 0, bluezTransportIface, "Volume", dbus.MakeVariant(value))
+	if call.Err != nil {
+		log.Printf("Failed to set volume of %s: %v", address, call.Err)
+		return fmt.Errorf("failed to set volume: %w", call.Err)
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package bluetooth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVolume(t *testing.T) {
+	adapter := newFakeTreeAdapter()
+
+	// 100 out of 127
+	volume, err := adapter.Volume("aa:aa:aa:aa:aa:aa")
+	if err != nil || volume != 79 {
+		t.Errorf("speaker volume = %d, %v, want 79%%", volume, err)
+	}
+
+	// Streaming without absolute volume
+	if _, err := adapter.Volume("BB:BB:BB:BB:BB:BB"); !errors.Is(err, ErrNoAbsoluteVolume) {
+		t.Errorf("earbuds volume error = %v, want ErrNoAbsoluteVolume", err)
+	}
+	if err := adapter.SetVolume("BB:BB:BB:BB:BB:BB", 50); !errors.Is(err, ErrNoAbsoluteVolume) {
+		t.Errorf("setting earbuds volume error = %v, want ErrNoAbsoluteVolume", err)
+	}
+
+	// Not streaming
+	if _, err := adapter.Volume("CC:CC:CC:CC:CC:CC"); !errors.Is(err, ErrNoAbsoluteVolume) {
+		t.Errorf("hearing aid volume error = %v, want ErrNoAbsoluteVolume", err)
+	}
+
+	if err := adapter.SetVolume("AA:AA:AA:AA:AA:AA", 101); err == nil {
+		t.Error("setting a volume above 100% succeeded")
+	}
+}
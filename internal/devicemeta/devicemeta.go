@@ -0,0 +1,172 @@
+package devicemeta
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/Ilshidur/bluepicast/internal/jsonfile"
+)
+
+// macAddressPattern validates MAC address format (XX:XX:XX:XX:XX:XX)
+var macAddressPattern = regexp.MustCompile(`^([0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}$`)
+
+// knownCodecs lists the Bluetooth audio codecs that can be set as preferred codec
+var knownCodecs = []string{"sbc", "aac", "aptx", "aptx-hd", "ldac", "lc3"}
+
+// Metadata holds bluepicast-side information about a Bluetooth device.
+// It is stored locally and never sent to the device itself.
+type Metadata struct {
+	Room            string `json:"room,omitempty"`
+	Notes           string `json:"notes,omitempty"`
+	PreferredVolume *int   `json:"preferredVolume,omitempty"` // Percentage (0-100)
+	PreferredCodec  string `json:"preferredCodec,omitempty"`
+	AutoConnect     bool   `json:"autoConnect"` // Connect the device automatically when bluepicast starts
+}
+
+// IsZero reports whether the metadata carries no information
+func (m Metadata) IsZero() bool {
+	return m.Room == "" && m.Notes == "" && m.PreferredVolume == nil && m.PreferredCodec == "" && !m.AutoConnect
+}
+
+// Validate checks that the metadata values are acceptable
+func (m Metadata) Validate() error {
+	if m.PreferredVolume != nil && (*m.PreferredVolume < 0 || *m.PreferredVolume > 100) {
+		return fmt.Errorf("preferred volume must be between 0 and 100, got %d", *m.PreferredVolume)
+	}
+
+	if m.PreferredCodec != "" {
+		known := false
+		for _, codec := range knownCodecs {
+			if m.PreferredCodec == codec {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown codec %q (expected one of %s)", m.PreferredCodec, strings.Join(knownCodecs, ", "))
+		}
+	}
+
+	return nil
+}
+
+// Store persists device metadata as a JSON file, keyed by MAC address
+type Store struct {
+	path    string
+	mu      sync.RWMutex
+	entries map[string]Metadata
+}
+
+// NewStore creates a metadata store backed by the file at path.
+// Call Load to read existing entries.
+func NewStore(path string) *Store {
+	return &Store{
+		path:    path,
+		entries: make(map[string]Metadata),
+	}
+}
+
+// Load reads the metadata file. A missing file is not an error.
+func (s *Store) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read device metadata: %w", err)
+	}
+
+	entries := make(map[string]Metadata)
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("failed to parse device metadata: %w", err)
+	}
+
+	// Entries written by hand may repeat an address in another case: the
+	// one spelled the way save writes it wins
+	s.entries = make(map[string]Metadata, len(entries))
+	for address, meta := range entries {
+		normalized := normalizeAddress(address)
+		if _, ok := s.entries[normalized]; ok && address != normalized {
+			continue
+		}
+		s.entries[normalized] = meta
+	}
+	return nil
+}
+
+// Get returns the metadata for a device
+func (s *Store) Get(address string) (Metadata, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	meta, ok := s.entries[normalizeAddress(address)]
+	return meta, ok
+}
+
+// All returns a copy of all stored metadata, keyed by MAC address
+func (s *Store) All() map[string]Metadata {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := make(map[string]Metadata, len(s.entries))
+	for address, meta := range s.entries {
+		entries[address] = meta
+	}
+	return entries
+}
+
+// Set stores the metadata for a device. Empty metadata removes the entry.
+func (s *Store) Set(address string, meta Metadata) error {
+	if !macAddressPattern.MatchString(address) {
+		return fmt.Errorf("invalid MAC address format: %s", address)
+	}
+	if err := meta.Validate(); err != nil {
+		return err
+	}
+
+	meta.Room = strings.TrimSpace(meta.Room)
+	meta.Notes = strings.TrimSpace(meta.Notes)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := normalizeAddress(address)
+	previous, existed := s.entries[key]
+	if meta.IsZero() {
+		delete(s.entries, key)
+	} else {
+		s.entries[key] = meta
+	}
+
+	// Keep serving what is on disk when it cannot be saved
+	if err := s.save(); err != nil {
+		if existed {
+			s.entries[key] = previous
+		} else {
+			delete(s.entries, key)
+		}
+		return err
+	}
+	return nil
+}
+
+// save writes all entries to disk. Must be called with the lock held.
+func (s *Store) save() error {
+	if err := jsonfile.Write(s.path, s.entries, "device metadata"); err != nil {
+		return err
+	}
+
+	log.Printf("Device metadata saved to %s", s.path)
+	return nil
+}
+
+func normalizeAddress(address string) string {
+	return strings.ToUpper(address)
+}
@@ -0,0 +1,131 @@
+package devicemeta
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "devices.json")
+	volume := 40
+
+	s := NewStore(path)
+	if err := s.Load(); err != nil {
+		t.Fatalf("Load of a missing file failed: %v", err)
+	}
+	if err := s.Set("aa:bb:cc:dd:ee:ff", Metadata{Room: "  Kitchen ", PreferredVolume: &volume, PreferredCodec: "aac", AutoConnect: true}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if err := s.Set("11:22:33:44:55:66", Metadata{Notes: "Garden"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	// Empty metadata removes the entry
+	if err := s.Set("11:22:33:44:55:66", Metadata{}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	loaded := NewStore(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if all := loaded.All(); len(all) != 1 {
+		t.Errorf("Loaded %d entries, want 1: %+v", len(all), all)
+	}
+	meta, ok := loaded.Get("AA:BB:CC:DD:EE:FF")
+	if !ok {
+		t.Fatal("Entry not found by its upper case address")
+	}
+	if meta.Room != "Kitchen" || meta.PreferredVolume == nil || *meta.PreferredVolume != 40 || meta.PreferredCodec != "aac" || !meta.AutoConnect {
+		t.Errorf("Loaded metadata = %+v", meta)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("Temporary file left behind")
+	}
+}
+
+func TestStoreSetFailureKeepsEntries(t *testing.T) {
+	dir := t.TempDir()
+	s := NewStore(filepath.Join(dir, "devices.json"))
+	if err := s.Set("AA:BB:CC:DD:EE:FF", Metadata{Room: "Kitchen"}); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+
+	// A directory in the way of the temporary file makes saving fail
+	if err := os.Mkdir(filepath.Join(dir, "devices.json.tmp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Set("AA:BB:CC:DD:EE:FF", Metadata{Room: "Garden"}); err == nil {
+		t.Error("Set of a change succeeded without writing the file")
+	}
+	if err := s.Set("11:22:33:44:55:66", Metadata{Room: "Patio"}); err == nil {
+		t.Error("Set of a new entry succeeded without writing the file")
+	}
+	if err := s.Set("AA:BB:CC:DD:EE:FF", Metadata{}); err == nil {
+		t.Error("Set removing an entry succeeded without writing the file")
+	}
+
+	all := s.All()
+	if len(all) != 1 || all["AA:BB:CC:DD:EE:FF"].Room != "Kitchen" {
+		t.Errorf("All = %+v, want only the Kitchen entry", all)
+	}
+}
+
+func TestStoreSetRejectsInvalidMetadata(t *testing.T) {
+	s := NewStore(filepath.Join(t.TempDir(), "devices.json"))
+	tooLoud := 120
+
+	tests := []struct {
+		address string
+		meta    Metadata
+	}{
+		{"speaker", Metadata{Room: "Kitchen"}},
+		{"AA:BB:CC:DD:EE:FF", Metadata{PreferredVolume: &tooLoud}},
+		{"AA:BB:CC:DD:EE:FF", Metadata{PreferredCodec: "mp3"}},
+	}
+	for _, tt := range tests {
+		if err := s.Set(tt.address, tt.meta); err == nil {
+			t.Errorf("Set(%s, %+v) succeeded", tt.address, tt.meta)
+		}
+	}
+	if len(s.All()) != 0 {
+		t.Error("Invalid metadata was stored")
+	}
+}
+
+func TestLoadMergesAddresses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.json")
+	data := `{
+		"aa:bb:cc:dd:ee:ff": {"room": "Old"},
+		"AA:BB:CC:DD:EE:FF": {"room": "Kitchen"},
+		"11:22:33:44:55:66": {"notes": "Garden"}
+	}`
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Map iteration order varies, so load a few times
+	for i := 0; i < 20; i++ {
+		s := NewStore(path)
+		if err := s.Load(); err != nil {
+			t.Fatalf("Load failed: %v", err)
+		}
+		all := s.All()
+		if len(all) != 2 {
+			t.Fatalf("Loaded %d entries, want 2: %+v", len(all), all)
+		}
+		if all["AA:BB:CC:DD:EE:FF"].Room != "Kitchen" {
+			t.Fatalf("Merged entry = %+v, want the upper case one", all["AA:BB:CC:DD:EE:FF"])
+		}
+	}
+}
+
+func TestLoadInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.json")
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewStore(path).Load(); err == nil {
+		t.Error("Load of an invalid file succeeded")
+	}
+}
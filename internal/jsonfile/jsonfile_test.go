@@ -0,0 +1,44 @@
+package jsonfile
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "devices.json")
+	if err := Write(path, map[string]int{"volume": 40}, "device metadata"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "{\n  \"volume\": 40\n}" {
+		t.Errorf("wrote %q", data)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("Temporary file left behind")
+	}
+}
+
+func TestWriteKeepsFileOnFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.json")
+	if err := Write(path, []string{"kept"}, "device metadata"); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// A directory in the way of the temporary file makes writing fail
+	if err := os.Mkdir(path+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	err := Write(path, []string{"lost"}, "device metadata")
+	if err == nil || !strings.Contains(err.Error(), "device metadata") {
+		t.Errorf("Write = %v, want an error naming the content", err)
+	}
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "kept") {
+		t.Errorf("file = %q, want the previous content", data)
+	}
+}
@@ -0,0 +1,400 @@
+// Package runner runs external commands such as systemctl, aplay and amixer.
+// Code that shells out takes a Runner so that tests can replace the real
+// commands with a Fake replaying captured outputs.
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Runner runs external commands
+type Runner interface {
+	// Output runs the command and returns its standard output
+	Output(name string, args ...string) ([]byte, error)
+	// CombinedOutput runs the command and returns its standard output and error
+	CombinedOutput(name string, args ...string) ([]byte, error)
+	// Stream starts the command and returns its standard output as it is
+	// written. The command is killed when ctx is done or the output closed.
+	// Closing the output returns an error, carrying the standard error of the
+	// command, if the command exited by itself with a non-zero status.
+	Stream(ctx context.Context, name string, args ...string) (io.ReadCloser, error)
+	// Input runs the command with stdin as its standard input and returns its
+	// standard output and error. The command is killed when ctx is done.
+	Input(ctx context.Context, stdin io.Reader, name string, args ...string) ([]byte, error)
+}
+
+// Exec runs commands with os/exec
+type Exec struct{}
+
+// Output runs the command and returns its standard output
+func (Exec) Output(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).Output()
+}
+
+// CombinedOutput runs the command and returns its standard output and error
+func (Exec) CombinedOutput(name string, args ...string) ([]byte, error) {
+	return exec.Command(name, args...).CombinedOutput()
+}
+
+// Input runs the command with stdin as its standard input and returns its
+// standard output and error
+func (Exec) Input(ctx context.Context, stdin io.Reader, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdin = stdin
+	return cmd.CombinedOutput()
+}
+
+// Stream starts the command and returns its standard output as it is written
+func (Exec) Stream(ctx context.Context, name string, args ...string) (io.ReadCloser, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	s := &stream{ReadCloser: stdout, cmd: cmd}
+	cmd.Stderr = &s.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// stream is the output of a started command, closing it stops the command
+type stream struct {
+	io.ReadCloser
+	cmd    *exec.Cmd
+	stderr bytes.Buffer
+	once   sync.Once
+	err    error
+}
+
+// Close kills the command if still running and waits for it to exit.
+// A command killed this way or by ctx is not an error.
+func (s *stream) Close() error {
+	s.once.Do(func() {
+		s.cmd.Process.Kill()
+		err := s.cmd.Wait()
+		if state := s.cmd.ProcessState; state != nil && state.Exited() && !state.Success() {
+			s.err = &StreamError{Err: err, Stderr: s.stderr.String()}
+		}
+	})
+	return s.err
+}
+
+// StreamError is returned when closing the output of a command that failed
+type StreamError struct {
+	Err    error  // Exit error of the command
+	Stderr string // Standard error of the command
+}
+
+func (e *StreamError) Error() string {
+	if message := strings.TrimSpace(e.Stderr); message != "" {
+		return fmt.Sprintf("%s (%v)", message, e.Err)
+	}
+	return e.Err.Error()
+}
+
+func (e *StreamError) Unwrap() error {
+	return e.Err
+}
+
+// Call is a command with its result, as captured by a Recorder or replayed by a Fake
+type Call struct {
+	Command  string `json:"command"`            // Command line, arguments separated by spaces
+	Stdout   string `json:"stdout,omitempty"`   // Standard output
+	Stderr   string `json:"stderr,omitempty"`   // Standard error
+	ExitCode int    `json:"exitCode,omitempty"` // Exit status, 0 on success
+	Error    string `json:"error,omitempty"`    // Set when the command could not be started
+}
+
+// ExitError is returned for calls with a non-zero exit code
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// commandLine joins a command and its arguments as in Call.Command
+func commandLine(name string, args []string) string {
+	return strings.Join(append([]string{name}, args...), " ")
+}
+
+// result returns the outputs and error of the call
+func (c Call) result(combined bool) ([]byte, error) {
+	output := c.Stdout
+	if combined {
+		output += c.Stderr
+	}
+	switch {
+	case c.Error != "":
+		return nil, errors.New(c.Error)
+	case c.ExitCode != 0:
+		return []byte(output), &ExitError{Code: c.ExitCode}
+	}
+	return []byte(output), nil
+}
+
+// Fake replays calls instead of running commands. Each queued call answers
+// the first matching command, and keeps answering it until another call for
+// the same command is queued after it. Commands without a call fail.
+type Fake struct {
+	mu      sync.Mutex
+	calls   []Call
+	used    []bool
+	history []string
+}
+
+// NewFake returns a fake replaying the given calls
+func NewFake(calls ...Call) *Fake {
+	f := &Fake{}
+	f.Add(calls...)
+	return f
+}
+
+// LoadFake returns a fake replaying the calls saved by Recorder.Save
+func LoadFake(path string) (*Fake, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read calls: %w", err)
+	}
+	var calls []Call
+	if err := json.Unmarshal(data, &calls); err != nil {
+		return nil, fmt.Errorf("failed to parse calls: %w", err)
+	}
+	return NewFake(calls...), nil
+}
+
+// Add queues calls
+func (f *Fake) Add(calls ...Call) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.calls = append(f.calls, calls...)
+	f.used = append(f.used, make([]bool, len(calls))...)
+}
+
+// Output replays the standard output of the command
+func (f *Fake) Output(name string, args ...string) ([]byte, error) {
+	return f.run(commandLine(name, args), false)
+}
+
+// CombinedOutput replays the standard output and error of the command
+func (f *Fake) CombinedOutput(name string, args ...string) ([]byte, error) {
+	return f.run(commandLine(name, args), true)
+}
+
+// Stream replays the standard output of the command at once. Closing it
+// returns the exit status and standard error of the call, if it failed.
+func (f *Fake) Stream(ctx context.Context, name string, args ...string) (io.ReadCloser, error) {
+	command := commandLine(name, args)
+	output, err := f.run(command, false)
+	var exitErr *ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return nil, err
+	}
+	replayed := &replayedStream{Reader: bytes.NewReader(output)}
+	if exitErr != nil {
+		replayed.err = &StreamError{Err: exitErr, Stderr: f.stderr(command)}
+	}
+	return replayed, nil
+}
+
+// replayedStream is the output of a command replayed by a Fake
+type replayedStream struct {
+	io.Reader
+	err error
+}
+
+// Close returns the error the command exited with
+func (s *replayedStream) Close() error {
+	return s.err
+}
+
+// stderr returns the standard error of the last call replayed for the command
+func (f *Fake) stderr(command string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i := len(f.calls) - 1; i >= 0; i-- {
+		if f.calls[i].Command == command && f.used[i] {
+			return f.calls[i].Stderr
+		}
+	}
+	return ""
+}
+
+// Input reads stdin to its end and replays the standard output and error of the command
+func (f *Fake) Input(ctx context.Context, stdin io.Reader, name string, args ...string) ([]byte, error) {
+	if stdin != nil {
+		io.Copy(io.Discard, stdin)
+	}
+	return f.run(commandLine(name, args), true)
+}
+
+func (f *Fake) run(command string, combined bool) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.history = append(f.history, command)
+
+	last := -1
+	for i, call := range f.calls {
+		if call.Command != command {
+			continue
+		}
+		if !f.used[i] {
+			f.used[i] = true
+			return call.result(combined)
+		}
+		last = i
+	}
+	if last >= 0 {
+		return f.calls[last].result(combined)
+	}
+	return nil, fmt.Errorf("unexpected command: %s", command)
+}
+
+// History returns the commands run so far
+func (f *Fake) History() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]string(nil), f.history...)
+}
+
+// Ran reports whether the command was run
+func (f *Fake) Ran(command string) bool {
+	for _, c := range f.History() {
+		if c == command {
+			return true
+		}
+	}
+	return false
+}
+
+// Recorder runs commands with another runner and keeps their results, to
+// capture fixtures for a Fake on a real system
+type Recorder struct {
+	runner Runner
+	mu     sync.Mutex
+	calls  []Call
+}
+
+// NewRecorder returns a recorder running commands with r
+func NewRecorder(r Runner) *Recorder {
+	return &Recorder{runner: r}
+}
+
+// Output runs the command and records its standard output
+func (r *Recorder) Output(name string, args ...string) ([]byte, error) {
+	output, err := r.runner.Output(name, args...)
+	call := Call{Command: commandLine(name, args), Stdout: string(output)}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		call.Stderr = string(exitErr.Stderr)
+	}
+	r.record(call, err)
+	return output, err
+}
+
+// CombinedOutput runs the command and records its output. The caller gets
+// the output as the command wrote it, so standard output and error are
+// recorded together as the standard output.
+func (r *Recorder) CombinedOutput(name string, args ...string) ([]byte, error) {
+	output, err := r.runner.CombinedOutput(name, args...)
+	r.record(Call{Command: commandLine(name, args), Stdout: string(output)}, err)
+	return output, err
+}
+
+// Stream starts the command and records its output once closed
+func (r *Recorder) Stream(ctx context.Context, name string, args ...string) (io.ReadCloser, error) {
+	call := Call{Command: commandLine(name, args)}
+	output, err := r.runner.Stream(ctx, name, args...)
+	if err != nil {
+		r.record(call, err)
+		return nil, err
+	}
+	s := &recordedStream{output: output}
+	s.Reader = io.TeeReader(output, &s.read)
+	s.done = func(err error) {
+		call.Stdout = s.read.String()
+		var streamErr *StreamError
+		if errors.As(err, &streamErr) {
+			call.Stderr = streamErr.Stderr
+		}
+		r.record(call, err)
+	}
+	return s, nil
+}
+
+// Input runs the command and records its output. Standard output and error
+// are recorded together as the standard output.
+func (r *Recorder) Input(ctx context.Context, stdin io.Reader, name string, args ...string) ([]byte, error) {
+	output, err := r.runner.Input(ctx, stdin, name, args...)
+	r.record(Call{Command: commandLine(name, args), Stdout: string(output)}, err)
+	return output, err
+}
+
+// recordedStream keeps what is read from a stream
+type recordedStream struct {
+	io.Reader
+	output io.Closer
+	read   bytes.Buffer
+	done   func(err error)
+	once   sync.Once
+}
+
+// Close stops the stream and records the call with the error it returned
+func (s *recordedStream) Close() error {
+	err := s.output.Close()
+	s.once.Do(func() { s.done(err) })
+	return err
+}
+
+func (r *Recorder) record(call Call, err error) {
+	var exitErr *exec.ExitError
+	var fakeErr *ExitError
+	switch {
+	case errors.As(err, &exitErr):
+		call.ExitCode = exitErr.ExitCode()
+	case errors.As(err, &fakeErr):
+		call.ExitCode = fakeErr.Code
+	case err != nil:
+		call.Error = err.Error()
+	}
+
+	r.mu.Lock()
+	r.calls = append(r.calls, call)
+	r.mu.Unlock()
+}
+
+// Calls returns the recorded calls
+func (r *Recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Call(nil), r.calls...)
+}
+
+// Save writes the recorded calls as JSON, to be loaded with LoadFake
+func (r *Recorder) Save(path string) error {
+	data, err := json.MarshalIndent(r.Calls(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode calls: %w", err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write calls: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFakeReplay(t *testing.T) {
+	fake := NewFake(
+		Call{Command: "systemctl is-active snapclient", Stdout: "activating\n"},
+		Call{Command: "systemctl is-active snapclient", Stdout: "active\n"},
+		Call{Command: "systemctl is-enabled snapclient", Stdout: "masked\n", ExitCode: 1},
+	)
+
+	// Calls for the same command are replayed in order, the last one sticks
+	for _, expected := range []string{"activating\n", "active\n", "active\n"} {
+		output, err := fake.Output("systemctl", "is-active", "snapclient")
+		if err != nil || string(output) != expected {
+			t.Errorf("Output() = %q, %v, want %q", output, err, expected)
+		}
+	}
+
+	output, err := fake.CombinedOutput("systemctl", "is-enabled", "snapclient")
+	var exitErr *ExitError
+	if !errors.As(err, &exitErr) || exitErr.Code != 1 || string(output) != "masked\n" {
+		t.Errorf("CombinedOutput() = %q, %v, want the output with exit status 1", output, err)
+	}
+
+	if _, err := fake.Output("aplay", "-l"); err == nil || !strings.Contains(err.Error(), "unexpected command: aplay -l") {
+		t.Errorf("unknown command error = %v", err)
+	}
+	if !fake.Ran("aplay -l") || len(fake.History()) != 5 {
+		t.Errorf("History() = %q", fake.History())
+	}
+}
+
+func TestFakeStderr(t *testing.T) {
+	fake := NewFake(Call{Command: "amixer get PCM", Stdout: "out\n", Stderr: "err\n"})
+
+	if output, _ := fake.Output("amixer", "get", "PCM"); string(output) != "out\n" {
+		t.Errorf("Output() = %q, want stdout only", output)
+	}
+	if output, _ := fake.CombinedOutput("amixer", "get", "PCM"); string(output) != "out\nerr\n" {
+		t.Errorf("CombinedOutput() = %q, want stdout and stderr", output)
+	}
+}
+
+func TestRecorderRoundTrip(t *testing.T) {
+	calls := []Call{
+		{Command: "snapclient --version", Stdout: "snapclient v0.27.0\n"},
+		{Command: "systemctl is-active snapclient", Stdout: "inactive\n", ExitCode: 3},
+		{Command: "amixer get PCM", Error: "exec: \"amixer\": executable file not found in $PATH"},
+	}
+	recorder := NewRecorder(NewFake(calls...))
+	recorder.CombinedOutput("snapclient", "--version")
+	recorder.Output("systemctl", "is-active", "snapclient")
+	recorder.CombinedOutput("amixer", "get", "PCM")
+
+	if !reflect.DeepEqual(recorder.Calls(), calls) {
+		t.Errorf("Calls() = %+v, want %+v", recorder.Calls(), calls)
+	}
+
+	path := filepath.Join(t.TempDir(), "calls.json")
+	if err := recorder.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	fake, err := LoadFake(path)
+	if err != nil {
+		t.Fatalf("LoadFake failed: %v", err)
+	}
+	if output, err := fake.Output("systemctl", "is-active", "snapclient"); string(output) != "inactive\n" || err == nil {
+		t.Errorf("replayed Output() = %q, %v", output, err)
+	}
+}
+
+func TestStream(t *testing.T) {
+	fake := NewFake(Call{Command: "journalctl -f", Stdout: "first\nsecond\n"})
+	recorder := NewRecorder(fake)
+
+	output, err := recorder.Stream(context.Background(), "journalctl", "-f")
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	data, _ := io.ReadAll(output)
+	output.Close()
+	if string(data) != "first\nsecond\n" {
+		t.Errorf("streamed %q", data)
+	}
+
+	// The output read is recorded once the stream is closed
+	expected := []Call{{Command: "journalctl -f", Stdout: "first\nsecond\n"}}
+	if !reflect.DeepEqual(recorder.Calls(), expected) {
+		t.Errorf("Calls() = %+v, want %+v", recorder.Calls(), expected)
+	}
+	if _, err := fake.Stream(context.Background(), "journalctl", "-n"); err == nil {
+		t.Error("Stream of an unknown command succeeded")
+	}
+}
+
+func TestRecorderCombinedOutput(t *testing.T) {
+	recorder := NewRecorder(Exec{})
+	// The output is passed through as the command wrote it
+	output, err := recorder.CombinedOutput("sh", "-c", "echo out; echo err >&2; echo more; exit 2")
+	if err == nil || string(output) != "out\nerr\nmore\n" {
+		t.Errorf("CombinedOutput() = %q, %v, want both outputs interleaved and an error", output, err)
+	}
+	expected := []Call{{Command: "sh -c echo out; echo err >&2; echo more; exit 2", Stdout: "out\nerr\nmore\n", ExitCode: 2}}
+	if !reflect.DeepEqual(recorder.Calls(), expected) {
+		t.Errorf("Calls() = %+v, want %+v", recorder.Calls(), expected)
+	}
+}
+
+func TestExecStreamClose(t *testing.T) {
+	output, err := Exec{}.Stream(context.Background(), "sh", "-c", "echo ready; exec sleep 10")
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	line := make([]byte, 6)
+	if _, err := io.ReadFull(output, line); err != nil || string(line) != "ready\n" {
+		t.Fatalf("read %q, %v", line, err)
+	}
+
+	// Closing kills the command instead of waiting for it
+	done := make(chan struct{})
+	go func() {
+		output.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close did not stop the command")
+	}
+}
+
+func TestInput(t *testing.T) {
+	recorder := NewRecorder(Exec{})
+	output, err := recorder.Input(context.Background(), strings.NewReader("samples"), "sh", "-c", "wc -c; echo busy >&2; exit 1")
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) || strings.TrimSpace(string(output)) != "7\nbusy" {
+		t.Errorf("Input() = %q, %v, want the input size, the error and exit status 1", output, err)
+	}
+
+	// The recorded call replays the same output and status
+	fake := NewFake(recorder.Calls()...)
+	output, err = fake.Input(context.Background(), strings.NewReader("samples"), "sh", "-c", "wc -c; echo busy >&2; exit 1")
+	var fakeErr *ExitError
+	if !errors.As(err, &fakeErr) || fakeErr.Code != 1 || strings.TrimSpace(string(output)) != "7\nbusy" {
+		t.Errorf("replayed Input() = %q, %v", output, err)
+	}
+}
+
+func TestStreamExitError(t *testing.T) {
+	recorder := NewRecorder(Exec{})
+	output, err := recorder.Stream(context.Background(), "sh", "-c", "echo level; echo no such device >&2; exit 1")
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	data, _ := io.ReadAll(output)
+	err = output.Close()
+	var exitErr *exec.ExitError
+	if string(data) != "level\n" || !errors.As(err, &exitErr) || !strings.Contains(err.Error(), "no such device") {
+		t.Fatalf("streamed %q, closed with %v, want the output then the error", data, err)
+	}
+
+	// The failure is recorded and replayed with the stream
+	fake := NewFake(recorder.Calls()...)
+	replayed, err := fake.Stream(context.Background(), "sh", "-c", "echo level; echo no such device >&2; exit 1")
+	if err != nil {
+		t.Fatalf("replayed Stream failed: %v", err)
+	}
+	data, _ = io.ReadAll(replayed)
+	err = replayed.Close()
+	var fakeErr *ExitError
+	if string(data) != "level\n" || !errors.As(err, &fakeErr) || fakeErr.Code != 1 || !strings.Contains(err.Error(), "no such device") {
+		t.Errorf("replayed %q, closed with %v", data, err)
+	}
+}
@@ -0,0 +1,180 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expr is a parsed cron expression: minute, hour, day of month, month and
+// day of week, as in crontab(5)
+type Expr struct {
+	minutes  uint64 // Bit n set when minute n matches
+	hours    uint64
+	days     uint64 // Days of month, from bit 1
+	months   uint64 // From bit 1
+	weekdays uint64 // Sunday is bit 0
+	// With both days of month and weekdays restricted, a time matches
+	// when either does, as in cron
+	anyDay, anyWeekday bool
+}
+
+// cronMacros are the shortcuts of crontab(5)
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames   = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	weekdayNames = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// Parse parses a cron expression such as "0 9 * * mon-fri" or "@daily"
+func Parse(spec string) (Expr, error) {
+	spec = strings.TrimSpace(spec)
+	if macro, ok := cronMacros[strings.ToLower(spec)]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return Expr{}, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", spec, len(fields))
+	}
+
+	var expr Expr
+	var err error
+	if expr.minutes, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return Expr{}, fmt.Errorf("invalid minute: %w", err)
+	}
+	if expr.hours, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return Expr{}, fmt.Errorf("invalid hour: %w", err)
+	}
+	if expr.days, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return Expr{}, fmt.Errorf("invalid day of month: %w", err)
+	}
+	if expr.months, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return Expr{}, fmt.Errorf("invalid month: %w", err)
+	}
+	// 7 is Sunday too
+	if expr.weekdays, err = parseField(fields[4], 0, 7, weekdayNames); err != nil {
+		return Expr{}, fmt.Errorf("invalid day of week: %w", err)
+	}
+	if expr.weekdays&(1<<7) != 0 {
+		expr.weekdays = expr.weekdays&^(1<<7) | 1
+	}
+	expr.anyDay = strings.HasPrefix(fields[2], "*")
+	expr.anyWeekday = strings.HasPrefix(fields[4], "*")
+	return expr, nil
+}
+
+// parseField parses a comma separated list of values, ranges and steps, such
+// as "*/15", "1-5" or "mon,wed,fri". names are the values from min on.
+func parseField(field string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+
+		var low, high int
+		switch {
+		case rangePart == "*":
+			low, high = min, max
+		case strings.Contains(rangePart, "-"):
+			lowPart, highPart, _ := strings.Cut(rangePart, "-")
+			var err error
+			if low, err = parseValue(lowPart, min, max, names); err != nil {
+				return 0, err
+			}
+			if high, err = parseValue(highPart, min, max, names); err != nil {
+				return 0, err
+			}
+			if high < low {
+				return 0, fmt.Errorf("invalid range %q", rangePart)
+			}
+		default:
+			var err error
+			if low, err = parseValue(rangePart, min, max, names); err != nil {
+				return 0, err
+			}
+			high = low
+			// "5/10" means from 5 to the end, every 10
+			if hasStep {
+				high = max
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << uint(value)
+		}
+	}
+	return bits, nil
+}
+
+// parseValue parses a number or a name of a field
+func parseValue(value string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(value, name) {
+			return min + i, nil
+		}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", value)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("value %d out of range %d-%d", n, min, max)
+	}
+	return n, nil
+}
+
+// Matches reports whether the minute of t matches the expression
+func (e Expr) Matches(t time.Time) bool {
+	return e.minutes&(1<<uint(t.Minute())) != 0 &&
+		e.hours&(1<<uint(t.Hour())) != 0 &&
+		e.months&(1<<uint(t.Month())) != 0 &&
+		e.dayMatches(t)
+}
+
+func (e Expr) dayMatches(t time.Time) bool {
+	day := e.days&(1<<uint(t.Day())) != 0
+	weekday := e.weekdays&(1<<uint(t.Weekday())) != 0
+	if e.anyDay || e.anyWeekday {
+		return day && weekday
+	}
+	return day || weekday
+}
+
+// Next returns the first minute after t matching the expression, or the zero
+// time when none does in the next five years, as for "0 0 30 2 *"
+func (e Expr) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case e.months&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !e.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case e.hours&(1<<uint(t.Hour())) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case e.minutes&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
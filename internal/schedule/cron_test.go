@@ -0,0 +1,91 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseErrors(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * * funday",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	// Monday 2 March 2026
+	monday := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		time time.Time
+		want bool
+	}{
+		{"0 9 * * mon-fri", monday, true},
+		{"0 9 * * sat,sun", monday, false},
+		{"0 9 * * 1", monday, true},
+		{"*/15 * * * *", monday.Add(45 * time.Minute), true},
+		{"*/15 * * * *", monday.Add(46 * time.Minute), false},
+		{"5/20 * * * *", monday.Add(25 * time.Minute), true},
+		{"0 18-23 * * *", monday.Add(9 * time.Hour), true},
+		{"0 9 * mar *", monday, true},
+		{"0 9 * jan-feb *", monday, false},
+		{"0 0 * * 7", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), true}, // Sunday
+		{"@daily", time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC), true},
+		{"@hourly", monday.Add(30 * time.Minute), false},
+		// Day of month or weekday when both are restricted
+		{"0 9 15 * mon", monday, true},
+		{"0 9 2 * fri", monday, true},
+		{"0 9 15 * fri", monday, false},
+		// Day of month and weekday when one is a wildcard
+		{"0 9 */2 * mon", monday, false},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.spec, err)
+		}
+		if got := expr.Matches(tt.time); got != tt.want {
+			t.Errorf("%q matches %s = %v, want %v", tt.spec, tt.time.Format(time.RFC1123), got, tt.want)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	// Friday 6 March 2026, 18:30
+	friday := time.Date(2026, 3, 6, 18, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		spec string
+		want time.Time
+	}{
+		{"0 9 * * mon-fri", time.Date(2026, 3, 9, 9, 0, 0, 0, time.UTC)},
+		{"0 18 * * *", time.Date(2026, 3, 7, 18, 0, 0, 0, time.UTC)},
+		{"45 18 * * *", time.Date(2026, 3, 6, 18, 45, 0, 0, time.UTC)},
+		{"30 18 * * *", time.Date(2026, 3, 7, 18, 30, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.spec, err)
+		}
+		if got := expr.Next(friday); !got.Equal(tt.want) {
+			t.Errorf("Next(%q) = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+// Package schedule runs actions at times given by cron expressions, such as
+// connecting a speaker every weekday morning. Schedules and the log of their
+// runs are saved to a JSON file.
+package schedule
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ilshidur/bluepicast/internal/jsonfile"
+)
+
+// Action is what a schedule does
+type Action string
+
+const (
+	ActionConnect         Action = "connect"          // Connect a Bluetooth device
+	ActionDisconnect      Action = "disconnect"       // Disconnect a Bluetooth device
+	ActionVolume          Action = "volume"           // Set the ALSA volume of a Snapclient instance
+	ActionSnapclientStart Action = "snapclient_start" // Start a Snapclient instance
+	ActionSnapclientStop  Action = "snapclient_stop"  // Stop a Snapclient instance
+)
+
+const (
+	maxRuns    = 100             // Runs kept in the log
+	maxCatchUp = 5 * time.Minute // Minutes missed while the system was busy or asleep that still run
+	maxRamp    = 60 * 60         // Longest volume ramp, in seconds
+)
+
+// macAddressPattern validates MAC address format (XX:XX:XX:XX:XX:XX)
+var macAddressPattern = regexp.MustCompile(`^([0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}$`)
+
+// Schedule is an action run at the times of a cron expression
+type Schedule struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Cron     string `json:"cron"` // e.g. "0 9 * * mon-fri"
+	Action   Action `json:"action"`
+	Enabled  bool   `json:"enabled"`
+	Address  string `json:"address,omitempty"`  // Device of ActionConnect and ActionDisconnect
+	Instance string `json:"instance,omitempty"` // Snapclient instance, the default one when empty
+	Volume   int    `json:"volume"`             // Percentage (0-100) of ActionVolume
+	Ramp     int    `json:"ramp,omitempty"`     // Seconds ActionVolume takes to reach Volume, 0 to set it at once
+}
+
+// Validate checks that the schedule can run
+func (s Schedule) Validate() error {
+	if _, err := Parse(s.Cron); err != nil {
+		return err
+	}
+	switch s.Action {
+	case ActionConnect, ActionDisconnect:
+		if !macAddressPattern.MatchString(s.Address) {
+			return fmt.Errorf("invalid MAC address format: %s", s.Address)
+		}
+	case ActionVolume:
+		if s.Volume < 0 || s.Volume > 100 {
+			return fmt.Errorf("volume must be between 0 and 100, got %d", s.Volume)
+		}
+		if s.Ramp < 0 || s.Ramp > maxRamp {
+			return fmt.Errorf("ramp must be between 0 and %d seconds, got %d", maxRamp, s.Ramp)
+		}
+	case ActionSnapclientStart, ActionSnapclientStop:
+	default:
+		return fmt.Errorf("unknown action %q", s.Action)
+	}
+	return nil
+}
+
+// target names what the action acts on: a device or a Snapclient instance
+func (s Schedule) target() string {
+	switch s.Action {
+	case ActionConnect, ActionDisconnect:
+		return "device " + strings.ToUpper(s.Address)
+	}
+	return "instance " + s.Instance
+}
+
+// Run is an execution of a schedule
+type Run struct {
+	ScheduleID string    `json:"scheduleId"`
+	Name       string    `json:"name"`
+	Action     Action    `json:"action"`
+	Time       time.Time `json:"time"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// file is the content of the schedules file
+type file struct {
+	Schedules []Schedule `json:"schedules"`
+	Runs      []Run      `json:"runs"`
+}
+
+// Scheduler runs the schedules and keeps them with the log of their runs
+type Scheduler struct {
+	path      string
+	mu        sync.Mutex
+	schedules []Schedule
+	runs      []Run // Newest first
+	execute   func(ctx context.Context, schedule Schedule) error
+	onChange  func()
+	targets   map[string]*sync.Mutex // Runs one action at a time per device or instance
+}
+
+// NewScheduler creates a scheduler saving to the file at path.
+// Call Load to read existing schedules.
+func NewScheduler(path string) *Scheduler {
+	return &Scheduler{path: path}
+}
+
+// Load reads the schedules file. A missing file is not an error.
+func (s *Scheduler) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read schedules: %w", err)
+	}
+
+	var content file
+	if err := json.Unmarshal(data, &content); err != nil {
+		return fmt.Errorf("failed to parse schedules: %w", err)
+	}
+	s.schedules = content.Schedules
+	s.runs = content.Runs
+	return nil
+}
+
+// SetExecutor sets the function running the action of a schedule
+func (s *Scheduler) SetExecutor(execute func(ctx context.Context, schedule Schedule) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.execute = execute
+}
+
+// SetOnChange sets the callback for changes of the schedules or their runs
+func (s *Scheduler) SetOnChange(callback func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onChange = callback
+}
+
+// Schedules returns a copy of the schedules
+func (s *Scheduler) Schedules() []Schedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Schedule(nil), s.schedules...)
+}
+
+// Runs returns the log of runs, newest first
+func (s *Scheduler) Runs() []Run {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Run(nil), s.runs...)
+}
+
+// Save adds a schedule, or replaces the one with the same ID. A schedule
+// without ID gets one.
+func (s *Scheduler) Save(schedule Schedule) (Schedule, error) {
+	if err := schedule.Validate(); err != nil {
+		return Schedule{}, err
+	}
+
+	s.mu.Lock()
+	previous := append([]Schedule(nil), s.schedules...)
+	if schedule.ID == "" {
+		schedule.ID = newID()
+		s.schedules = append(s.schedules, schedule)
+	} else {
+		i := s.index(schedule.ID)
+		if i < 0 {
+			s.mu.Unlock()
+			return Schedule{}, fmt.Errorf("schedule %q not found", schedule.ID)
+		}
+		s.schedules[i] = schedule
+	}
+	if err := s.save(); err != nil {
+		s.schedules = previous
+		s.mu.Unlock()
+		return Schedule{}, err
+	}
+	s.mu.Unlock()
+
+	s.changed()
+	return schedule, nil
+}
+
+// Remove deletes a schedule
+func (s *Scheduler) Remove(id string) error {
+	s.mu.Lock()
+	i := s.index(id)
+	if i < 0 {
+		s.mu.Unlock()
+		return fmt.Errorf("schedule %q not found", id)
+	}
+	previous := append([]Schedule(nil), s.schedules...)
+	s.schedules = append(s.schedules[:i], s.schedules[i+1:]...)
+	if err := s.save(); err != nil {
+		s.schedules = previous
+		s.mu.Unlock()
+		return err
+	}
+	s.mu.Unlock()
+
+	s.changed()
+	return nil
+}
+
+// NextRun returns when an enabled schedule runs next, the zero time otherwise
+func NextRun(schedule Schedule, after time.Time) time.Time {
+	expr, err := Parse(schedule.Cron)
+	if err != nil || !schedule.Enabled {
+		return time.Time{}
+	}
+	return expr.Next(after)
+}
+
+// Run runs the schedules at their times until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	last := time.Now().Truncate(time.Minute)
+	for {
+		next := last.Add(time.Minute)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+
+		now := time.Now().Truncate(time.Minute)
+		// Minutes missed while suspended run late, but not hours late
+		if now.Sub(last) > maxCatchUp {
+			last = now.Add(-maxCatchUp)
+		}
+		// A volume ramp must not delay the next minute
+		go s.runMinutes(ctx, last.Add(time.Minute), now)
+		last = now
+	}
+}
+
+// runMinutes runs the schedules of the minutes from first to last, one
+// minute after the other: after a suspend, a 9:00 connect must not run after
+// a 9:02 disconnect of the same device.
+func (s *Scheduler) runMinutes(ctx context.Context, first, last time.Time) {
+	for minute := first; !minute.After(last); minute = minute.Add(time.Minute) {
+		s.runDue(ctx, minute)
+	}
+}
+
+// runDue runs the enabled schedules matching a minute. Schedules of the same
+// device or instance run in order, the others alongside them.
+func (s *Scheduler) runDue(ctx context.Context, minute time.Time) {
+	var order []string
+	due := make(map[string][]Schedule)
+	for _, schedule := range s.Schedules() {
+		if !schedule.Enabled {
+			continue
+		}
+		expr, err := Parse(schedule.Cron)
+		if err != nil || !expr.Matches(minute) {
+			continue
+		}
+		target := schedule.target()
+		if _, ok := due[target]; !ok {
+			order = append(order, target)
+		}
+		due[target] = append(due[target], schedule)
+	}
+
+	var wg sync.WaitGroup
+	for _, target := range order {
+		wg.Add(1)
+		go func(schedules []Schedule) {
+			defer wg.Done()
+			for _, schedule := range schedules {
+				s.RunNow(ctx, schedule)
+			}
+		}(due[target])
+	}
+	wg.Wait()
+}
+
+// RunNow runs the action of a schedule and logs the run
+func (s *Scheduler) RunNow(ctx context.Context, schedule Schedule) error {
+	s.mu.Lock()
+	execute := s.execute
+	s.mu.Unlock()
+	if execute == nil {
+		return fmt.Errorf("no executor for schedules")
+	}
+
+	// Actions on the same device or instance run one after the other, so a
+	// volume ramp only delays the actions of its instance
+	targetMu := s.targetLock(schedule.target())
+	targetMu.Lock()
+	log.Printf("Running schedule %q (%s)", schedule.Name, schedule.Action)
+	run := Run{ScheduleID: schedule.ID, Name: schedule.Name, Action: schedule.Action, Time: time.Now()}
+	err := execute(ctx, schedule)
+	targetMu.Unlock()
+	if err != nil {
+		log.Printf("Warning: Schedule %q failed: %v", schedule.Name, err)
+		run.Error = err.Error()
+	}
+
+	s.mu.Lock()
+	s.runs = append([]Run{run}, s.runs...)
+	if len(s.runs) > maxRuns {
+		s.runs = s.runs[:maxRuns]
+	}
+	if saveErr := s.save(); saveErr != nil {
+		log.Printf("Warning: Failed to save schedule runs: %v", saveErr)
+	}
+	s.mu.Unlock()
+
+	s.changed()
+	return err
+}
+
+// Find returns the schedule with an ID
+func (s *Scheduler) Find(id string) (Schedule, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.index(id)
+	if i < 0 {
+		return Schedule{}, false
+	}
+	return s.schedules[i], true
+}
+
+// targetLock returns the mutex serializing the actions on a target
+func (s *Scheduler) targetLock(target string) *sync.Mutex {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.targets == nil {
+		s.targets = make(map[string]*sync.Mutex)
+	}
+	targetMu, ok := s.targets[target]
+	if !ok {
+		targetMu = &sync.Mutex{}
+		s.targets[target] = targetMu
+	}
+	return targetMu
+}
+
+func (s *Scheduler) index(id string) int {
+	for i, schedule := range s.schedules {
+		if schedule.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s *Scheduler) changed() {
+	s.mu.Lock()
+	callback := s.onChange
+	s.mu.Unlock()
+	if callback != nil {
+		callback()
+	}
+}
+
+// save writes the schedules file, with s.mu held
+func (s *Scheduler) save() error {
+	return jsonfile.Write(s.path, file{Schedules: s.schedules, Runs: s.runs}, "schedules")
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
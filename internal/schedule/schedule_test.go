@@ -0,0 +1,221 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		schedule Schedule
+		valid    bool
+	}{
+		{Schedule{Cron: "0 9 * * *", Action: ActionConnect, Address: "AA:BB:CC:DD:EE:FF"}, true},
+		{Schedule{Cron: "0 9 * * *", Action: ActionConnect, Address: "speaker"}, false},
+		{Schedule{Cron: "0 18 * * *", Action: ActionVolume, Volume: 30, Ramp: 60}, true},
+		{Schedule{Cron: "0 18 * * *", Action: ActionVolume, Volume: 130}, false},
+		{Schedule{Cron: "0 18 * * *", Action: ActionVolume, Volume: 30, Ramp: -1}, false},
+		{Schedule{Cron: "0 23 * * *", Action: ActionSnapclientStop}, true},
+		{Schedule{Cron: "0 23 * *", Action: ActionSnapclientStop}, false},
+		{Schedule{Cron: "0 23 * * *", Action: "reboot"}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.schedule.Validate(); (err == nil) != tt.valid {
+			t.Errorf("Validate(%+v) = %v, want valid %v", tt.schedule, err, tt.valid)
+		}
+	}
+}
+
+func TestSchedulerPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "schedules.json")
+	s := NewScheduler(path)
+
+	saved, err := s.Save(Schedule{Name: "Office hours", Cron: "0 9 * * mon-fri", Action: ActionConnect, Address: "AA:BB:CC:DD:EE:FF", Enabled: true})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if saved.ID == "" {
+		t.Fatal("Save did not assign an ID")
+	}
+	if _, err := s.Save(Schedule{ID: "unknown", Cron: "@daily", Action: ActionSnapclientStop}); err == nil {
+		t.Error("Save of an unknown ID succeeded")
+	}
+
+	s.SetExecutor(func(ctx context.Context, schedule Schedule) error {
+		return errors.New("device not available")
+	})
+	if err := s.RunNow(context.Background(), saved); err == nil {
+		t.Error("RunNow did not return the executor error")
+	}
+
+	loaded := NewScheduler(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	schedules := loaded.Schedules()
+	if len(schedules) != 1 || schedules[0] != saved {
+		t.Errorf("Loaded schedules = %+v, want [%+v]", schedules, saved)
+	}
+	runs := loaded.Runs()
+	if len(runs) != 1 || runs[0].ScheduleID != saved.ID || runs[0].Error != "device not available" {
+		t.Errorf("Loaded runs = %+v, want a failed run of %s", runs, saved.ID)
+	}
+
+	if err := loaded.Remove(saved.ID); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if len(loaded.Schedules()) != 0 {
+		t.Error("Schedule not removed")
+	}
+}
+
+func TestRunDue(t *testing.T) {
+	s := NewScheduler(filepath.Join(t.TempDir(), "schedules.json"))
+	for _, schedule := range []Schedule{
+		{Name: "connect", Cron: "0 9 * * *", Action: ActionConnect, Address: "AA:BB:CC:DD:EE:FF", Enabled: true},
+		{Name: "quiet", Cron: "0 18 * * *", Action: ActionVolume, Volume: 30, Enabled: true},
+		{Name: "start", Cron: "0 9 * * *", Action: ActionSnapclientStart, Enabled: true},
+		{Name: "disabled", Cron: "0 9 * * *", Action: ActionSnapclientStop},
+	} {
+		if _, err := s.Save(schedule); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	var ran []string
+	s.SetExecutor(func(ctx context.Context, schedule Schedule) error {
+		mu.Lock()
+		defer mu.Unlock()
+		ran = append(ran, schedule.Name)
+		return nil
+	})
+	s.runDue(context.Background(), time.Date(2026, 3, 2, 9, 0, 0, 0, time.Local))
+
+	sort.Strings(ran)
+	if len(ran) != 2 || ran[0] != "connect" || ran[1] != "start" {
+		t.Errorf("Ran %v, want [connect start]", ran)
+	}
+	if runs := s.Runs(); len(runs) != 2 {
+		t.Errorf("Runs = %+v, want 2", runs)
+	}
+}
+
+func TestRunMinutesInOrder(t *testing.T) {
+	s := NewScheduler(filepath.Join(t.TempDir(), "schedules.json"))
+	for _, schedule := range []Schedule{
+		{Name: "disconnect", Cron: "2 9 * * *", Action: ActionDisconnect, Address: "AA:BB:CC:DD:EE:FF", Enabled: true},
+		{Name: "connect", Cron: "0 9 * * *", Action: ActionConnect, Address: "AA:BB:CC:DD:EE:FF", Enabled: true},
+		{Name: "volume", Cron: "1 9 * * *", Action: ActionVolume, Volume: 30, Enabled: true},
+	} {
+		if _, err := s.Save(schedule); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	var mu sync.Mutex
+	var ran []string
+	s.SetExecutor(func(ctx context.Context, schedule Schedule) error {
+		mu.Lock()
+		defer mu.Unlock()
+		ran = append(ran, schedule.Name)
+		return nil
+	})
+	// The minutes missed while suspended, from 9:00 to 9:02
+	s.runMinutes(context.Background(), time.Date(2026, 3, 2, 9, 0, 0, 0, time.Local), time.Date(2026, 3, 2, 9, 2, 0, 0, time.Local))
+
+	if len(ran) != 3 || ran[0] != "connect" || ran[1] != "volume" || ran[2] != "disconnect" {
+		t.Errorf("Ran %v, want [connect volume disconnect]", ran)
+	}
+}
+
+func TestRunDueSerializesPerTarget(t *testing.T) {
+	s := NewScheduler(filepath.Join(t.TempDir(), "schedules.json"))
+	for _, schedule := range []Schedule{
+		{Name: "ramp", Cron: "0 9 * * *", Action: ActionVolume, Volume: 30, Ramp: 60, Enabled: true},
+		{Name: "connect", Cron: "0 9 * * *", Action: ActionConnect, Address: "AA:BB:CC:DD:EE:FF", Enabled: true},
+		{Name: "stop", Cron: "0 9 * * *", Action: ActionSnapclientStop, Enabled: true},
+	} {
+		if _, err := s.Save(schedule); err != nil {
+			t.Fatalf("Save failed: %v", err)
+		}
+	}
+
+	ramping := make(chan struct{})
+	connected := make(chan struct{})
+	var mu sync.Mutex
+	var ran []string
+	s.SetExecutor(func(ctx context.Context, schedule Schedule) error {
+		mu.Lock()
+		ran = append(ran, schedule.Name)
+		mu.Unlock()
+		switch schedule.Name {
+		case "ramp":
+			close(ramping)
+			// The ramp lasts until the device of another schedule is connected
+			select {
+			case <-connected:
+			case <-time.After(5 * time.Second):
+				return errors.New("connect waited for the ramp")
+			}
+		case "connect":
+			close(connected)
+		}
+		return nil
+	})
+	s.runDue(context.Background(), time.Date(2026, 3, 2, 9, 0, 0, 0, time.Local))
+
+	for _, run := range s.Runs() {
+		if run.Error != "" {
+			t.Errorf("Run of %s failed: %s", run.Name, run.Error)
+		}
+	}
+	// The stop of the same instance waits for the end of the ramp
+	var ramp, stop int
+	for i, name := range ran {
+		switch name {
+		case "ramp":
+			ramp = i
+		case "stop":
+			stop = i
+		}
+	}
+	if len(ran) != 3 || stop < ramp {
+		t.Errorf("Ran %v, want stop after ramp", ran)
+	}
+}
+
+func TestSaveFailureKeepsSchedules(t *testing.T) {
+	dir := t.TempDir()
+	s := NewScheduler(filepath.Join(dir, "schedules.json"))
+	saved, err := s.Save(Schedule{Name: "stop", Cron: "0 23 * * *", Action: ActionSnapclientStop, Enabled: true})
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// A directory in the way of the temporary file makes saving fail
+	if err := os.Mkdir(filepath.Join(dir, "schedules.json.tmp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Save(Schedule{Name: "start", Cron: "0 9 * * *", Action: ActionSnapclientStart}); err == nil {
+		t.Error("Save succeeded without writing the file")
+	}
+	changed := saved
+	changed.Enabled = false
+	if _, err := s.Save(changed); err == nil {
+		t.Error("Save of a change succeeded without writing the file")
+	}
+	if err := s.Remove(saved.ID); err == nil {
+		t.Error("Remove succeeded without writing the file")
+	}
+
+	if schedules := s.Schedules(); len(schedules) != 1 || schedules[0] != saved {
+		t.Errorf("Schedules = %+v, want [%+v]", schedules, saved)
+	}
+}
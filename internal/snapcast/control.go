@@ -0,0 +1,320 @@
+package snapcast
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultControlPort is the TCP port of the Snapserver JSON-RPC control API
+	DefaultControlPort = "1705"
+
+	controlRetryInterval = 10 * time.Second
+	controlCallTimeout   = 5 * time.Second
+	controlMaxLineSize   = 4 * 1024 * 1024 // Server.GetStatus grows with the number of clients
+)
+
+// ErrControlUnavailable is returned when the Snapserver control connection is down
+var ErrControlUnavailable = errors.New("not connected to the Snapserver")
+
+// ServerStatus is the state of the Snapserver, as returned by Server.GetStatus
+type ServerStatus struct {
+	Groups  []Group  `json:"groups"`
+	Streams []Stream `json:"streams"`
+}
+
+// Group is a set of clients playing the same stream
+type Group struct {
+	ID       string         `json:"id"`
+	Name     string         `json:"name"`
+	Muted    bool           `json:"muted"`
+	StreamID string         `json:"stream_id"`
+	Clients  []ServerClient `json:"clients"`
+}
+
+// ServerClient is a Snapclient known by the Snapserver
+type ServerClient struct {
+	ID        string `json:"id"`
+	Connected bool   `json:"connected"`
+	Host      struct {
+		Name string `json:"name"`
+		IP   string `json:"ip"`
+	} `json:"host"`
+	Config struct {
+		Name string `json:"name"`
+	} `json:"config"`
+}
+
+// Stream is an audio source of the Snapserver
+type Stream struct {
+	ID         string `json:"id"`
+	Status     string `json:"status"` // "playing", "idle" or "unknown"
+	Properties struct {
+		PlaybackStatus string         `json:"playbackStatus"`
+		Metadata       StreamMetadata `json:"metadata"`
+	} `json:"properties"`
+}
+
+// StreamMetadata describes the track played on a stream, when the source reports it
+type StreamMetadata struct {
+	Title    string   `json:"title"`
+	Artist   []string `json:"artist"`
+	Album    string   `json:"album"`
+	Duration float64  `json:"duration"` // Seconds
+}
+
+// FindGroup returns the group of the client with the given ID or host name
+func (s *ServerStatus) FindGroup(clientID string) *Group {
+	for i := range s.Groups {
+		for _, c := range s.Groups[i].Clients {
+			if c.ID == clientID || c.Config.Name == clientID || c.Host.Name == clientID {
+				return &s.Groups[i]
+			}
+		}
+	}
+	return nil
+}
+
+// FindStream returns the stream with the given ID
+func (s *ServerStatus) FindStream(id string) *Stream {
+	for i := range s.Streams {
+		if s.Streams[i].ID == id {
+			return &s.Streams[i]
+		}
+	}
+	return nil
+}
+
+// ControlAddress returns the address of the control API of the Snapserver
+// a client connects to (e.g. "ws://192.168.1.10:1780" -> "192.168.1.10:1705")
+func ControlAddress(host string) string {
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	host = strings.TrimSuffix(host, "/")
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, DefaultControlPort)
+}
+
+type rpcRequest struct {
+	JSONRPC string      `json:"jsonrpc"`
+	ID      int         `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+type rpcMessage struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("snapserver error %d: %s", e.Code, e.Message)
+}
+
+// ControlClient talks to the Snapserver over its JSON-RPC control API
+type ControlClient struct {
+	addr           string
+	mu             sync.Mutex
+	conn           net.Conn
+	nextID         int
+	pending        map[int]chan rpcMessage
+	onNotification func(method string)
+}
+
+// NewControlClient creates a client for the control API at addr (host:port)
+func NewControlClient(addr string) *ControlClient {
+	return &ControlClient{
+		addr:    addr,
+		pending: make(map[int]chan rpcMessage),
+	}
+}
+
+// SetOnNotification sets the callback for notifications sent by the Snapserver
+// (e.g. "Stream.OnProperties"). It is also called with an empty method once
+// the connection is established.
+func (c *ControlClient) SetOnNotification(fn func(method string)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onNotification = fn
+}
+
+// Run keeps the connection to the Snapserver open until ctx is canceled
+func (c *ControlClient) Run(ctx context.Context) {
+	for {
+		if err := c.serve(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Snapserver control connection to %s: %v", c.addr, err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(controlRetryInterval):
+		}
+	}
+}
+
+// serve connects to the Snapserver and dispatches messages until the connection drops
+func (c *ControlClient) serve(ctx context.Context) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect: %w", err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	c.mu.Lock()
+	c.conn = conn
+	onNotification := c.onNotification
+	c.mu.Unlock()
+
+	log.Printf("Connected to Snapserver control API at %s", c.addr)
+	if onNotification != nil {
+		go onNotification("")
+	}
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 64*1024), controlMaxLineSize)
+	for scanner.Scan() {
+		c.handleLine(scanner.Bytes())
+	}
+	err = scanner.Err()
+	if err == nil {
+		err = errors.New("connection closed by the server")
+	}
+
+	c.mu.Lock()
+	c.conn = nil
+	for id, ch := range c.pending {
+		close(ch)
+		delete(c.pending, id)
+	}
+	c.mu.Unlock()
+	conn.Close()
+
+	return err
+}
+
+// handleLine dispatches a response or notification received from the Snapserver
+func (c *ControlClient) handleLine(line []byte) {
+	var msg rpcMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		log.Printf("Ignoring malformed message from Snapserver: %v", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if msg.ID != nil {
+		if ch, ok := c.pending[*msg.ID]; ok {
+			ch <- msg
+			delete(c.pending, *msg.ID)
+		}
+		return
+	}
+	if msg.Method != "" && c.onNotification != nil {
+		go c.onNotification(msg.Method)
+	}
+}
+
+// call sends a request and stores its result in result (if not nil)
+func (c *ControlClient) call(ctx context.Context, method string, params, result interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, controlCallTimeout)
+	defer cancel()
+
+	c.mu.Lock()
+	if c.conn == nil {
+		c.mu.Unlock()
+		return ErrControlUnavailable
+	}
+	c.nextID++
+	id := c.nextID
+	ch := make(chan rpcMessage, 1)
+	c.pending[id] = ch
+
+	data, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: id, Method: method, Params: params})
+	if err == nil {
+		c.conn.SetWriteDeadline(time.Now().Add(controlCallTimeout))
+		_, err = c.conn.Write(append(data, '\n'))
+	}
+	if err != nil {
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return fmt.Errorf("failed to send %s: %w", method, err)
+	}
+	c.mu.Unlock()
+
+	select {
+	case msg, ok := <-ch:
+		if !ok {
+			return ErrControlUnavailable
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if result != nil {
+			if err := json.Unmarshal(msg.Result, result); err != nil {
+				return fmt.Errorf("failed to parse %s result: %w", method, err)
+			}
+		}
+		return nil
+	case <-ctx.Done():
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// GetStatus returns the groups and streams of the Snapserver
+func (c *ControlClient) GetStatus(ctx context.Context) (*ServerStatus, error) {
+	var result struct {
+		Server ServerStatus `json:"server"`
+	}
+	if err := c.call(ctx, "Server.GetStatus", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result.Server, nil
+}
+
+// SetGroupMute mutes or unmutes a group
+func (c *ControlClient) SetGroupMute(ctx context.Context, groupID string, mute bool) error {
+	params := map[string]interface{}{"id": groupID, "mute": mute}
+	return c.call(ctx, "Group.SetMute", params, nil)
+}
+
+// SetGroupStream switches the stream played by a group
+func (c *ControlClient) SetGroupStream(ctx context.Context, groupID, streamID string) error {
+	params := map[string]interface{}{"id": groupID, "stream_id": streamID}
+	return c.call(ctx, "Group.SetStream", params, nil)
+}
@@ -0,0 +1,191 @@
+package snapcast
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+)
+
+const testStatus = `{"server":{"groups":[{"id":"g1","name":"","muted":false,"stream_id":"radio",
+"clients":[{"id":"b8:27:eb:00:00:01","connected":true,"host":{"name":"livingroom","ip":"192.168.1.20"},"config":{"name":""}}]}],
+"streams":[{"id":"radio","status":"playing","properties":{"playbackStatus":"playing",
+"metadata":{"title":"Song","artist":["Band"],"album":"Album","duration":215.5}}},
+{"id":"spotify","status":"idle","properties":{}}]}}`
+
+// fakeSnapserver answers JSON-RPC requests with the handler result and
+// records the requests it received
+type fakeSnapserver struct {
+	listener net.Listener
+	requests chan map[string]interface{}
+	conns    chan net.Conn
+}
+
+func newFakeSnapserver(t *testing.T, handler func(method string) string) *fakeSnapserver {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &fakeSnapserver{
+		listener: listener,
+		requests: make(chan map[string]interface{}, 10),
+		conns:    make(chan net.Conn, 1),
+	}
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		server.conns <- conn
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			var req map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &req); err != nil {
+				continue
+			}
+			server.requests <- req
+			// Responses are newline delimited, compact the canned result
+			var line bytes.Buffer
+			json.Compact(&line, []byte(`{"jsonrpc":"2.0","id":`+formatID(req["id"])+`,`+handler(req["method"].(string))+`}`))
+			conn.Write(append(line.Bytes(), '\n'))
+		}
+	}()
+	return server
+}
+
+func formatID(id interface{}) string {
+	data, _ := json.Marshal(id)
+	return string(data)
+}
+
+// connectedClient returns a client connected to server
+func connectedClient(t *testing.T, server *fakeSnapserver) (*ControlClient, chan string) {
+	t.Helper()
+	client := NewControlClient(server.listener.Addr().String())
+	notifications := make(chan string, 10)
+	client.SetOnNotification(func(method string) { notifications <- method })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	go client.Run(ctx)
+
+	select {
+	case method := <-notifications:
+		if method != "" {
+			t.Fatalf("first notification = %q, want connection notice", method)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client did not connect")
+	}
+	return client, notifications
+}
+
+func TestControlGetStatus(t *testing.T) {
+	server := newFakeSnapserver(t, func(method string) string {
+		return `"result":` + testStatus
+	})
+	client, _ := connectedClient(t, server)
+
+	status, err := client.GetStatus(context.Background())
+	if err != nil {
+		t.Fatalf("GetStatus failed: %v", err)
+	}
+
+	group := status.FindGroup("livingroom")
+	if group == nil || group.ID != "g1" {
+		t.Fatalf("FindGroup(livingroom) = %+v, want group g1", group)
+	}
+	if status.FindGroup("b8:27:eb:00:00:01") != group {
+		t.Error("FindGroup should also match the client ID")
+	}
+	if status.FindGroup("kitchen") != nil {
+		t.Error("FindGroup should not match an unknown client")
+	}
+
+	stream := status.FindStream(group.StreamID)
+	if stream == nil {
+		t.Fatal("stream of the group not found")
+	}
+	metadata := stream.Properties.Metadata
+	if metadata.Title != "Song" || len(metadata.Artist) != 1 || metadata.Artist[0] != "Band" || metadata.Duration != 215.5 {
+		t.Errorf("metadata = %+v, want Song by Band", metadata)
+	}
+}
+
+func TestControlSetGroupMute(t *testing.T) {
+	server := newFakeSnapserver(t, func(method string) string {
+		return `"result":{"mute":true}`
+	})
+	client, _ := connectedClient(t, server)
+
+	if err := client.SetGroupMute(context.Background(), "g1", true); err != nil {
+		t.Fatalf("SetGroupMute failed: %v", err)
+	}
+
+	req := <-server.requests
+	params, _ := req["params"].(map[string]interface{})
+	if req["method"] != "Group.SetMute" || params["id"] != "g1" || params["mute"] != true {
+		t.Errorf("request = %v, want Group.SetMute on g1", req)
+	}
+}
+
+func TestControlError(t *testing.T) {
+	server := newFakeSnapserver(t, func(method string) string {
+		return `"error":{"code":-32603,"message":"Group not found"}`
+	})
+	client, _ := connectedClient(t, server)
+
+	err := client.SetGroupStream(context.Background(), "missing", "radio")
+	if err == nil || err.Error() != "snapserver error -32603: Group not found" {
+		t.Errorf("SetGroupStream error = %v, want the server error", err)
+	}
+}
+
+func TestControlNotification(t *testing.T) {
+	server := newFakeSnapserver(t, func(method string) string { return `"result":{}` })
+	_, notifications := connectedClient(t, server)
+
+	conn := <-server.conns
+	conn.Write([]byte(`{"jsonrpc":"2.0","method":"Stream.OnProperties","params":{"id":"radio"}}` + "\n"))
+
+	select {
+	case method := <-notifications:
+		if method != "Stream.OnProperties" {
+			t.Errorf("notification = %q, want Stream.OnProperties", method)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("notification not received")
+	}
+}
+
+func TestControlNotConnected(t *testing.T) {
+	client := NewControlClient("127.0.0.1:1")
+	if _, err := client.GetStatus(context.Background()); err != ErrControlUnavailable {
+		t.Errorf("GetStatus error = %v, want %v", err, ErrControlUnavailable)
+	}
+}
+
+func TestControlAddress(t *testing.T) {
+	tests := []struct {
+		host     string
+		expected string
+	}{
+		{host: "", expected: "127.0.0.1:1705"},
+		{host: "192.168.1.10", expected: "192.168.1.10:1705"},
+		{host: "ws://192.168.1.10:1780", expected: "192.168.1.10:1705"},
+		{host: "tcp://snapserver.local:1704/", expected: "snapserver.local:1705"},
+		{host: "ws://[fd00::1]:1780", expected: "[fd00::1]:1705"},
+	}
+
+	for _, tt := range tests {
+		if result := ControlAddress(tt.host); result != tt.expected {
+			t.Errorf("ControlAddress(%q) = %q, want %q", tt.host, result, tt.expected)
+		}
+	}
+}
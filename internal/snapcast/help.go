@@ -0,0 +1,100 @@
+package snapcast
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// helpOptionRegex matches the long option at the start of a snapclient --help line
+var helpOptionRegex = regexp.MustCompile(`^\s*(?:-\w,\s*)?--([A-Za-z][\w-]*)`)
+
+// parseHelpOptions returns the long options listed by snapclient --help,
+// with the rest of their help line
+func parseHelpOptions(help string) map[string]string {
+	options := make(map[string]string)
+	for _, line := range strings.Split(help, "\n") {
+		matches := helpOptionRegex.FindStringSubmatchIndex(line)
+		if matches == nil {
+			continue
+		}
+		name := line[matches[2]:matches[3]]
+		options[name] = strings.TrimSpace(line[matches[1]:])
+	}
+	return options
+}
+
+// SupportedOptions returns the options supported by the installed snapclient.
+// The result is cached until the snapclient version changes.
+func (m *Manager) SupportedOptions() (map[string]string, error) {
+	version, err := m.GetVersion()
+	if err != nil {
+		return nil, err
+	}
+
+	m.helpMu.Lock()
+	defer m.helpMu.Unlock()
+
+	if m.helpOptions != nil && m.helpVersion == version {
+		return m.helpOptions, nil
+	}
+
+	// Some versions exit with a non-zero status after printing the help
+	output, err := m.runner.CombinedOutput(m.executablePath, "--help")
+	options := parseHelpOptions(string(output))
+	if len(options) == 0 {
+		if err != nil {
+			return nil, fmt.Errorf("failed to run snapclient --help: %w", err)
+		}
+		return nil, fmt.Errorf("no options found in snapclient --help output")
+	}
+
+	m.helpOptions = options
+	m.helpVersion = version
+	return options, nil
+}
+
+// checkSupported verifies that the installed snapclient supports the
+// settings managed by BluePiCast
+func checkSupported(options *Options, supported map[string]string) error {
+	for _, name := range []string{"latency", "sampleformat", "mixer"} {
+		value, ok := options.Get(name)
+		if !ok {
+			continue
+		}
+		help, ok := supported[name]
+		if !ok {
+			return fmt.Errorf("the installed snapclient does not support --%s", name)
+		}
+
+		// The mixer help lists the modes, e.g. "software|hardware|script|none|?[:<options>]"
+		if name == "mixer" {
+			if modes := helpChoices(help); modes != nil {
+				mode, _, _ := strings.Cut(value, ":")
+				if !contains(modes, mode) {
+					return fmt.Errorf("the installed snapclient does not support the %s mixer", mode)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// helpChoices returns the values listed as "a|b|c" in a help line, or nil
+func helpChoices(help string) []string {
+	for _, field := range strings.Fields(help) {
+		if strings.Contains(field, "|") {
+			return strings.Split(field, "|")
+		}
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package snapcast
+
+import (
+	"strings"
+	"testing"
+)
+
+// Output of snapclient --help, v0.27
+const testHelp = `snapclient v0.27.0
+Copyright (C) 2014-2023 BadAix (snapcast@badaix.de).
+
+Allowed options:
+  --help                          Produce help message
+  -v, --version                   Show version number
+  --hostID arg                    unique host id, default is MAC address
+  -i, --instance arg (=1)         instance id when running multiple instances on the same host
+  --logsink arg                   log sink [null,system,stdout,stderr,file:<filename>]
+  --logfilter arg (=*:info)       log filter <tag>:<level>[,<tag>:<level>]*
+  -l, --list                      list PCM devices
+  -s, --soundcard arg (=default)  index or name of the pcm device
+  --latency arg (=0)              latency of the PCM device
+  --sampleformat arg              resample audio stream to <rate>:<bits>:<channels>
+  --player arg (=alsa)            alsa|pulse|file[:<options>|?]
+  --mixer arg (=software)         software|hardware|script|none|?[:<options>]
+  -d, --daemon [=arg(=-3)]        daemonize, optional process priority [-20..19]
+  --user arg                      the user[:group] to run snapclient as when daemonized
+`
+
+func TestParseHelpOptions(t *testing.T) {
+	options := parseHelpOptions(testHelp)
+
+	for _, name := range []string{"help", "version", "hostID", "instance", "soundcard", "latency", "sampleformat", "mixer", "daemon"} {
+		if _, ok := options[name]; !ok {
+			t.Errorf("option --%s not found", name)
+		}
+	}
+	if _, ok := options["Allowed"]; ok {
+		t.Error("non-option lines should be ignored")
+	}
+	if help := options["mixer"]; help != "arg (=software)         software|hardware|script|none|?[:<options>]" {
+		t.Errorf("mixer help = %q", help)
+	}
+}
+
+func TestCheckSupported(t *testing.T) {
+	supported := parseHelpOptions(testHelp)
+
+	// Versions before 0.27 have no "none" mixer, very old ones no --mixer at all
+	older := parseHelpOptions(strings.Replace(testHelp, "script|none|?", "script|?", 1))
+	oldest := parseHelpOptions(strings.Replace(testHelp, "  --mixer", "  --xmixer", 1))
+
+	tests := []struct {
+		name      string
+		opts      string
+		supported map[string]string
+		wantErr   string
+	}{
+		{name: "all supported", opts: "--latency 100 --sampleformat 48000:16:* --mixer hardware:Digital", supported: supported},
+		{name: "none mixer", opts: "--mixer none", supported: supported},
+		{name: "none mixer on older version", opts: "--mixer none", supported: older, wantErr: "does not support the none mixer"},
+		{name: "mixer on oldest version", opts: "--mixer software", supported: oldest, wantErr: "does not support --mixer"},
+		{name: "unmanaged option", opts: "--future-option x", supported: oldest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSupported(ParseOptions(tt.opts), tt.supported)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("checkSupported(%q) = %v, want no error", tt.opts, err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("checkSupported(%q) = %v, want error containing %q", tt.opts, err, tt.wantErr)
+			}
+		})
+	}
+}
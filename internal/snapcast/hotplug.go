@@ -0,0 +1,143 @@
+package snapcast
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Ilshidur/bluepicast/internal/alsa"
+)
+
+// soundcardPollInterval is how often soundcards are read when no uevent
+// reports them
+const soundcardPollInterval = 5 * time.Second
+
+// preferredPCMPrefixes are tried in order to play to a card plugged in, the
+// first ones convert the stream format when the card needs it
+var preferredPCMPrefixes = []string{"default:CARD=", "sysdefault:CARD=", "front:CARD=", "hw:CARD="}
+
+// SetOnSoundcardChange sets the callback for soundcards plugged in or removed
+func (m *Manager) SetOnSoundcardChange(callback func(event alsa.CardEvent)) {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+	m.onSoundcardChange = callback
+}
+
+// SetPreferredSoundcard sets the card, by ID as in /proc/asound/cards, the
+// default instance switches to when it is plugged in. Empty keeps the
+// configured soundcard.
+func (m *Manager) SetPreferredSoundcard(card string) {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+	m.preferredSoundcard = card
+}
+
+// WatchSoundcards follows the soundcards plugged in and removed until ctx is done
+func (m *Manager) WatchSoundcards(ctx context.Context) {
+	if !m.enabled || m.alsa == nil {
+		return
+	}
+	m.alsa.Watch(ctx, soundcardPollInterval, m.handleSoundcardEvent)
+}
+
+// handleSoundcardEvent restarts the instances playing to a card plugged back
+// in, or switches the default instance to the preferred card
+func (m *Manager) handleSoundcardEvent(event alsa.CardEvent) {
+	m.statusMu.Lock()
+	callback := m.onSoundcardChange
+	preferred := m.preferredSoundcard
+	m.statusMu.Unlock()
+
+	if event.Added {
+		log.Printf("Soundcard %d (%s) plugged in", event.Card.Index, event.Card.ID)
+		m.soundcardAdded(event.Card, preferred)
+	} else {
+		log.Printf("Soundcard %d (%s) removed", event.Card.Index, event.Card.ID)
+	}
+
+	if callback != nil {
+		callback(event)
+	}
+}
+
+func (m *Manager) soundcardAdded(card alsa.Card, preferred string) {
+	instances, err := m.ListInstances()
+	if err != nil {
+		log.Printf("Warning: Failed to list Snapclient instances: %v", err)
+		return
+	}
+
+	for _, instance := range instances {
+		config, err := m.GetConfig(instance)
+		if err != nil || config.Player != "alsa" {
+			continue
+		}
+
+		switch {
+		case playsTo(config.Soundcard, card):
+			// snapclient lost the card when it was removed
+			log.Printf("Soundcard of %s is back, restarting it", unitName(instance))
+		// A Bluetooth route takes precedence over the preferred card
+		case instance == DefaultInstance && preferred != "" && strings.EqualFold(preferred, card.ID) &&
+			!strings.Contains(config.Soundcard, "bluealsa"):
+			config.Soundcard = m.cardPCM(card)
+			if err := m.SetConfig(instance, config); err != nil {
+				log.Printf("Warning: Failed to switch %s to soundcard %s: %v", unitName(instance), card.ID, err)
+				continue
+			}
+			log.Printf("Switched %s to preferred soundcard %s", unitName(instance), config.Soundcard)
+		default:
+			continue
+		}
+
+		if !m.IsUserServiceEnabled(instance) {
+			continue
+		}
+		if err := m.restartUnlessStopped(instance); err != nil {
+			log.Printf("Warning: Failed to restart %s: %v", unitName(instance), err)
+		}
+	}
+}
+
+// restartUnlessStopped restarts an instance that is running, or that failed,
+// usually because snapclient crashed when its card went away. An instance
+// stopped on purpose stays stopped.
+func (m *Manager) restartUnlessStopped(instance string) error {
+	if m.instanceState(instance).ActiveState != "failed" {
+		return m.TryRestartService(instance)
+	}
+	if m.supervisor == nil {
+		// systemd refuses to start a unit that hit its start limit
+		if err := m.runUserSystemctl("reset-failed", unitName(instance)); err != nil {
+			log.Printf("Warning: Failed to reset the failed state of %s: %v", unitName(instance), err)
+		}
+	}
+	log.Printf("%s failed, starting it again", unitName(instance))
+	return m.RestartService(instance)
+}
+
+// playsTo reports whether a soundcard setting plays to a card
+func playsTo(soundcard string, card alsa.Card) bool {
+	name, ok := cardName(soundcard)
+	if !ok {
+		return false
+	}
+	return name == card.ID || name == strconv.Itoa(card.Index)
+}
+
+// cardPCM returns the PCM device to play to a card, as listed by snapclient -l
+func (m *Manager) cardPCM(card alsa.Card) string {
+	if devices, err := m.ListPCMDevices(); err == nil {
+		for _, prefix := range preferredPCMPrefixes {
+			for _, device := range devices {
+				if device.Name == prefix+card.ID || strings.HasPrefix(device.Name, prefix+card.ID+",") {
+					return device.Name
+				}
+			}
+		}
+	}
+	return fmt.Sprintf("hw:CARD=%s,DEV=0", card.ID)
+}
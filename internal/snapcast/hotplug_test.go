@@ -0,0 +1,124 @@
+package snapcast
+
+import (
+	"testing"
+
+	"github.com/Ilshidur/bluepicast/internal/alsa"
+	"github.com/Ilshidur/bluepicast/internal/runner"
+)
+
+const restartCommand = "sudo -u pi XDG_RUNTIME_DIR=/run/user/1000 DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/1000/bus systemctl --user try-restart snapclient"
+
+func TestPlaysTo(t *testing.T) {
+	card := alsa.Card{Index: 2, ID: "Audio"}
+	for soundcard, expected := range map[string]bool{
+		"hw:CARD=Audio,DEV=0":    true,
+		"front:CARD=Audio,DEV=0": true,
+		"hw:2":                   true,
+		"hw:2,0":                 true,
+		"hw:20,0":                false,
+		"hw:CARD=Device,DEV=0":   false,
+		"default":                false,
+		"bluealsa":               false,
+	} {
+		if playsTo(soundcard, card) != expected {
+			t.Errorf("playsTo(%q) = %v, want %v", soundcard, !expected, expected)
+		}
+	}
+}
+
+func TestSoundcardPluggedBack(t *testing.T) {
+	manager, fake := newFixtureManager(t, "pi-usb-dac")
+	fake.Add(runner.Call{Command: restartCommand})
+	writeOptions(t, manager.configPath, "--hostID pi --player alsa --soundcard hw:CARD=Audio,DEV=0 ws://192.168.1.10")
+
+	var events []alsa.CardEvent
+	manager.SetOnSoundcardChange(func(event alsa.CardEvent) { events = append(events, event) })
+
+	manager.handleSoundcardEvent(alsa.CardEvent{Card: alsa.Card{Index: 1, ID: "Device"}, Added: true})
+	if fake.Ran(restartCommand) {
+		t.Error("snapclient should not restart for another card")
+	}
+
+	manager.handleSoundcardEvent(alsa.CardEvent{Card: alsa.Card{Index: 2, ID: "Audio"}, Added: true})
+	if !fake.Ran(restartCommand) {
+		t.Error("snapclient should restart when its card is plugged back, if running")
+	}
+	if len(events) != 2 {
+		t.Errorf("got %d soundcard events, want 2", len(events))
+	}
+}
+
+func TestPreferredSoundcard(t *testing.T) {
+	manager, fake := newFixtureManager(t, "pi-usb-dac")
+	fake.Add(runner.Call{Command: restartCommand})
+	writeOptions(t, manager.configPath, "--hostID pi --player alsa --soundcard hw:CARD=Headphones,DEV=0 ws://192.168.1.10")
+	manager.SetPreferredSoundcard("Audio")
+
+	manager.handleSoundcardEvent(alsa.CardEvent{Card: alsa.Card{Index: 2, ID: "Audio"}, Added: true})
+	config, err := manager.GetConfig(DefaultInstance)
+	if err != nil {
+		t.Fatalf("GetConfig failed: %v", err)
+	}
+	// front: is the first PCM of the card listed by snapclient -l
+	if config.Soundcard != "front:CARD=Audio,DEV=0" {
+		t.Errorf("soundcard = %q, want front:CARD=Audio,DEV=0", config.Soundcard)
+	}
+	if !fake.Ran(restartCommand) {
+		t.Error("snapclient should restart on the preferred card")
+	}
+
+	// A Bluetooth route is kept
+	writeOptions(t, manager.configPath, "--hostID pi --player alsa --soundcard bluealsa ws://192.168.1.10")
+	manager.handleSoundcardEvent(alsa.CardEvent{Card: alsa.Card{Index: 2, ID: "Audio"}, Added: true})
+	if config, _ := manager.GetConfig(DefaultInstance); config.Soundcard != "bluealsa" {
+		t.Errorf("soundcard = %q, want bluealsa", config.Soundcard)
+	}
+}
+
+func TestSoundcardPluggedBackKeepsStoppedInstances(t *testing.T) {
+	manager := newInstanceTestManager(t)
+	manager.executablePath = fakeSnapclient(t, "exec sleep 60")
+	if err := manager.SetSupervisor(SupervisorProcess); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+	writeOptions(t, manager.configPath, "--hostID pi --player alsa --soundcard hw:CARD=Audio,DEV=0 ws://server")
+	card := alsa.CardEvent{Card: alsa.Card{Index: 2, ID: "Audio"}, Added: true}
+
+	// The user stopped snapclient, plugging its card back must not start it
+	manager.handleSoundcardEvent(card)
+	if running, _ := manager.supervisor.State(DefaultInstance); running {
+		t.Error("a stopped instance was started")
+	}
+
+	if err := manager.StartService(DefaultInstance); err != nil {
+		t.Fatalf("StartService failed: %v", err)
+	}
+	waitFor(t, "start", func() bool { return countStarts(logLines(manager.supervisor, DefaultInstance)) == 1 })
+	manager.handleSoundcardEvent(card)
+	waitFor(t, "restart", func() bool { return countStarts(logLines(manager.supervisor, DefaultInstance)) == 2 })
+}
+
+func TestSoundcardPluggedBackRestartsFailedInstances(t *testing.T) {
+	manager, _ := newFixtureManager(t, "pi-usb-dac")
+	const userSystemctl = "sudo -u pi XDG_RUNTIME_DIR=/run/user/1000 DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/1000/bus systemctl --user "
+	// snapclient crashed when its card went away, until systemd gave up
+	fake := runner.NewFake(
+		runner.Call{Command: "getent passwd", Stdout: "pi:x:1000:1000:,,,:/home/pi:/bin/bash\n"},
+		runner.Call{Command: userSystemctl + "is-enabled snapclient", Stdout: "enabled\n"},
+		runner.Call{Command: userSystemctl + "show snapclient --property=ActiveState,SubState,NRestarts,ExecMainStatus", Stdout: "NRestarts=5\nExecMainStatus=1\nActiveState=failed\nSubState=failed\n"},
+		runner.Call{Command: userSystemctl + "reset-failed snapclient"},
+		runner.Call{Command: userSystemctl + "restart snapclient"},
+	)
+	manager.runner = fake
+	writeOptions(t, manager.configPath, "--hostID pi --player alsa --soundcard hw:2,0 ws://192.168.1.10")
+
+	manager.handleSoundcardEvent(alsa.CardEvent{Card: alsa.Card{Index: 2, ID: "Audio"}, Added: true})
+	if !fake.Ran(userSystemctl + "reset-failed snapclient") {
+		t.Error("the failed state should be reset, systemd refuses to start a unit past its start limit")
+	}
+	if !fake.Ran(userSystemctl + "restart snapclient") {
+		t.Errorf("a failed snapclient should start when its card is plugged back, ran %v", fake.History())
+	}
+}
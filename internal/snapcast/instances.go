@@ -0,0 +1,301 @@
+package snapcast
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultInstance is the name of the instance run by the plain snapclient unit
+const DefaultInstance = ""
+
+// instanceNameRegex restricts instance names to what is safe in a unit name and a file name
+var instanceNameRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+
+// instanceOptionsSuffix is appended to the instance name to get its options file
+const instanceOptionsSuffix = ".options"
+
+// ValidateInstanceName checks that name can be used for a snapclient@<name> unit
+func ValidateInstanceName(name string) error {
+	if !instanceNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid instance name %q: use up to 32 lowercase letters, digits and dashes", name)
+	}
+	return nil
+}
+
+// unitName returns the systemd unit running the instance
+func unitName(instance string) string {
+	if instance == DefaultInstance {
+		return "snapclient"
+	}
+	return "snapclient@" + instance
+}
+
+// instanceConfigPath returns the options file of the instance
+func (m *Manager) instanceConfigPath(instance string) string {
+	if instance == DefaultInstance {
+		return m.configPath
+	}
+	return filepath.Join(m.instanceDir, instance+instanceOptionsSuffix)
+}
+
+// CheckInstance verifies that the instance name is valid and that it exists
+func (m *Manager) CheckInstance(instance string) error {
+	if instance == DefaultInstance {
+		return nil
+	}
+	if err := ValidateInstanceName(instance); err != nil {
+		return err
+	}
+	if _, err := os.Stat(m.instanceConfigPath(instance)); err != nil {
+		return fmt.Errorf("snapclient instance %q not found", instance)
+	}
+	return nil
+}
+
+// ListInstances returns the configured instances, starting with the default one
+func (m *Manager) ListInstances() ([]string, error) {
+	if !m.enabled {
+		return nil, fmt.Errorf("snapclient integration not enabled")
+	}
+
+	instances := []string{DefaultInstance}
+	paths, err := filepath.Glob(filepath.Join(m.instanceDir, "*"+instanceOptionsSuffix))
+	if err != nil {
+		return instances, fmt.Errorf("failed to list instances: %w", err)
+	}
+
+	var names []string
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), instanceOptionsSuffix)
+		if ValidateInstanceName(name) == nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return append(instances, names...), nil
+}
+
+// CreateInstance adds a snapclient@<name> instance. It connects to the same
+// server as the default instance, with its own host ID.
+func (m *Manager) CreateInstance(name string) error {
+	if !m.enabled {
+		return fmt.Errorf("snapclient integration not enabled")
+	}
+	if err := ValidateInstanceName(name); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	configPath := m.instanceConfigPath(name)
+	if _, err := os.Stat(configPath); err == nil {
+		m.mu.Unlock()
+		return fmt.Errorf("snapclient instance %q already exists", name)
+	}
+
+	options := ParseOptions("")
+	if defaults, err := readOptionsFile(m.configPath); err == nil {
+		options.SetServer(defaults.Server())
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "bluepicast"
+	}
+	options.Set("hostID", hostname+"-"+name)
+	options.Set("player", "alsa")
+	options.Set("soundcard", "default")
+
+	err = writeOptionsFile(configPath, options)
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// Instances run by the supervisor need no unit
+	if m.supervisor == nil {
+		if err := m.installInstanceTemplate(); err != nil {
+			return err
+		}
+		if err := m.runUserSystemctl("enable", unitName(name)); err != nil {
+			return fmt.Errorf("failed to enable instance: %w", err)
+		}
+	}
+
+	log.Printf("Created Snapclient instance %s", name)
+	return nil
+}
+
+// RemoveInstance stops and disables the instance and deletes its options file
+func (m *Manager) RemoveInstance(name string) error {
+	if name == DefaultInstance {
+		return fmt.Errorf("the default snapclient instance cannot be removed")
+	}
+	if err := m.CheckInstance(name); err != nil {
+		return err
+	}
+
+	if m.supervisor != nil {
+		m.supervisor.Stop(name)
+	} else if err := m.runUserSystemctl("disable", "--now", unitName(name)); err != nil {
+		log.Printf("Warning: Failed to disable %s: %v", unitName(name), err)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := os.Remove(m.instanceConfigPath(name)); err != nil {
+		return fmt.Errorf("failed to remove instance options: %w", err)
+	}
+
+	log.Printf("Removed Snapclient instance %s", name)
+	return nil
+}
+
+// installInstanceTemplate writes the snapclient@.service template unit if it is missing
+func (m *Manager) installInstanceTemplate() error {
+	_, _, homeDir, err := m.realUser()
+	if err != nil {
+		return fmt.Errorf("failed to get real user: %w", err)
+	}
+
+	systemdUserDir := fmt.Sprintf("%s/.config/systemd/user", homeDir)
+	if err := os.MkdirAll(systemdUserDir, 0755); err != nil {
+		return fmt.Errorf("failed to create systemd user directory: %w", err)
+	}
+
+	serviceFile := fmt.Sprintf("%s/snapclient@.service", systemdUserDir)
+	if _, err := os.Stat(serviceFile); err == nil {
+		return nil
+	}
+
+	serviceContent := `[Unit]
+Description=Snapcast client %i (user)
+Documentation=man:snapclient(1)
+Wants=network-online.target
+After=network-online.target sound.target
+
+[Service]
+EnvironmentFile=-%h/.config/snapclient/%i.options
+ExecStart=/usr/bin/snapclient --logsink=system $SNAPCLIENT_OPTS
+Restart=on-failure
+
+[Install]
+WantedBy=default.target
+`
+	if err := os.WriteFile(serviceFile, []byte(serviceContent), 0644); err != nil {
+		return fmt.Errorf("failed to create service file: %w", err)
+	}
+
+	if err := m.runUserSystemctl("daemon-reload"); err != nil {
+		log.Printf("Warning: daemon-reload failed: %v", err)
+	}
+	return nil
+}
+
+// checkHostIDs makes sure the instance does not share its host ID with
+// another instance, the server would see them as a single client
+func (m *Manager) checkHostIDs(instance string, options *Options) error {
+	hostID, _ := options.Get("hostID")
+	if hostID == "" {
+		if instance != DefaultInstance {
+			return fmt.Errorf("a host ID is required for the %s instance", instance)
+		}
+		return nil
+	}
+
+	instances, err := m.ListInstances()
+	if err != nil {
+		return err
+	}
+	for _, other := range instances {
+		if other == instance {
+			continue
+		}
+		otherOptions, err := readOptionsFile(m.instanceConfigPath(other))
+		if err != nil {
+			continue
+		}
+		if otherID, _ := otherOptions.Get("hostID"); otherID == hostID {
+			return fmt.Errorf("host ID %q is already used by the %s instance", hostID, instanceLabel(other))
+		}
+	}
+	return nil
+}
+
+// instanceLabel returns a readable name for the instance
+func instanceLabel(instance string) string {
+	if instance == DefaultInstance {
+		return "default"
+	}
+	return instance
+}
+
+// InstancesPlayingTo returns the running instances whose ALSA output is on
+// the same card as soundcard, or the same plugin PCM such as "bluealsa"
+func (m *Manager) InstancesPlayingTo(soundcard string) ([]string, error) {
+	instances, err := m.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+
+	var playing []string
+	for _, instance := range instances {
+		options, err := readOptionsFile(m.instanceConfigPath(instance))
+		if err != nil {
+			continue
+		}
+		if player, _ := options.Get("player"); player != "" && player != "alsa" {
+			continue
+		}
+		configured, _ := options.Get("soundcard")
+		if !m.sameOutput(configured, soundcard) {
+			continue
+		}
+		if m.instanceState(instance).ActiveState == "active" {
+			playing = append(playing, instance)
+		}
+	}
+	return playing, nil
+}
+
+// sameOutput reports whether two soundcard settings play to the same card,
+// such as "hw:2,0" and "front:CARD=Audio,DEV=0", or through the same plugin,
+// such as "bluealsa" and "bluealsa:DEV=00:11:22:33:44:55,PROFILE=a2dp"
+func (m *Manager) sameOutput(a, b string) bool {
+	cardA, isCardA := m.settingCard(a)
+	cardB, isCardB := m.settingCard(b)
+	if isCardA || isCardB {
+		return isCardA && isCardB && cardA == cardB
+	}
+	return strings.EqualFold(pcmPlugin(a), pcmPlugin(b))
+}
+
+// settingCard returns the card a soundcard setting plays to, as its index
+// when the card is plugged in. ok is false for settings such as "default"
+// or "bluealsa" that do not name a card.
+func (m *Manager) settingCard(soundcard string) (card string, ok bool) {
+	name, ok := cardName(soundcard)
+	if !ok {
+		return "", false
+	}
+	if m.alsa != nil {
+		if found, err := m.alsa.FindCard(name); err == nil {
+			return strconv.Itoa(found.Index), true
+		}
+	}
+	return name, true
+}
+
+// pcmPlugin returns the ALSA plugin of a PCM name, the part before its
+// arguments: "bluealsa" for "bluealsa:DEV=...". It is "default" when empty.
+func pcmPlugin(pcm string) string {
+	if pcm == "" {
+		return "default"
+	}
+	plugin, _, _ := strings.Cut(pcm, ":")
+	return plugin
+}
@@ -0,0 +1,190 @@
+package snapcast
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Ilshidur/bluepicast/internal/alsa"
+	"github.com/Ilshidur/bluepicast/internal/runner"
+)
+
+func TestValidateInstanceName(t *testing.T) {
+	for _, name := range []string{"kitchen", "patio-2", "0"} {
+		if err := ValidateInstanceName(name); err != nil {
+			t.Errorf("ValidateInstanceName(%q) = %v, want no error", name, err)
+		}
+	}
+	for _, name := range []string{"", "Kitchen", "-patio", "living room", "../options", "a@b", strings.Repeat("a", 33)} {
+		if err := ValidateInstanceName(name); err == nil {
+			t.Errorf("ValidateInstanceName(%q) should fail", name)
+		}
+	}
+}
+
+func TestUnitName(t *testing.T) {
+	if unit := unitName(DefaultInstance); unit != "snapclient" {
+		t.Errorf("unitName(default) = %q, want snapclient", unit)
+	}
+	if unit := unitName("patio"); unit != "snapclient@patio" {
+		t.Errorf("unitName(patio) = %q, want snapclient@patio", unit)
+	}
+}
+
+// newInstanceTestManager returns a manager with a default instance and the
+// given named instances, each with its own host ID
+func newInstanceTestManager(t *testing.T, instances ...string) *Manager {
+	dir := t.TempDir()
+	manager := &Manager{enabled: true, configPath: filepath.Join(dir, "options"), instanceDir: dir, runner: runner.NewFake()}
+	writeOptions(t, manager.configPath, "--hostID pi ws://server")
+	for _, instance := range instances {
+		writeOptions(t, manager.instanceConfigPath(instance), "--hostID pi-"+instance+" --soundcard hw:"+instance+" ws://server")
+	}
+	return manager
+}
+
+func TestListInstances(t *testing.T) {
+	manager := newInstanceTestManager(t, "patio", "kitchen")
+	// Files that are not instance options are ignored
+	os.WriteFile(filepath.Join(manager.instanceDir, "Not Valid.options"), nil, 0644)
+	os.WriteFile(filepath.Join(manager.instanceDir, "kitchen.options.tmp"), nil, 0644)
+
+	instances, err := manager.ListInstances()
+	if err != nil {
+		t.Fatalf("ListInstances failed: %v", err)
+	}
+	if expected := []string{DefaultInstance, "kitchen", "patio"}; !reflect.DeepEqual(instances, expected) {
+		t.Errorf("ListInstances() = %q, want %q", instances, expected)
+	}
+}
+
+func TestInstanceConfig(t *testing.T) {
+	manager := newInstanceTestManager(t, "patio", "kitchen")
+
+	config, err := manager.GetConfig("patio")
+	if err != nil {
+		t.Fatalf("GetConfig failed: %v", err)
+	}
+	if config.InstanceID != "pi-patio" || config.Soundcard != "hw:patio" {
+		t.Errorf("patio config = %+v", config)
+	}
+
+	if err := manager.SetConfig("patio", Config{Host: "server", InstanceID: "pi-deck", Player: "alsa", Soundcard: "bluealsa"}); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+	if config, _ := manager.GetConfig("patio"); config.InstanceID != "pi-deck" || config.Soundcard != "bluealsa" {
+		t.Errorf("patio config after SetConfig = %+v", config)
+	}
+	// The other instances are left alone
+	if config, _ := manager.GetConfig("kitchen"); config.InstanceID != "pi-kitchen" {
+		t.Errorf("kitchen config = %+v, should not change", config)
+	}
+	if config, _ := manager.GetConfig(DefaultInstance); config.InstanceID != "pi" {
+		t.Errorf("default config = %+v, should not change", config)
+	}
+}
+
+func TestInstanceHostIDs(t *testing.T) {
+	manager := newInstanceTestManager(t, "patio", "kitchen")
+
+	tests := []struct {
+		name     string
+		instance string
+		hostID   string
+		wantErr  string
+	}{
+		{name: "unique", instance: "patio", hostID: "pi-deck"},
+		{name: "unchanged", instance: "patio", hostID: "pi-patio"},
+		{name: "used by another instance", instance: "patio", hostID: "pi-kitchen", wantErr: "already used by the kitchen instance"},
+		{name: "used by the default instance", instance: "kitchen", hostID: "pi", wantErr: "already used by the default instance"},
+		{name: "missing on a named instance", instance: "patio", wantErr: "host ID is required"},
+		{name: "missing on the default instance", instance: DefaultInstance},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := ParseOptions("ws://server")
+			if tt.hostID != "" {
+				options.Set("hostID", tt.hostID)
+			}
+			err := manager.checkHostIDs(tt.instance, options)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("checkHostIDs = %v, want no error", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("checkHostIDs = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestUnknownInstance(t *testing.T) {
+	manager := newInstanceTestManager(t)
+
+	if _, err := manager.GetConfig("garage"); err == nil {
+		t.Error("GetConfig should fail for an unknown instance")
+	}
+	if err := manager.SetConfig("../options", Config{Host: "server"}); err == nil {
+		t.Error("SetConfig should refuse an invalid instance name")
+	}
+	if err := manager.RemoveInstance(DefaultInstance); err == nil {
+		t.Error("the default instance should not be removable")
+	}
+}
+
+func TestInstancesPlayingTo(t *testing.T) {
+	manager, fake := newFixtureManager(t, "pi-usb-dac")
+	fake.Add(runner.Call{
+		Command: "sudo -u pi XDG_RUNTIME_DIR=/run/user/1000 DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/1000/bus systemctl --user show snapclient@patio --property=ActiveState,SubState,NRestarts,ExecMainStatus",
+		Stdout:  "NRestarts=0\nExecMainStatus=0\nActiveState=active\nSubState=running\n",
+	}, runner.Call{
+		Command: "sudo -u pi XDG_RUNTIME_DIR=/run/user/1000 DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/1000/bus systemctl --user show snapclient@kitchen --property=ActiveState,SubState,NRestarts,ExecMainStatus",
+		Stdout:  "NRestarts=0\nExecMainStatus=3\nActiveState=inactive\nSubState=dead\n",
+	})
+	manager.alsa = alsa.LoadFixture(alsa.FixtureDir("pi-usb-dac"))
+	writeOptions(t, manager.configPath, "--hostID pi --player alsa --soundcard front:CARD=Audio,DEV=0 ws://server")
+	writeOptions(t, manager.instanceConfigPath("patio"), "--hostID pi-patio --soundcard bluealsa ws://server")
+	writeOptions(t, manager.instanceConfigPath("kitchen"), "--hostID pi-kitchen --soundcard hw:CARD=Audio,DEV=0 ws://server")
+
+	tests := map[string][]string{
+		"hw:CARD=Audio,DEV=0": {DefaultInstance}, // kitchen is stopped
+		"hw:2,0":              {DefaultInstance}, // the test tone PCM of card 2
+		"bluealsa":            {"patio"},
+		"bluealsa:DEV=00:11:22:33:44:55,PROFILE=a2dp": {"patio"},
+		"hw:CARD=Headphones,DEV=0":                    nil,
+		"sysdefault:CARD=Headphones":                  nil,
+	}
+	for soundcard, expected := range tests {
+		playing, err := manager.InstancesPlayingTo(soundcard)
+		if err != nil {
+			t.Fatalf("InstancesPlayingTo(%q) failed: %v", soundcard, err)
+		}
+		if !reflect.DeepEqual(playing, expected) {
+			t.Errorf("InstancesPlayingTo(%q) = %q, want %q", soundcard, playing, expected)
+		}
+	}
+}
+
+func TestSameOutput(t *testing.T) {
+	manager := &Manager{alsa: alsa.LoadFixture(alsa.FixtureDir("pi-usb-dac"))}
+	tests := []struct {
+		a, b     string
+		expected bool
+	}{
+		{"hw:2", "hw:CARD=Audio,DEV=0", true},
+		{"hw:2,0", "front:CARD=Audio,DEV=0", true},
+		{"hw:2,0", "hw:2", true},
+		{"hw:0", "hw:CARD=Audio,DEV=0", false},
+		{"bluealsa", "bluealsa:DEV=00:11:22:33:44:55,PROFILE=a2dp", true},
+		{"bluealsa", "default", false},
+		{"", "default", true},
+		{"default", "hw:CARD=Audio,DEV=0", false},
+	}
+	for _, test := range tests {
+		if got := manager.sameOutput(test.a, test.b); got != test.expected {
+			t.Errorf("sameOutput(%q, %q) = %v, want %v", test.a, test.b, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,401 @@
+package snapcast
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Ilshidur/bluepicast/internal/jsonfile"
+)
+
+// mixerControlsFile stores the mixer control chosen for each soundcard, in
+// the BluePiCast data directory
+const mixerControlsFile = "mixer-controls.json"
+
+// preferredMixerControls are tried in order when no control was chosen for a
+// soundcard, before falling back to its first playback volume control
+var preferredMixerControls = []string{"PCM", "Master", "Speaker", "Headphone", "Digital"}
+
+var (
+	// simpleControlRegex matches the first line of a control in amixer scontents
+	simpleControlRegex = regexp.MustCompile(`^Simple mixer control '(.*)',(\d+)$`)
+	// limitsRegex matches the playback range, "Limits: Playback 0 - 37" or "Limits: 0 - 37"
+	limitsRegex = regexp.MustCompile(`^Limits:(?: Playback)? (-?\d+) - (-?\d+)`)
+	// levelRegex matches the value of a channel, "Front Left: Playback 28 [76%] [-9.00dB] [on]"
+	levelRegex = regexp.MustCompile(`\[(\d+)%\](?: \[(-?[\d.]+)dB\])?`)
+	// dBMinMaxRegex and dBScaleRegex match the dB range printed by amixer cget
+	dBMinMaxRegex = regexp.MustCompile(`dBminmax-min=(-?[\d.]+)dB,max=(-?[\d.]+)dB`)
+	dBScaleRegex  = regexp.MustCompile(`dBscale-min=(-?[\d.]+)dB,step=([\d.]+)dB`)
+)
+
+// MixerControl is an ALSA simple mixer control of a soundcard
+type MixerControl struct {
+	Name       string  `json:"name"`      // Name as given to amixer, with ",<index>" when the index is not 0
+	HasVolume  bool    `json:"hasVolume"` // The control sets a playback volume
+	HasSwitch  bool    `json:"hasSwitch"` // The control has a playback mute switch
+	Volume     int     `json:"volume"`    // Percentage of the first channel
+	Muted      bool    `json:"muted"`
+	DB         float64 `json:"db"` // Current level of the first channel, if HasDB
+	HasDB      bool    `json:"hasDb"`
+	MinDB      float64 `json:"minDb"` // Range of the control, if HasDBRange
+	MaxDB      float64 `json:"maxDb"`
+	HasDBRange bool    `json:"hasDbRange"`
+
+	min, max int // Raw playback limits
+}
+
+// parseMixerControls parses the output of amixer scontents, keeping the
+// controls with a playback volume or switch
+func parseMixerControls(output string) []MixerControl {
+	var controls []MixerControl
+	var current *MixerControl
+	levelRead := false
+
+	for _, line := range strings.Split(output, "\n") {
+		if match := simpleControlRegex.FindStringSubmatch(line); match != nil {
+			name := match[1]
+			if match[2] != "0" {
+				name += "," + match[2]
+			}
+			controls = append(controls, MixerControl{Name: name})
+			current = &controls[len(controls)-1]
+			levelRead = false
+			continue
+		}
+		if current == nil {
+			continue
+		}
+
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "Capabilities:"):
+			for _, capability := range strings.Fields(strings.TrimPrefix(line, "Capabilities:")) {
+				switch capability {
+				case "pvolume", "volume":
+					current.HasVolume = true
+				case "pswitch", "switch":
+					current.HasSwitch = true
+				}
+			}
+		case strings.HasPrefix(line, "Limits:"):
+			if match := limitsRegex.FindStringSubmatch(line); match != nil {
+				current.min, _ = strconv.Atoi(match[1])
+				current.max, _ = strconv.Atoi(match[2])
+			}
+		case strings.HasPrefix(line, "Playback channels:"), strings.HasPrefix(line, "Capture channels:"):
+		case !levelRead && strings.Contains(line, ": "):
+			// Channel lines such as "Mono: Playback 23 [72%] [34.36dB] [off] Capture 0 [0%]"
+			level := line[strings.Index(line, ": ")+2:]
+			if i := strings.Index(level, "Capture"); i >= 0 {
+				level = level[:i]
+			}
+			if match := levelRegex.FindStringSubmatch(level); match != nil {
+				current.Volume, _ = strconv.Atoi(match[1])
+				if match[2] != "" {
+					current.DB, _ = strconv.ParseFloat(match[2], 64)
+					current.HasDB = true
+				}
+				levelRead = true
+			}
+			if strings.Contains(level, "[off]") {
+				current.Muted = true
+				levelRead = true
+			} else if strings.Contains(level, "[on]") {
+				levelRead = true
+			}
+		}
+	}
+
+	playback := controls[:0]
+	for _, control := range controls {
+		if control.HasVolume || control.HasSwitch {
+			playback = append(playback, control)
+		}
+	}
+	return playback
+}
+
+// parseDBRange reads the dB range of a volume element from amixer cget
+func (c *MixerControl) parseDBRange(output string) {
+	if match := dBMinMaxRegex.FindStringSubmatch(output); match != nil {
+		c.MinDB, _ = strconv.ParseFloat(match[1], 64)
+		c.MaxDB, _ = strconv.ParseFloat(match[2], 64)
+		c.HasDBRange = true
+	} else if match := dBScaleRegex.FindStringSubmatch(output); match != nil {
+		minDB, _ := strconv.ParseFloat(match[1], 64)
+		step, _ := strconv.ParseFloat(match[2], 64)
+		c.MinDB = minDB
+		c.MaxDB = minDB + step*float64(c.max-c.min)
+		c.HasDBRange = true
+	}
+}
+
+// amixerArgs returns the amixer arguments for a device, without -D for the default one
+func amixerArgs(device string, args ...string) []string {
+	if device == "" {
+		return args
+	}
+	return append([]string{"-D", device}, args...)
+}
+
+// alsaCard returns the card of an amixer device when its mixer can be read
+// with the alsa package, only "hw:<card>" devices are
+func (m *Manager) alsaCard(device string) (int, bool) {
+	if m.alsa == nil || !strings.HasPrefix(device, "hw:") {
+		return 0, false
+	}
+	card, err := m.alsa.FindCard(strings.TrimPrefix(device, "hw:"))
+	if err != nil {
+		return 0, false
+	}
+	return card.Index, true
+}
+
+// alsaCardExists checks a card name as found in aplay -l: its index, ID or name
+func (m *Manager) alsaCardExists(cardName string) (bool, error) {
+	cards, err := m.alsa.Cards()
+	if err != nil {
+		return false, err
+	}
+	for _, card := range cards {
+		if strconv.Itoa(card.Index) == cardName || strings.EqualFold(card.ID, cardName) ||
+			strings.Contains(strings.ToLower(card.Name), strings.ToLower(cardName)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// splitControlName splits a control name as given to amixer, "<name>[,<index>]"
+func splitControlName(control string) (string, int) {
+	name, index, found := strings.Cut(control, ",")
+	if !found {
+		return control, 0
+	}
+	i, _ := strconv.Atoi(index)
+	return name, i
+}
+
+// mixerControls lists the playback controls of an amixer device
+func (m *Manager) mixerControls(device string) ([]MixerControl, error) {
+	if card, ok := m.alsaCard(device); ok {
+		elements, err := m.alsa.Mixer(card)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list mixer controls: %w", err)
+		}
+		controls := make([]MixerControl, len(elements))
+		for i, element := range elements {
+			name := element.Name
+			if element.Index != 0 {
+				name += "," + strconv.Itoa(element.Index)
+			}
+			controls[i] = MixerControl{
+				Name:       name,
+				HasVolume:  element.HasVolume,
+				HasSwitch:  element.HasSwitch,
+				Volume:     element.Volume,
+				Muted:      element.Muted,
+				DB:         element.DB,
+				HasDB:      element.HasDB,
+				MinDB:      element.MinDB,
+				MaxDB:      element.MaxDB,
+				HasDBRange: element.HasDB,
+			}
+		}
+		return controls, nil
+	}
+
+	output, err := m.runner.CombinedOutput("amixer", amixerArgs(device, "scontents")...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list mixer controls with amixer: %w (output: %s)", err, strings.TrimSpace(string(output)))
+	}
+	return parseMixerControls(string(output)), nil
+}
+
+// ListMixerControls returns the playback controls of a soundcard, with their dB range
+func (m *Manager) ListMixerControls(soundcard string) ([]MixerControl, error) {
+	if !m.enabled {
+		return nil, fmt.Errorf("snapclient integration not enabled")
+	}
+	if !m.soundcardExists(soundcard) {
+		return nil, fmt.Errorf("soundcard '%s' not found in system (check 'aplay -l' output)", soundcard)
+	}
+
+	device := convertToAmixerDevice(soundcard)
+	controls, err := m.mixerControls(device)
+	if err != nil {
+		return nil, err
+	}
+	for i := range controls {
+		if !controls[i].HasVolume || controls[i].HasDBRange {
+			continue
+		}
+		name, index, _ := strings.Cut(controls[i].Name, ",")
+		element := "name=" + name + " Playback Volume"
+		if index != "" {
+			element += ",index=" + index
+		}
+		if output, err := m.runner.CombinedOutput("amixer", amixerArgs(device, "cget", element)...); err == nil {
+			controls[i].parseDBRange(string(output))
+		}
+	}
+	return controls, nil
+}
+
+// selectedMixerControl returns the control setting the volume of a soundcard:
+// the one chosen with SetMixerControl, or else the first preferred control
+func (m *Manager) selectedMixerControl(soundcard string) (MixerControl, error) {
+	device := convertToAmixerDevice(soundcard)
+	controls, err := m.mixerControls(device)
+	if err != nil {
+		return MixerControl{}, err
+	}
+
+	if chosen := m.ChosenMixerControl(soundcard); chosen != "" {
+		for _, control := range controls {
+			if control.Name == chosen {
+				return control, nil
+			}
+		}
+		log.Printf("Warning: Mixer control %q of %s not found, using the default one", chosen, soundcard)
+	}
+
+	for _, name := range preferredMixerControls {
+		for _, control := range controls {
+			if control.Name == name && control.HasVolume {
+				return control, nil
+			}
+		}
+	}
+	for _, control := range controls {
+		if control.HasVolume {
+			return control, nil
+		}
+	}
+	return MixerControl{}, fmt.Errorf("no playback volume control found on %s", soundcard)
+}
+
+// mixerKey returns the key of a soundcard in the mixer controls file. The
+// amixer device is used so that the PCMs of a card share their control.
+func mixerKey(soundcard string) string {
+	if device := convertToAmixerDevice(soundcard); device != "" {
+		return device
+	}
+	return "default"
+}
+
+// SetDataDir sets the BluePiCast data directory, where the mixer control
+// chosen for each soundcard is kept. snapclient's config directory belongs to
+// the user running it, BluePiCast runs as root.
+func (m *Manager) SetDataDir(dir string) {
+	m.mixerMu.Lock()
+	defer m.mixerMu.Unlock()
+	m.mixerControlsPath = filepath.Join(dir, mixerControlsFile)
+}
+
+// readMixerChoices reads the mixer controls file. Must be called with m.mixerMu held.
+func (m *Manager) readMixerChoices() map[string]string {
+	choices := make(map[string]string)
+	if m.mixerControlsPath == "" {
+		return choices
+	}
+	data, err := os.ReadFile(m.mixerControlsPath)
+	if err != nil {
+		return choices
+	}
+	if err := json.Unmarshal(data, &choices); err != nil {
+		log.Printf("Warning: Failed to parse %s: %v", mixerControlsFile, err)
+	}
+	return choices
+}
+
+// ChosenMixerControl returns the control chosen for a soundcard, empty when
+// it is picked automatically
+func (m *Manager) ChosenMixerControl(soundcard string) string {
+	m.mixerMu.Lock()
+	defer m.mixerMu.Unlock()
+	return m.readMixerChoices()[mixerKey(soundcard)]
+}
+
+// SetMixerControl chooses the control setting the volume of a soundcard. An
+// empty control goes back to picking it automatically.
+func (m *Manager) SetMixerControl(soundcard, control string) error {
+	if !m.enabled {
+		return fmt.Errorf("snapclient integration not enabled")
+	}
+	if control != "" {
+		controls, err := m.mixerControls(convertToAmixerDevice(soundcard))
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, c := range controls {
+			if c.Name == control && c.HasVolume {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("soundcard %s has no playback volume control named %q", soundcard, control)
+		}
+	}
+
+	m.mixerMu.Lock()
+	defer m.mixerMu.Unlock()
+	if m.mixerControlsPath == "" {
+		return fmt.Errorf("no data directory to save the mixer control in")
+	}
+	choices := m.readMixerChoices()
+	if control == "" {
+		delete(choices, mixerKey(soundcard))
+	} else {
+		choices[mixerKey(soundcard)] = control
+	}
+	if err := jsonfile.Write(m.mixerControlsPath, choices, "mixer controls"); err != nil {
+		return err
+	}
+	log.Printf("Mixer control of %s set to %q", soundcard, control)
+	return nil
+}
+
+// SetAlsaMute mutes or unmutes a soundcard with the switch of its mixer control
+func (m *Manager) SetAlsaMute(soundcard string, muted bool) error {
+	if !m.enabled {
+		return fmt.Errorf("snapclient integration not enabled")
+	}
+	if !m.soundcardExists(soundcard) {
+		return fmt.Errorf("soundcard '%s' not found in system (check 'aplay -l' output)", soundcard)
+	}
+
+	control, err := m.selectedMixerControl(soundcard)
+	if err != nil {
+		return err
+	}
+	if !control.HasSwitch {
+		return fmt.Errorf("mixer control %s has no mute switch", control.Name)
+	}
+
+	value := "unmute"
+	if muted {
+		value = "mute"
+	}
+	device := convertToAmixerDevice(soundcard)
+	if card, ok := m.alsaCard(device); ok {
+		name, index := splitControlName(control.Name)
+		if err := m.alsa.SetMute(card, name, index, muted); err != nil {
+			return fmt.Errorf("failed to %s: %w", value, err)
+		}
+	} else {
+		output, err := m.runner.CombinedOutput("amixer", amixerArgs(device, "set", control.Name, value)...)
+		if err != nil {
+			return fmt.Errorf("failed to %s with amixer: %w (output: %s)", value, err, string(output))
+		}
+	}
+	log.Printf("ALSA %s %sd (device: %s)", control.Name, value, device)
+	return nil
+}
@@ -0,0 +1,159 @@
+package snapcast
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Ilshidur/bluepicast/internal/alsa"
+)
+
+func TestParseMixerControls(t *testing.T) {
+	output := `Simple mixer control 'Master',0
+  Capabilities: volume volume-joined switch switch-joined
+  Playback channels: Mono
+  Capture channels: Mono
+  Limits: 0 - 87
+  Mono: 60 [69%] [-20.25dB] [on]
+Simple mixer control 'PCM',1
+  Capabilities: pvolume
+  Playback channels: Front Left - Front Right
+  Limits: Playback 0 - 255
+  Mono:
+  Front Left: Playback 255 [100%]
+  Front Right: Playback 255 [100%]
+Simple mixer control 'Capture',0
+  Capabilities: cvolume cswitch
+  Capture channels: Front Left - Front Right
+  Limits: Capture 0 - 63
+  Front Left: Capture 39 [62%] [12.00dB] [on]
+  Front Right: Capture 39 [62%] [12.00dB] [on]
+Simple mixer control 'Auto Gain Control',0
+  Capabilities: pswitch pswitch-joined
+  Playback channels: Mono
+  Mono: Playback [off]
+`
+	controls := parseMixerControls(output)
+
+	expected := []MixerControl{
+		{Name: "Master", HasVolume: true, HasSwitch: true, Volume: 69, DB: -20.25, HasDB: true, min: 0, max: 87},
+		{Name: "PCM,1", HasVolume: true, Volume: 100, min: 0, max: 255},
+		{Name: "Auto Gain Control", HasSwitch: true, Muted: true},
+	}
+	if len(controls) != len(expected) {
+		t.Fatalf("parseMixerControls() = %+v, want %d playback controls", controls, len(expected))
+	}
+	for i := range expected {
+		if controls[i] != expected[i] {
+			t.Errorf("control %d = %+v, want %+v", i, controls[i], expected[i])
+		}
+	}
+}
+
+func TestListMixerControls(t *testing.T) {
+	manager, _ := newFixtureManager(t, "usb-speaker-dac")
+
+	controls, err := manager.ListMixerControls("hw:CARD=Device,DEV=0")
+	if err != nil {
+		t.Fatalf("ListMixerControls failed: %v", err)
+	}
+	if len(controls) != 3 {
+		t.Fatalf("ListMixerControls() = %+v, want Speaker, Mic and Auto Gain Control", controls)
+	}
+
+	speaker := controls[0]
+	if speaker.Name != "Speaker" || speaker.Volume != 75 || speaker.Muted || !speaker.HasDBRange || speaker.MinDB != -28.37 || speaker.MaxDB != 0 {
+		t.Errorf("Speaker = %+v", speaker)
+	}
+	// dBscale ranges are computed from the limits
+	mic := controls[1]
+	if mic.Name != "Mic" || !mic.Muted || !mic.HasDBRange || mic.MinDB != -23 || mic.MaxDB != 25 {
+		t.Errorf("Mic = %+v", mic)
+	}
+	if controls[2].HasVolume || controls[2].HasDBRange {
+		t.Errorf("Auto Gain Control = %+v, want a switch only", controls[2])
+	}
+}
+
+func TestMixerControlSelection(t *testing.T) {
+	manager, fake := newFixtureManager(t, "usb-speaker-dac")
+	soundcard := "front:CARD=Device,DEV=0"
+
+	// Without PCM or Master, Speaker is preferred to the first control
+	volume, err := manager.GetAlsaVolume(soundcard)
+	if err != nil || volume != 75 {
+		t.Errorf("GetAlsaVolume() = %d, %v, want the Speaker volume", volume, err)
+	}
+	if err := manager.SetAlsaVolume(soundcard, 40); err != nil || !fake.Ran("amixer -D hw:Device set Speaker 40%") {
+		t.Errorf("SetAlsaVolume() = %v, ran %q", err, fake.History())
+	}
+
+	// The choice is kept for every PCM of the card
+	if err := manager.SetMixerControl(soundcard, "Mic"); err != nil {
+		t.Fatalf("SetMixerControl failed: %v", err)
+	}
+	if chosen := manager.ChosenMixerControl("hw:CARD=Device,DEV=0"); chosen != "Mic" {
+		t.Errorf("ChosenMixerControl() = %q, want Mic", chosen)
+	}
+	if _, err := os.Stat(manager.mixerControlsPath); err != nil {
+		t.Errorf("choice not saved: %v", err)
+	}
+	if err := manager.SetAlsaVolume("hw:CARD=Device,DEV=0", 40); err != nil || !fake.Ran("amixer -D hw:Device set Mic 40%") {
+		t.Errorf("SetAlsaVolume() = %v, ran %q", err, fake.History())
+	}
+
+	if err := manager.SetMixerControl(soundcard, "Auto Gain Control"); err == nil || !strings.Contains(err.Error(), "no playback volume control") {
+		t.Errorf("SetMixerControl(switch only) = %v, want an error", err)
+	}
+
+	// Back to the automatic choice
+	if err := manager.SetMixerControl(soundcard, ""); err != nil {
+		t.Fatalf("SetMixerControl failed: %v", err)
+	}
+	if err := manager.SetAlsaMute(soundcard, true); err != nil || !fake.Ran("amixer -D hw:Device set Speaker mute") {
+		t.Errorf("SetAlsaMute() = %v, ran %q", err, fake.History())
+	}
+}
+
+func TestMixerControlsFromAlsa(t *testing.T) {
+	manager, fake := newFixtureManager(t, "pi-usb-dac")
+	manager.alsa = alsa.LoadFixture(alsa.FixtureDir("pi-usb-dac"))
+	soundcard := "hw:CARD=Audio,DEV=0"
+
+	controls, err := manager.ListMixerControls(soundcard)
+	if err != nil {
+		t.Fatalf("ListMixerControls failed: %v", err)
+	}
+	expected := []MixerControl{{
+		Name: "PCM", HasVolume: true, HasSwitch: true, Volume: 76,
+		DB: -9, HasDB: true, MinDB: -37, MaxDB: 0, HasDBRange: true,
+	}}
+	if !reflect.DeepEqual(controls, expected) {
+		t.Errorf("ListMixerControls() = %+v, want %+v", controls, expected)
+	}
+
+	if err := manager.SetAlsaVolume(soundcard, 40); err != nil {
+		t.Fatalf("SetAlsaVolume failed: %v", err)
+	}
+	if err := manager.SetAlsaMute(soundcard, true); err != nil {
+		t.Fatalf("SetAlsaMute failed: %v", err)
+	}
+	control, _ := manager.alsa.Control(2)
+	if values := control.(*alsa.FakeControl).Values("PCM Playback Volume", 0); !reflect.DeepEqual(values, []int64{15, 15}) {
+		t.Errorf("PCM Playback Volume = %v, want [15 15]", values)
+	}
+	if volume, err := manager.GetAlsaVolume(soundcard); err != nil || volume != 41 {
+		t.Errorf("GetAlsaVolume() = %d, %v, want 41", volume, err)
+	}
+
+	// Missing cards are reported without aplay
+	if manager.soundcardExists("hw:CARD=Device,DEV=0") {
+		t.Error("soundcardExists(Device) = true, want false")
+	}
+	for _, command := range fake.History() {
+		if strings.HasPrefix(command, "aplay") || strings.HasPrefix(command, "amixer") {
+			t.Errorf("ran %q, the mixer should be read from the control device", command)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+package snapcast
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// optionSpec describes a snapclient command-line option
+type optionSpec struct {
+	name          string // Long name, without dashes
+	short         string // Short name, without dash
+	takesValue    bool
+	optionalValue bool // The value can only be given with "=" (e.g. --daemon=-3)
+	validate      func(value string) error
+}
+
+// optionSpecs lists the options of snapclient. -i is not a snapclient
+// option but older BluePiCast versions wrote it for --hostID.
+var optionSpecs = []optionSpec{
+	{name: "host", short: "h", takesValue: true}, // Deprecated, the server URI is positional
+	{name: "port", short: "p", takesValue: true, validate: validatePort},
+	{name: "hostID", short: "i", takesValue: true, validate: validateNotEmpty},
+	{name: "instance", takesValue: true, validate: validatePositiveInt},
+	{name: "soundcard", short: "s", takesValue: true, validate: validateNotEmpty},
+	{name: "latency", takesValue: true, validate: validateLatency},
+	{name: "sampleformat", takesValue: true, validate: validateSampleFormat},
+	{name: "player", takesValue: true, validate: validatePlayer},
+	{name: "mixer", takesValue: true, validate: validateMixer},
+	{name: "buffer", takesValue: true, validate: validatePositiveInt},
+	{name: "logsink", takesValue: true, validate: validateLogSink},
+	{name: "logfilter", takesValue: true, validate: validateNotEmpty},
+	{name: "user", takesValue: true, validate: validateNotEmpty},
+	{name: "daemon", short: "d", optionalValue: true},
+	{name: "certificate", takesValue: true, validate: validateNotEmpty},
+	{name: "certificate-key", takesValue: true, validate: validateNotEmpty},
+	{name: "key-password", takesValue: true},
+	{name: "server-cert", takesValue: true, validate: validateNotEmpty},
+	{name: "list", short: "l"},
+	{name: "version", short: "v"},
+	{name: "help"},
+}
+
+// findOptionSpec returns the spec of an option given as written (e.g. "-s" or "--soundcard")
+func findOptionSpec(flag string) *optionSpec {
+	for i := range optionSpecs {
+		spec := &optionSpecs[i]
+		if flag == "--"+spec.name || (spec.short != "" && flag == "-"+spec.short) {
+			return spec
+		}
+	}
+	return nil
+}
+
+// option is one item of the command line: a flag with its value, or the
+// positional server URI when flag is empty
+type option struct {
+	flag     string // As written, e.g. "-s" or "--soundcard"
+	value    string
+	hasValue bool
+	equals   bool // Value was attached with "=" (--player=alsa)
+}
+
+// name returns the long name of the option, or the flag itself when unknown
+func (o option) name() string {
+	if spec := findOptionSpec(o.flag); spec != nil {
+		return spec.name
+	}
+	return strings.TrimLeft(o.flag, "-")
+}
+
+// Options is the parsed content of SNAPCLIENT_OPTS. Options keep their order
+// and spelling, so writing them back only changes what was edited, and
+// options BluePiCast does not know about are preserved.
+type Options struct {
+	items []option
+}
+
+// ParseOptions parses a snapclient command line the way systemd splits
+// $SNAPCLIENT_OPTS: on whitespace, with quotes kept as part of the values
+func ParseOptions(opts string) *Options {
+	words := splitOptions(opts)
+	o := &Options{}
+
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		if !strings.HasPrefix(word, "-") || word == "-" {
+			o.items = append(o.items, option{value: word, hasValue: true})
+			continue
+		}
+
+		flag, value, equals := strings.Cut(word, "=")
+		item := option{flag: flag, value: value, hasValue: equals, equals: equals}
+		if !equals && i+1 < len(words) && takesNextWord(flag, words[i+1]) {
+			item.value = words[i+1]
+			item.hasValue = true
+			i++
+		}
+		o.items = append(o.items, item)
+	}
+	return o
+}
+
+// takesNextWord reports whether the word following flag is its value.
+// Unknown options take it unless it looks like an option or a server URI.
+func takesNextWord(flag, next string) bool {
+	if spec := findOptionSpec(flag); spec != nil {
+		return spec.takesValue
+	}
+	return !strings.HasPrefix(next, "-") && !strings.Contains(next, "://")
+}
+
+// splitOptions splits a command line on whitespace. The units expand
+// $SNAPCLIENT_OPTS without quote or escape processing, so neither is done
+// here: what is parsed is what snapclient receives.
+func splitOptions(opts string) []string {
+	return strings.Fields(opts)
+}
+
+// String returns the command line
+func (o *Options) String() string {
+	parts := make([]string, 0, len(o.items))
+	for _, item := range o.items {
+		switch {
+		case item.flag == "":
+			parts = append(parts, item.value)
+		case !item.hasValue:
+			parts = append(parts, item.flag)
+		case item.equals:
+			parts = append(parts, item.flag+"="+item.value)
+		default:
+			parts = append(parts, item.flag+" "+item.value)
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Args returns the command line as the arguments of exec.Command
+func (o *Options) Args() []string {
+	args := make([]string, 0, len(o.items))
+	for _, item := range o.items {
+		switch {
+		case item.flag == "":
+			args = append(args, item.value)
+		case !item.hasValue:
+			args = append(args, item.flag)
+		case item.equals:
+			args = append(args, item.flag+"="+item.value)
+		default:
+			args = append(args, item.flag, item.value)
+		}
+	}
+	return args
+}
+
+func (o *Options) find(name string) int {
+	for i, item := range o.items {
+		if item.flag != "" && item.name() == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// Get returns the value of an option given by its long name
+func (o *Options) Get(name string) (string, bool) {
+	if i := o.find(name); i >= 0 {
+		return o.items[i].value, true
+	}
+	return "", false
+}
+
+// Has reports whether an option is set
+func (o *Options) Has(name string) bool {
+	return o.find(name) >= 0
+}
+
+// Set sets the value of an option given by its long name. An option already
+// present keeps its place and spelling, a new one is added before the server URI.
+func (o *Options) Set(name, value string) {
+	if i := o.find(name); i >= 0 {
+		o.items[i].value = value
+		o.items[i].hasValue = true
+		return
+	}
+
+	item := option{flag: "--" + name, value: value, hasValue: true}
+	for i, existing := range o.items {
+		if existing.flag == "" {
+			o.items = append(o.items[:i], append([]option{item}, o.items[i:]...)...)
+			return
+		}
+	}
+	o.items = append(o.items, item)
+}
+
+// Unset removes an option given by its long name
+func (o *Options) Unset(name string) {
+	items := o.items[:0]
+	for _, item := range o.items {
+		if item.flag == "" || item.name() != name {
+			items = append(items, item)
+		}
+	}
+	o.items = items
+}
+
+// Server returns the server URI, or the deprecated --host value
+func (o *Options) Server() string {
+	server := ""
+	for _, item := range o.items {
+		switch {
+		case item.flag != "" && item.name() == "host":
+			server = item.value
+		case item.flag == "" && (server == "" || strings.Contains(item.value, "://")):
+			server = item.value
+		}
+	}
+	return server
+}
+
+// SetServer sets the server URI. It replaces the deprecated --host option.
+func (o *Options) SetServer(uri string) {
+	o.Unset("host")
+	items := o.items[:0]
+	for _, item := range o.items {
+		if item.flag != "" {
+			items = append(items, item)
+		}
+	}
+	o.items = items
+	if uri != "" {
+		o.items = append(o.items, option{value: uri, hasValue: true})
+	}
+}
+
+// Validate checks the values of the known options and the server URI
+func (o *Options) Validate() error {
+	for _, item := range o.items {
+		if strings.ContainsAny(item.value, "\n\r") {
+			return fmt.Errorf("invalid value for %s: line breaks are not allowed", item.displayName())
+		}
+		// systemd splits $SNAPCLIENT_OPTS on whitespace and passes quotes through
+		if strings.ContainsAny(item.value, " \t'\"") {
+			return fmt.Errorf("invalid value for %s: spaces and quotes are not allowed", item.displayName())
+		}
+		if item.flag == "" {
+			if err := validateServerURI(item.value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		spec := findOptionSpec(item.flag)
+		if spec == nil {
+			continue
+		}
+		if spec.takesValue && !item.hasValue {
+			return fmt.Errorf("option --%s requires a value", spec.name)
+		}
+		if !spec.takesValue && !spec.optionalValue && item.hasValue {
+			return fmt.Errorf("option --%s does not take a value", spec.name)
+		}
+		if spec.validate != nil && item.hasValue {
+			if err := spec.validate(item.value); err != nil {
+				return fmt.Errorf("invalid value for --%s: %w", spec.name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (o option) displayName() string {
+	if o.flag == "" {
+		return "server URI"
+	}
+	return "--" + o.name()
+}
+
+// Config returns the settings managed by BluePiCast
+func (o *Options) Config() Config {
+	config := Config{
+		Host:   o.Server(),
+		Player: defaultPlayer,
+	}
+	config.InstanceID, _ = o.Get("hostID")
+	if player, ok := o.Get("player"); ok {
+		config.Player = player
+	}
+	config.Soundcard, _ = o.Get("soundcard")
+	if latency, ok := o.Get("latency"); ok {
+		config.Latency, _ = strconv.Atoi(latency)
+	}
+	config.SampleFormat, _ = o.Get("sampleformat")
+	config.Mixer, _ = o.Get("mixer")
+	return config
+}
+
+// ApplyConfig updates the options from the settings managed by BluePiCast,
+// leaving the other options untouched
+func (o *Options) ApplyConfig(config Config) {
+	setOrUnset := func(name, value string) {
+		if value == "" {
+			o.Unset(name)
+		} else {
+			o.Set(name, value)
+		}
+	}
+
+	setOrUnset("hostID", config.InstanceID)
+	if config.Player == "" {
+		config.Player = defaultPlayer
+	}
+	o.Set("player", config.Player)
+	setOrUnset("soundcard", config.Soundcard)
+	if config.Latency == 0 {
+		o.Unset("latency")
+	} else {
+		o.Set("latency", strconv.Itoa(config.Latency))
+	}
+	setOrUnset("sampleformat", config.SampleFormat)
+	setOrUnset("mixer", config.Mixer)
+	if strings.Contains(config.Host, "://") {
+		o.SetServer(config.Host) // Validate reports unsupported schemes
+	} else {
+		o.SetServer(ensureURIScheme(config.Host))
+	}
+}
+
+var (
+	sampleFormatRegex = regexp.MustCompile(`^(\d+|\*):(\d+|\*):(\d+|\*)$`)
+	playerRegex       = regexp.MustCompile(`^[a-z0-9_]+(:.*)?$`)
+)
+
+func validateNotEmpty(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return fmt.Errorf("value cannot be empty")
+	}
+	return nil
+}
+
+func validatePositiveInt(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return fmt.Errorf("%q is not a positive number", value)
+	}
+	return nil
+}
+
+func validatePort(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("%q is not a valid port", value)
+	}
+	return nil
+}
+
+// validateLatency checks the PCM latency, in milliseconds
+func validateLatency(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < -10000 || n > 10000 {
+		return fmt.Errorf("%q is not a latency between -10000 and 10000 ms", value)
+	}
+	return nil
+}
+
+// validateSampleFormat checks a <rate>:<bits>:<channels> format, "*" keeping the stream value
+func validateSampleFormat(value string) error {
+	matches := sampleFormatRegex.FindStringSubmatch(value)
+	if matches == nil {
+		return fmt.Errorf("%q is not in the <rate>:<bits>:<channels> format", value)
+	}
+	if bits := matches[2]; bits != "*" && bits != "16" && bits != "24" && bits != "32" {
+		return fmt.Errorf("unsupported sample size %s, use 16, 24 or 32", bits)
+	}
+	if matches[1] == "0" || matches[3] == "0" {
+		return fmt.Errorf("rate and channels must be greater than 0")
+	}
+	return nil
+}
+
+// validatePlayer checks a player backend, optionally followed by ":<options>"
+func validatePlayer(value string) error {
+	if !playerRegex.MatchString(value) {
+		return fmt.Errorf("%q is not a valid player", value)
+	}
+	return nil
+}
+
+// validateMixer checks a mixer mode, optionally followed by ":<options>"
+func validateMixer(value string) error {
+	mode, _, _ := strings.Cut(value, ":")
+	switch mode {
+	case "software", "hardware", "script", "none":
+		return nil
+	}
+	return fmt.Errorf("%q is not a mixer mode (software, hardware, script or none)", mode)
+}
+
+func validateLogSink(value string) error {
+	switch {
+	case value == "null", value == "system", value == "stdout", value == "stderr":
+		return nil
+	case strings.HasPrefix(value, "file:") && len(value) > len("file:"):
+		return nil
+	}
+	return fmt.Errorf("%q is not a log sink (null, system, stdout, stderr or file:<path>)", value)
+}
+
+// validateServerURI checks the positional server URI
+func validateServerURI(uri string) error {
+	if uri == "" {
+		return fmt.Errorf("server URI cannot be empty")
+	}
+	if i := strings.Index(uri, "://"); i >= 0 {
+		switch uri[:i] {
+		case "ws", "wss", "tcp":
+		default:
+			return fmt.Errorf("unsupported server URI scheme %q, use ws, wss or tcp", uri[:i])
+		}
+		if len(uri) == i+3 {
+			return fmt.Errorf("server URI %q has no host", uri)
+		}
+	}
+	if strings.ContainsAny(uri, " \t") {
+		return fmt.Errorf("server URI %q contains spaces", uri)
+	}
+	return nil
+}
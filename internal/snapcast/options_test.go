@@ -0,0 +1,183 @@
+package snapcast
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Ilshidur/bluepicast/internal/runner"
+)
+
+func TestSplitOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected []string
+	}{
+		{
+			name:     "plain words",
+			input:    "--player alsa  ws://host",
+			expected: []string{"--player", "alsa", "ws://host"},
+		},
+		{
+			// systemd passes quotes through when it expands $SNAPCLIENT_OPTS
+			name:     "quotes are kept",
+			input:    "--player 'file:filename=/tmp/my file.raw'",
+			expected: []string{"--player", "'file:filename=/tmp/my", "file.raw'"},
+		},
+		{
+			name:     "backslashes are kept",
+			input:    `--hostID living\ room`,
+			expected: []string{"--hostID", `living\`, "room"},
+		},
+		{
+			name:     "tabs and line breaks",
+			input:    "--player\talsa\nws://host",
+			expected: []string{"--player", "alsa", "ws://host"},
+		},
+		{
+			name:     "empty",
+			input:    "   ",
+			expected: []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := splitOptions(tt.input)
+			if !reflect.DeepEqual(result, tt.expected) {
+				t.Errorf("splitOptions(%q) = %q, want %q", tt.input, result, tt.expected)
+			}
+		})
+	}
+}
+
+func TestOptionsRoundTrip(t *testing.T) {
+	tests := []string{
+		"--hostID my-client --player alsa --soundcard hw:0,0 ws://192.168.1.100",
+		"-h 10.0.0.1 -p 1704 -i my-id -s hw:0,0",
+		"--latency=120 --sampleformat 48000:16:* --mixer hardware:Digital ws://host",
+		"--logfilter *:debug --daemon --user snapclient:audio tcp://host:1704",
+		"--player file:filename=/tmp/my-file.raw --custom-flag value ws://host",
+	}
+
+	for _, opts := range tests {
+		if result := ParseOptions(opts).String(); result != opts {
+			t.Errorf("round trip of %q = %q", opts, result)
+		}
+	}
+}
+
+func TestOptionsGetSet(t *testing.T) {
+	options := ParseOptions("-s hw:0,0 --latency=50 --future-option x ws://host")
+
+	if value, ok := options.Get("soundcard"); !ok || value != "hw:0,0" {
+		t.Errorf("Get(soundcard) = %q, %v, want hw:0,0", value, ok)
+	}
+	if value, _ := options.Get("future-option"); value != "x" {
+		t.Errorf("Get(future-option) = %q, want x", value)
+	}
+
+	options.Set("latency", "80")
+	options.Set("mixer", "software")
+	options.Unset("soundcard")
+
+	expected := "--latency=80 --future-option x --mixer software ws://host"
+	if result := options.String(); result != expected {
+		t.Errorf("String() = %q, want %q", result, expected)
+	}
+}
+
+func TestOptionsApplyConfigKeepsOtherOptions(t *testing.T) {
+	options := ParseOptions("-h old-host -i old-id --latency 100 --logfilter *:debug --future-option x")
+
+	options.ApplyConfig(Config{
+		Host:       "192.168.1.10",
+		InstanceID: "kitchen",
+		Player:     "alsa",
+		Soundcard:  "bluealsa",
+		Latency:    -20,
+		Mixer:      "hardware:Digital",
+	})
+
+	expected := "-i kitchen --latency -20 --logfilter *:debug --future-option x --player alsa --soundcard bluealsa --mixer hardware:Digital ws://192.168.1.10"
+	if result := options.String(); result != expected {
+		t.Errorf("String() = %q, want %q", result, expected)
+	}
+
+	config := options.Config()
+	if config.Host != "ws://192.168.1.10" || config.InstanceID != "kitchen" || config.Player != "alsa" || config.Soundcard != "bluealsa" ||
+		config.Latency != -20 || config.SampleFormat != "" || config.Mixer != "hardware:Digital" {
+		t.Errorf("Config() = %+v, does not match the applied config", config)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	tests := []struct {
+		opts    string
+		wantErr string
+	}{
+		{opts: "--latency 120 --sampleformat 48000:16:* --mixer hardware:Digital --port 1704 ws://host"},
+		{opts: "--daemon --logsink file:/tmp/snapclient.log --unknown anything"},
+		{opts: "--latency soon", wantErr: "invalid value for --latency"},
+		{opts: "--sampleformat 48000:12:2", wantErr: "unsupported sample size"},
+		{opts: "--sampleformat 48000-16-2", wantErr: "<rate>:<bits>:<channels>"},
+		{opts: "--mixer loud", wantErr: "not a mixer mode"},
+		{opts: "--port 70000", wantErr: "not a valid port"},
+		{opts: "--logsink syslog", wantErr: "not a log sink"},
+		{opts: "--list=yes", wantErr: "does not take a value"},
+		{opts: "--hostID 'living room'", wantErr: "spaces and quotes are not allowed"},
+		{opts: "--soundcard", wantErr: "requires a value"},
+		{opts: "http://host", wantErr: "unsupported server URI scheme"},
+	}
+
+	for _, tt := range tests {
+		err := ParseOptions(tt.opts).Validate()
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("Validate(%q) = %v, want no error", tt.opts, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("Validate(%q) = %v, want error containing %q", tt.opts, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSetConfigPreservesOptions(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "options")
+	initial := `START_SNAPCLIENT=true
+SNAPCLIENT_OPTS="--latency 100 --player file:filename=/tmp/a.raw --logfilter *:debug ws://old"
+`
+	if err := os.WriteFile(configPath, []byte(initial), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	manager := &Manager{enabled: true, configPath: configPath, instanceDir: filepath.Dir(configPath), runner: runner.NewFake()}
+	if err := manager.SetConfig(DefaultInstance, Config{Host: "new-host", Player: "alsa", Soundcard: "default", Latency: 100}); err != nil {
+		t.Fatalf("SetConfig failed: %v", err)
+	}
+
+	options, err := readOptionsFile(configPath)
+	if err != nil {
+		t.Fatalf("readOptionsFile failed: %v", err)
+	}
+	expected := "--latency 100 --player alsa --logfilter *:debug --soundcard default ws://new-host"
+	if result := options.String(); result != expected {
+		t.Errorf("options after SetConfig = %q, want %q", result, expected)
+	}
+
+	// Invalid values are refused and the file is left untouched
+	if err := manager.SetConfig(DefaultInstance, Config{Host: "http://new-host", Player: "alsa"}); err == nil {
+		t.Error("SetConfig should refuse an http:// server URI")
+	}
+	if err := manager.SetConfig(DefaultInstance, Config{Host: "new-host", Player: "alsa", SampleFormat: "48000:12:2"}); err == nil {
+		t.Error("SetConfig should refuse an invalid sample format")
+	}
+	if err := manager.SetConfig(DefaultInstance, Config{Host: "new-host", Player: "alsa", InstanceID: "living room"}); err == nil {
+		t.Error("SetConfig should refuse a value systemd would split")
+	}
+	if options, _ := readOptionsFile(configPath); options.String() != expected {
+		t.Errorf("options after a refused SetConfig = %q, want %q", options.String(), expected)
+	}
+}
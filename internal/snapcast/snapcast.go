@@ -6,42 +6,72 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
-)
+	"time"
 
-// Compiled regex for parsing volume percentage from amixer output
-var volumeRegex = regexp.MustCompile(`\d+`)
+	"github.com/Ilshidur/bluepicast/internal/alsa"
+	"github.com/Ilshidur/bluepicast/internal/runner"
+	"github.com/godbus/dbus/v5"
+)
 
 // Manager handles Snapclient operations
 type Manager struct {
-	enabled        bool
-	executablePath string
-	configPath     string
-	mu             sync.RWMutex
+	enabled            bool
+	executablePath     string
+	configPath         string
+	instanceDir        string      // Directory of the <name>.options files of the snapclient@ instances
+	supervisor         *Supervisor // Runs snapclient as child processes instead of systemd units, see SetSupervisor
+	runner             runner.Runner
+	systemConfigPath   string         // Config of the system service, see MigrateToUserService
+	systemBus          *systemdClient // System systemd manager, nil to always run systemctl
+	userBus            *systemdClient // User systemd manager of the real user, nil to always run systemctl
+	onStatusChange     func(instance string)
+	statusTimers       map[string]*time.Timer // Pending status change notifications, by instance
+	statusMu           sync.Mutex             // Guards the callbacks, statusTimers and preferredSoundcard
+	onSoundcardChange  func(event alsa.CardEvent)
+	preferredSoundcard string // Card ID the default instance switches to when plugged in
+	mu                 sync.RWMutex
+	helpOptions        map[string]string // Options listed by snapclient --help, see SupportedOptions
+	helpVersion        string            // Version the help was read from
+	helpMu             sync.Mutex
+	mixerControlsPath  string       // File of the mixer control chosen for each soundcard, empty to not keep them
+	mixerMu            sync.Mutex   // Guards the mixer controls file
+	alsa               *alsa.System // Cards and mixers read without aplay and amixer, nil to run them
 }
 
 // Config represents the Snapclient configuration
 type Config struct {
-	Host                string `json:"host"`
-	InstanceID          string `json:"instanceId"`
-	Player              string `json:"player"`
-	Soundcard           string `json:"soundcard"`
-	Volume              int    `json:"volume"`              // ALSA volume percentage (0-100), only used when player is "alsa"
-	SoundcardAvailable  bool   `json:"soundcardAvailable"`  // Indicates if the soundcard is available in the system (checked via aplay -l)
+	Host               string `json:"host"`
+	InstanceID         string `json:"instanceId"`
+	Player             string `json:"player"`
+	Soundcard          string `json:"soundcard"`
+	Volume             int    `json:"volume"`             // ALSA volume percentage (0-100), only used when player is "alsa"
+	SoundcardAvailable bool   `json:"soundcardAvailable"` // Indicates if the soundcard is available in the system (checked via aplay -l)
+	Latency            int    `json:"latency"`            // PCM latency offset in ms, to keep speakers in sync
+	SampleFormat       string `json:"sampleFormat"`       // <rate>:<bits>:<channels> to resample to, empty to keep the stream format
+	Mixer              string `json:"mixer"`              // software, hardware, script or none, optionally with ":<options>"
+	MixerControl       string `json:"mixerControl"`       // ALSA mixer control setting Volume, see SetMixerControl
+	Muted              bool   `json:"muted"`              // The mute switch of MixerControl is off
 }
 
 // Status represents the current state of the Snapclient service
 type Status struct {
-	Running            bool   `json:"running"`
-	Failed             bool   `json:"failed"`
-	Version            string `json:"version"`
-	Config             Config `json:"config"`
-	IsSystemService    bool   `json:"isSystemService"`    // True only if system service is actively running/enabled
-	UserServiceEnabled bool   `json:"userServiceEnabled"` // True if user service is enabled (even if not running)
+	Instance           string   `json:"instance"` // Instance name, empty for the default snapclient unit
+	Running            bool     `json:"running"`
+	Failed             bool     `json:"failed"`
+	ActiveState        string   `json:"activeState,omitempty"` // systemd ActiveState: active, activating, failed, inactive...
+	SubState           string   `json:"subState,omitempty"`    // systemd SubState: running, auto-restart, dead...
+	Restarts           uint32   `json:"restarts"`              // Automatic restarts since snapclient was last started
+	ExitCode           int      `json:"exitCode"`              // Exit status of the last snapclient process
+	Version            string   `json:"version"`
+	Config             Config   `json:"config"`
+	IsSystemService    bool     `json:"isSystemService"`            // True only if system service is actively running/enabled
+	UserServiceEnabled bool     `json:"userServiceEnabled"`         // True if user service is enabled (even if not running)
+	SupportedOptions   []string `json:"supportedOptions,omitempty"` // Options listed by snapclient --help
 }
 
 // MigrationResult contains the result of migration attempt
@@ -66,19 +96,17 @@ const (
 	defaultLogLines       = 100 // Number of initial log lines to fetch
 )
 
-// getRealUser returns the actual user (not root) who should own the user service
+// realUser returns the actual user (not root) who should own the user service
 // Returns username, uid, and home directory
-func getRealUser() (string, string, string, error) {
+func (m *Manager) realUser() (string, string, string, error) {
 	// Check SUDO_USER first (set when running via sudo)
 	sudoUser := os.Getenv("SUDO_USER")
 	if sudoUser != "" && sudoUser != "root" {
 		// Get user info
-		cmd := exec.Command("id", "-u", sudoUser)
-		output, err := cmd.Output()
+		output, err := m.runner.Output("id", "-u", sudoUser)
 		if err == nil {
 			uid := strings.TrimSpace(string(output))
-			cmd = exec.Command("getent", "passwd", sudoUser)
-			output, err = cmd.Output()
+			output, err = m.runner.Output("getent", "passwd", sudoUser)
 			if err == nil {
 				parts := strings.Split(string(output), ":")
 				if len(parts) >= 6 {
@@ -89,8 +117,7 @@ func getRealUser() (string, string, string, error) {
 	}
 
 	// Fallback: find first non-root user with UID >= 1000
-	cmd := exec.Command("getent", "passwd")
-	output, err := cmd.Output()
+	output, err := m.runner.Output("getent", "passwd")
 	if err != nil {
 		return "", "", "", fmt.Errorf("failed to get passwd entries: %w", err)
 	}
@@ -110,9 +137,16 @@ func getRealUser() (string, string, string, error) {
 	return "", "", "", fmt.Errorf("no suitable user found")
 }
 
-// runUserSystemctl runs a systemctl --user command as the real user (not root)
-func runUserSystemctl(args ...string) error {
-	username, uid, _, err := getRealUser()
+// runUserSystemctl runs a systemctl --user command as the real user (not root),
+// over D-Bus when the user manager can be reached
+func (m *Manager) runUserSystemctl(args ...string) error {
+	if m.userBus != nil {
+		if err := m.userBus.Systemctl(args...); !useSystemctl(err) {
+			return err
+		}
+	}
+
+	username, uid, _, err := m.realUser()
 	if err != nil {
 		return fmt.Errorf("failed to determine user: %w", err)
 	}
@@ -130,17 +164,16 @@ func runUserSystemctl(args ...string) error {
 	}
 	cmdArgs = append(cmdArgs, args...)
 
-	cmd := exec.Command("sudo", cmdArgs...)
-	output, err := cmd.CombinedOutput()
+	output, err := m.runner.CombinedOutput("sudo", cmdArgs...)
 	if err != nil {
 		return fmt.Errorf("%w (output: %s)", err, strings.TrimSpace(string(output)))
 	}
 	return nil
 }
 
-// getUserConfigPath returns the user-specific config path for the real user (not root)
-func getUserConfigPath() string {
-	_, _, homeDir, err := getRealUser()
+// userConfigPath returns the user-specific config path for the real user (not root)
+func (m *Manager) userConfigPath() string {
+	_, _, homeDir, err := m.realUser()
 	if err != nil {
 		// Fallback to os.UserHomeDir if we can't determine real user
 		homeDir, err = os.UserHomeDir()
@@ -154,11 +187,27 @@ func getUserConfigPath() string {
 
 // NewManager creates a new Snapclient manager
 func NewManager(enabled bool) *Manager {
-	return &Manager{
-		enabled:        enabled,
-		executablePath: defaultExecutablePath,
-		configPath:     getUserConfigPath(),
-	}
+	m := &Manager{
+		enabled:          enabled,
+		executablePath:   defaultExecutablePath,
+		systemConfigPath: systemConfigPath,
+		runner:           runner.Exec{},
+	}
+	m.systemBus = newSystemdClient("system", func() (*dbus.Conn, error) { return dbus.ConnectSystemBus() }, m.handleUnitChange)
+	m.userBus = newSystemdClient("user", m.connectUserBus, m.handleUnitChange)
+	m.userBus.direct = true
+	if system := alsa.New(); system.Available() {
+		m.alsa = system
+	}
+	m.configPath = m.userConfigPath()
+	m.instanceDir = filepath.Dir(m.configPath)
+	return m
+}
+
+// SetRunner replaces the runner of the external commands, e.g. with a
+// runner.Recorder to capture their outputs
+func (m *Manager) SetRunner(r runner.Runner) {
+	m.runner = r
 }
 
 // IsEnabled returns whether Snapclient integration is enabled
@@ -172,8 +221,7 @@ func (m *Manager) GetVersion() (string, error) {
 		return "", fmt.Errorf("snapclient integration not enabled")
 	}
 
-	cmd := exec.Command(m.executablePath, "--version")
-	output, err := cmd.CombinedOutput()
+	output, err := m.runner.CombinedOutput(m.executablePath, "--version")
 	if err != nil {
 		return "", fmt.Errorf("failed to get version: %w", err)
 	}
@@ -194,8 +242,7 @@ func (m *Manager) ListPCMDevices() ([]Player, error) {
 		return nil, fmt.Errorf("snapclient integration not enabled")
 	}
 
-	cmd := exec.Command(m.executablePath, "-l")
-	output, err := cmd.CombinedOutput()
+	output, err := m.runner.CombinedOutput(m.executablePath, "-l")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list PCM devices: %w", err)
 	}
@@ -236,7 +283,7 @@ func (m *Manager) ListPCMDevices() ([]Player, error) {
 				currentDevice = &Player{
 					Name:        deviceName,
 					Description: "",
-					Available:   checkSoundcardExists(deviceName), // Check if device exists in aplay -l
+					Available:   m.soundcardExists(deviceName), // Check if device exists in aplay -l
 				}
 				devices = append(devices, *currentDevice)
 				descLines = nil
@@ -267,8 +314,8 @@ func (m *Manager) ListPCMDevices() ([]Player, error) {
 	return devices, nil
 }
 
-// GetConfig reads the current configuration from /etc/default/snapclient
-func (m *Manager) GetConfig() (Config, error) {
+// GetConfig reads the current configuration of an instance
+func (m *Manager) GetConfig(instance string) (Config, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
@@ -279,57 +326,36 @@ func (m *Manager) GetConfig() (Config, error) {
 	if !m.enabled {
 		return config, fmt.Errorf("snapclient integration not enabled")
 	}
-
-	file, err := os.Open(m.configPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			// Config file doesn't exist, return defaults
-			return config, nil
-		}
-		return config, fmt.Errorf("failed to open config file: %w", err)
+	if err := m.CheckInstance(instance); err != nil {
+		return config, err
 	}
-	defer file.Close()
 
-	// Parse SNAPCLIENT_OPTS line
-	scanner := bufio.NewScanner(file)
-	optsRegex := regexp.MustCompile(`SNAPCLIENT_OPTS="([^"]*)"`)
-
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		matches := optsRegex.FindStringSubmatch(line)
-		if len(matches) >= 2 {
-			opts := matches[1]
-			config = parseOptions(opts)
-			break
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return config, fmt.Errorf("error reading config file: %w", err)
+	options, err := readOptionsFile(m.instanceConfigPath(instance))
+	if err != nil {
+		return config, err
 	}
+	config = options.Config()
 
 	// Check if soundcard is available and get current ALSA volume if player is "alsa"
 	// Note: SoundcardAvailable is only relevant for ALSA player, defaults to false for other players
 	if config.Player == "alsa" {
 		// Check if soundcard exists in the system
-		config.SoundcardAvailable = checkSoundcardExists(config.Soundcard)
-		
+		config.SoundcardAvailable = m.soundcardExists(config.Soundcard)
+
 		// Skip volume retrieval for bluealsa - it doesn't support standard ALSA mixer controls
 		// BlueALSA volume is controlled via Bluetooth protocol, not amixer
 		if strings.Contains(strings.ToLower(config.Soundcard), "bluealsa") {
 			config.Volume = 100 // BlueALSA doesn't use amixer volume
 		} else if config.SoundcardAvailable {
 			// Only attempt to get volume for non-bluealsa soundcards
-			volume, err := m.GetAlsaVolume(config.Soundcard)
+			control, err := m.selectedMixerControl(config.Soundcard)
 			if err != nil {
 				// Only log once, not on every status check
 				config.Volume = 100 // Default to 100% if we can't get current volume
 			} else {
-				config.Volume = volume
+				config.Volume = control.Volume
+				config.Muted = control.Muted
+				config.MixerControl = control.Name
 			}
 		} else {
 			config.Volume = 100 // Default to 100% when soundcard is not available
@@ -343,69 +369,40 @@ func (m *Manager) GetConfig() (Config, error) {
 	return config, nil
 }
 
-// parseOptions parses command-line options from SNAPCLIENT_OPTS
-func parseOptions(opts string) Config {
-	config := Config{
-		Player: defaultPlayer,
-	}
-
-	// Split by spaces, but respect quoted values
-	parts := strings.Fields(opts)
-
-	for i := 0; i < len(parts); i++ {
-		part := parts[i]
+// optsLineRegex matches the SNAPCLIENT_OPTS line, whose value may contain escaped quotes
+var optsLineRegex = regexp.MustCompile(`SNAPCLIENT_OPTS="((?:[^"\\]|\\.)*)"`)
 
-		// Handle --host or -h (deprecated but still support for backward compatibility)
-		if (part == "--host" || part == "-h") && i+1 < len(parts) {
-			config.Host = parts[i+1]
-			i++
-			continue
-		} else if strings.HasPrefix(part, "--host=") {
-			config.Host = strings.TrimPrefix(part, "--host=")
-			continue
-		}
-
-		// Handle --hostID or -i
-		if (part == "--hostID" || part == "-i") && i+1 < len(parts) {
-			config.InstanceID = parts[i+1]
-			i++
-			continue
-		} else if strings.HasPrefix(part, "--hostID=") {
-			config.InstanceID = strings.TrimPrefix(part, "--hostID=")
-			continue
-		}
-
-		// Handle --player (no short form)
-		if part == "--player" && i+1 < len(parts) {
-			config.Player = parts[i+1]
-			i++
-			continue
-		} else if strings.HasPrefix(part, "--player=") {
-			config.Player = strings.TrimPrefix(part, "--player=")
-			continue
+// readOptionsFile reads SNAPCLIENT_OPTS from an options file.
+// A missing file gives empty options.
+func readOptionsFile(path string) (*Options, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ParseOptions(""), nil
 		}
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer file.Close()
 
-		// Handle --soundcard or -s
-		if (part == "--soundcard" || part == "-s") && i+1 < len(parts) {
-			config.Soundcard = parts[i+1]
-			i++
-			continue
-		} else if strings.HasPrefix(part, "--soundcard=") {
-			config.Soundcard = strings.TrimPrefix(part, "--soundcard=")
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
 			continue
 		}
-
-		// Handle positional argument (server URI) - anything that doesn't start with --
-		// or looks like a URI with scheme
-		if !strings.HasPrefix(part, "--") && !strings.HasPrefix(part, "-") {
-			// This is likely the server URI (may include ws://, wss://, tcp:// scheme)
-			if config.Host == "" || strings.Contains(part, "://") {
-				config.Host = part
-			}
+		if matches := optsLineRegex.FindStringSubmatch(line); matches != nil {
+			return ParseOptions(unescapeShellArg(matches[1])), nil
 		}
 	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+	return ParseOptions(""), nil
+}
 
-	return config
+// parseOptions parses command-line options from SNAPCLIENT_OPTS
+func parseOptions(opts string) Config {
+	return ParseOptions(opts).Config()
 }
 
 // escapeShellArg escapes a string for safe use in shell scripts
@@ -417,6 +414,18 @@ func escapeShellArg(arg string) string {
 	return arg
 }
 
+// unescapeShellArg reverts escapeShellArg
+func unescapeShellArg(arg string) string {
+	var b strings.Builder
+	for i := 0; i < len(arg); i++ {
+		if arg[i] == '\\' && i+1 < len(arg) && (arg[i+1] == '\\' || arg[i+1] == '"') {
+			i++
+		}
+		b.WriteByte(arg[i])
+	}
+	return b.String()
+}
+
 // ensureURIScheme ensures the host has a proper URI scheme (ws://)
 func ensureURIScheme(host string) string {
 	if host == "" {
@@ -431,39 +440,48 @@ func ensureURIScheme(host string) string {
 	return "ws://" + host
 }
 
-// SetConfig writes the configuration to /etc/default/snapclient
-func (m *Manager) SetConfig(config Config) error {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
+// SetConfig writes the configuration of an instance
+func (m *Manager) SetConfig(instance string, config Config) error {
 	if !m.enabled {
 		return fmt.Errorf("snapclient integration not enabled")
 	}
+	if err := m.CheckInstance(instance); err != nil {
+		return err
+	}
 
-	// Build the SNAPCLIENT_OPTS string with escaped values
-	var opts []string
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	if config.InstanceID != "" {
-		opts = append(opts, fmt.Sprintf("--hostID %s", escapeShellArg(config.InstanceID)))
+	// Update the existing options so that options set by hand are kept
+	configPath := m.instanceConfigPath(instance)
+	options, err := readOptionsFile(configPath)
+	if err != nil {
+		return err
 	}
-
-	if config.Player != "" {
-		opts = append(opts, fmt.Sprintf("--player %s", escapeShellArg(config.Player)))
-	} else {
-		opts = append(opts, fmt.Sprintf("--player %s", defaultPlayer))
+	options.ApplyConfig(config)
+	if err := options.Validate(); err != nil {
+		return fmt.Errorf("invalid snapclient options: %w", err)
 	}
-
-	if config.Soundcard != "" {
-		opts = append(opts, fmt.Sprintf("--soundcard %s", escapeShellArg(config.Soundcard)))
+	if err := m.checkHostIDs(instance, options); err != nil {
+		return err
+	}
+	if supported, err := m.SupportedOptions(); err != nil {
+		log.Printf("Warning: Cannot check the options supported by snapclient: %v", err)
+	} else if err := checkSupported(options, supported); err != nil {
+		return err
 	}
 
-	// Add server URI as positional argument (not deprecated --host flag)
-	// Ensure the URI has a proper scheme (ws://, wss://, or tcp://)
-	if config.Host != "" {
-		opts = append(opts, escapeShellArg(ensureURIScheme(config.Host)))
+	if err := writeOptionsFile(configPath, options); err != nil {
+		return err
 	}
 
-	optsStr := strings.Join(opts, " ")
+	log.Printf("Snapclient configuration saved to %s", configPath)
+	return nil
+}
+
+// writeOptionsFile saves the options as SNAPCLIENT_OPTS
+func writeOptionsFile(path string, options *Options) error {
+	optsStr := escapeShellArg(options.String())
 
 	// Create the config file content
 	content := fmt.Sprintf(`# Snapclient configuration (auto-generated)
@@ -472,63 +490,52 @@ SNAPCLIENT_OPTS="%s"
 `, optsStr)
 
 	// Ensure the config directory exists
-	configDir := filepath.Dir(m.configPath)
+	configDir := filepath.Dir(path)
 	if err := os.MkdirAll(configDir, 0755); err != nil {
 		return fmt.Errorf("failed to create config directory: %w", err)
 	}
 
 	// Write to temporary file first, then move
-	tmpPath := m.configPath + ".tmp"
+	tmpPath := path + ".tmp"
 	if err := os.WriteFile(tmpPath, []byte(content), 0644); err != nil {
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
 
-	if err := os.Rename(tmpPath, m.configPath); err != nil {
+	if err := os.Rename(tmpPath, path); err != nil {
 		os.Remove(tmpPath)
 		return fmt.Errorf("failed to save config file: %w", err)
 	}
-
-	log.Printf("Snapclient configuration saved to %s", m.configPath)
-	
 	return nil
 }
 
-// GetStatus returns the current status of the Snapclient service
-func (m *Manager) GetStatus() (Status, error) {
+// GetStatus returns the current status of an instance
+func (m *Manager) GetStatus(instance string) (Status, error) {
 	status := Status{
-		Running: false,
+		Instance: instance,
+		Running:  false,
 	}
 
 	if !m.enabled {
 		return status, fmt.Errorf("snapclient integration not enabled")
 	}
+	if err := m.CheckInstance(instance); err != nil {
+		return status, err
+	}
 
-	// Check if it's a system service or user service
-	status.IsSystemService = m.IsSystemService()
-	status.UserServiceEnabled = m.IsUserServiceEnabled()
-
-	// Check if service is running (as user service)
-	// Need to run as the actual user, not root
-	username, uid, _, err := getRealUser()
-	if err == nil {
-		xdgRuntimeDir := fmt.Sprintf("/run/user/%s", uid)
-		dbusAddr := fmt.Sprintf("unix:path=%s/bus", xdgRuntimeDir)
-
-		cmd := exec.Command("sudo", "-u", username,
-			fmt.Sprintf("XDG_RUNTIME_DIR=%s", xdgRuntimeDir),
-			fmt.Sprintf("DBUS_SESSION_BUS_ADDRESS=%s", dbusAddr),
-			"systemctl", "--user", "is-active", "snapclient")
-		output, cmdErr := cmd.CombinedOutput()
-		activeStatus := strings.TrimSpace(string(output))
-		if cmdErr == nil && activeStatus == "active" {
-			status.Running = true
-		}
-		
-		// Check if service is in failed state
-		if activeStatus == "failed" {
-			status.Failed = true
-		}
+	// Check if it's a system service or user service. Only the default
+	// instance can have been installed as a system service.
+	if instance == DefaultInstance {
+		status.IsSystemService = m.IsSystemService()
 	}
+	status.UserServiceEnabled = m.IsUserServiceEnabled(instance)
+
+	state := m.instanceState(instance)
+	status.Running = state.ActiveState == "active"
+	status.Failed = state.ActiveState == "failed"
+	status.ActiveState = state.ActiveState
+	status.SubState = state.SubState
+	status.Restarts = state.Restarts
+	status.ExitCode = state.ExitCode
 
 	// Get version
 	version, err := m.GetVersion()
@@ -536,10 +543,16 @@ func (m *Manager) GetStatus() (Status, error) {
 		log.Printf("Failed to get Snapclient version: %v", err)
 	} else {
 		status.Version = version
+		if supported, err := m.SupportedOptions(); err == nil {
+			for name := range supported {
+				status.SupportedOptions = append(status.SupportedOptions, name)
+			}
+			sort.Strings(status.SupportedOptions)
+		}
 	}
 
 	// Get configuration
-	config, err := m.GetConfig()
+	config, err := m.GetConfig(instance)
 	if err != nil {
 		log.Printf("Failed to get Snapclient config: %v", err)
 	} else {
@@ -549,80 +562,153 @@ func (m *Manager) GetStatus() (Status, error) {
 	return status, nil
 }
 
-// StartService starts the Snapclient systemd service
-func (m *Manager) StartService() error {
+// IsRunning reports whether snapclient runs for an instance
+func (m *Manager) IsRunning(instance string) bool {
+	if !m.enabled {
+		return false
+	}
+	return m.instanceState(instance).ActiveState == "active"
+}
+
+// instanceState returns the state of the unit or process of an instance
+func (m *Manager) instanceState(instance string) unitState {
+	if m.supervisor != nil {
+		return m.supervisor.UnitState(instance)
+	}
+	state, err := m.userServiceState(unitName(instance))
+	if err != nil {
+		log.Printf("Failed to get %s state: %v", unitName(instance), err)
+	}
+	return state
+}
+
+// StartService starts the systemd service of an instance
+func (m *Manager) StartService(instance string) error {
 	if !m.enabled {
 		return fmt.Errorf("snapclient integration not enabled")
 	}
+	if err := m.CheckInstance(instance); err != nil {
+		return err
+	}
 
 	// First check if user service is enabled
-	if !m.IsUserServiceEnabled() {
+	if !m.IsUserServiceEnabled(instance) {
 		return fmt.Errorf("user service not enabled. Enable it first via the UI")
 	}
 
-	log.Println("Starting Snapclient service...")
-	if err := runUserSystemctl("start", "snapclient"); err != nil {
+	if m.supervisor != nil {
+		args, err := m.instanceArgs(instance)
+		if err != nil {
+			return err
+		}
+		return m.supervisor.Start(instance, args)
+	}
+
+	log.Printf("Starting %s service...", unitName(instance))
+	if err := m.runUserSystemctl("start", unitName(instance)); err != nil {
 		return fmt.Errorf("failed to start service: %w", err)
 	}
 
-	log.Println("Snapclient service started successfully")
+	log.Printf("%s service started successfully", unitName(instance))
 	return nil
 }
 
-// StopService stops the Snapclient systemd service
-func (m *Manager) StopService() error {
+// StopService stops the systemd service of an instance
+func (m *Manager) StopService(instance string) error {
 	if !m.enabled {
 		return fmt.Errorf("snapclient integration not enabled")
 	}
+	if err := m.CheckInstance(instance); err != nil {
+		return err
+	}
+
+	if m.supervisor != nil {
+		return m.supervisor.Stop(instance)
+	}
 
-	log.Println("Stopping Snapclient service...")
-	if err := runUserSystemctl("stop", "snapclient"); err != nil {
+	log.Printf("Stopping %s service...", unitName(instance))
+	if err := m.runUserSystemctl("stop", unitName(instance)); err != nil {
 		return fmt.Errorf("failed to stop service: %w", err)
 	}
 
-	log.Println("Snapclient service stopped successfully")
+	log.Printf("%s service stopped successfully", unitName(instance))
 	return nil
 }
 
-// RestartService restarts the Snapclient systemd service
-func (m *Manager) RestartService() error {
+// RestartService restarts the systemd service of an instance
+func (m *Manager) RestartService(instance string) error {
 	if !m.enabled {
 		return fmt.Errorf("snapclient integration not enabled")
 	}
+	if err := m.CheckInstance(instance); err != nil {
+		return err
+	}
 
 	// First check if user service is enabled
-	if !m.IsUserServiceEnabled() {
+	if !m.IsUserServiceEnabled(instance) {
 		return fmt.Errorf("user service not enabled. Enable it first via the UI")
 	}
 
-	log.Println("Restarting Snapclient service...")
-	if err := runUserSystemctl("restart", "snapclient"); err != nil {
+	if m.supervisor != nil {
+		args, err := m.instanceArgs(instance)
+		if err != nil {
+			return err
+		}
+		return m.supervisor.Restart(instance, args)
+	}
+
+	log.Printf("Restarting %s service...", unitName(instance))
+	if err := m.runUserSystemctl("restart", unitName(instance)); err != nil {
 		return fmt.Errorf("failed to restart service: %w", err)
 	}
 
-	log.Println("Snapclient service restarted successfully")
+	log.Printf("%s service restarted successfully", unitName(instance))
+	return nil
+}
+
+// TryRestartService restarts an instance only if it is running or about to
+// be restarted, as systemctl try-restart does. A stopped instance stays stopped.
+func (m *Manager) TryRestartService(instance string) error {
+	if !m.enabled {
+		return fmt.Errorf("snapclient integration not enabled")
+	}
+	if err := m.CheckInstance(instance); err != nil {
+		return err
+	}
+
+	if m.supervisor != nil {
+		if state := m.supervisor.UnitState(instance); state.ActiveState != "active" && state.ActiveState != "activating" {
+			return nil
+		}
+		args, err := m.instanceArgs(instance)
+		if err != nil {
+			return err
+		}
+		return m.supervisor.Restart(instance, args)
+	}
+
+	if err := m.runUserSystemctl("try-restart", unitName(instance)); err != nil {
+		return fmt.Errorf("failed to restart service: %w", err)
+	}
 	return nil
 }
 
 // IsSystemService checks if Snapclient is running as a system service (root) instead of user service
 // Returns true ONLY if system service is actively running or enabled (not just because user service isn't configured)
 func (m *Manager) IsSystemService() bool {
-	if !m.enabled {
+	if !m.enabled || m.supervisor != nil {
 		return false
 	}
 
 	// Check if system service is active
-	cmd := exec.Command("systemctl", "is-active", "snapclient")
-	output, err := cmd.CombinedOutput()
-	if err == nil && strings.TrimSpace(string(output)) == "active" {
+	status, err := m.systemUnitState("is-active", "snapclient")
+	if err == nil && status == "active" {
 		return true
 	}
 
 	// Check if system service is enabled (even if not running)
-	cmd = exec.Command("systemctl", "is-enabled", "snapclient")
-	output, err = cmd.CombinedOutput()
+	status, err = m.systemUnitState("is-enabled", "snapclient")
 	if err == nil {
-		status := strings.TrimSpace(string(output))
 		if status == "enabled" || status == "static" || status == "alias" {
 			return true
 		}
@@ -632,33 +718,99 @@ func (m *Manager) IsSystemService() bool {
 	return false
 }
 
-// IsUserServiceEnabled checks if the user service is enabled
-func (m *Manager) IsUserServiceEnabled() bool {
+// IsUserServiceEnabled checks if the user service of an instance is enabled
+func (m *Manager) IsUserServiceEnabled(instance string) bool {
 	if !m.enabled {
 		return false
 	}
+	// Instances run by the supervisor need no unit
+	if m.supervisor != nil {
+		return true
+	}
 
-	// Try using the helper for proper user context
-	username, uid, _, err := getRealUser()
+	status, err := m.userUnitState("is-enabled", unitName(instance))
+	if err == nil {
+		if status == "enabled" || status == "static" || status == "alias" {
+			return true
+		}
+	}
+	return false
+}
+
+// userServiceState returns the state of a user service, as shown by
+// systemctl --user show
+func (m *Manager) userServiceState(unit string) (unitState, error) {
+	if m.userBus != nil {
+		if state, err := m.userBus.ServiceState(unit); !useSystemctl(err) {
+			return state, err
+		}
+	}
+
+	username, uid, _, err := m.realUser()
 	if err != nil {
-		return false
+		return unitState{}, err
+	}
+	xdgRuntimeDir := fmt.Sprintf("/run/user/%s", uid)
+	output, err := m.runner.CombinedOutput("sudo", "-u", username,
+		fmt.Sprintf("XDG_RUNTIME_DIR=%s", xdgRuntimeDir),
+		fmt.Sprintf("DBUS_SESSION_BUS_ADDRESS=unix:path=%s/bus", xdgRuntimeDir),
+		"systemctl", "--user", "show", unit, "--property="+strings.Join(unitStateProperties, ","))
+	if err != nil {
+		return unitState{}, fmt.Errorf("%w (output: %s)", err, strings.TrimSpace(string(output)))
+	}
+	return parseUnitState(string(output)), nil
+}
+
+// systemUnitState runs a systemctl query such as is-active on the system
+// manager and returns its output
+func (m *Manager) systemUnitState(query, unit string) (string, error) {
+	if m.systemBus != nil {
+		if state, err := m.systemBus.UnitState(query, unit); !useSystemctl(err) {
+			return state, err
+		}
+	}
+
+	output, err := m.runner.CombinedOutput("systemctl", query, unit)
+	return strings.TrimSpace(string(output)), err
+}
+
+// runSystemctl runs a systemctl command on the system manager
+func (m *Manager) runSystemctl(args ...string) error {
+	if m.systemBus != nil {
+		if err := m.systemBus.Systemctl(args...); !useSystemctl(err) {
+			return err
+		}
+	}
+
+	output, err := m.runner.CombinedOutput("sudo", append([]string{"systemctl"}, args...)...)
+	if err != nil {
+		return fmt.Errorf("%w (output: %s)", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// userUnitState runs a systemctl --user query such as is-active as the real
+// user (not root) and returns its output
+func (m *Manager) userUnitState(query, unit string) (string, error) {
+	if m.userBus != nil {
+		if state, err := m.userBus.UnitState(query, unit); !useSystemctl(err) {
+			return state, err
+		}
+	}
+
+	username, uid, _, err := m.realUser()
+	if err != nil {
+		return "", err
 	}
 
 	xdgRuntimeDir := fmt.Sprintf("/run/user/%s", uid)
 	dbusAddr := fmt.Sprintf("unix:path=%s/bus", xdgRuntimeDir)
 
-	cmd := exec.Command("sudo", "-u", username,
+	output, err := m.runner.CombinedOutput("sudo", "-u", username,
 		fmt.Sprintf("XDG_RUNTIME_DIR=%s", xdgRuntimeDir),
 		fmt.Sprintf("DBUS_SESSION_BUS_ADDRESS=%s", dbusAddr),
-		"systemctl", "--user", "is-enabled", "snapclient")
-	output, err := cmd.CombinedOutput()
-	if err == nil {
-		status := strings.TrimSpace(string(output))
-		if status == "enabled" || status == "static" || status == "alias" {
-			return true
-		}
-	}
-	return false
+		"systemctl", "--user", query, unit)
+	return strings.TrimSpace(string(output)), err
 }
 
 // EnableResult contains the result of enabling the user service
@@ -675,8 +827,12 @@ func (m *Manager) EnableUserService() EnableResult {
 		result.Error = "Snapclient integration not enabled"
 		return result
 	}
+	if m.supervisor != nil {
+		result.Error = "Snapclient is run by BluePiCast, not by systemd"
+		return result
+	}
 
-	_, _, homeDir, err := getRealUser()
+	_, _, homeDir, err := m.realUser()
 	if err != nil {
 		result.Error = fmt.Sprintf("Failed to get real user: %v", err)
 		return result
@@ -720,18 +876,18 @@ WantedBy=default.target
 	}
 
 	// Reload user daemon
-	if err := runUserSystemctl("daemon-reload"); err != nil {
+	if err := m.runUserSystemctl("daemon-reload"); err != nil {
 		log.Printf("Warning: daemon-reload failed: %v", err)
 	}
 
 	// Enable user service
-	if err := runUserSystemctl("enable", "snapclient"); err != nil {
+	if err := m.runUserSystemctl("enable", "snapclient"); err != nil {
 		result.Error = fmt.Sprintf("Failed to enable user service: %v", err)
 		return result
 	}
 
 	// Start user service
-	if err := runUserSystemctl("start", "snapclient"); err != nil {
+	if err := m.runUserSystemctl("start", "snapclient"); err != nil {
 		result.Error = fmt.Sprintf("Failed to start user service: %v", err)
 		return result
 	}
@@ -775,9 +931,22 @@ func extractCardName(soundcard string) string {
 	return soundcard
 }
 
-// checkSoundcardExists checks if a soundcard exists in the system using aplay -l
+// cardName returns the card ID or index named by a soundcard setting, "Audio"
+// for "front:CARD=Audio,DEV=0" and "2" for "hw:2,0". ok is false for settings
+// such as "default" or "bluealsa" that do not name a card.
+func cardName(soundcard string) (name string, ok bool) {
+	name = extractCardName(soundcard)
+	if name == "" || name == soundcard {
+		return "", false
+	}
+	// "hw:2,0" names the device of card 2
+	name, _, _ = strings.Cut(name, ",")
+	return name, true
+}
+
+// soundcardExists checks if a soundcard exists in the system using aplay -l
 // Returns true if the soundcard is found or if soundcard is empty/default
-func checkSoundcardExists(soundcard string) bool {
+func (m *Manager) soundcardExists(soundcard string) bool {
 	// Empty or default soundcard is always valid
 	if soundcard == "" || soundcard == "default" {
 		return true
@@ -798,9 +967,16 @@ func checkSoundcardExists(soundcard string) bool {
 		cardName = soundcard
 	}
 
+	if m.alsa != nil {
+		exists, err := m.alsaCardExists(cardName)
+		if err == nil {
+			return exists
+		}
+		log.Printf("Warning: Failed to read soundcards, running aplay -l: %v", err)
+	}
+
 	// Run aplay -l to list hardware devices
-	cmd := exec.Command("aplay", "-l")
-	output, err := cmd.CombinedOutput()
+	output, err := m.runner.CombinedOutput("aplay", "-l")
 	if err != nil {
 		log.Printf("Warning: Failed to run aplay -l: %v", err)
 		// If aplay fails, we can't verify, so return false for safety
@@ -903,35 +1079,36 @@ func (m *Manager) SetAlsaVolume(soundcard string, volume int) error {
 	}
 
 	// Check if soundcard exists in the system
-	if !checkSoundcardExists(soundcard) {
+	if !m.soundcardExists(soundcard) {
 		return fmt.Errorf("soundcard '%s' not found in system (check 'aplay -l' output)", soundcard)
 	}
 
 	// Convert soundcard to amixer-compatible device format
 	device := convertToAmixerDevice(soundcard)
 
-	// Build the amixer command
-	// Format: amixer [-D device] set PCM volume%
-	args := []string{}
-	
-	// Add device specification if provided
-	if device != "" {
-		args = append(args, "-D", device)
+	control, err := m.selectedMixerControl(soundcard)
+	if err != nil {
+		return err
 	}
-	
-	args = append(args, "set", "PCM", fmt.Sprintf("%d%%", volume))
 
-	cmd := exec.Command("amixer", args...)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("failed to set volume with amixer: %w (output: %s)", err, string(output))
+	if card, ok := m.alsaCard(device); ok {
+		name, index := splitControlName(control.Name)
+		if err := m.alsa.SetVolume(card, name, index, volume); err != nil {
+			return fmt.Errorf("failed to set volume: %w", err)
+		}
+	} else {
+		// Format: amixer [-D device] set <control> volume%
+		output, err := m.runner.CombinedOutput("amixer", amixerArgs(device, "set", control.Name, fmt.Sprintf("%d%%", volume))...)
+		if err != nil {
+			return fmt.Errorf("failed to set volume with amixer: %w (output: %s)", err, string(output))
+		}
 	}
 
-	log.Printf("ALSA volume set to %d%% (device: %s -> %s)", volume, soundcard, device)
+	log.Printf("ALSA volume set to %d%% (device: %s -> %s, control: %s)", volume, soundcard, device, control.Name)
 	return nil
 }
 
-// GetAlsaVolume gets the current ALSA volume using amixer command
+// GetAlsaVolume gets the current ALSA volume of the mixer control of a soundcard
 // Returns volume percentage (0-100) or error
 func (m *Manager) GetAlsaVolume(soundcard string) (int, error) {
 	if !m.enabled {
@@ -939,52 +1116,15 @@ func (m *Manager) GetAlsaVolume(soundcard string) (int, error) {
 	}
 
 	// Check if soundcard exists in the system
-	if !checkSoundcardExists(soundcard) {
+	if !m.soundcardExists(soundcard) {
 		return 0, fmt.Errorf("soundcard '%s' not found in system (check 'aplay -l' output)", soundcard)
 	}
 
-	// Convert soundcard to amixer-compatible device format
-	device := convertToAmixerDevice(soundcard)
-
-	// Build the amixer command
-	// Format: amixer [-D device] get PCM
-	args := []string{}
-	
-	// Add device specification if provided
-	if device != "" {
-		args = append(args, "-D", device)
-	}
-	
-	args = append(args, "get", "PCM")
-
-	cmd := exec.Command("amixer", args...)
-	output, err := cmd.CombinedOutput()
+	control, err := m.selectedMixerControl(soundcard)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get volume with amixer: %w (output: %s)", err, string(output))
-	}
-
-	// Parse the output to extract volume percentage
-	// Output format example: "Simple mixer control 'PCM',0\n  Capabilities: pvolume pvolume-joined pswitch pswitch-joined\n  Playback channels: Mono\n  Limits: Playback 0 - 255\n  Mono: Playback 255 [100%] [0.00dB] [on]"
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		// Look for line with percentage like "[50%]"
-		if strings.Contains(line, "[") && strings.Contains(line, "%]") {
-			// Extract percentage value
-			startIdx := strings.Index(line, "[")
-			endIdx := strings.Index(line, "%]")
-			if startIdx >= 0 && endIdx > startIdx {
-				volumeStr := strings.TrimSpace(line[startIdx+1 : endIdx])
-				volume := volumeRegex.FindString(volumeStr)
-				if volume != "" {
-					var vol int
-					fmt.Sscanf(volume, "%d", &vol)
-					return vol, nil
-				}
-			}
-		}
+		return 0, err
 	}
-
-	return 0, fmt.Errorf("could not parse volume from amixer output")
+	return control.Volume, nil
 }
 
 // MigrateToUserService attempts to migrate from system service to user service
@@ -995,8 +1135,12 @@ func (m *Manager) MigrateToUserService() MigrationResult {
 		result.Error = "Snapclient integration not enabled"
 		return result
 	}
+	if m.supervisor != nil {
+		result.Error = "Snapclient is run by BluePiCast, not by systemd"
+		return result
+	}
 
-	_, _, homeDir, err := getRealUser()
+	_, _, homeDir, err := m.realUser()
 	if err != nil {
 		result.Error = fmt.Sprintf("Failed to get real user: %v", err)
 		return result
@@ -1040,10 +1184,10 @@ WantedBy=default.target
 
 	// Get current config or use defaults
 	var currentConfig Config
-	if _, err := os.Stat(systemConfigPath); err == nil {
+	if _, err := os.Stat(m.systemConfigPath); err == nil {
 		// Try to read system config
-		m.configPath = systemConfigPath
-		currentConfig, _ = m.GetConfig()
+		m.configPath = m.systemConfigPath
+		currentConfig, _ = m.GetConfig(DefaultInstance)
 	}
 	// Set default if no host specified
 	if currentConfig.Host == "" {
@@ -1054,8 +1198,8 @@ WantedBy=default.target
 	}
 
 	// Create user config file with current or default settings
-	m.configPath = getUserConfigPath()
-	if err := m.SetConfig(currentConfig); err != nil {
+	m.configPath = m.userConfigPath()
+	if err := m.SetConfig(DefaultInstance, currentConfig); err != nil {
 		result.Error = fmt.Sprintf("Failed to create user config file: %v", err)
 		return result
 	}
@@ -1064,34 +1208,31 @@ WantedBy=default.target
 	manualSteps := []string{}
 
 	// Stop system service
-	cmd := exec.Command("sudo", "systemctl", "stop", "snapclient")
-	if err := cmd.Run(); err != nil {
+	if err := m.runSystemctl("stop", "snapclient"); err != nil {
 		manualSteps = append(manualSteps, "sudo systemctl stop snapclient")
 	}
 
 	// Disable system service
-	cmd = exec.Command("sudo", "systemctl", "disable", "snapclient")
-	if err := cmd.Run(); err != nil {
+	if err := m.runSystemctl("disable", "snapclient"); err != nil {
 		manualSteps = append(manualSteps, "sudo systemctl disable snapclient")
 	}
 
 	// Mask system service
-	cmd = exec.Command("sudo", "systemctl", "mask", "snapclient")
-	if err := cmd.Run(); err != nil {
+	if err := m.runSystemctl("mask", "snapclient"); err != nil {
 		manualSteps = append(manualSteps, "sudo systemctl mask snapclient")
 	}
 
 	// Reload user daemon
-	if err := runUserSystemctl("daemon-reload"); err != nil {
+	if err := m.runUserSystemctl("daemon-reload"); err != nil {
 		manualSteps = append(manualSteps, "systemctl --user daemon-reload")
 	}
 
 	// Enable and start user service
-	if err := runUserSystemctl("enable", "snapclient"); err != nil {
+	if err := m.runUserSystemctl("enable", "snapclient"); err != nil {
 		manualSteps = append(manualSteps, "systemctl --user enable snapclient")
 	}
 
-	if err := runUserSystemctl("start", "snapclient"); err != nil {
+	if err := m.runUserSystemctl("start", "snapclient"); err != nil {
 		manualSteps = append(manualSteps, "systemctl --user start snapclient")
 	}
 
@@ -1108,43 +1249,44 @@ WantedBy=default.target
 	return result
 }
 
-// StreamLogs streams the systemd journal logs for the snapclient service
+// StreamLogs streams the systemd journal logs for the service of an instance
 // The function returns a channel that will receive log lines and an error if the stream cannot be started.
 // The caller should read from the returned channel until it is closed.
 // Call the returned stop function to stop the log stream.
-func (m *Manager) StreamLogs(ctx context.Context, lines int) (<-chan string, func(), error) {
+func (m *Manager) StreamLogs(ctx context.Context, instance string, lines int) (<-chan string, func(), error) {
 	if !m.enabled {
 		return nil, nil, fmt.Errorf("snapclient integration not enabled")
 	}
+	if err := m.CheckInstance(instance); err != nil {
+		return nil, nil, err
+	}
+	if m.supervisor != nil {
+		return m.supervisor.Logs(ctx, instance, lines)
+	}
 
 	// Create channel for log lines
 	logChan := make(chan string, logChannelBufferSize)
 
 	// Build journalctl command
-	// --user-unit snapclient: user service unit name
+	// --user-unit snapclient[@name]: user service unit name
 	// -f: follow (stream new logs)
 	// -n lines: show last N lines
 	// -o cat: output format (just the message, no metadata)
-	args := []string{"--user-unit", "snapclient", "-f", "-n", fmt.Sprintf("%d", lines), "-o", "cat"}
-	cmd := exec.CommandContext(ctx, "journalctl", args...)
-
-	// Get stdout pipe
-	stdout, err := cmd.StdoutPipe()
+	args := []string{"--user-unit", unitName(instance), "-f", "-n", fmt.Sprintf("%d", lines), "-o", "cat"}
+	ctx, cancel := context.WithCancel(ctx)
+	output, err := m.runner.Stream(ctx, "journalctl", args...)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create stdout pipe: %w", err)
-	}
-
-	// Start the command
-	if err := cmd.Start(); err != nil {
+		cancel()
 		return nil, nil, fmt.Errorf("failed to start journalctl: %w", err)
 	}
 
 	// Start goroutine to read logs
 	go func() {
 		defer close(logChan)
-		defer cmd.Wait()
+		defer cancel()
+		defer output.Close()
 
-		scanner := bufio.NewScanner(stdout)
+		scanner := bufio.NewScanner(output)
 		for scanner.Scan() {
 			select {
 			case <-ctx.Done():
@@ -1158,12 +1300,6 @@ func (m *Manager) StreamLogs(ctx context.Context, lines int) (<-chan string, fun
 		}
 	}()
 
-	// Return stop function
-	stop := func() {
-		if cmd.Process != nil {
-			cmd.Process.Kill()
-		}
-	}
-
-	return logChan, stop, nil
+	// Stopping cancels the context, which kills journalctl
+	return logChan, cancel, nil
 }
@@ -1,8 +1,14 @@
 package snapcast
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/Ilshidur/bluepicast/internal/runner"
 )
 
 func TestParseOptions(t *testing.T) {
@@ -117,7 +123,7 @@ func TestNewManager(t *testing.T) {
 	}
 
 	// configPath should be user-specific now
-	expectedConfigPath := getUserConfigPath()
+	expectedConfigPath := manager.userConfigPath()
 	if manager.configPath != expectedConfigPath {
 		t.Errorf("configPath = %v, want %v", manager.configPath, expectedConfigPath)
 	}
@@ -436,3 +442,219 @@ func TestExtractCardName(t *testing.T) {
 		})
 	}
 }
+
+// newFixtureManager returns a manager replaying the commands of
+// testdata/<fixture>.json, with its options files in a temporary directory.
+// The fixtures are synthetic: they were written by hand after the output of
+// the tools on the hardware they are named after, not recorded there.
+func newFixtureManager(t *testing.T, fixture string) (*Manager, *runner.Fake) {
+	t.Helper()
+	fake, err := runner.LoadFake(filepath.Join("testdata", fixture+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The real user is looked up in the replayed getent passwd output
+	t.Setenv("SUDO_USER", "")
+
+	dir := t.TempDir()
+	manager := &Manager{
+		enabled:           true,
+		executablePath:    defaultExecutablePath,
+		configPath:        filepath.Join(dir, "options"),
+		instanceDir:       dir,
+		systemConfigPath:  filepath.Join(dir, "system-options"),
+		mixerControlsPath: filepath.Join(dir, "data", mixerControlsFile),
+		runner:            fake,
+	}
+	return manager, fake
+}
+
+// writeOptions writes an options file with the given snapclient options
+func writeOptions(t *testing.T, path, opts string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte("SNAPCLIENT_OPTS=\""+opts+"\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetStatusFixture(t *testing.T) {
+	manager, fake := newFixtureManager(t, "pi-usb-dac")
+	writeOptions(t, manager.configPath, "--hostID pi --player alsa --soundcard hw:CARD=Audio,DEV=0 ws://192.168.1.10")
+
+	status, err := manager.GetStatus(DefaultInstance)
+	if err != nil {
+		t.Fatalf("GetStatus failed: %v", err)
+	}
+	if !status.Running || status.Failed || status.SubState != "running" {
+		t.Errorf("Running = %v, Failed = %v, SubState = %q, want a running unit", status.Running, status.Failed, status.SubState)
+	}
+	if status.Restarts != 2 {
+		t.Errorf("Restarts = %d, want 2", status.Restarts)
+	}
+	if !status.UserServiceEnabled {
+		t.Error("UserServiceEnabled should be true")
+	}
+	// The system unit is inactive and masked
+	if status.IsSystemService {
+		t.Error("IsSystemService should be false")
+	}
+	if status.Version != "0.27.0" {
+		t.Errorf("Version = %q, want 0.27.0", status.Version)
+	}
+	if !status.Config.SoundcardAvailable || status.Config.Volume != 76 {
+		t.Errorf("Config = %+v, want an available soundcard at 76%%", status.Config)
+	}
+	if !fake.Ran("sudo -u pi XDG_RUNTIME_DIR=/run/user/1000 DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/1000/bus systemctl --user show snapclient --property=ActiveState,SubState,NRestarts,ExecMainStatus") {
+		t.Errorf("the user unit should be queried as pi, ran %q", fake.History())
+	}
+}
+
+func TestListPCMDevicesFixture(t *testing.T) {
+	manager, _ := newFixtureManager(t, "pi-usb-dac")
+
+	devices, err := manager.ListPCMDevices()
+	if err != nil {
+		t.Fatalf("ListPCMDevices failed: %v", err)
+	}
+
+	expected := []Player{
+		{Name: "null", Description: "Discard all samples (playback) or generate zero samples (capture)"},
+		{Name: "default", Description: "Default ALSA Output (currently PulseAudio Sound Server)", Available: true},
+		{Name: "bluealsa", Description: "Bluetooth Audio Hub"},
+		{Name: "sysdefault:CARD=Headphones", Description: "bcm2835 Headphones, bcm2835 Headphones - Default Audio Device", Available: true},
+		{Name: "hw:CARD=Headphones,DEV=0", Description: "bcm2835 Headphones, bcm2835 Headphones - Direct hardware device without any conversions", Available: true},
+		{Name: "front:CARD=Audio,DEV=0", Description: "AB13X USB Audio, USB Audio - Front output / input", Available: true},
+		{Name: "hw:CARD=Audio,DEV=0", Description: "AB13X USB Audio, USB Audio - Direct hardware device without any conversions", Available: true},
+		{Name: "hw:CARD=vc4hdmi,DEV=0", Description: "vc4-hdmi, MAI PCM i2s-hifi-0 - Direct hardware device without any conversions"},
+	}
+	if !reflect.DeepEqual(devices, expected) {
+		t.Errorf("ListPCMDevices() =\n%+v\nwant\n%+v", devices, expected)
+	}
+}
+
+func TestAlsaVolumeFixture(t *testing.T) {
+	manager, fake := newFixtureManager(t, "pi-usb-dac")
+
+	volume, err := manager.GetAlsaVolume("front:CARD=Audio,DEV=0")
+	if err != nil {
+		t.Fatalf("GetAlsaVolume failed: %v", err)
+	}
+	if volume != 76 {
+		t.Errorf("GetAlsaVolume() = %d, want 76", volume)
+	}
+
+	if err := manager.SetAlsaVolume("hw:CARD=Audio,DEV=0", 50); err != nil {
+		t.Fatalf("SetAlsaVolume failed: %v", err)
+	}
+	if !fake.Ran("amixer -D hw:Audio set PCM 50%") {
+		t.Errorf("amixer should set the PCM control of hw:Audio, ran %q", fake.History())
+	}
+
+	// The headphones jack has a mono PCM control
+	if volume, err := manager.GetAlsaVolume("hw:CARD=Headphones,DEV=0"); err != nil || volume != 77 {
+		t.Errorf("GetAlsaVolume(Headphones) = %d, %v, want 77", volume, err)
+	}
+	// Cards missing from aplay -l are not passed to amixer
+	if _, err := manager.GetAlsaVolume("hw:CARD=vc4hdmi,DEV=0"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("GetAlsaVolume(vc4hdmi) = %v, want a not found error", err)
+	}
+}
+
+// migrationFake returns a fake for MigrateToUserService, with pi's home in a
+// temporary directory and the given results for the sudo systemctl commands
+func migrationFake(t *testing.T, systemExitCode int) (*runner.Fake, string) {
+	home := t.TempDir()
+	user := "sudo -u pi XDG_RUNTIME_DIR=/run/user/1000 DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/1000/bus systemctl --user "
+	fake := runner.NewFake(
+		runner.Call{Command: "getent passwd", Stdout: "root:x:0:0:root:/root:/bin/bash\npi:x:1000:1000:,,,:" + home + ":/bin/bash\n"},
+		runner.Call{Command: "sudo systemctl stop snapclient", ExitCode: systemExitCode, Stderr: "sudo: a terminal is required to read the password\n"},
+		runner.Call{Command: "sudo systemctl disable snapclient", ExitCode: systemExitCode},
+		runner.Call{Command: "sudo systemctl mask snapclient", ExitCode: systemExitCode},
+		runner.Call{Command: user + "daemon-reload"},
+		runner.Call{Command: user + "enable snapclient"},
+		runner.Call{Command: user + "start snapclient"},
+	)
+	return fake, home
+}
+
+func TestMigrateToUserService(t *testing.T) {
+	manager, _ := newFixtureManager(t, "pi-usb-dac")
+	fake, home := migrationFake(t, 0)
+	manager.runner = fake
+	system := "SNAPCLIENT_OPTS=\"--host 192.168.1.10 --hostID living-room\"\n"
+	if err := os.WriteFile(manager.systemConfigPath, []byte(system), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := manager.MigrateToUserService()
+	if !result.Success || len(result.ManualSteps) != 0 {
+		t.Fatalf("MigrateToUserService() = %+v, want success", result)
+	}
+
+	if _, err := os.Stat(filepath.Join(home, ".config/systemd/user/snapclient.service")); err != nil {
+		t.Errorf("user unit not written: %v", err)
+	}
+	// The settings of the system service are carried over
+	config, err := manager.GetConfig(DefaultInstance)
+	if err != nil {
+		t.Fatalf("GetConfig failed: %v", err)
+	}
+	if manager.configPath != filepath.Join(home, ".config/snapclient/options") || config.InstanceID != "living-room" || config.Host != "ws://192.168.1.10" {
+		t.Errorf("config at %s = %+v, want the system settings", manager.configPath, config)
+	}
+	for _, command := range []string{"sudo systemctl mask snapclient", "sudo -u pi XDG_RUNTIME_DIR=/run/user/1000 DBUS_SESSION_BUS_ADDRESS=unix:path=/run/user/1000/bus systemctl --user start snapclient"} {
+		if !fake.Ran(command) {
+			t.Errorf("%q was not run", command)
+		}
+	}
+}
+
+func TestMigrateToUserServiceManualSteps(t *testing.T) {
+	manager, _ := newFixtureManager(t, "pi-usb-dac")
+	fake, _ := migrationFake(t, 1)
+	manager.runner = fake
+
+	result := manager.MigrateToUserService()
+	if result.Success {
+		t.Fatal("migration should need manual steps when sudo fails")
+	}
+	expected := []string{
+		"sudo systemctl stop snapclient",
+		"sudo systemctl disable snapclient",
+		"sudo systemctl mask snapclient",
+	}
+	if !reflect.DeepEqual(result.ManualSteps, expected) {
+		t.Errorf("ManualSteps = %q, want %q", result.ManualSteps, expected)
+	}
+	// Without a system config, the defaults are written
+	config, err := manager.GetConfig(DefaultInstance)
+	if err != nil {
+		t.Fatalf("GetConfig failed: %v", err)
+	}
+	if config.Host != "ws://127.0.0.1" {
+		t.Errorf("Host = %q, want the default server", config.Host)
+	}
+}
+
+func TestStreamLogsFixture(t *testing.T) {
+	manager, fake := newFixtureManager(t, "pi-usb-dac")
+	fake.Add(runner.Call{
+		Command: "journalctl --user-unit snapclient -f -n 2 -o cat",
+		Stdout:  "(Connection) Connecting to 192.168.1.10:1704\n(Controller) Connected to 192.168.1.10\n",
+	})
+
+	logs, stop, err := manager.StreamLogs(context.Background(), DefaultInstance, 2)
+	if err != nil {
+		t.Fatalf("StreamLogs failed: %v", err)
+	}
+	defer stop()
+
+	var lines []string
+	for line := range logs {
+		lines = append(lines, line)
+	}
+	expected := []string{"(Connection) Connecting to 192.168.1.10:1704", "(Controller) Connected to 192.168.1.10"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("lines = %q, want %q", lines, expected)
+	}
+}
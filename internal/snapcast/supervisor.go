@@ -0,0 +1,499 @@
+package snapcast
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	SupervisorSystemd = "systemd" // snapclient runs as a systemd user unit
+	SupervisorProcess = "process" // snapclient runs as a child process of BluePiCast
+
+	logRingSize     = 1000             // Log lines kept per instance
+	restartDelay    = 2 * time.Second  // Delay before the first restart after a crash
+	maxRestartDelay = 30 * time.Second // Restart delays double up to this
+	stableRunTime   = 30 * time.Second // A crash after running this long starts the count again
+	maxFailures     = 5                // Crashes in a row before giving up
+	stopTimeout     = 5 * time.Second  // Time given to snapclient to exit before it is killed
+)
+
+// Supervisor runs snapclient instances as child processes, for systems
+// without systemd such as containers. Like Restart=on-failure, a process that
+// crashes is restarted with an increasing delay, one that exits cleanly is not.
+// The output of each instance is kept in a ring buffer for StreamLogs.
+type Supervisor struct {
+	executablePath  string
+	restartDelay    time.Duration
+	maxRestartDelay time.Duration
+	stableRunTime   time.Duration
+	maxFailures     int
+	onChange        func(instance string) // Called when an instance starts, exits or is restarted
+
+	mu        sync.Mutex
+	processes map[string]*process
+	logs      map[string]*logRing // Kept across restarts, by instance
+}
+
+// process is a supervised snapclient instance
+type process struct {
+	cmd        *exec.Cmd
+	running    bool
+	failed     bool
+	restarting bool          // Waiting to be restarted after a crash
+	restarts   uint32        // Automatic restarts since Start
+	exitCode   int           // Exit status of the last process
+	stopping   bool          // Set by Stop, the exit is not a failure
+	stop       chan struct{} // Closed by Stop to cancel a pending restart
+	done       chan struct{} // Closed when supervision ends
+}
+
+// NewSupervisor creates a supervisor running the given snapclient executable
+func NewSupervisor(executablePath string) *Supervisor {
+	return &Supervisor{
+		executablePath:  executablePath,
+		restartDelay:    restartDelay,
+		maxRestartDelay: maxRestartDelay,
+		stableRunTime:   stableRunTime,
+		maxFailures:     maxFailures,
+		processes:       make(map[string]*process),
+		logs:            make(map[string]*logRing),
+	}
+}
+
+// instanceLogs returns the log buffer of an instance. Must be called with s.mu held.
+func (s *Supervisor) instanceLogs(instance string) *logRing {
+	ring, ok := s.logs[instance]
+	if !ok {
+		ring = newLogRing(logRingSize)
+		s.logs[instance] = ring
+	}
+	return ring
+}
+
+// Start launches snapclient with the given arguments. It does nothing if the
+// instance is already running.
+func (s *Supervisor) Start(instance string, args []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.processes[instance]; ok && p.running {
+		return nil
+	}
+
+	p := &process{
+		running: true,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := s.launch(instance, p, args); err != nil {
+		return err
+	}
+	s.processes[instance] = p
+	go s.supervise(instance, p, args)
+	go s.changed(instance)
+	return nil
+}
+
+// changed calls the change callback, it must not be called with s.mu held
+func (s *Supervisor) changed(instance string) {
+	if s.onChange != nil {
+		s.onChange(instance)
+	}
+}
+
+// launch starts one snapclient process. Must be called with s.mu held.
+func (s *Supervisor) launch(instance string, p *process, args []string) error {
+	ring := s.instanceLogs(instance)
+	cmd := exec.Command(s.executablePath, args...)
+	cmd.Stdout = ring.writer()
+	cmd.Stderr = ring.writer()
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start snapclient: %w", err)
+	}
+	p.cmd = cmd
+	log.Printf("Started snapclient %s (pid %d)", instanceLabel(instance), cmd.Process.Pid)
+	return nil
+}
+
+// supervise waits for the process and restarts it when it fails
+func (s *Supervisor) supervise(instance string, p *process, args []string) {
+	defer close(p.done)
+	defer s.changed(instance)
+
+	delay := s.restartDelay
+	failures := 0
+	for {
+		started := time.Now()
+		err := p.cmd.Wait()
+
+		s.mu.Lock()
+		p.exitCode = exitCode(err)
+		if p.stopping {
+			p.running = false
+			s.mu.Unlock()
+			return
+		}
+		if err == nil {
+			log.Printf("snapclient %s exited", instanceLabel(instance))
+			p.running = false
+			s.mu.Unlock()
+			return
+		}
+
+		if time.Since(started) >= s.stableRunTime {
+			failures = 0
+			delay = s.restartDelay
+		}
+		failures++
+		if failures >= s.maxFailures {
+			log.Printf("snapclient %s failed %d times in a row, giving up: %v", instanceLabel(instance), failures, err)
+			p.running = false
+			p.failed = true
+			s.mu.Unlock()
+			return
+		}
+		p.restarting = true
+		s.mu.Unlock()
+		s.changed(instance)
+
+		log.Printf("snapclient %s failed: %v, restarting in %v", instanceLabel(instance), err, delay)
+		select {
+		case <-time.After(delay):
+		case <-p.stop:
+			s.mu.Lock()
+			p.running = false
+			p.restarting = false
+			s.mu.Unlock()
+			return
+		}
+		delay *= 2
+		if delay > s.maxRestartDelay {
+			delay = s.maxRestartDelay
+		}
+
+		s.mu.Lock()
+		p.restarting = false
+		if p.stopping {
+			p.running = false
+			s.mu.Unlock()
+			return
+		}
+		if err := s.launch(instance, p, args); err != nil {
+			log.Printf("Failed to restart snapclient %s: %v", instanceLabel(instance), err)
+			p.running = false
+			p.failed = true
+			s.mu.Unlock()
+			return
+		}
+		p.restarts++
+		s.mu.Unlock()
+		s.changed(instance)
+	}
+}
+
+// exitCode returns the exit status of a process from the error of Wait
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return 0
+}
+
+// Stop terminates the instance, killing it if it does not exit in time.
+// Concurrent calls all return once the instance has exited.
+func (s *Supervisor) Stop(instance string) error {
+	s.mu.Lock()
+	p, ok := s.processes[instance]
+	if !ok || !p.running {
+		s.mu.Unlock()
+		return nil
+	}
+	// running stays true until supervise returns: another Stop may be on it
+	if p.stopping {
+		s.mu.Unlock()
+		<-p.done
+		return nil
+	}
+	p.stopping = true
+	close(p.stop)
+	cmd := p.cmd
+	s.mu.Unlock()
+
+	// The process may already have exited while waiting to be restarted
+	cmd.Process.Signal(syscall.SIGTERM)
+	select {
+	case <-p.done:
+	case <-time.After(stopTimeout):
+		log.Printf("snapclient %s did not exit, killing it", instanceLabel(instance))
+		cmd.Process.Kill()
+		<-p.done
+	}
+	return nil
+}
+
+// Restart stops the instance and starts it again with the given arguments
+func (s *Supervisor) Restart(instance string, args []string) error {
+	if err := s.Stop(instance); err != nil {
+		return err
+	}
+	return s.Start(instance, args)
+}
+
+// StopAll stops every instance
+func (s *Supervisor) StopAll() {
+	s.mu.Lock()
+	instances := make([]string, 0, len(s.processes))
+	for instance := range s.processes {
+		instances = append(instances, instance)
+	}
+	s.mu.Unlock()
+
+	for _, instance := range instances {
+		s.Stop(instance)
+	}
+}
+
+// State reports whether the instance is running, or gave up after failing
+func (s *Supervisor) State(instance string) (running, failed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.processes[instance]; ok {
+		return p.running, p.failed
+	}
+	return false, false
+}
+
+// UnitState returns the state of an instance with the names systemd uses
+func (s *Supervisor) UnitState(instance string) unitState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.processes[instance]
+	switch {
+	case !ok:
+		return unitState{ActiveState: "inactive", SubState: "dead"}
+	case p.restarting:
+		return unitState{ActiveState: "activating", SubState: "auto-restart", Restarts: p.restarts, ExitCode: p.exitCode}
+	case p.running:
+		return unitState{ActiveState: "active", SubState: "running", Restarts: p.restarts, ExitCode: p.exitCode}
+	case p.failed:
+		return unitState{ActiveState: "failed", SubState: "failed", Restarts: p.restarts, ExitCode: p.exitCode}
+	}
+	return unitState{ActiveState: "inactive", SubState: "dead", Restarts: p.restarts, ExitCode: p.exitCode}
+}
+
+// Logs streams the output of an instance, starting with its last lines.
+// The channel is closed when ctx is done or the returned stop function is called.
+func (s *Supervisor) Logs(ctx context.Context, instance string, lines int) (<-chan string, func(), error) {
+	s.mu.Lock()
+	ring := s.instanceLogs(instance)
+	s.mu.Unlock()
+
+	tail, follow, unsubscribe := ring.follow(lines)
+	logChan := make(chan string, logChannelBufferSize)
+	stopChan := make(chan struct{})
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() { close(stopChan) })
+	}
+
+	go func() {
+		defer close(logChan)
+		defer unsubscribe()
+
+		for _, line := range tail {
+			select {
+			case logChan <- line:
+			case <-ctx.Done():
+				return
+			case <-stopChan:
+				return
+			}
+		}
+		for {
+			select {
+			case line := <-follow:
+				select {
+				case logChan <- line:
+				case <-ctx.Done():
+					return
+				case <-stopChan:
+					return
+				}
+			case <-ctx.Done():
+				return
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+
+	return logChan, stop, nil
+}
+
+// logRing keeps the last lines written by a process and passes new lines on
+// to followers
+type logRing struct {
+	mu        sync.Mutex
+	lines     []string
+	next      int // Index of the next line to write
+	full      bool
+	followers map[chan string]struct{}
+}
+
+func newLogRing(size int) *logRing {
+	return &logRing{
+		lines:     make([]string, size),
+		followers: make(map[chan string]struct{}),
+	}
+}
+
+func (r *logRing) add(line string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+
+	// A slow follower misses lines rather than blocking snapclient
+	for follower := range r.followers {
+		select {
+		case follower <- line:
+		default:
+		}
+	}
+}
+
+// tail returns up to n of the last lines. Must be called with r.mu held.
+func (r *logRing) tail(n int) []string {
+	count := r.next
+	if r.full {
+		count = len(r.lines)
+	}
+	if n > count {
+		n = count
+	}
+
+	result := make([]string, 0, n)
+	for i := r.next - n; i < r.next; i++ {
+		result = append(result, r.lines[(i+len(r.lines))%len(r.lines)])
+	}
+	return result
+}
+
+// follow returns the last n lines and a channel receiving the lines added
+// after them, until unsubscribe is called
+func (r *logRing) follow(n int) (tail []string, lines <-chan string, unsubscribe func()) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	follower := make(chan string, logChannelBufferSize)
+	r.followers[follower] = struct{}{}
+	unsubscribe = func() {
+		r.mu.Lock()
+		delete(r.followers, follower)
+		r.mu.Unlock()
+	}
+	return r.tail(n), follower, unsubscribe
+}
+
+// writer returns an io.Writer adding complete lines to the ring. Each output
+// stream needs its own writer so partial lines are not mixed.
+func (r *logRing) writer() *lineWriter {
+	return &lineWriter{ring: r}
+}
+
+type lineWriter struct {
+	ring    *logRing
+	partial []byte
+}
+
+func (w *lineWriter) Write(data []byte) (int, error) {
+	w.partial = append(w.partial, data...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			break
+		}
+		w.ring.add(string(bytes.TrimSuffix(w.partial[:i], []byte("\r"))))
+		w.partial = w.partial[i+1:]
+	}
+	return len(data), nil
+}
+
+// SetSupervisor selects how snapclient is run: as systemd user units
+// (SupervisorSystemd, the default) or as child processes (SupervisorProcess)
+func (m *Manager) SetSupervisor(kind string) error {
+	switch kind {
+	case SupervisorSystemd:
+		m.supervisor = nil
+	case SupervisorProcess:
+		m.supervisor = NewSupervisor(m.executablePath)
+		m.supervisor.onChange = m.notifyStatusChange
+	default:
+		return fmt.Errorf("unknown snapclient supervisor %q", kind)
+	}
+	return nil
+}
+
+// instanceArgs returns the command line of an instance run by the supervisor
+func (m *Manager) instanceArgs(instance string) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	options, err := readOptionsFile(m.instanceConfigPath(instance))
+	if err != nil {
+		return nil, err
+	}
+	// A daemonized snapclient would look like it exited, and its output
+	// must go to stdout to be captured
+	options.Unset("daemon")
+	args := options.Args()
+	if !options.Has("logsink") {
+		args = append([]string{"--logsink=stdout"}, args...)
+	}
+	return args, nil
+}
+
+// AutoStart starts every instance when snapclient is run as child processes.
+// systemd starts its units by itself.
+func (m *Manager) AutoStart() {
+	if !m.enabled || m.supervisor == nil {
+		return
+	}
+	instances, err := m.ListInstances()
+	if err != nil {
+		log.Printf("Warning: Failed to list Snapclient instances: %v", err)
+		return
+	}
+	for _, instance := range instances {
+		if err := m.StartService(instance); err != nil {
+			log.Printf("Warning: Failed to start snapclient %s: %v", instanceLabel(instance), err)
+		}
+	}
+}
+
+// Close stops the snapclient processes started by BluePiCast and closes the
+// connections to systemd
+func (m *Manager) Close() {
+	if m.supervisor != nil {
+		m.supervisor.StopAll()
+	}
+	for _, bus := range []*systemdClient{m.systemBus, m.userBus} {
+		if bus != nil {
+			bus.Close()
+		}
+	}
+}
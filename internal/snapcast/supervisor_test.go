@@ -0,0 +1,294 @@
+package snapcast
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeSnapclient writes a shell script standing in for snapclient. It prints
+// its arguments, then runs the given commands.
+func fakeSnapclient(t *testing.T, commands string) string {
+	path := filepath.Join(t.TempDir(), "snapclient")
+	script := `#!/bin/sh
+case "$1" in
+--version) echo "snapclient v0.27.0"; exit 0 ;;
+--help) echo "  --hostID arg  unique host id"; exit 0 ;;
+esac
+echo "started $*"
+echo "warning on stderr" >&2
+` + commands + "\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestSupervisor returns a supervisor with short delays
+func newTestSupervisor(executablePath string) *Supervisor {
+	s := NewSupervisor(executablePath)
+	s.restartDelay = 10 * time.Millisecond
+	s.maxRestartDelay = 20 * time.Millisecond
+	s.maxFailures = 3
+	return s
+}
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// logLines returns the lines currently buffered for an instance
+func logLines(s *Supervisor, instance string) []string {
+	s.mu.Lock()
+	ring := s.instanceLogs(instance)
+	s.mu.Unlock()
+
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	return ring.tail(logRingSize)
+}
+
+func TestSupervisorStartStop(t *testing.T) {
+	s := newTestSupervisor(fakeSnapclient(t, "exec sleep 60"))
+
+	if err := s.Start("patio", []string{"--hostID", "pi patio"}); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if running, _ := s.State("patio"); !running {
+		t.Error("instance should be running")
+	}
+	waitFor(t, "output", func() bool { return len(logLines(s, "patio")) == 2 })
+	lines := logLines(s, "patio")
+	if !contains(lines, "started --hostID pi patio") || !contains(lines, "warning on stderr") {
+		t.Errorf("logs = %q, want stdout and stderr", lines)
+	}
+
+	if err := s.Stop("patio"); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+	if running, failed := s.State("patio"); running || failed {
+		t.Errorf("state after Stop = running %v, failed %v", running, failed)
+	}
+	// Stopping is not a failure, the process must not come back
+	time.Sleep(50 * time.Millisecond)
+	if started := countStarts(logLines(s, "patio")); started != 1 {
+		t.Errorf("snapclient started %d times, want 1", started)
+	}
+}
+
+func TestSupervisorConcurrentStops(t *testing.T) {
+	s := newTestSupervisor(fakeSnapclient(t, "exec sleep 60"))
+	if err := s.Start(DefaultInstance, nil); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	const stops = 5
+	errs := make(chan error, stops)
+	for i := 0; i < stops; i++ {
+		go func() { errs <- s.Stop(DefaultInstance) }()
+	}
+	for i := 0; i < stops; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("Stop failed: %v", err)
+		}
+	}
+
+	// Every Stop returned once the process was gone
+	if running, _ := s.State(DefaultInstance); running {
+		t.Error("instance still running after Stop returned")
+	}
+}
+
+func TestSupervisorRestartsOnFailure(t *testing.T) {
+	s := newTestSupervisor(fakeSnapclient(t, "exit 1"))
+	var changes atomic.Int32
+	s.onChange = func(instance string) { changes.Add(1) }
+
+	if err := s.Start(DefaultInstance, nil); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	waitFor(t, "giving up", func() bool {
+		_, failed := s.State(DefaultInstance)
+		return failed
+	})
+
+	if running, _ := s.State(DefaultInstance); running {
+		t.Error("instance should not be running after giving up")
+	}
+	if started := countStarts(logLines(s, DefaultInstance)); started != 3 {
+		t.Errorf("snapclient started %d times, want 3", started)
+	}
+	expected := unitState{ActiveState: "failed", SubState: "failed", Restarts: 2, ExitCode: 1}
+	if state := s.UnitState(DefaultInstance); state != expected {
+		t.Errorf("UnitState() = %+v, want %+v", state, expected)
+	}
+
+	// Start, each crash and restart and giving up are reported
+	waitFor(t, "changes", func() bool { return changes.Load() >= 6 })
+
+	// Starting again clears the failed state
+	s.Start(DefaultInstance, nil)
+	if _, failed := s.State(DefaultInstance); failed {
+		t.Error("failed state should be cleared by Start")
+	}
+	s.Stop(DefaultInstance)
+}
+
+func TestSupervisorCleanExit(t *testing.T) {
+	s := newTestSupervisor(fakeSnapclient(t, "exit 0"))
+
+	if err := s.Start(DefaultInstance, nil); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	waitFor(t, "exit", func() bool {
+		running, _ := s.State(DefaultInstance)
+		return !running
+	})
+
+	time.Sleep(50 * time.Millisecond)
+	if _, failed := s.State(DefaultInstance); failed {
+		t.Error("a clean exit is not a failure")
+	}
+	if started := countStarts(logLines(s, DefaultInstance)); started != 1 {
+		t.Errorf("snapclient started %d times, want 1", started)
+	}
+}
+
+func TestSupervisorLogs(t *testing.T) {
+	s := newTestSupervisor(fakeSnapclient(t, "exec sleep 60"))
+
+	s.Start(DefaultInstance, nil)
+	defer s.Stop(DefaultInstance)
+	waitFor(t, "output", func() bool { return len(logLines(s, DefaultInstance)) == 2 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logChan, stop, err := s.Logs(ctx, DefaultInstance, 1)
+	if err != nil {
+		t.Fatalf("Logs failed: %v", err)
+	}
+
+	// Only the requested number of past lines is sent. Standard output and
+	// error are separate pipes, so either line may have been buffered last.
+	last := logLines(s, DefaultInstance)[1]
+	select {
+	case line := <-logChan:
+		if line != last {
+			t.Errorf("first line = %q, want the last buffered line %q", line, last)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no buffered line received")
+	}
+
+	// New lines follow
+	ring := s.logs[DefaultInstance]
+	ring.writer().Write([]byte("partial "))
+	writer := ring.writer()
+	writer.Write([]byte("new "))
+	writer.Write([]byte("line\r\n"))
+	select {
+	case line := <-logChan:
+		if line != "new line" {
+			t.Errorf("followed line = %q, want %q", line, "new line")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("new line not received")
+	}
+
+	stop()
+	select {
+	case _, ok := <-logChan:
+		for ok {
+			_, ok = <-logChan
+		}
+	case <-time.After(time.Second):
+		t.Fatal("log channel not closed by stop")
+	}
+}
+
+func TestLogRing(t *testing.T) {
+	ring := newLogRing(3)
+	if tail := ring.tail(10); len(tail) != 0 {
+		t.Errorf("tail of an empty ring = %q", tail)
+	}
+
+	for _, line := range []string{"a", "b", "c", "d", "e"} {
+		ring.add(line)
+	}
+	if tail := ring.tail(10); !reflect.DeepEqual(tail, []string{"c", "d", "e"}) {
+		t.Errorf("tail(10) = %q, want the last 3 lines", tail)
+	}
+	if tail := ring.tail(2); !reflect.DeepEqual(tail, []string{"d", "e"}) {
+		t.Errorf("tail(2) = %q", tail)
+	}
+}
+
+func TestManagerProcessSupervisor(t *testing.T) {
+	manager := newInstanceTestManager(t, "patio")
+	manager.executablePath = fakeSnapclient(t, "exec sleep 60")
+	if err := manager.SetSupervisor(SupervisorProcess); err != nil {
+		t.Fatal(err)
+	}
+	defer manager.Close()
+
+	// --daemon would fork snapclient away from the supervisor
+	options := ParseOptions("--daemon --hostID pi-patio --soundcard hw:patio ws://server")
+	if err := writeOptionsFile(manager.instanceConfigPath("patio"), options); err != nil {
+		t.Fatal(err)
+	}
+
+	manager.AutoStart()
+	s := manager.supervisor
+	for _, instance := range []string{DefaultInstance, "patio"} {
+		if running, _ := s.State(instance); !running {
+			t.Errorf("instance %q should have been started", instance)
+		}
+	}
+
+	waitFor(t, "output", func() bool { return len(logLines(s, "patio")) == 2 })
+	if lines := logLines(s, "patio"); !contains(lines, "started --logsink=stdout --hostID pi-patio --soundcard hw:patio ws://server") {
+		t.Errorf("patio logs = %q", lines)
+	}
+
+	status, err := manager.GetStatus("patio")
+	if err != nil {
+		t.Fatalf("GetStatus failed: %v", err)
+	}
+	if !status.Running || !status.UserServiceEnabled || status.IsSystemService {
+		t.Errorf("status = %+v", status)
+	}
+
+	if err := manager.StopService("patio"); err != nil {
+		t.Fatalf("StopService failed: %v", err)
+	}
+	if running, _ := s.State("patio"); running {
+		t.Error("patio should be stopped")
+	}
+
+	if err := manager.SetSupervisor("runit"); err == nil {
+		t.Error("unknown supervisors should be refused")
+	}
+}
+
+func countStarts(lines []string) int {
+	count := 0
+	for _, line := range lines {
+		if strings.HasPrefix(line, "started") {
+			count++
+		}
+	}
+	return count
+}
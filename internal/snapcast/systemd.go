@@ -0,0 +1,559 @@
+package snapcast
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+const (
+	systemdService       = "org.freedesktop.systemd1"
+	systemdPath          = dbus.ObjectPath("/org/freedesktop/systemd1")
+	systemdUnitPath      = "/org/freedesktop/systemd1/unit" // Parent of the unit objects
+	systemdManagerIface  = "org.freedesktop.systemd1.Manager"
+	systemdUnitIface     = "org.freedesktop.systemd1.Unit"
+	systemdServiceIface  = "org.freedesktop.systemd1.Service"
+	dbusPropertiesIface  = "org.freedesktop.DBus.Properties"
+	systemdJobTimeout    = 30 * time.Second       // Time given to a start, stop or restart job
+	statusChangeDelay    = 300 * time.Millisecond // Unit changes closer than this are reported once
+	systemdReconnectWait = 10 * time.Second       // Time before trying to connect again after a failure
+)
+
+// unitStateProperties are the properties making up a unitState
+var unitStateProperties = []string{"ActiveState", "SubState", "NRestarts", "ExecMainStatus"}
+
+// unitState is the state of the service running an instance, with the names
+// systemd uses for the ActiveState and SubState properties
+type unitState struct {
+	ActiveState string
+	SubState    string
+	Restarts    uint32 // NRestarts
+	ExitCode    int    // ExecMainStatus
+}
+
+// parseUnitState parses the output of systemctl show with unitStateProperties
+func parseUnitState(output string) unitState {
+	var state unitState
+	for _, line := range strings.Split(output, "\n") {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "ActiveState":
+			state.ActiveState = value
+		case "SubState":
+			state.SubState = value
+		case "NRestarts":
+			if n, err := strconv.ParseUint(value, 10, 32); err == nil {
+				state.Restarts = uint32(n)
+			}
+		case "ExecMainStatus":
+			state.ExitCode, _ = strconv.Atoi(value)
+		}
+	}
+	return state
+}
+
+// errBusUnavailable is returned when the systemd manager cannot be reached
+// over D-Bus, e.g. when the user manager is not running
+var errBusUnavailable = errors.New("systemd manager not reachable over D-Bus")
+
+// busDeniedErrors are the D-Bus errors of calls the manager refused, e.g.
+// polkit asking for a password, which sudo systemctl may still be allowed
+var busDeniedErrors = map[string]bool{
+	"org.freedesktop.DBus.Error.AccessDenied":                     true,
+	"org.freedesktop.DBus.Error.AuthFailed":                       true,
+	"org.freedesktop.DBus.Error.InteractiveAuthorizationRequired": true,
+}
+
+// useSystemctl reports whether a call failed over D-Bus in a way that
+// systemctl may not: the manager cannot be reached or refused the call
+func useSystemctl(err error) bool {
+	if errors.Is(err, errBusUnavailable) {
+		return true
+	}
+	var dbusErr dbus.Error
+	return errors.As(err, &dbusErr) && busDeniedErrors[dbusErr.Name]
+}
+
+// systemdClient talks to a systemd manager over D-Bus: the system one, or the
+// user one of the real user. It connects on first use, and again after the
+// connection is lost.
+type systemdClient struct {
+	name     string                     // "system" or "user", for logs
+	connect  func() (*dbus.Conn, error) // Opens a connection to the bus of the manager
+	onChange func(unit string)          // Called when the state of a unit changes
+	direct   bool                       // connect opens the socket of the manager itself, not a bus
+
+	mu         sync.Mutex
+	conn       *dbus.Conn
+	lastFailed time.Time                       // Last failed connection, to avoid retrying on every call
+	warned     bool                            // The failure was logged
+	jobs       map[dbus.ObjectPath]chan string // Pending jobs, receiving their result
+	starting   int                             // Jobs being requested, whose path is not known yet
+	finished   map[dbus.ObjectPath]string      // Results of the jobs that ended while some were being requested
+}
+
+// newSystemdClient creates a client connecting with connect
+func newSystemdClient(name string, connect func() (*dbus.Conn, error), onChange func(unit string)) *systemdClient {
+	return &systemdClient{
+		name:     name,
+		connect:  connect,
+		onChange: onChange,
+		jobs:     make(map[dbus.ObjectPath]chan string),
+		finished: make(map[dbus.ObjectPath]string),
+	}
+}
+
+// bus returns the connection to the manager, connecting if needed.
+// Must be called with c.mu held.
+func (c *systemdClient) bus() (*dbus.Conn, error) {
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	if time.Since(c.lastFailed) < systemdReconnectWait {
+		return nil, errBusUnavailable
+	}
+
+	conn, err := c.connect()
+	if err == nil {
+		err = c.subscribe(conn)
+		if err != nil {
+			conn.Close()
+		}
+	}
+	if err != nil {
+		c.lastFailed = time.Now()
+		if !c.warned {
+			log.Printf("Warning: Cannot reach the %s systemd manager over D-Bus, using systemctl: %v", c.name, err)
+			c.warned = true
+		}
+		return nil, fmt.Errorf("%w: %v", errBusUnavailable, err)
+	}
+
+	c.conn = conn
+	c.warned = false
+	return conn, nil
+}
+
+// subscribe asks the manager for unit and job signals and handles them
+func (c *systemdClient) subscribe(conn *dbus.Conn) error {
+	// Without a bus in between, the manager sends its signals to the
+	// subscribed peer and there are no match rules to add
+	if !c.direct {
+		if err := conn.AddMatchSignal(
+			dbus.WithMatchInterface(dbusPropertiesIface),
+			dbus.WithMatchMember("PropertiesChanged"),
+			dbus.WithMatchPathNamespace(systemdUnitPath),
+		); err != nil {
+			return fmt.Errorf("failed to watch units: %w", err)
+		}
+		if err := conn.AddMatchSignal(
+			dbus.WithMatchInterface(systemdManagerIface),
+			dbus.WithMatchMember("JobRemoved"),
+		); err != nil {
+			return fmt.Errorf("failed to watch jobs: %w", err)
+		}
+	}
+	// systemd only sends signals once a client has subscribed
+	if err := conn.Object(systemdService, systemdPath).Call(systemdManagerIface+".Subscribe", 0).Err; err != nil {
+		return fmt.Errorf("failed to subscribe: %w", err)
+	}
+
+	signals := make(chan *dbus.Signal, 10)
+	conn.Signal(signals)
+	go func() {
+		for signal := range signals {
+			c.handleSignal(signal)
+		}
+		// The channel is closed along with the connection
+		c.mu.Lock()
+		if c.conn == conn {
+			c.conn = nil
+		}
+		for job, result := range c.jobs {
+			result <- "disconnected"
+			delete(c.jobs, job)
+		}
+		c.mu.Unlock()
+	}()
+	return nil
+}
+
+func (c *systemdClient) handleSignal(signal *dbus.Signal) {
+	switch signal.Name {
+	case systemdManagerIface + ".JobRemoved":
+		if len(signal.Body) < 4 {
+			return
+		}
+		job, _ := signal.Body[1].(dbus.ObjectPath)
+		result, _ := signal.Body[3].(string)
+		c.mu.Lock()
+		if ch, ok := c.jobs[job]; ok {
+			ch <- result
+			delete(c.jobs, job)
+		} else if c.starting > 0 {
+			// The job may be one being requested, which ended before its
+			// path was returned
+			c.finished[job] = result
+		}
+		c.mu.Unlock()
+	case dbusPropertiesIface + ".PropertiesChanged":
+		if len(signal.Body) < 1 || c.onChange == nil {
+			return
+		}
+		// NRestarts and ExecMainStatus change on the Service interface
+		if iface, _ := signal.Body[0].(string); iface != systemdUnitIface && iface != systemdServiceIface {
+			return
+		}
+		if unit, ok := unitFromPath(signal.Path); ok {
+			c.onChange(unit)
+		}
+	}
+}
+
+// Close closes the connection to the manager
+func (c *systemdClient) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// Systemctl runs a systemctl command over D-Bus. It supports start, stop,
+// restart, try-restart, reset-failed, enable, disable (with --now), mask and daemon-reload, like
+// "systemctl --user <args>" would. errBusUnavailable is returned when the
+// manager cannot be reached, the caller can then run systemctl instead.
+func (c *systemdClient) Systemctl(args ...string) error {
+	now := false
+	var rest []string
+	for _, arg := range args {
+		if arg == "--now" {
+			now = true
+			continue
+		}
+		rest = append(rest, arg)
+	}
+	if len(rest) == 0 {
+		return fmt.Errorf("missing systemctl command")
+	}
+
+	command, units := rest[0], rest[1:]
+	switch command {
+	case "daemon-reload":
+		return c.call("Reload")
+	case "start", "stop", "restart", "try-restart":
+		for _, unit := range units {
+			if err := c.runJob(command, serviceUnit(unit)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "reset-failed":
+		for _, unit := range units {
+			if err := c.call("ResetFailedUnit", serviceUnit(unit)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "enable", "disable", "mask":
+		files := make([]string, len(units))
+		for i, unit := range units {
+			files[i] = serviceUnit(unit)
+		}
+		var err error
+		switch command {
+		case "enable":
+			err = c.call("EnableUnitFiles", files, false, true)
+		case "disable":
+			err = c.call("DisableUnitFiles", files, false)
+		case "mask":
+			err = c.call("MaskUnitFiles", files, false, true)
+		}
+		if err != nil {
+			return err
+		}
+		// systemctl reloads the manager after changing unit files
+		if err := c.call("Reload"); err != nil {
+			return err
+		}
+		if now {
+			action := "start"
+			if command != "enable" {
+				action = "stop"
+			}
+			return c.Systemctl(append([]string{action}, units...)...)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported systemctl command %q", command)
+}
+
+// call calls a method of the manager, dropping its results
+func (c *systemdClient) call(method string, args ...interface{}) error {
+	c.mu.Lock()
+	conn, err := c.bus()
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := conn.Object(systemdService, systemdPath).Call(systemdManagerIface+"."+method, 0, args...).Err; err != nil {
+		return fmt.Errorf("%s failed: %w", method, err)
+	}
+	return nil
+}
+
+// runJob starts, stops or restarts a unit and waits for the job to finish,
+// as systemctl does
+func (c *systemdClient) runJob(command, unit string) error {
+	method := map[string]string{"start": "StartUnit", "stop": "StopUnit", "restart": "RestartUnit", "try-restart": "TryRestartUnit"}[command]
+
+	c.mu.Lock()
+	conn, err := c.bus()
+	if err != nil {
+		c.mu.Unlock()
+		return err
+	}
+	// Results of jobs ending during the call are kept, so that the
+	// JobRemoved signal of this one is not missed
+	c.starting++
+	c.mu.Unlock()
+
+	var job dbus.ObjectPath
+	err = conn.Object(systemdService, systemdPath).Call(systemdManagerIface+"."+method, 0, unit, "replace").Store(&job)
+
+	c.mu.Lock()
+	c.starting--
+	result := make(chan string, 1)
+	if err == nil {
+		if r, ok := c.finished[job]; ok {
+			result <- r
+		} else {
+			c.jobs[job] = result
+		}
+	}
+	if c.starting == 0 {
+		c.finished = make(map[dbus.ObjectPath]string)
+	}
+	c.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to %s %s: %w", command, unit, err)
+	}
+
+	select {
+	case r := <-result:
+		if r != "done" {
+			return fmt.Errorf("failed to %s %s: job %s", command, unit, r)
+		}
+		return nil
+	case <-time.After(systemdJobTimeout):
+		c.mu.Lock()
+		delete(c.jobs, job)
+		c.mu.Unlock()
+		return fmt.Errorf("timed out waiting for %s to %s", unit, command)
+	}
+}
+
+// UnitState answers a systemctl is-active or is-enabled query with the
+// ActiveState or UnitFileState property of the unit
+func (c *systemdClient) UnitState(query, unit string) (string, error) {
+	property := map[string]string{"is-active": "ActiveState", "is-enabled": "UnitFileState"}[query]
+	if property == "" {
+		return "", fmt.Errorf("unsupported systemctl query %q", query)
+	}
+
+	c.mu.Lock()
+	conn, err := c.bus()
+	c.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	// LoadUnit also returns units that are not loaded, unlike GetUnit
+	var path dbus.ObjectPath
+	if err := conn.Object(systemdService, systemdPath).Call(systemdManagerIface+".LoadUnit", 0, serviceUnit(unit)).Store(&path); err != nil {
+		return "", fmt.Errorf("failed to load %s: %w", unit, err)
+	}
+	value, err := conn.Object(systemdService, path).GetProperty(systemdUnitIface + "." + property)
+	if err != nil {
+		return "", fmt.Errorf("failed to get %s of %s: %w", property, unit, err)
+	}
+	state, _ := value.Value().(string)
+	return state, nil
+}
+
+// ServiceState returns the state of a service
+func (c *systemdClient) ServiceState(unit string) (unitState, error) {
+	var state unitState
+
+	c.mu.Lock()
+	conn, err := c.bus()
+	c.mu.Unlock()
+	if err != nil {
+		return state, err
+	}
+
+	var path dbus.ObjectPath
+	if err := conn.Object(systemdService, systemdPath).Call(systemdManagerIface+".LoadUnit", 0, serviceUnit(unit)).Store(&path); err != nil {
+		return state, fmt.Errorf("failed to load %s: %w", unit, err)
+	}
+	obj := conn.Object(systemdService, path)
+	var exitCode int32
+	for _, p := range []struct {
+		name  string
+		value interface{}
+	}{
+		{systemdUnitIface + ".ActiveState", &state.ActiveState},
+		{systemdUnitIface + ".SubState", &state.SubState},
+		{systemdServiceIface + ".NRestarts", &state.Restarts},
+		{systemdServiceIface + ".ExecMainStatus", &exitCode},
+	} {
+		if err := obj.StoreProperty(p.name, p.value); err != nil {
+			return state, fmt.Errorf("failed to get %s of %s: %w", p.name, unit, err)
+		}
+	}
+	state.ExitCode = int(exitCode)
+	return state, nil
+}
+
+// Connected reports whether the manager can be reached, connecting if needed
+func (c *systemdClient) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := c.bus()
+	return err == nil
+}
+
+// serviceUnit adds the .service suffix that systemctl implies
+func serviceUnit(unit string) string {
+	if strings.Contains(unit, ".") {
+		return unit
+	}
+	return unit + ".service"
+}
+
+// unitFromPath returns the unit name of a systemd unit object path, whose
+// characters other than letters and digits are escaped as _xx
+func unitFromPath(path dbus.ObjectPath) (string, bool) {
+	escaped, ok := strings.CutPrefix(string(path), systemdUnitPath+"/")
+	if !ok || escaped == "" {
+		return "", false
+	}
+
+	var unit strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] == '_' && i+2 < len(escaped) {
+			if b, err := strconv.ParseUint(escaped[i+1:i+3], 16, 8); err == nil {
+				unit.WriteByte(byte(b))
+				i += 2
+				continue
+			}
+		}
+		unit.WriteByte(escaped[i])
+	}
+	return unit.String(), true
+}
+
+// instanceFromUnit returns the snapclient instance run by a unit
+func instanceFromUnit(unit string) (string, bool) {
+	name := strings.TrimSuffix(unit, ".service")
+	if name == "snapclient" {
+		return DefaultInstance, true
+	}
+	if instance, ok := strings.CutPrefix(name, "snapclient@"); ok && ValidateInstanceName(instance) == nil {
+		return instance, true
+	}
+	return "", false
+}
+
+// connectUserBus connects to the systemd user manager of the real user on
+// its private socket, as systemctl --user does. The session bus of the user
+// refuses root, the manager accepts its own user and root.
+func (m *Manager) connectUserBus() (*dbus.Conn, error) {
+	_, uid, _, err := m.realUser()
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine user: %w", err)
+	}
+	conn, err := dbus.Dial(fmt.Sprintf("unix:path=/run/user/%s/systemd/private", uid))
+	if err != nil {
+		return nil, err
+	}
+	// The manager is the peer, there is no bus to send Hello to
+	if err := conn.Auth(nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// SetOnStatusChange sets the callback called when the unit of an instance
+// changes state, e.g. when snapclient crashes or is restarted by systemd
+func (m *Manager) SetOnStatusChange(fn func(instance string)) {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+	m.onStatusChange = fn
+}
+
+// handleUnitChange reports a change of a snapclient unit
+func (m *Manager) handleUnitChange(unit string) {
+	if instance, ok := instanceFromUnit(unit); ok {
+		m.notifyStatusChange(instance)
+	}
+}
+
+// notifyStatusChange calls the status change callback. A state change comes
+// as several signals, they are reported once.
+func (m *Manager) notifyStatusChange(instance string) {
+	m.statusMu.Lock()
+	defer m.statusMu.Unlock()
+	if m.onStatusChange == nil {
+		return
+	}
+	if m.statusTimers == nil {
+		m.statusTimers = make(map[string]*time.Timer)
+	}
+	if timer, ok := m.statusTimers[instance]; ok {
+		timer.Reset(statusChangeDelay)
+		return
+	}
+	m.statusTimers[instance] = time.AfterFunc(statusChangeDelay, func() {
+		m.statusMu.Lock()
+		delete(m.statusTimers, instance)
+		fn := m.onStatusChange
+		m.statusMu.Unlock()
+		fn(instance)
+	})
+}
+
+// Watch keeps the connection to the user manager, whose unit signals report
+// the status changes of the instances, until ctx is done. It connects again
+// after the manager restarts or comes up after BluePiCast, changes in between
+// are only seen by the next GetStatus.
+func (m *Manager) Watch(ctx context.Context) {
+	if !m.enabled || m.supervisor != nil || m.userBus == nil {
+		return
+	}
+
+	ticker := time.NewTicker(systemdReconnectWait)
+	defer ticker.Stop()
+	for {
+		// Connecting also subscribes to the unit signals
+		m.userBus.Connected()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
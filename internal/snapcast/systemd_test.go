@@ -0,0 +1,169 @@
+package snapcast
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+func TestUnitFromPath(t *testing.T) {
+	tests := []struct {
+		path dbus.ObjectPath
+		unit string
+		ok   bool
+	}{
+		{path: "/org/freedesktop/systemd1/unit/snapclient_2eservice", unit: "snapclient.service", ok: true},
+		{path: "/org/freedesktop/systemd1/unit/snapclient_40patio_2dleft_2eservice", unit: "snapclient@patio-left.service", ok: true},
+		{path: "/org/freedesktop/systemd1/unit/dbus_2eservice", unit: "dbus.service", ok: true},
+		{path: "/org/freedesktop/systemd1/unit/", ok: false},
+		{path: "/org/freedesktop/systemd1", ok: false},
+	}
+
+	for _, tt := range tests {
+		unit, ok := unitFromPath(tt.path)
+		if unit != tt.unit || ok != tt.ok {
+			t.Errorf("unitFromPath(%q) = %q, %v, want %q, %v", tt.path, unit, ok, tt.unit, tt.ok)
+		}
+	}
+}
+
+func TestInstanceFromUnit(t *testing.T) {
+	tests := []struct {
+		unit     string
+		instance string
+		ok       bool
+	}{
+		{unit: "snapclient.service", instance: DefaultInstance, ok: true},
+		{unit: "snapclient@patio.service", instance: "patio", ok: true},
+		{unit: "snapclient@.service", ok: false},
+		{unit: "snapserver.service", ok: false},
+		{unit: "bluealsa.service", ok: false},
+	}
+
+	for _, tt := range tests {
+		instance, ok := instanceFromUnit(tt.unit)
+		if instance != tt.instance || ok != tt.ok {
+			t.Errorf("instanceFromUnit(%q) = %q, %v, want %q, %v", tt.unit, instance, ok, tt.instance, tt.ok)
+		}
+	}
+}
+
+func TestSystemctlFallback(t *testing.T) {
+	manager, fake := newFixtureManager(t, "pi-usb-dac")
+	connects := 0
+	unreachable := func() (*dbus.Conn, error) {
+		connects++
+		return nil, errors.New("no such file or directory")
+	}
+	manager.systemBus = newSystemdClient("system", unreachable, nil)
+	manager.userBus = newSystemdClient("user", unreachable, nil)
+
+	// Without a bus, the same commands as before are run
+	if state, err := manager.userUnitState("is-active", "snapclient"); err != nil || state != "active" {
+		t.Errorf("userUnitState() = %q, %v, want active", state, err)
+	}
+	if manager.IsSystemService() {
+		t.Error("IsSystemService should be false, the system unit is masked")
+	}
+	if !fake.Ran("systemctl is-enabled snapclient") {
+		t.Errorf("systemctl should be run instead, ran %q", fake.History())
+	}
+
+	// The connection is not retried on every call
+	if connects != 2 {
+		t.Errorf("connected %d times, want once per bus", connects)
+	}
+}
+
+func TestUseSystemctl(t *testing.T) {
+	tests := []struct {
+		err      error
+		expected bool
+	}{
+		{fmt.Errorf("%w: no such file or directory", errBusUnavailable), true},
+		// polkit refuses the call without a password, sudo may be allowed
+		{fmt.Errorf("failed to restart snapclient.service: %w", dbus.Error{Name: "org.freedesktop.DBus.Error.InteractiveAuthorizationRequired"}), true},
+		{fmt.Errorf("EnableUnitFiles failed: %w", dbus.Error{Name: "org.freedesktop.DBus.Error.AccessDenied"}), true},
+		{fmt.Errorf("failed to load x: %w", dbus.Error{Name: "org.freedesktop.systemd1.NoSuchUnit"}), false},
+		{errors.New("failed to start snapclient.service: job failed"), false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := useSystemctl(tt.err); got != tt.expected {
+			t.Errorf("useSystemctl(%v) = %v, want %v", tt.err, got, tt.expected)
+		}
+	}
+}
+
+func TestJobEndingBeforeItsPath(t *testing.T) {
+	c := newSystemdClient("user", nil, nil)
+	removed := func(job dbus.ObjectPath, result string) *dbus.Signal {
+		return &dbus.Signal{Name: systemdManagerIface + ".JobRemoved", Body: []interface{}{uint32(1), job, "snapclient.service", result}}
+	}
+
+	// Results are only kept while a job is being requested
+	c.handleSignal(removed("/org/freedesktop/systemd1/job/1", "done"))
+	if len(c.finished) != 0 {
+		t.Errorf("finished = %v, want nothing kept", c.finished)
+	}
+	c.starting = 1
+	c.handleSignal(removed("/org/freedesktop/systemd1/job/2", "failed"))
+	if c.finished["/org/freedesktop/systemd1/job/2"] != "failed" {
+		t.Errorf("finished = %v, want the result of job 2", c.finished)
+	}
+}
+
+func TestHandleUnitChange(t *testing.T) {
+	manager := &Manager{}
+	var mu sync.Mutex
+	changes := map[string]int{}
+	manager.SetOnStatusChange(func(instance string) {
+		mu.Lock()
+		changes[instance]++
+		mu.Unlock()
+	})
+
+	// Starting a unit changes its properties several times
+	for _, unit := range []string{"snapclient.service", "snapclient.service", "snapclient@patio.service", "snapclient.service", "dbus.service"} {
+		manager.handleUnitChange(unit)
+	}
+	time.Sleep(statusChangeDelay + 200*time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(changes) != 2 || changes[DefaultInstance] != 1 || changes["patio"] != 1 {
+		t.Errorf("changes = %v, want one per instance", changes)
+	}
+}
+
+func TestServiceUnit(t *testing.T) {
+	for unit, expected := range map[string]string{
+		"snapclient":         "snapclient.service",
+		"snapclient@patio":   "snapclient@patio.service",
+		"snapclient.service": "snapclient.service",
+		"default.target":     "default.target",
+	} {
+		if got := serviceUnit(unit); got != expected {
+			t.Errorf("serviceUnit(%q) = %q, want %q", unit, got, expected)
+		}
+	}
+}
+
+func TestParseUnitState(t *testing.T) {
+	output := "NRestarts=5\nExecMainStatus=1\nActiveState=failed\nSubState=failed\n"
+	expected := unitState{ActiveState: "failed", SubState: "failed", Restarts: 5, ExitCode: 1}
+	if state := parseUnitState(output); state != expected {
+		t.Errorf("parseUnitState() = %+v, want %+v", state, expected)
+	}
+
+	// Units that were never loaded have no restart count
+	output = "NRestarts=[not set]\nExecMainStatus=0\nActiveState=inactive\nSubState=dead\n"
+	expected = unitState{ActiveState: "inactive", SubState: "dead"}
+	if state := parseUnitState(output); state != expected {
+		t.Errorf("parseUnitState() = %+v, want %+v", state, expected)
+	}
+}
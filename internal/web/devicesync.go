@@ -0,0 +1,209 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// deviceSyncInterval is how long device changes are coalesced before being
+// sent to clients. RSSI updates during a scan arrive many times per second.
+const deviceSyncInterval = 250 * time.Millisecond
+
+// DeviceDeltaPayload describes a single change to the device list.
+// Seq increases by one for every delta; a client that sees a gap
+// should send a devices_resync message to get a full snapshot.
+// A device_updated delta without a device only updates HiddenCount.
+type DeviceDeltaPayload struct {
+	Seq         uint64      `json:"seq"`
+	Device      *DeviceInfo `json:"device,omitempty"`  // Set for device_added and device_updated
+	Address     string      `json:"address,omitempty"` // Set for device_removed
+	HiddenCount int         `json:"hiddenCount"`
+}
+
+// deviceSync tracks what clients last received so that only the
+// devices that changed are sent
+type deviceSync struct {
+	mu          sync.Mutex
+	sendMu      sync.Mutex // Held while deltas are written, so clients get them in sequence order
+	seq         uint64
+	sent        map[string][]byte // Address -> last sent DeviceInfo JSON
+	showUnnamed bool
+	hiddenCount int // Hidden device count last sent
+	pending     bool
+}
+
+func newDeviceSync() *deviceSync {
+	return &deviceSync{
+		sent: make(map[string][]byte),
+	}
+}
+
+// scheduleDeviceSync queues a device list update. Changes arriving within
+// deviceSyncInterval are merged into a single set of deltas.
+func (s *Server) scheduleDeviceSync() {
+	s.devSync.mu.Lock()
+	defer s.devSync.mu.Unlock()
+
+	if s.devSync.pending {
+		return
+	}
+	s.devSync.pending = true
+	time.AfterFunc(deviceSyncInterval, s.syncDevices)
+}
+
+// deviceMessage is a device list update waiting to be broadcast
+type deviceMessage struct {
+	msgType MessageType
+	payload interface{}
+}
+
+// syncDevices broadcasts the changes since the last sync right away
+func (s *Server) syncDevices() {
+	s.devSync.mu.Lock()
+	_, messages := s.syncDevicesLocked()
+	s.devSync.sendMu.Lock()
+	s.devSync.mu.Unlock()
+	defer s.devSync.sendMu.Unlock()
+
+	s.broadcastDeviceMessages(messages)
+}
+
+// broadcastDeviceMessages sends device list updates to all clients.
+// devSync.sendMu must be held.
+func (s *Server) broadcastDeviceMessages(messages []deviceMessage) {
+	for _, m := range messages {
+		s.broadcastPayload(m.msgType, m.payload)
+	}
+}
+
+// syncDevicesLocked diffs the current device list against what clients
+// last received and returns the deltas to broadcast, along with the payload
+// it diffed so callers can send a snapshot consistent with the sequence
+// number. devSync.mu must be held; sending is left to the caller so that
+// slow clients do not block device changes.
+func (s *Server) syncDevicesLocked() (DevicesPayload, []deviceMessage) {
+	s.devSync.pending = false
+	return s.devSync.diff(s.devicesPayload(s.adapter.GetDevices()))
+}
+
+// diff records payload as sent and returns it with its sequence number set,
+// along with the deltas from the previously sent list. ds.mu must be held.
+func (ds *deviceSync) diff(payload DevicesPayload) (DevicesPayload, []deviceMessage) {
+	// Toggling unnamed devices changes most of the list: send a full snapshot
+	if payload.ShowUnnamed != ds.showUnnamed {
+		ds.showUnnamed = payload.ShowUnnamed
+		ds.hiddenCount = payload.HiddenCount
+		ds.sent = make(map[string][]byte)
+		for i := range payload.Devices {
+			if data, err := json.Marshal(payload.Devices[i]); err == nil {
+				ds.sent[payload.Devices[i].Address] = data
+			}
+		}
+		ds.seq++
+		payload.Seq = ds.seq
+		return payload, []deviceMessage{{MsgTypeDevices, payload}}
+	}
+
+	var messages []deviceMessage
+	current := make(map[string]bool, len(payload.Devices))
+	for i := range payload.Devices {
+		info := &payload.Devices[i]
+		current[info.Address] = true
+
+		data, err := json.Marshal(info)
+		if err != nil {
+			log.Printf("Error marshaling device %s: %v", info.Address, err)
+			continue
+		}
+		previous, known := ds.sent[info.Address]
+		if known && bytes.Equal(previous, data) {
+			continue
+		}
+		ds.sent[info.Address] = data
+
+		msgType := MsgTypeDeviceUpdated
+		if !known {
+			msgType = MsgTypeDeviceAdded
+		}
+		ds.seq++
+		messages = append(messages, deviceMessage{msgType, DeviceDeltaPayload{
+			Seq:         ds.seq,
+			Device:      info,
+			HiddenCount: payload.HiddenCount,
+		}})
+	}
+
+	for address := range ds.sent {
+		if current[address] {
+			continue
+		}
+		delete(ds.sent, address)
+		ds.seq++
+		messages = append(messages, deviceMessage{MsgTypeDeviceRemoved, DeviceDeltaPayload{
+			Seq:         ds.seq,
+			Address:     address,
+			HiddenCount: payload.HiddenCount,
+		}})
+	}
+
+	// Hidden beacons coming and going change no listed device, but the
+	// count shown to the user must follow
+	if len(messages) == 0 && payload.HiddenCount != ds.hiddenCount {
+		ds.seq++
+		messages = append(messages, deviceMessage{MsgTypeDeviceUpdated, DeviceDeltaPayload{
+			Seq:         ds.seq,
+			HiddenCount: payload.HiddenCount,
+		}})
+	}
+	ds.hiddenCount = payload.HiddenCount
+
+	payload.Seq = ds.seq
+	return payload, messages
+}
+
+// sendDevices sends a full device list snapshot to a single client.
+// Pending changes are flushed first so the snapshot matches the sequence number.
+func (s *Server) sendDevices(c *client) {
+	s.devSync.mu.Lock()
+	payload, messages := s.syncDevicesLocked()
+	s.devSync.sendMu.Lock()
+	s.devSync.mu.Unlock()
+	defer s.devSync.sendMu.Unlock()
+
+	s.broadcastDeviceMessages(messages)
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error marshaling devices payload: %v", err)
+		return
+	}
+	msg := Message{
+		Type:    MsgTypeDevices,
+		Payload: payloadBytes,
+	}
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling devices message: %v", err)
+		return
+	}
+	c.mu.Lock()
+	c.conn.WriteMessage(websocket.TextMessage, msgBytes)
+	c.mu.Unlock()
+}
+
+func (s *Server) broadcastPayload(msgType MessageType, payload interface{}) {
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error marshaling %s payload: %v", msgType, err)
+		return
+	}
+	s.broadcast(&Message{
+		Type:    msgType,
+		Payload: payloadBytes,
+	})
+}
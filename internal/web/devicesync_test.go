@@ -0,0 +1,132 @@
+package web
+
+import (
+	"testing"
+
+	"github.com/Ilshidur/bluepicast/internal/bluetooth"
+)
+
+func deviceInfo(address, name string) DeviceInfo {
+	return DeviceInfo{Device: &bluetooth.Device{Address: address, Name: name}}
+}
+
+// deltas returns the delta payloads of messages, failing on snapshots
+func deltas(t *testing.T, messages []deviceMessage) []DeviceDeltaPayload {
+	t.Helper()
+	var out []DeviceDeltaPayload
+	for _, m := range messages {
+		delta, ok := m.payload.(DeviceDeltaPayload)
+		if !ok {
+			t.Fatalf("%s message is not a delta", m.msgType)
+		}
+		out = append(out, delta)
+	}
+	return out
+}
+
+func TestDeviceSyncDeltas(t *testing.T) {
+	ds := newDeviceSync()
+
+	// A new device is added
+	_, messages := ds.diff(DevicesPayload{Devices: []DeviceInfo{deviceInfo("01", "Speaker")}})
+	if len(messages) != 1 || messages[0].msgType != MsgTypeDeviceAdded {
+		t.Fatalf("messages = %+v, want one device_added", messages)
+	}
+	if d := deltas(t, messages)[0]; d.Seq != 1 || d.Device.Address != "01" {
+		t.Errorf("added delta = %+v, want seq 1 for 01", d)
+	}
+
+	// Nothing changed: nothing is sent
+	if _, messages := ds.diff(DevicesPayload{Devices: []DeviceInfo{deviceInfo("01", "Speaker")}}); len(messages) != 0 {
+		t.Errorf("unchanged list sent %d message(s)", len(messages))
+	}
+
+	// The device is renamed and another one shows up
+	_, messages = ds.diff(DevicesPayload{Devices: []DeviceInfo{deviceInfo("01", "Kitchen"), deviceInfo("02", "Headphones")}})
+	if len(messages) != 2 || messages[0].msgType != MsgTypeDeviceUpdated || messages[1].msgType != MsgTypeDeviceAdded {
+		t.Fatalf("messages = %+v, want device_updated then device_added", messages)
+	}
+	got := deltas(t, messages)
+	if got[0].Seq != 2 || got[0].Device.Name != "Kitchen" || got[1].Seq != 3 || got[1].Device.Address != "02" {
+		t.Errorf("deltas = %+v, want 01 renamed at seq 2 and 02 added at seq 3", got)
+	}
+
+	// The first device goes away
+	_, messages = ds.diff(DevicesPayload{Devices: []DeviceInfo{deviceInfo("02", "Headphones")}, HiddenCount: 2})
+	if len(messages) != 1 || messages[0].msgType != MsgTypeDeviceRemoved {
+		t.Fatalf("messages = %+v, want one device_removed", messages)
+	}
+	if d := deltas(t, messages)[0]; d.Seq != 4 || d.Address != "01" || d.Device != nil || d.HiddenCount != 2 {
+		t.Errorf("removed delta = %+v, want 01 removed at seq 4 with 2 hidden", d)
+	}
+}
+
+func TestDeviceSyncHiddenCount(t *testing.T) {
+	ds := newDeviceSync()
+	ds.diff(DevicesPayload{Devices: []DeviceInfo{deviceInfo("01", "Speaker")}})
+
+	// Beacons appearing change no listed device
+	_, messages := ds.diff(DevicesPayload{Devices: []DeviceInfo{deviceInfo("01", "Speaker")}, HiddenCount: 3})
+	if len(messages) != 1 || messages[0].msgType != MsgTypeDeviceUpdated {
+		t.Fatalf("messages = %+v, want one device_updated", messages)
+	}
+	if d := deltas(t, messages)[0]; d.Seq != 2 || d.Device != nil || d.HiddenCount != 3 {
+		t.Errorf("delta = %+v, want seq 2 without device and 3 hidden", d)
+	}
+
+	// The count is only sent once
+	if _, messages := ds.diff(DevicesPayload{Devices: []DeviceInfo{deviceInfo("01", "Speaker")}, HiddenCount: 3}); len(messages) != 0 {
+		t.Errorf("unchanged hidden count sent %d message(s)", len(messages))
+	}
+
+	// Beacons leaving are reported too
+	_, messages = ds.diff(DevicesPayload{Devices: []DeviceInfo{deviceInfo("01", "Speaker")}})
+	if d := deltas(t, messages); len(d) != 1 || d[0].HiddenCount != 0 {
+		t.Errorf("deltas = %+v, want one with no hidden device", d)
+	}
+}
+
+func TestDeviceSyncSnapshotSeq(t *testing.T) {
+	ds := newDeviceSync()
+	ds.diff(DevicesPayload{Devices: []DeviceInfo{deviceInfo("01", "Speaker")}})
+
+	// A snapshot taken with pending changes carries the seq of the last delta
+	payload, messages := ds.diff(DevicesPayload{Devices: []DeviceInfo{deviceInfo("01", "Speaker"), deviceInfo("02", "Headphones")}})
+	if len(messages) != 1 {
+		t.Fatalf("pending changes sent %d message(s), want 1", len(messages))
+	}
+	if last := deltas(t, messages)[0].Seq; payload.Seq != last {
+		t.Errorf("snapshot seq = %d, want %d", payload.Seq, last)
+	}
+
+	// The next delta follows the snapshot without a gap
+	_, messages = ds.diff(DevicesPayload{Devices: []DeviceInfo{deviceInfo("02", "Headphones")}})
+	if d := deltas(t, messages); len(d) != 1 || d[0].Seq != payload.Seq+1 {
+		t.Errorf("deltas = %+v, want one at seq %d", d, payload.Seq+1)
+	}
+}
+
+func TestDeviceSyncShowUnnamedSnapshot(t *testing.T) {
+	ds := newDeviceSync()
+	ds.diff(DevicesPayload{Devices: []DeviceInfo{deviceInfo("01", "Speaker")}, HiddenCount: 1})
+
+	// Showing unnamed devices sends the whole list
+	listed := DevicesPayload{Devices: []DeviceInfo{deviceInfo("01", "Speaker"), deviceInfo("02", "")}, ShowUnnamed: true}
+	payload, messages := ds.diff(listed)
+	if len(messages) != 1 || messages[0].msgType != MsgTypeDevices {
+		t.Fatalf("messages = %+v, want one devices snapshot", messages)
+	}
+	snapshot, ok := messages[0].payload.(DevicesPayload)
+	if !ok || len(snapshot.Devices) != 2 || snapshot.Seq != 2 || payload.Seq != 2 {
+		t.Errorf("snapshot = %+v, want both devices at seq 2", messages[0].payload)
+	}
+
+	// Deltas resume from the snapshot
+	if _, messages := ds.diff(listed); len(messages) != 0 {
+		t.Errorf("unchanged list after snapshot sent %d message(s)", len(messages))
+	}
+	_, messages = ds.diff(DevicesPayload{Devices: []DeviceInfo{deviceInfo("01", "Speaker")}, ShowUnnamed: true})
+	if d := deltas(t, messages); len(d) != 1 || d[0].Seq != 3 || d[0].Address != "02" {
+		t.Errorf("deltas = %+v, want 02 removed at seq 3", d)
+	}
+}
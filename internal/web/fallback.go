@@ -0,0 +1,200 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Ilshidur/bluepicast/internal/alsa"
+	"github.com/Ilshidur/bluepicast/internal/audio"
+	"github.com/Ilshidur/bluepicast/internal/bluetooth"
+	"github.com/Ilshidur/bluepicast/internal/snapcast"
+	"github.com/gorilla/websocket"
+)
+
+// FallbackPayload is the fallback output chain, with the output routed to and
+// the soundcards that can be added to it
+type FallbackPayload struct {
+	Chain  []audio.Output `json:"chain"`
+	Active *audio.Output  `json:"active,omitempty"`
+	Cards  []alsa.Card    `json:"cards,omitempty"`
+}
+
+// FallbackSetPayload contains the new fallback output chain
+type FallbackSetPayload struct {
+	Chain []audio.Output `json:"chain"`
+}
+
+// connectionsChanged records the audio devices connected and reports whether
+// a device connected or disconnected since the last change
+func (s *Server) connectionsChanged(devices []*bluetooth.Device) bool {
+	connected := make(map[string]bool)
+	for _, device := range devices {
+		if device.Connected && isAudioOutput(device) {
+			connected[strings.ToUpper(device.Address)] = true
+		}
+	}
+
+	s.connectedMu.Lock()
+	defer s.connectedMu.Unlock()
+	changed := len(connected) != len(s.connected)
+	for address := range connected {
+		if !s.connected[address] {
+			changed = true
+		}
+	}
+	s.connected = connected
+	return changed
+}
+
+// isConnected reports whether a Bluetooth device is connected
+func (s *Server) isConnected(address string) bool {
+	for _, device := range s.adapter.GetPairedDevices() {
+		if strings.EqualFold(device.Address, address) {
+			return device.Connected
+		}
+	}
+	return false
+}
+
+// applyFallbackChain routes the audio to the first output of the fallback
+// chain that can play, and moves Snapclient to it when the route changes. It
+// returns the instances it moved to the new route, restarted or left stopped.
+func (s *Server) applyFallbackChain() map[string]bool {
+	s.fallbackMu.Lock()
+	defer s.fallbackMu.Unlock()
+
+	output, ok := s.audioMgr.SelectOutput(s.isConnected)
+	if !ok || s.audioMgr.IsCurrentOutput(output) {
+		return nil
+	}
+
+	log.Printf("Routing audio to %s, the first available output of the fallback chain", output)
+	var err error
+	if output.Type == audio.OutputBluetooth {
+		err = s.routeAudio(output.Address)
+	} else {
+		err = s.audioMgr.SetDefaultOutput(output)
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to route audio to %s: %v", output, err)
+		return nil
+	}
+
+	s.broadcastStatus(fmt.Sprintf("Audio output switched to %s", output), s.adapter.IsScanning())
+	s.broadcastAlsaConfig()
+	s.broadcastFallback()
+
+	if !s.snapclientMgr.IsEnabled() {
+		return nil
+	}
+	return s.followDefaultRoute(output)
+}
+
+// followDefaultRoute restarts the instances playing through the default
+// output so that they play to output, which it was switched to. Instances
+// playing to bluealsa are moved to the default PCM first, as bluealsa cannot
+// play to a soundcard, and back to their device when it is the output again.
+// Stopped instances only get the new setting and stay stopped. It returns the
+// instances it handled, restarted or not.
+func (s *Server) followDefaultRoute(output audio.Output) map[string]bool {
+	instances, err := s.snapclientMgr.ListInstances()
+	if err != nil {
+		log.Printf("Warning: Failed to list Snapclient instances: %v", err)
+		return nil
+	}
+
+	configs := make(map[string]snapcast.Config)
+	soundcards := make(map[string]string)
+	for _, instance := range instances {
+		config, err := s.snapclientMgr.GetConfig(instance)
+		if err != nil || config.Player != "alsa" {
+			continue
+		}
+		configs[instance] = config
+		soundcards[instance] = config.Soundcard
+	}
+
+	restarted := make(map[string]bool)
+	changes := s.audioMgr.Reroutes().Plan(output, soundcards)
+	for _, instance := range instances {
+		config, ok := configs[instance]
+		if !ok {
+			continue
+		}
+		if soundcard, ok := changes[instance]; ok {
+			config.Soundcard = soundcard
+			if err := s.snapclientMgr.SetConfig(instance, config); err != nil {
+				log.Printf("Warning: Failed to move Snapclient instance %q to %s: %v", instance, soundcard, err)
+				continue
+			}
+			log.Printf("Moved Snapclient instance %q from %s to %s", instance, soundcards[instance], soundcard)
+		} else if soundcard := strings.ToLower(config.Soundcard); soundcard != "default" && soundcard != "" {
+			continue
+		}
+
+		if !s.snapclientMgr.IsUserServiceEnabled(instance) {
+			continue
+		}
+		if err := s.snapclientMgr.TryRestartService(instance); err != nil {
+			log.Printf("Warning: Failed to restart Snapclient instance %q: %v", instance, err)
+			continue
+		}
+		restarted[instance] = true
+		s.broadcastSnapclientStatus(instance)
+	}
+	return restarted
+}
+
+// fallbackPayload returns the fallback chain with the output routed to
+func (s *Server) fallbackPayload() FallbackPayload {
+	payload := FallbackPayload{
+		Chain: s.audioMgr.FallbackChain(),
+		Cards: s.audioMgr.Soundcards(),
+	}
+	if output, ok := s.audioMgr.CurrentOutput(); ok {
+		payload.Active = &output
+	}
+	return payload
+}
+
+func (s *Server) sendFallback(c *client) {
+	payloadBytes, err := json.Marshal(s.fallbackPayload())
+	if err != nil {
+		log.Printf("Error marshaling fallback payload: %v", err)
+		return
+	}
+	msgBytes, err := json.Marshal(Message{Type: MsgTypeAlsaFallback, Payload: payloadBytes})
+	if err != nil {
+		log.Printf("Error marshaling fallback message: %v", err)
+		return
+	}
+	c.mu.Lock()
+	c.conn.WriteMessage(websocket.TextMessage, msgBytes)
+	c.mu.Unlock()
+}
+
+func (s *Server) broadcastFallback() {
+	s.broadcastPayload(MsgTypeAlsaFallback, s.fallbackPayload())
+}
+
+// setFallbackChain saves a new chain and routes to its first available output
+func (s *Server) setFallbackChain(op *operation, chain []audio.Output) {
+	if err := s.audioMgr.SetFallbackChain(chain); err != nil {
+		op.fail("Failed to set the fallback chain", err)
+		return
+	}
+	if len(chain) == 0 {
+		op.done("Fallback outputs disabled")
+	} else {
+		op.done(fmt.Sprintf("Fallback chain set to %d outputs", len(chain)))
+	}
+	s.broadcastFallback()
+	s.applyFallbackChain()
+}
+
+// hasFallbackChain reports whether the fallback chain decides the output
+func (s *Server) hasFallbackChain() bool {
+	return len(s.audioMgr.FallbackChain()) > 0
+}
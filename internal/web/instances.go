@@ -0,0 +1,94 @@
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/Ilshidur/bluepicast/internal/snapcast"
+	"github.com/gorilla/websocket"
+)
+
+// SnapclientInstancePayload selects a snapclient instance. An empty
+// instance is the default snapclient unit.
+type SnapclientInstancePayload struct {
+	Instance string `json:"instance"`
+}
+
+// SnapclientInstancesPayload lists the snapclient instances
+type SnapclientInstancesPayload struct {
+	Instances []string `json:"instances"`
+}
+
+// snapclientInstance returns the instance a Snapclient message is about.
+// Messages without a payload go to the default instance.
+func snapclientInstance(msg *Message) string {
+	var payload SnapclientInstancePayload
+	if len(msg.Payload) > 0 {
+		json.Unmarshal(msg.Payload, &payload)
+	}
+	return payload.Instance
+}
+
+func (s *Server) snapclientInstancesPayload() SnapclientInstancesPayload {
+	instances, err := s.snapclientMgr.ListInstances()
+	if err != nil {
+		log.Printf("Error listing Snapclient instances: %v", err)
+	}
+	return SnapclientInstancesPayload{Instances: instances}
+}
+
+// sendSnapclientInstances sends the instance list to a client
+func (s *Server) sendSnapclientInstances(c *client) {
+	payloadBytes, err := json.Marshal(s.snapclientInstancesPayload())
+	if err != nil {
+		log.Printf("Error marshaling Snapclient instances: %v", err)
+		return
+	}
+	msg := Message{
+		Type:    MsgTypeSnapclientInstances,
+		Payload: payloadBytes,
+	}
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling Snapclient instances message: %v", err)
+		return
+	}
+	c.mu.Lock()
+	c.conn.WriteMessage(websocket.TextMessage, msgBytes)
+	c.mu.Unlock()
+}
+
+func (s *Server) broadcastSnapclientInstances() {
+	s.broadcastPayload(MsgTypeSnapclientInstances, s.snapclientInstancesPayload())
+}
+
+// restartSnapclientInstances restarts the instances that play through a
+// Bluetooth device after one connects. The default instance is always
+// restarted, as it was before instances existed. Instances in restarted, just
+// handled by the fallback chain, are skipped.
+func (s *Server) restartSnapclientInstances(restarted map[string]bool) {
+	instances, err := s.snapclientMgr.ListInstances()
+	if err != nil {
+		log.Printf("Warning: Failed to list Snapclient instances: %v", err)
+		return
+	}
+
+	for _, instance := range instances {
+		if restarted[instance] {
+			continue
+		}
+		if instance != snapcast.DefaultInstance {
+			config, err := s.snapclientMgr.GetConfig(instance)
+			if err != nil || !strings.Contains(strings.ToLower(config.Soundcard), "bluealsa") {
+				continue
+			}
+		}
+		log.Printf("Restarting Snapclient instance %q after device connection...", instance)
+		if err := s.snapclientMgr.RestartService(instance); err != nil {
+			log.Printf("Warning: Failed to restart Snapclient service: %v", err)
+		} else {
+			log.Println("Snapclient service restarted successfully")
+		}
+	}
+}
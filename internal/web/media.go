@@ -0,0 +1,215 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Ilshidur/bluepicast/internal/bluetooth"
+	"github.com/Ilshidur/bluepicast/internal/snapcast"
+	"github.com/gorilla/websocket"
+)
+
+const mediaCommandTimeout = 10 * time.Second
+
+// nowPlayingRefreshDelay is how long Snapserver notifications are coalesced
+// before the track is read again. A stream change sends several at once.
+const nowPlayingRefreshDelay = 200 * time.Millisecond
+
+// errMediaControlDisabled is returned when no Snapserver is configured
+var errMediaControlDisabled = errors.New("media controls are disabled, no Snapserver configured")
+
+// NowPlayingPayload is the track played by the Snapcast group of this client
+type NowPlayingPayload struct {
+	bluetooth.NowPlaying
+	Available bool `json:"available"` // The Snapserver is reachable and knows this client
+	Muted     bool `json:"muted"`
+}
+
+// MediaControlPayload is a playback command sent from the UI
+type MediaControlPayload struct {
+	Command bluetooth.MediaCommand `json:"command"`
+}
+
+// SetSnapserverControl enables media controls through the Snapserver control
+// API. Buttons pressed on speakers and the UI transport controls are mapped
+// to actions on the group of this Snapclient.
+func (s *Server) SetSnapserverControl(control *snapcast.ControlClient) {
+	s.control = control
+	control.SetOnNotification(func(method string) {
+		// Any change on the server may affect our group or its stream
+		s.scheduleNowPlayingRefresh()
+	})
+	s.adapter.SetOnMediaCommand(func(cmd bluetooth.MediaCommand) {
+		ctx, cancel := context.WithTimeout(context.Background(), mediaCommandTimeout)
+		defer cancel()
+		if err := s.runMediaCommand(ctx, cmd); err != nil {
+			log.Printf("Failed to handle media command %s: %v", cmd, err)
+		}
+	})
+}
+
+// snapclientID returns the ID the Snapserver knows this client by: the
+// configured host ID, or else the host name
+func (s *Server) snapclientID() string {
+	if s.snapclientMgr.IsEnabled() {
+		if config, err := s.snapclientMgr.GetConfig(snapcast.DefaultInstance); err == nil && config.InstanceID != "" {
+			return config.InstanceID
+		}
+	}
+	hostname, _ := os.Hostname()
+	return hostname
+}
+
+// runMediaCommand maps a playback command to the Snapserver:
+// play/pause mute the group and next/previous switch its stream
+func (s *Server) runMediaCommand(ctx context.Context, cmd bluetooth.MediaCommand) error {
+	if s.control == nil {
+		return errMediaControlDisabled
+	}
+
+	status, err := s.control.GetStatus(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get Snapserver status: %w", err)
+	}
+	clientID := s.snapclientID()
+	group := status.FindGroup(clientID)
+	if group == nil {
+		return fmt.Errorf("client %q not found on the Snapserver", clientID)
+	}
+
+	switch cmd {
+	case bluetooth.MediaPlay:
+		err = s.control.SetGroupMute(ctx, group.ID, false)
+	case bluetooth.MediaPause, bluetooth.MediaStop:
+		err = s.control.SetGroupMute(ctx, group.ID, true)
+	case bluetooth.MediaPlayPause:
+		err = s.control.SetGroupMute(ctx, group.ID, !group.Muted)
+	case bluetooth.MediaNext, bluetooth.MediaPrevious:
+		step := 1
+		if cmd == bluetooth.MediaPrevious {
+			step = -1
+		}
+		streamID := adjacentStream(status.Streams, group.StreamID, step)
+		if streamID == "" || streamID == group.StreamID {
+			return nil // Nothing to switch to
+		}
+		log.Printf("Switching Snapcast group %s to stream %s", group.ID, streamID)
+		err = s.control.SetGroupStream(ctx, group.ID, streamID)
+	default:
+		return fmt.Errorf("unknown media command %q", cmd)
+	}
+	if err != nil {
+		return err
+	}
+
+	s.scheduleNowPlayingRefresh()
+	return nil
+}
+
+// adjacentStream returns the stream step positions away from current,
+// wrapping around the list
+func adjacentStream(streams []snapcast.Stream, current string, step int) string {
+	if len(streams) == 0 {
+		return ""
+	}
+	index := 0
+	for i, stream := range streams {
+		if stream.ID == current {
+			index = i
+			break
+		}
+	}
+	index = ((index+step)%len(streams) + len(streams)) % len(streams)
+	return streams[index].ID
+}
+
+// scheduleNowPlayingRefresh queues a refresh of the track. Requests arriving
+// within nowPlayingRefreshDelay share a single Snapserver status request.
+func (s *Server) scheduleNowPlayingRefresh() {
+	s.nowPlayingMu.Lock()
+	defer s.nowPlayingMu.Unlock()
+
+	if s.nowPlayingDue {
+		return
+	}
+	s.nowPlayingDue = true
+	time.AfterFunc(nowPlayingRefreshDelay, s.refreshNowPlaying)
+}
+
+// refreshNowPlaying reads the track of our group from the Snapserver, shows
+// it on connected speakers and broadcasts it to the UI
+func (s *Server) refreshNowPlaying() {
+	if s.control == nil {
+		return
+	}
+
+	s.nowPlayingRead.Lock()
+	defer s.nowPlayingRead.Unlock()
+
+	// Changes from now on need another refresh
+	s.nowPlayingMu.Lock()
+	s.nowPlayingDue = false
+	s.nowPlayingMu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), mediaCommandTimeout)
+	defer cancel()
+
+	payload := NowPlayingPayload{}
+	status, err := s.control.GetStatus(ctx)
+	if err == nil {
+		if group := status.FindGroup(s.snapclientID()); group != nil {
+			payload.Available = true
+			payload.Muted = group.Muted
+			payload.Stream = group.StreamID
+			if stream := status.FindStream(group.StreamID); stream != nil {
+				metadata := stream.Properties.Metadata
+				payload.Title = metadata.Title
+				payload.Artist = strings.Join(metadata.Artist, ", ")
+				payload.Album = metadata.Album
+				payload.Duration = metadata.Duration
+				payload.Playing = stream.Status == "playing" && !group.Muted
+			}
+		}
+	}
+
+	s.nowPlayingMu.Lock()
+	changed := payload != s.nowPlaying
+	s.nowPlaying = payload
+	s.nowPlayingMu.Unlock()
+
+	if changed {
+		s.adapter.SetNowPlaying(payload.NowPlaying)
+		s.broadcastPayload(MsgTypeNowPlaying, payload)
+	}
+}
+
+// sendNowPlaying sends the last known track to a client
+func (s *Server) sendNowPlaying(c *client) {
+	s.nowPlayingMu.Lock()
+	payload := s.nowPlaying
+	s.nowPlayingMu.Unlock()
+
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error marshaling now playing payload: %v", err)
+		return
+	}
+	msg := Message{
+		Type:    MsgTypeNowPlaying,
+		Payload: payloadBytes,
+	}
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling now playing message: %v", err)
+		return
+	}
+	c.mu.Lock()
+	c.conn.WriteMessage(websocket.TextMessage, msgBytes)
+	c.mu.Unlock()
+}
@@ -0,0 +1,85 @@
+package web
+
+import (
+	"encoding/json"
+	"log"
+
+	"github.com/Ilshidur/bluepicast/internal/audio"
+	"github.com/Ilshidur/bluepicast/internal/snapcast"
+	"github.com/gorilla/websocket"
+)
+
+// silenceQueueSize is how many silence alerts can wait for a slow client
+// before the meter waits too
+const silenceQueueSize = 16
+
+// SetLevelMeter streams the output levels measured by a meter to the UI, with
+// its silence alerts. They are broadcast from their own goroutine, so that a
+// slow client skips levels instead of stalling the capture.
+func (s *Server) SetLevelMeter(meter *audio.Meter) {
+	s.meter = meter
+	s.levelsReady = make(chan struct{}, 1)
+	s.silences = make(chan audio.SilenceAlert, silenceQueueSize)
+	meter.SetOnLevels(func(levels audio.Levels) {
+		// Only the latest levels are kept
+		s.levelsMu.Lock()
+		s.levels = levels
+		s.levelsMu.Unlock()
+		select {
+		case s.levelsReady <- struct{}{}:
+		default:
+		}
+	})
+	meter.SetOnSilence(func(alert audio.SilenceAlert) {
+		s.silences <- alert
+	})
+	go s.broadcastMeter()
+}
+
+// broadcastMeter sends the levels and silence alerts of the meter to every client
+func (s *Server) broadcastMeter() {
+	for {
+		select {
+		case <-s.levelsReady:
+			s.levelsMu.Lock()
+			levels := s.levels
+			s.levelsMu.Unlock()
+			s.broadcastPayload(MsgTypeAudioLevels, levels)
+		case alert := <-s.silences:
+			s.broadcastPayload(MsgTypeAudioSilence, alert)
+		}
+	}
+}
+
+// OutputPlaying reports whether the output should be making sound: the
+// Snapserver stream of our group is playing and the group is not muted.
+// Without the Snapserver control API, only snapclient running is known.
+func (s *Server) OutputPlaying() bool {
+	if s.control == nil {
+		return s.snapclientMgr.IsRunning(snapcast.DefaultInstance)
+	}
+	s.nowPlayingMu.Lock()
+	defer s.nowPlayingMu.Unlock()
+	return s.nowPlaying.Playing
+}
+
+// sendSilence tells a client connecting during a silence alert about it
+func (s *Server) sendSilence(c *client) {
+	alert, ok := s.meter.Silence()
+	if !ok {
+		return
+	}
+	payloadBytes, err := json.Marshal(alert)
+	if err != nil {
+		log.Printf("Error marshaling silence payload: %v", err)
+		return
+	}
+	msgBytes, err := json.Marshal(Message{Type: MsgTypeAudioSilence, Payload: payloadBytes})
+	if err != nil {
+		log.Printf("Error marshaling silence message: %v", err)
+		return
+	}
+	c.mu.Lock()
+	c.conn.WriteMessage(websocket.TextMessage, msgBytes)
+	c.mu.Unlock()
+}
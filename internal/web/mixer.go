@@ -0,0 +1,80 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/Ilshidur/bluepicast/internal/snapcast"
+	"github.com/gorilla/websocket"
+)
+
+// MixerControlsPayload lists the mixer controls of the soundcard of an instance
+type MixerControlsPayload struct {
+	Instance  string                  `json:"instance"`
+	Soundcard string                  `json:"soundcard"`
+	Controls  []snapcast.MixerControl `json:"controls"`
+	Chosen    string                  `json:"chosen"` // Empty when the control is picked automatically
+}
+
+// MixerControlPayload chooses the mixer control of the soundcard of an instance
+type MixerControlPayload struct {
+	Instance string `json:"instance"`
+	Control  string `json:"control"` // Empty to pick it automatically
+}
+
+// MutePayload mutes or unmutes the soundcard of an instance
+type MutePayload struct {
+	Instance string `json:"instance"`
+	Muted    bool   `json:"muted"`
+}
+
+// alsaSoundcard returns the soundcard an instance plays to, if its mixer can
+// be controlled
+func (s *Server) alsaSoundcard(instance string) (string, error) {
+	config, err := s.snapclientMgr.GetConfig(instance)
+	if err != nil {
+		return "", fmt.Errorf("failed to get Snapclient config: %w", err)
+	}
+	if config.Player != "alsa" {
+		return "", fmt.Errorf("mixer controls are only available when player is 'alsa'")
+	}
+	return config.Soundcard, nil
+}
+
+// sendMixerControls sends the mixer controls of the soundcard of an instance
+func (s *Server) sendMixerControls(c *client, instance string) {
+	soundcard, err := s.alsaSoundcard(instance)
+	if err != nil {
+		s.sendError(c, err.Error())
+		return
+	}
+	controls, err := s.snapclientMgr.ListMixerControls(soundcard)
+	if err != nil {
+		s.sendError(c, fmt.Sprintf("Failed to list mixer controls: %v", err))
+		return
+	}
+
+	payloadBytes, err := json.Marshal(MixerControlsPayload{
+		Instance:  instance,
+		Soundcard: soundcard,
+		Controls:  controls,
+		Chosen:    s.snapclientMgr.ChosenMixerControl(soundcard),
+	})
+	if err != nil {
+		log.Printf("Error marshaling mixer controls: %v", err)
+		return
+	}
+	msg := Message{
+		Type:    MsgTypeSnapclientMixerControls,
+		Payload: payloadBytes,
+	}
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling mixer controls message: %v", err)
+		return
+	}
+	c.mu.Lock()
+	c.conn.WriteMessage(websocket.TextMessage, msgBytes)
+	c.mu.Unlock()
+}
@@ -0,0 +1,162 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/Ilshidur/bluepicast/internal/bluetooth"
+	"github.com/gorilla/websocket"
+)
+
+// Reply states of a client command
+const (
+	replyPending  = "pending"
+	replyProgress = "progress"
+	replyDone     = "done"
+	replyFailed   = "failed"
+)
+
+// ReplyPayload reports the state of a client command, identified by the ID
+// of the message that started it
+type ReplyPayload struct {
+	ID      string `json:"id"`
+	State   string `json:"state"`
+	Message string `json:"message,omitempty"`
+	Code    string `json:"code,omitempty"` // Error code, set when State is "failed"
+}
+
+// CancelPayload identifies the command to cancel
+type CancelPayload struct {
+	ID string `json:"id"`
+}
+
+// operation tracks a long-running client command.
+// Commands sent without an ID get no replies: failures are reported
+// with a plain error message as before.
+type operation struct {
+	s      *Server
+	c      *client
+	id     string
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// startOperation registers the command carried by msg and replies "pending"
+func (s *Server) startOperation(c *client, msg *Message) *operation {
+	ctx, cancel := context.WithCancel(context.Background())
+	op := &operation{
+		s:      s,
+		c:      c,
+		id:     msg.ID,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+
+	if op.id != "" {
+		c.opsMu.Lock()
+		if c.ops == nil {
+			c.ops = make(map[string]*operation)
+		}
+		c.ops[op.id] = op
+		c.opsMu.Unlock()
+	}
+
+	op.reply(replyPending, "", "")
+	return op
+}
+
+// progress reports an intermediate step of the command
+func (op *operation) progress(message string) {
+	op.reply(replyProgress, message, "")
+}
+
+// done reports that the command succeeded
+func (op *operation) done(message string) {
+	op.finish()
+	op.reply(replyDone, message, "")
+}
+
+// fail reports that the command failed. message describes what was attempted.
+func (op *operation) fail(message string, err error) {
+	op.finish()
+	text := fmt.Sprintf("%s: %v", message, err)
+	if op.id == "" {
+		op.s.sendError(op.c, text)
+		return
+	}
+	op.reply(replyFailed, text, bluetooth.ErrorCode(err))
+}
+
+func (op *operation) finish() {
+	op.cancel()
+	if op.id == "" {
+		return
+	}
+	op.c.opsMu.Lock()
+	if op.c.ops[op.id] == op {
+		delete(op.c.ops, op.id)
+	}
+	op.c.opsMu.Unlock()
+}
+
+func (op *operation) reply(state, message, code string) {
+	if op.id == "" {
+		return
+	}
+	op.s.sendReply(op.c, ReplyPayload{
+		ID:      op.id,
+		State:   state,
+		Message: message,
+		Code:    code,
+	})
+}
+
+// cancelOperation cancels a command of the client. The command itself
+// replies "failed" with the "canceled" code once it has stopped.
+func (s *Server) cancelOperation(c *client, id string) bool {
+	c.opsMu.Lock()
+	op, ok := c.ops[id]
+	c.opsMu.Unlock()
+	if !ok {
+		return false
+	}
+	op.cancel()
+	return true
+}
+
+// rejectMessage reports a command that could not be started, e.g. because
+// its payload is invalid
+func (s *Server) rejectMessage(c *client, msg *Message, errMsg string) {
+	if msg.ID == "" {
+		s.sendError(c, errMsg)
+		return
+	}
+	s.sendReply(c, ReplyPayload{
+		ID:      msg.ID,
+		State:   replyFailed,
+		Message: errMsg,
+		Code:    bluetooth.CodeInvalidArguments,
+	})
+}
+
+func (s *Server) sendReply(c *client, reply ReplyPayload) {
+	payloadBytes, err := json.Marshal(reply)
+	if err != nil {
+		log.Printf("Error marshaling reply payload: %v", err)
+		return
+	}
+	msg := Message{
+		Type:    MsgTypeReply,
+		Payload: payloadBytes,
+	}
+	msgBytes, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error marshaling reply message: %v", err)
+		return
+	}
+	c.mu.Lock()
+	c.conn.WriteMessage(websocket.TextMessage, msgBytes)
+	c.mu.Unlock()
+}
@@ -0,0 +1,137 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/Ilshidur/bluepicast/internal/schedule"
+	"github.com/Ilshidur/bluepicast/internal/snapcast"
+	"github.com/gorilla/websocket"
+)
+
+// errSchedulerDisabled is returned when no scheduler is set
+var errSchedulerDisabled = errors.New("scheduled actions are disabled")
+
+// ScheduleInfo is a schedule with the time it runs next
+type ScheduleInfo struct {
+	schedule.Schedule
+	NextRun *time.Time `json:"nextRun,omitempty"` // Unset for disabled schedules
+}
+
+// SchedulesPayload contains the schedules and the log of their runs
+type SchedulesPayload struct {
+	Schedules []ScheduleInfo `json:"schedules"`
+	Runs      []schedule.Run `json:"runs"`
+}
+
+// ScheduleIDPayload identifies a schedule to remove or run
+type ScheduleIDPayload struct {
+	ID string `json:"id"`
+}
+
+// SetScheduler runs the actions of scheduled tasks and lets the UI manage them
+func (s *Server) SetScheduler(scheduler *schedule.Scheduler) {
+	s.scheduler = scheduler
+	scheduler.SetExecutor(s.runScheduledAction)
+	scheduler.SetOnChange(s.broadcastSchedules)
+}
+
+// runScheduledAction runs the action of a schedule with the operations the UI uses
+func (s *Server) runScheduledAction(ctx context.Context, sch schedule.Schedule) error {
+	instance := sch.Instance
+	if instance == "" {
+		instance = snapcast.DefaultInstance
+	}
+
+	switch sch.Action {
+	case schedule.ActionConnect:
+		if err := s.adapter.Connect(ctx, sch.Address); err != nil {
+			return err
+		}
+		s.handleDeviceConnected(sch.Address)
+		s.broadcastStatus(fmt.Sprintf("Connected to %s", sch.Address), s.adapter.IsScanning())
+	case schedule.ActionDisconnect:
+		if err := s.adapter.Disconnect(ctx, sch.Address); err != nil {
+			return err
+		}
+		s.broadcastStatus(fmt.Sprintf("Disconnected from %s", sch.Address), s.adapter.IsScanning())
+	case schedule.ActionVolume:
+		soundcard, err := s.alsaSoundcard(instance)
+		if err != nil {
+			return err
+		}
+		if err := rampVolume(ctx, s.alsaVolume(soundcard), sch.Volume, time.Duration(sch.Ramp)*time.Second); err != nil {
+			return err
+		}
+		s.broadcastSnapclientStatus(instance)
+	case schedule.ActionSnapclientStart:
+		if err := s.snapclientMgr.StartService(instance); err != nil {
+			return err
+		}
+		s.broadcastSnapclientStatus(instance)
+	case schedule.ActionSnapclientStop:
+		if err := s.snapclientMgr.StopService(instance); err != nil {
+			return err
+		}
+		s.broadcastSnapclientStatus(instance)
+	default:
+		return fmt.Errorf("unknown action %q", sch.Action)
+	}
+	return nil
+}
+
+// schedulesPayload returns the schedules with their next run and the run log
+func (s *Server) schedulesPayload() SchedulesPayload {
+	now := time.Now()
+	payload := SchedulesPayload{
+		Schedules: []ScheduleInfo{},
+		Runs:      s.scheduler.Runs(),
+	}
+	for _, sch := range s.scheduler.Schedules() {
+		info := ScheduleInfo{Schedule: sch}
+		if next := schedule.NextRun(sch, now); !next.IsZero() {
+			info.NextRun = &next
+		}
+		payload.Schedules = append(payload.Schedules, info)
+	}
+	return payload
+}
+
+func (s *Server) sendSchedules(c *client) {
+	payloadBytes, err := json.Marshal(s.schedulesPayload())
+	if err != nil {
+		log.Printf("Error marshaling schedules payload: %v", err)
+		return
+	}
+	msgBytes, err := json.Marshal(Message{Type: MsgTypeSchedules, Payload: payloadBytes})
+	if err != nil {
+		log.Printf("Error marshaling schedules message: %v", err)
+		return
+	}
+	c.mu.Lock()
+	c.conn.WriteMessage(websocket.TextMessage, msgBytes)
+	c.mu.Unlock()
+}
+
+func (s *Server) broadcastSchedules() {
+	s.broadcastPayload(MsgTypeSchedules, s.schedulesPayload())
+}
+
+// runScheduleNow runs a schedule from the UI, to try it
+func (s *Server) runScheduleNow(op *operation, id string) {
+	sch, ok := s.scheduler.Find(id)
+	if !ok {
+		op.fail("Failed to run schedule", fmt.Errorf("schedule %q not found", id))
+		return
+	}
+	op.progress(fmt.Sprintf("Running %s...", sch.Name))
+	if err := s.scheduler.RunNow(op.ctx, sch); err != nil {
+		op.fail(fmt.Sprintf("Schedule %s failed", sch.Name), err)
+		return
+	}
+	op.done(fmt.Sprintf("Schedule %s ran", sch.Name))
+}
@@ -9,6 +9,7 @@ import (
 	"io/fs"
 	"log"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,6 +17,8 @@ import (
 
 	"github.com/Ilshidur/bluepicast/internal/audio"
 	"github.com/Ilshidur/bluepicast/internal/bluetooth"
+	"github.com/Ilshidur/bluepicast/internal/devicemeta"
+	"github.com/Ilshidur/bluepicast/internal/schedule"
 	"github.com/Ilshidur/bluepicast/internal/snapcast"
 )
 
@@ -26,51 +29,107 @@ var staticFiles embed.FS
 type MessageType string
 
 const (
-	MsgTypeDevices                   MessageType = "devices"
-	MsgTypeScan                      MessageType = "scan"
-	MsgTypeStopScan                  MessageType = "stop_scan"
-	MsgTypePair                      MessageType = "pair"
-	MsgTypeConnect                   MessageType = "connect"
-	MsgTypeDisconnect                MessageType = "disconnect"
-	MsgTypeRemove                    MessageType = "remove"
-	MsgTypePairAndConnect            MessageType = "pair_and_connect"
-	MsgTypeError                     MessageType = "error"
-	MsgTypeStatus                    MessageType = "status"
-	MsgTypeAlsaConfig                MessageType = "alsa_config"
-	MsgTypeAlsaGetConfig             MessageType = "alsa_get_config"
-	MsgTypeAlsaSetConfig             MessageType = "alsa_set_config"
-	MsgTypeAlsaSetDevice             MessageType = "alsa_set_device"
-	MsgTypeSnapclientStatus          MessageType = "snapclient_status"
-	MsgTypeSnapclientGetStatus       MessageType = "snapclient_get_status"
-	MsgTypeSnapclientStart           MessageType = "snapclient_start"
-	MsgTypeSnapclientStop            MessageType = "snapclient_stop"
-	MsgTypeSnapclientRestart         MessageType = "snapclient_restart"
-	MsgTypeSnapclientSetConfig       MessageType = "snapclient_set_config"
-	MsgTypeSnapclientPlayers         MessageType = "snapclient_players"
-	MsgTypeSnapclientGetPlayers      MessageType = "snapclient_get_players"
-	MsgTypeSnapclientPCMDevices      MessageType = "snapclient_pcm_devices"
-	MsgTypeSnapclientGetPCM          MessageType = "snapclient_get_pcm"
+	MsgTypeDevices                     MessageType = "devices"
+	MsgTypeScan                        MessageType = "scan"
+	MsgTypeStopScan                    MessageType = "stop_scan"
+	MsgTypePair                        MessageType = "pair"
+	MsgTypeConnect                     MessageType = "connect"
+	MsgTypeDisconnect                  MessageType = "disconnect"
+	MsgTypeRemove                      MessageType = "remove"
+	MsgTypePairAndConnect              MessageType = "pair_and_connect"
+	MsgTypeError                       MessageType = "error"
+	MsgTypeStatus                      MessageType = "status"
+	MsgTypeAlsaConfig                  MessageType = "alsa_config"
+	MsgTypeAlsaGetConfig               MessageType = "alsa_get_config"
+	MsgTypeAlsaSetConfig               MessageType = "alsa_set_config"
+	MsgTypeAlsaSetDevice               MessageType = "alsa_set_device"
+	MsgTypeAlsaFallback                MessageType = "alsa_fallback"
+	MsgTypeAlsaGetFallback             MessageType = "alsa_get_fallback"
+	MsgTypeAlsaSetFallback             MessageType = "alsa_set_fallback"
+	MsgTypeSnapclientStatus            MessageType = "snapclient_status"
+	MsgTypeSnapclientGetStatus         MessageType = "snapclient_get_status"
+	MsgTypeSnapclientStart             MessageType = "snapclient_start"
+	MsgTypeSnapclientStop              MessageType = "snapclient_stop"
+	MsgTypeSnapclientRestart           MessageType = "snapclient_restart"
+	MsgTypeSnapclientSetConfig         MessageType = "snapclient_set_config"
+	MsgTypeSnapclientPlayers           MessageType = "snapclient_players"
+	MsgTypeSnapclientGetPlayers        MessageType = "snapclient_get_players"
+	MsgTypeSnapclientPCMDevices        MessageType = "snapclient_pcm_devices"
+	MsgTypeSnapclientGetPCM            MessageType = "snapclient_get_pcm"
 	MsgTypeSnapclientMigrate           MessageType = "snapclient_migrate"
 	MsgTypeSnapclientMigrationResult   MessageType = "snapclient_migration_result"
 	MsgTypeSnapclientEnableUserService MessageType = "snapclient_enable_user_service"
 	MsgTypeSnapclientEnableResult      MessageType = "snapclient_enable_result"
 	MsgTypeSnapclientSetVolume         MessageType = "snapclient_set_volume"
-	MsgTypeSnapclientGetVolume       MessageType = "snapclient_get_volume"
-	MsgTypeSnapclientStartLogs       MessageType = "snapclient_start_logs"
-	MsgTypeSnapclientStopLogs        MessageType = "snapclient_stop_logs"
-	MsgTypeSnapclientLog             MessageType = "snapclient_log"
+	MsgTypeSnapclientGetVolume         MessageType = "snapclient_get_volume"
+	MsgTypeSnapclientStartLogs         MessageType = "snapclient_start_logs"
+	MsgTypeSnapclientStopLogs          MessageType = "snapclient_stop_logs"
+	MsgTypeSnapclientLog               MessageType = "snapclient_log"
+	MsgTypeSnapclientInstances         MessageType = "snapclient_instances"
+	MsgTypeSnapclientGetInstances      MessageType = "snapclient_get_instances"
+	MsgTypeSnapclientCreateInstance    MessageType = "snapclient_create_instance"
+	MsgTypeSnapclientRemoveInstance    MessageType = "snapclient_remove_instance"
+	MsgTypeSnapclientMixerControls     MessageType = "snapclient_mixer_controls"
+	MsgTypeSnapclientGetMixerControls  MessageType = "snapclient_get_mixer_controls"
+	MsgTypeSnapclientSetMixerControl   MessageType = "snapclient_set_mixer_control"
+	MsgTypeSnapclientSetMute           MessageType = "snapclient_set_mute"
+	MsgTypeSoundcardAdded              MessageType = "soundcard_added"
+	MsgTypeSoundcardRemoved            MessageType = "soundcard_removed"
+	MsgTypeTestTone                    MessageType = "test_tone"
+	MsgTypeAudioLevels                 MessageType = "audio_levels"
+	MsgTypeAudioSilence                MessageType = "audio_silence"
+	MsgTypeSchedules                   MessageType = "schedules"
+	MsgTypeScheduleGet                 MessageType = "schedule_get"
+	MsgTypeScheduleSave                MessageType = "schedule_save"
+	MsgTypeScheduleRemove              MessageType = "schedule_remove"
+	MsgTypeScheduleRun                 MessageType = "schedule_run"
+	MsgTypeSleepTimer                  MessageType = "sleep_timer"
+	MsgTypeSleepTimerSet               MessageType = "sleep_timer_set"
+	MsgTypeSleepTimerCancel            MessageType = "sleep_timer_cancel"
+	MsgTypeAdapter                     MessageType = "adapter"
+	MsgTypeAdapterGet                  MessageType = "adapter_get"
+	MsgTypeAdapterSet                  MessageType = "adapter_set"
+	MsgTypeDeviceSetAlias              MessageType = "device_set_alias"
+	MsgTypeDeviceSetBlocked            MessageType = "device_set_blocked"
+	MsgTypeDeviceSetMetadata           MessageType = "device_set_metadata"
+	MsgTypeDeviceListOptions           MessageType = "device_list_options"
+	MsgTypeDeviceAdded                 MessageType = "device_added"
+	MsgTypeDeviceUpdated               MessageType = "device_updated"
+	MsgTypeDeviceRemoved               MessageType = "device_removed"
+	MsgTypeDevicesResync               MessageType = "devices_resync"
+	MsgTypeReply                       MessageType = "reply"
+	MsgTypeCancel                      MessageType = "cancel"
+	MsgTypeNowPlaying                  MessageType = "now_playing"
+	MsgTypeMediaControl                MessageType = "media_control"
 )
 
-// Message represents a WebSocket message
+// Message represents a WebSocket message.
+// Commands may carry an ID: the server then answers with reply messages
+// carrying the same ID.
 type Message struct {
 	Type    MessageType     `json:"type"`
+	ID      string          `json:"id,omitempty"`
 	Payload json.RawMessage `json:"payload,omitempty"`
 }
 
+// DeviceInfo is a Bluetooth device merged with its bluepicast-side metadata
+type DeviceInfo struct {
+	*bluetooth.Device
+	Metadata *devicemeta.Metadata `json:"metadata,omitempty"`
+}
+
 // DevicesPayload contains the list of discovered devices
 type DevicesPayload struct {
-	Devices  []*bluetooth.Device `json:"devices"`
-	Scanning bool                `json:"scanning"`
+	Seq         uint64       `json:"seq"`
+	Devices     []DeviceInfo `json:"devices"`
+	Scanning    bool         `json:"scanning"`
+	ShowUnnamed bool         `json:"showUnnamed"`
+	HiddenCount int          `json:"hiddenCount"`
+}
+
+// DeviceListOptionsPayload contains the device list display options
+type DeviceListOptionsPayload struct {
+	ShowUnnamed bool `json:"showUnnamed"`
 }
 
 // StatusPayload contains status information
@@ -79,11 +138,69 @@ type StatusPayload struct {
 	Message  string `json:"message,omitempty"`
 }
 
+// DeviceAliasPayload contains the new local name of a device
+type DeviceAliasPayload struct {
+	Address string `json:"address"`
+	Alias   string `json:"alias"`
+}
+
+// DeviceBlockedPayload contains the blocked state of a device
+type DeviceBlockedPayload struct {
+	Address string `json:"address"`
+	Blocked bool   `json:"blocked"`
+}
+
+// DeviceMetadataPayload contains the bluepicast-side metadata of a device
+type DeviceMetadataPayload struct {
+	Address  string              `json:"address"`
+	Metadata devicemeta.Metadata `json:"metadata"`
+}
+
+// ScanPayload contains optional discovery settings for a scan request
+type ScanPayload struct {
+	Transport string `json:"transport,omitempty"` // "auto", "bredr" or "le"
+	MinRSSI   *int16 `json:"minRssi,omitempty"`   // Minimum signal strength in dBm
+	AudioOnly bool   `json:"audioOnly,omitempty"` // Only report devices advertising an audio sink
+	Duration  int    `json:"duration,omitempty"`  // Scan duration in seconds
+}
+
+func (p ScanPayload) filter() bluetooth.DiscoveryFilter {
+	filter := bluetooth.DiscoveryFilter{
+		Transport: p.Transport,
+		RSSI:      p.MinRSSI,
+	}
+	if p.AudioOnly {
+		filter.UUIDs = []string{bluetooth.AudioSinkUUID}
+	}
+	return filter
+}
+
+func (p ScanPayload) duration() time.Duration {
+	d := time.Duration(p.Duration) * time.Second
+	if d <= 0 {
+		return defaultScanDuration
+	}
+	if d > maxScanDuration {
+		return maxScanDuration
+	}
+	return d
+}
+
 // DeviceActionPayload contains a device address for actions
 type DeviceActionPayload struct {
 	Address string `json:"address"`
 }
 
+// AdapterSetPayload contains the adapter properties to change.
+// Fields left out of the payload are not modified.
+type AdapterSetPayload struct {
+	Alias               *string `json:"alias,omitempty"`
+	Powered             *bool   `json:"powered,omitempty"`
+	Discoverable        *bool   `json:"discoverable,omitempty"`
+	DiscoverableTimeout *uint32 `json:"discoverableTimeout,omitempty"`
+	Pairable            *bool   `json:"pairable,omitempty"`
+}
+
 // ErrorPayload contains error information
 type ErrorPayload struct {
 	Message string `json:"message"`
@@ -97,25 +214,48 @@ type AlsaConfig struct {
 
 // VolumePayload contains volume information
 type VolumePayload struct {
-	Volume int `json:"volume"`
+	Volume   int    `json:"volume"`
+	Instance string `json:"instance"`
 }
 
 // SoundcardPayload contains soundcard information for volume queries
 type SoundcardPayload struct {
 	Soundcard string `json:"soundcard"`
+	Instance  string `json:"instance"`
 }
 
 // LogPayload contains a single log line
 type LogPayload struct {
-	Line string `json:"line"`
+	Line     string `json:"line"`
+	Instance string `json:"instance"`
 }
 
+const (
+	defaultScanDuration = 30 * time.Second // Scan duration when the client does not specify one
+	maxScanDuration     = 5 * time.Minute  // Upper bound for client-requested scan durations
+)
+
 // client wraps a websocket connection with a mutex for safe concurrent writes
 type client struct {
 	conn           *websocket.Conn
 	mu             sync.Mutex
-	logStopFunc    func()          // Function to stop log streaming
-	logStopFuncMu  sync.Mutex      // Mutex for log stop function
+	logStopFunc    func()                // Function to stop log streaming
+	logStopFuncMu  sync.Mutex            // Mutex for log stop function
+	scanStopFunc   func()                // Function to end this client's scan session
+	scanStopFuncMu sync.Mutex            // Mutex for scan stop function
+	ops            map[string]*operation // Commands in progress, by message ID
+	opsMu          sync.Mutex
+}
+
+// setScanStopFunc ends the current scan session of the client, if any,
+// and remembers fn as the way to end the next one
+func (c *client) setScanStopFunc(fn func()) {
+	c.scanStopFuncMu.Lock()
+	defer c.scanStopFuncMu.Unlock()
+	if c.scanStopFunc != nil {
+		c.scanStopFunc()
+	}
+	c.scanStopFunc = fn
 }
 
 // Server handles HTTP and WebSocket connections
@@ -123,6 +263,19 @@ type Server struct {
 	adapter         *bluetooth.Adapter
 	audioMgr        *audio.Manager
 	snapclientMgr   *snapcast.Manager
+	metaStore       *devicemeta.Store
+	devSync         *deviceSync
+	control         *snapcast.ControlClient // Snapserver control API, nil when media controls are disabled
+	meter           *audio.Meter            // Output level meter, nil when disabled
+	levels          audio.Levels            // Last levels measured, waiting to be broadcast
+	levelsMu        sync.Mutex
+	levelsReady     chan struct{}           // Signals new levels to broadcastMeter
+	silences        chan audio.SilenceAlert // Silence alerts waiting to be broadcast
+	scheduler       *schedule.Scheduler     // Scheduled actions, nil when disabled
+	nowPlaying      NowPlayingPayload
+	nowPlayingMu    sync.Mutex
+	nowPlayingDue   bool       // A refresh of the track is scheduled
+	nowPlayingRead  sync.Mutex // Serializes refreshes, so that an older status never wins
 	upgrader        websocket.Upgrader
 	clients         map[*client]bool
 	clientsMu       sync.RWMutex
@@ -130,14 +283,21 @@ type Server struct {
 	tlsConfig       *tls.Config
 	alsaAutoRoute   bool
 	alsaAutoRouteMu sync.RWMutex
+	toneMu          sync.Mutex      // Held while a test tone plays
+	fallbackMu      sync.Mutex      // Held while the fallback chain switches output
+	connected       map[string]bool // Audio devices connected at the last change, by address
+	connectedMu     sync.Mutex
+	sleep           *sleepTimer
 }
 
 // NewServer creates a new web server
-func NewServer(adapter *bluetooth.Adapter, audioMgr *audio.Manager, snapclientMgr *snapcast.Manager, port int, tlsConfig *tls.Config) *Server {
+func NewServer(adapter *bluetooth.Adapter, audioMgr *audio.Manager, snapclientMgr *snapcast.Manager, metaStore *devicemeta.Store, port int, tlsConfig *tls.Config) *Server {
 	s := &Server{
 		adapter:       adapter,
 		audioMgr:      audioMgr,
 		snapclientMgr: snapclientMgr,
+		metaStore:     metaStore,
+		devSync:       newDeviceSync(),
 		alsaAutoRoute: true, // Enable automatic ALSA routing by default
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
@@ -160,8 +320,14 @@ func NewServer(adapter *bluetooth.Adapter, audioMgr *audio.Manager, snapclientMg
 		tlsConfig: tlsConfig,
 	}
 
+	s.sleep = newSleepTimer(s.sleepVolume, s.sleepAction, s.broadcastSleepTimer, s.sleepTimerEnded)
+
 	// Set up callback for device changes
-	adapter.SetOnChange(s.broadcastDevices)
+	adapter.SetOnChange(s.handleDevicesChange)
+	adapter.SetOnAdapterChange(s.broadcastAdapter)
+	adapter.SetOnScanChange(s.handleScanChange)
+	snapclientMgr.SetOnStatusChange(s.broadcastSnapclientStatus)
+	snapclientMgr.SetOnSoundcardChange(s.handleSoundcardChange)
 
 	return s
 }
@@ -180,6 +346,9 @@ func (s *Server) Start(ctx context.Context) error {
 	// WebSocket endpoint
 	mux.HandleFunc("/ws", s.handleWebSocket)
 
+	// REST endpoint for the local Bluetooth adapter
+	mux.HandleFunc("/api/adapter", s.handleAdapterAPI)
+
 	// Health check endpoint
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -192,6 +361,13 @@ func (s *Server) Start(ctx context.Context) error {
 		TLSConfig: s.tlsConfig,
 	}
 
+	// Connect devices flagged for auto-connect, then play to the first
+	// available output of the fallback chain
+	go func() {
+		s.autoConnectDevices()
+		s.applyFallbackChain()
+	}()
+
 	go func() {
 		<-ctx.Done()
 		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -231,6 +407,9 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		}
 		c.logStopFuncMu.Unlock()
 
+		// End this client's scan session so discovery does not outlive the browser
+		c.setScanStopFunc(nil)
+
 		s.clientsMu.Lock()
 		delete(s.clients, c)
 		s.clientsMu.Unlock()
@@ -239,12 +418,35 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	// Send initial device list
 	s.sendDevices(c)
 
+	// Send local adapter state
+	s.sendAdapter(c)
+
 	// Send ALSA configuration
 	s.sendAlsaConfig(c)
+	s.sendFallback(c)
 
 	// Send Snapclient status if enabled
 	if s.snapclientMgr.IsEnabled() {
-		s.sendSnapclientStatus(c)
+		s.sendSnapclientInstances(c)
+		s.sendSnapclientStatus(c, snapcast.DefaultInstance)
+	}
+
+	// Send the current track if media controls are enabled
+	if s.control != nil {
+		s.sendNowPlaying(c)
+	}
+
+	// Send the sleep timer countdown
+	s.sendSleepTimer(c)
+
+	// Send the scheduled actions
+	if s.scheduler != nil {
+		s.sendSchedules(c)
+	}
+
+	// Send a silence alert in progress if the level meter is enabled
+	if s.meter != nil {
+		s.sendSilence(c)
 	}
 
 	// Handle incoming messages
@@ -270,55 +472,68 @@ func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleMessage(c *client, msg *Message) {
 	switch msg.Type {
 	case MsgTypeScan:
+		var payload ScanPayload
+		if len(msg.Payload) > 0 {
+			if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+				s.rejectMessage(c, msg, "Invalid scan payload")
+				return
+			}
+		}
 		log.Println("Received scan request")
+		op := s.startOperation(c, msg)
+
+		// The scan session belongs to this client: it ends on timeout,
+		// on stop_scan, or when the client disconnects. The stop function is
+		// registered before the scan starts so that a stop_scan right after
+		// this message is not lost.
+		ctx, cancel := context.WithTimeout(context.Background(), payload.duration())
+		c.setScanStopFunc(cancel)
 		go func() {
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer cancel()
-
-			if err := s.adapter.StartDiscovery(ctx); err != nil {
-				s.sendError(c, fmt.Sprintf("Failed to start scan: %v", err))
+			if err := s.adapter.StartDiscovery(ctx, payload.filter()); err != nil {
+				cancel()
+				op.fail("Failed to start scan", err)
 				return
 			}
-			s.broadcastStatus("Scanning for devices...", true)
+			op.done("Scanning for devices...")
 		}()
 
 	case MsgTypeStopScan:
 		log.Println("Received stop scan request")
-		if err := s.adapter.StopDiscovery(); err != nil {
-			s.sendError(c, fmt.Sprintf("Failed to stop scan: %v", err))
-			return
-		}
-		s.broadcastStatus("Scan stopped", false)
-		// Also broadcast devices to ensure UI reflects the scanning=false state
-		s.broadcastDevices(s.adapter.GetDevices())
+		op := s.startOperation(c, msg)
+		c.setScanStopFunc(nil)
+		op.done("Scan stopped")
 
 	case MsgTypePair:
 		var payload DeviceActionPayload
 		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-			s.sendError(c, "Invalid payload")
+			s.rejectMessage(c, msg, "Invalid payload")
 			return
 		}
 		log.Printf("Received pair request for: %s", payload.Address)
+		op := s.startOperation(c, msg)
 		go func() {
-			if err := s.adapter.Pair(payload.Address); err != nil {
-				s.sendError(c, fmt.Sprintf("Failed to pair: %v", err))
+			if err := s.adapter.Pair(op.ctx, payload.Address); err != nil {
+				op.fail("Failed to pair", err)
 				return
 			}
+			op.done(fmt.Sprintf("Paired with %s", payload.Address))
 			s.broadcastStatus(fmt.Sprintf("Paired with %s", payload.Address), s.adapter.IsScanning())
 		}()
 
 	case MsgTypeConnect:
 		var payload DeviceActionPayload
 		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-			s.sendError(c, "Invalid payload")
+			s.rejectMessage(c, msg, "Invalid payload")
 			return
 		}
 		log.Printf("Received connect request for: %s", payload.Address)
+		op := s.startOperation(c, msg)
 		go func() {
-			if err := s.adapter.Connect(payload.Address); err != nil {
-				s.sendError(c, fmt.Sprintf("Failed to connect: %v", err))
+			if err := s.adapter.Connect(op.ctx, payload.Address); err != nil {
+				op.fail("Failed to connect", err)
 				return
 			}
+			op.done(fmt.Sprintf("Connected to %s", payload.Address))
 			s.broadcastStatus(fmt.Sprintf("Connected to %s", payload.Address), s.adapter.IsScanning())
 
 			// Handle auto-routing and Snapclient restart
@@ -328,23 +543,27 @@ func (s *Server) handleMessage(c *client, msg *Message) {
 	case MsgTypePairAndConnect:
 		var payload DeviceActionPayload
 		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-			s.sendError(c, "Invalid payload")
+			s.rejectMessage(c, msg, "Invalid payload")
 			return
 		}
 		log.Printf("Received pair and connect request for: %s", payload.Address)
+		op := s.startOperation(c, msg)
 		go func() {
 			// First pair with the device
-			if err := s.adapter.Pair(payload.Address); err != nil {
-				s.sendError(c, fmt.Sprintf("Failed to pair: %v", err))
+			op.progress(fmt.Sprintf("Pairing with %s...", payload.Address))
+			if err := s.adapter.Pair(op.ctx, payload.Address); err != nil {
+				op.fail("Failed to pair", err)
 				return
 			}
 			s.broadcastStatus(fmt.Sprintf("Paired with %s", payload.Address), s.adapter.IsScanning())
 
 			// Then connect to the device
-			if err := s.adapter.Connect(payload.Address); err != nil {
-				s.sendError(c, fmt.Sprintf("Failed to connect after pairing: %v", err))
+			op.progress(fmt.Sprintf("Connecting to %s...", payload.Address))
+			if err := s.adapter.Connect(op.ctx, payload.Address); err != nil {
+				op.fail("Failed to connect after pairing", err)
 				return
 			}
+			op.done(fmt.Sprintf("Connected to %s", payload.Address))
 			s.broadcastStatus(fmt.Sprintf("Connected to %s", payload.Address), s.adapter.IsScanning())
 
 			// Handle auto-routing and Snapclient restart
@@ -354,33 +573,157 @@ func (s *Server) handleMessage(c *client, msg *Message) {
 	case MsgTypeDisconnect:
 		var payload DeviceActionPayload
 		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-			s.sendError(c, "Invalid payload")
+			s.rejectMessage(c, msg, "Invalid payload")
 			return
 		}
 		log.Printf("Received disconnect request for: %s", payload.Address)
+		op := s.startOperation(c, msg)
 		go func() {
-			if err := s.adapter.Disconnect(payload.Address); err != nil {
-				s.sendError(c, fmt.Sprintf("Failed to disconnect: %v", err))
+			if err := s.adapter.Disconnect(op.ctx, payload.Address); err != nil {
+				op.fail("Failed to disconnect", err)
 				return
 			}
+			op.done(fmt.Sprintf("Disconnected from %s", payload.Address))
 			s.broadcastStatus(fmt.Sprintf("Disconnected from %s", payload.Address), s.adapter.IsScanning())
 		}()
 
 	case MsgTypeRemove:
 		var payload DeviceActionPayload
 		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-			s.sendError(c, "Invalid payload")
+			s.rejectMessage(c, msg, "Invalid payload")
 			return
 		}
 		log.Printf("Received remove request for: %s", payload.Address)
+		op := s.startOperation(c, msg)
 		go func() {
-			if err := s.adapter.Remove(payload.Address); err != nil {
-				s.sendError(c, fmt.Sprintf("Failed to remove device: %v", err))
+			if err := s.adapter.Remove(op.ctx, payload.Address); err != nil {
+				op.fail("Failed to remove device", err)
 				return
 			}
+			op.done(fmt.Sprintf("Removed %s", payload.Address))
 			s.broadcastStatus(fmt.Sprintf("Removed %s", payload.Address), s.adapter.IsScanning())
 		}()
 
+	case MsgTypeCancel:
+		var payload CancelPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			s.sendError(c, "Invalid payload")
+			return
+		}
+		log.Printf("Received cancel request for: %s", payload.ID)
+		if !s.cancelOperation(c, payload.ID) {
+			s.sendReply(c, ReplyPayload{
+				ID:      payload.ID,
+				State:   replyFailed,
+				Message: "No such operation in progress",
+				Code:    bluetooth.CodeNotFound,
+			})
+		}
+
+	case MsgTypeAdapterGet:
+		s.sendAdapter(c)
+
+	case MsgTypeAdapterSet:
+		var payload AdapterSetPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			s.rejectMessage(c, msg, "Invalid adapter payload")
+			return
+		}
+		log.Println("Received adapter settings update")
+		op := s.startOperation(c, msg)
+		go func() {
+			if err := s.applyAdapterSettings(payload); err != nil {
+				op.fail("Failed to update adapter", err)
+				return
+			}
+			op.done("Bluetooth adapter updated")
+			s.broadcastStatus("Bluetooth adapter updated", s.adapter.IsScanning())
+		}()
+
+	case MsgTypeDeviceSetAlias:
+		var payload DeviceAliasPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			s.rejectMessage(c, msg, "Invalid payload")
+			return
+		}
+		log.Printf("Received rename request for: %s", payload.Address)
+		op := s.startOperation(c, msg)
+		go func() {
+			if err := s.adapter.SetAlias(payload.Address, payload.Alias); err != nil {
+				op.fail("Failed to rename device", err)
+				return
+			}
+			op.done(fmt.Sprintf("Renamed %s", payload.Address))
+			s.broadcastStatus(fmt.Sprintf("Renamed %s", payload.Address), s.adapter.IsScanning())
+		}()
+
+	case MsgTypeDeviceSetBlocked:
+		var payload DeviceBlockedPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			s.rejectMessage(c, msg, "Invalid payload")
+			return
+		}
+		log.Printf("Received block request for: %s (blocked=%v)", payload.Address, payload.Blocked)
+		op := s.startOperation(c, msg)
+		go func() {
+			if err := s.adapter.SetBlocked(payload.Address, payload.Blocked); err != nil {
+				op.fail("Failed to update blocked state", err)
+				return
+			}
+			op.done("")
+			if payload.Blocked {
+				s.broadcastStatus(fmt.Sprintf("Blocked %s", payload.Address), s.adapter.IsScanning())
+			} else {
+				s.broadcastStatus(fmt.Sprintf("Unblocked %s", payload.Address), s.adapter.IsScanning())
+			}
+		}()
+
+	case MsgTypeDeviceSetMetadata:
+		var payload DeviceMetadataPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			s.rejectMessage(c, msg, "Invalid payload")
+			return
+		}
+		log.Printf("Received metadata update for: %s", payload.Address)
+		op := s.startOperation(c, msg)
+		if err := s.metaStore.Set(payload.Address, payload.Metadata); err != nil {
+			op.fail("Failed to save device settings", err)
+			return
+		}
+		op.done("Device settings saved")
+		s.syncDevices()
+
+	case MsgTypeDeviceListOptions:
+		var payload DeviceListOptionsPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			s.sendError(c, "Invalid payload")
+			return
+		}
+		log.Printf("Received device list options (showUnnamed=%v)", payload.ShowUnnamed)
+		s.adapter.SetShowUnnamed(payload.ShowUnnamed)
+
+	case MsgTypeMediaControl:
+		var payload MediaControlPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			s.rejectMessage(c, msg, "Invalid payload")
+			return
+		}
+		log.Printf("Received media control: %s", payload.Command)
+		op := s.startOperation(c, msg)
+		go func() {
+			ctx, cancel := context.WithTimeout(op.ctx, mediaCommandTimeout)
+			defer cancel()
+			if err := s.runMediaCommand(ctx, payload.Command); err != nil {
+				op.fail("Failed to control playback", err)
+				return
+			}
+			op.done("")
+		}()
+
+	case MsgTypeDevicesResync:
+		log.Println("Client requested a device list resync")
+		s.sendDevices(c)
+
 	case MsgTypeAlsaGetConfig:
 		s.sendAlsaConfig(c)
 
@@ -401,67 +744,206 @@ func (s *Server) handleMessage(c *client, msg *Message) {
 		}
 		s.broadcastAlsaConfig()
 
+	case MsgTypeAlsaGetFallback:
+		s.sendFallback(c)
+
+	case MsgTypeAlsaSetFallback:
+		var payload FallbackSetPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			s.rejectMessage(c, msg, "Invalid fallback chain payload")
+			return
+		}
+		op := s.startOperation(c, msg)
+		go s.setFallbackChain(op, payload.Chain)
+
+	case MsgTypeScheduleGet:
+		if s.scheduler == nil {
+			s.sendError(c, errSchedulerDisabled.Error())
+			return
+		}
+		s.sendSchedules(c)
+
+	case MsgTypeScheduleSave:
+		var payload schedule.Schedule
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			s.rejectMessage(c, msg, "Invalid schedule payload")
+			return
+		}
+		if s.scheduler == nil {
+			s.rejectMessage(c, msg, errSchedulerDisabled.Error())
+			return
+		}
+		op := s.startOperation(c, msg)
+		go func() {
+			saved, err := s.scheduler.Save(payload)
+			if err != nil {
+				op.fail("Failed to save schedule", err)
+				return
+			}
+			op.done(fmt.Sprintf("Schedule %s saved", saved.Name))
+		}()
+
+	case MsgTypeScheduleRemove:
+		var payload ScheduleIDPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			s.rejectMessage(c, msg, "Invalid schedule payload")
+			return
+		}
+		if s.scheduler == nil {
+			s.rejectMessage(c, msg, errSchedulerDisabled.Error())
+			return
+		}
+		op := s.startOperation(c, msg)
+		go func() {
+			if err := s.scheduler.Remove(payload.ID); err != nil {
+				op.fail("Failed to remove schedule", err)
+				return
+			}
+			op.done("Schedule removed")
+		}()
+
+	case MsgTypeScheduleRun:
+		var payload ScheduleIDPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			s.rejectMessage(c, msg, "Invalid schedule payload")
+			return
+		}
+		if s.scheduler == nil {
+			s.rejectMessage(c, msg, errSchedulerDisabled.Error())
+			return
+		}
+		op := s.startOperation(c, msg)
+		go s.runScheduleNow(op, payload.ID)
+
+	case MsgTypeSleepTimerSet:
+		var payload SleepTimerSetPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			s.rejectMessage(c, msg, "Invalid sleep timer payload")
+			return
+		}
+		op := s.startOperation(c, msg)
+		go s.startSleepTimer(op, payload)
+
+	case MsgTypeSleepTimerCancel:
+		op := s.startOperation(c, msg)
+		if s.cancelSleepTimer() {
+			op.done("Sleep timer canceled")
+		} else {
+			op.done("No sleep timer")
+		}
+
 	case MsgTypeAlsaSetDevice:
 		var payload DeviceActionPayload
 		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
-			s.sendError(c, "Invalid payload")
+			s.rejectMessage(c, msg, "Invalid payload")
 			return
 		}
 		log.Printf("Received ALSA set device request for: %s", payload.Address)
+		op := s.startOperation(c, msg)
 		go func() {
-			if err := s.audioMgr.SetDefaultDevice(payload.Address); err != nil {
-				s.sendError(c, fmt.Sprintf("Failed to set ALSA device: %v", err))
+			if err := s.routeAudio(payload.Address); err != nil {
+				op.fail("Failed to set ALSA device", err)
 				return
 			}
+			op.done(fmt.Sprintf("Set %s as default audio output", payload.Address))
 			s.broadcastStatus(fmt.Sprintf("Set %s as default audio output", payload.Address), s.adapter.IsScanning())
 			s.broadcastAlsaConfig()
 		}()
 
 	case MsgTypeSnapclientGetStatus:
-		s.sendSnapclientStatus(c)
+		s.sendSnapclientStatus(c, snapclientInstance(msg))
+
+	case MsgTypeSnapclientGetInstances:
+		s.sendSnapclientInstances(c)
+
+	case MsgTypeSnapclientCreateInstance:
+		instance := snapclientInstance(msg)
+		if err := snapcast.ValidateInstanceName(instance); err != nil {
+			s.rejectMessage(c, msg, err.Error())
+			return
+		}
+		op := s.startOperation(c, msg)
+		go func() {
+			if err := s.snapclientMgr.CreateInstance(instance); err != nil {
+				op.fail("Failed to create Snapclient instance", err)
+				return
+			}
+			op.done(fmt.Sprintf("Snapclient instance %s created", instance))
+			s.broadcastSnapclientInstances()
+			s.sendSnapclientStatus(c, instance)
+		}()
+
+	case MsgTypeSnapclientRemoveInstance:
+		instance := snapclientInstance(msg)
+		op := s.startOperation(c, msg)
+		go func() {
+			if err := s.snapclientMgr.RemoveInstance(instance); err != nil {
+				op.fail("Failed to remove Snapclient instance", err)
+				return
+			}
+			op.done(fmt.Sprintf("Snapclient instance %s removed", instance))
+			s.broadcastSnapclientInstances()
+		}()
 
 	case MsgTypeSnapclientStart:
+		instance := snapclientInstance(msg)
+		op := s.startOperation(c, msg)
 		go func() {
-			if err := s.snapclientMgr.StartService(); err != nil {
-				s.sendError(c, fmt.Sprintf("Failed to start Snapclient: %v", err))
+			if err := s.snapclientMgr.StartService(instance); err != nil {
+				op.fail("Failed to start Snapclient", err)
 				return
 			}
-			s.sendSnapclientStatus(c)
+			op.done("Snapclient service started")
+			s.sendSnapclientStatus(c, instance)
 			s.broadcastStatus("Snapclient service started", s.adapter.IsScanning())
 		}()
 
 	case MsgTypeSnapclientStop:
+		instance := snapclientInstance(msg)
+		op := s.startOperation(c, msg)
 		go func() {
-			if err := s.snapclientMgr.StopService(); err != nil {
-				s.sendError(c, fmt.Sprintf("Failed to stop Snapclient: %v", err))
+			if err := s.snapclientMgr.StopService(instance); err != nil {
+				op.fail("Failed to stop Snapclient", err)
 				return
 			}
-			s.sendSnapclientStatus(c)
+			op.done("Snapclient service stopped")
+			s.sendSnapclientStatus(c, instance)
 			s.broadcastStatus("Snapclient service stopped", s.adapter.IsScanning())
 		}()
 
 	case MsgTypeSnapclientRestart:
+		instance := snapclientInstance(msg)
+		op := s.startOperation(c, msg)
 		go func() {
-			if err := s.snapclientMgr.RestartService(); err != nil {
-				s.sendError(c, fmt.Sprintf("Failed to restart Snapclient: %v", err))
+			if err := s.snapclientMgr.RestartService(instance); err != nil {
+				op.fail("Failed to restart Snapclient", err)
 				return
 			}
-			s.sendSnapclientStatus(c)
+			op.done("Snapclient service restarted")
+			s.sendSnapclientStatus(c, instance)
 			s.broadcastStatus("Snapclient service restarted", s.adapter.IsScanning())
 		}()
 
 	case MsgTypeSnapclientSetConfig:
 		var config snapcast.Config
 		if err := json.Unmarshal(msg.Payload, &config); err != nil {
-			s.sendError(c, "Invalid Snapclient config payload")
+			s.rejectMessage(c, msg, "Invalid Snapclient config payload")
 			return
 		}
+		instance := snapclientInstance(msg)
+		op := s.startOperation(c, msg)
 		go func() {
-			if err := s.snapclientMgr.SetConfig(config); err != nil {
-				s.sendError(c, fmt.Sprintf("Failed to update Snapclient config: %v", err))
+			// Settings left out of the payload keep their current value
+			if current, err := s.snapclientMgr.GetConfig(instance); err == nil {
+				config = current
+				json.Unmarshal(msg.Payload, &config)
+			}
+			if err := s.snapclientMgr.SetConfig(instance, config); err != nil {
+				op.fail("Failed to update Snapclient config", err)
 				return
 			}
-			s.sendSnapclientStatus(c)
+			op.done("Snapclient configuration updated")
+			s.sendSnapclientStatus(c, instance)
 			s.broadcastStatus("Snapclient configuration updated", s.adapter.IsScanning())
 		}()
 
@@ -493,7 +975,7 @@ func (s *Server) handleMessage(c *client, msg *Message) {
 			result := s.snapclientMgr.MigrateToUserService()
 			s.sendSnapclientMigrationResult(c, result)
 			// Refresh status after migration
-			s.sendSnapclientStatus(c)
+			s.sendSnapclientStatus(c, snapcast.DefaultInstance)
 		}()
 
 	case MsgTypeSnapclientEnableUserService:
@@ -502,7 +984,7 @@ func (s *Server) handleMessage(c *client, msg *Message) {
 			result := s.snapclientMgr.EnableUserService()
 			s.sendSnapclientEnableResult(c, result)
 			// Refresh status after enabling
-			s.sendSnapclientStatus(c)
+			s.sendSnapclientStatus(c, snapcast.DefaultInstance)
 		}()
 
 	case MsgTypeSnapclientSetVolume:
@@ -512,29 +994,30 @@ func (s *Server) handleMessage(c *client, msg *Message) {
 			return
 		}
 		log.Printf("Received Snapclient set volume request: %d", payload.Volume)
+		instance := payload.Instance
 		go func() {
 			// Get current config to check player and soundcard
-			config, err := s.snapclientMgr.GetConfig()
+			config, err := s.snapclientMgr.GetConfig(payload.Instance)
 			if err != nil {
 				s.sendError(c, fmt.Sprintf("Failed to get Snapclient config: %v", err))
 				return
 			}
-			
+
 			// Only set volume if player is "alsa"
 			if config.Player != "alsa" {
 				s.sendError(c, "Volume control is only available when player is 'alsa'")
 				return
 			}
-			
+
 			// Set the volume
 			if err := s.snapclientMgr.SetAlsaVolume(config.Soundcard, payload.Volume); err != nil {
 				s.sendError(c, fmt.Sprintf("Failed to set volume: %v", err))
 				return
 			}
-			
+
 			s.broadcastStatus(fmt.Sprintf("Volume set to %d%%", payload.Volume), s.adapter.IsScanning())
 			// Refresh status to update UI with new volume
-			s.sendSnapclientStatus(c)
+			s.sendSnapclientStatus(c, instance)
 		}()
 
 	case MsgTypeSnapclientGetVolume:
@@ -552,15 +1035,15 @@ func (s *Server) handleMessage(c *client, msg *Message) {
 				// Send default volume on error
 				volume = 100
 			}
-			
+
 			// Send back volume as part of a volume response
-			volumeResponse := VolumePayload{Volume: volume}
+			volumeResponse := VolumePayload{Volume: volume, Instance: payload.Instance}
 			volumeBytes, err := json.Marshal(volumeResponse)
 			if err != nil {
 				log.Printf("Error marshaling volume response: %v", err)
 				return
 			}
-			
+
 			msg := Message{
 				Type:    MsgTypeSnapclientSetVolume,
 				Payload: volumeBytes,
@@ -575,8 +1058,72 @@ func (s *Server) handleMessage(c *client, msg *Message) {
 			c.mu.Unlock()
 		}()
 
+	case MsgTypeSnapclientGetMixerControls:
+		go s.sendMixerControls(c, snapclientInstance(msg))
+
+	case MsgTypeSnapclientSetMixerControl:
+		var payload MixerControlPayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			s.rejectMessage(c, msg, "Invalid mixer control payload")
+			return
+		}
+		op := s.startOperation(c, msg)
+		go func() {
+			soundcard, err := s.alsaSoundcard(payload.Instance)
+			if err != nil {
+				op.fail("Failed to set mixer control", err)
+				return
+			}
+			if err := s.snapclientMgr.SetMixerControl(soundcard, payload.Control); err != nil {
+				op.fail("Failed to set mixer control", err)
+				return
+			}
+			if payload.Control == "" {
+				op.done("Mixer control picked automatically")
+			} else {
+				op.done(fmt.Sprintf("Volume set with the %s control", payload.Control))
+			}
+			s.sendMixerControls(c, payload.Instance)
+			s.broadcastSnapclientStatus(payload.Instance)
+		}()
+
+	case MsgTypeTestTone:
+		var payload TestTonePayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil || (payload.Address == "") == (payload.Soundcard == "") {
+			s.rejectMessage(c, msg, "Invalid test tone payload: set either address or soundcard")
+			return
+		}
+		op := s.startOperation(c, msg)
+		go s.playTestTone(op, payload)
+
+	case MsgTypeSnapclientSetMute:
+		var payload MutePayload
+		if err := json.Unmarshal(msg.Payload, &payload); err != nil {
+			s.rejectMessage(c, msg, "Invalid mute payload")
+			return
+		}
+		op := s.startOperation(c, msg)
+		go func() {
+			soundcard, err := s.alsaSoundcard(payload.Instance)
+			if err != nil {
+				op.fail("Failed to set mute", err)
+				return
+			}
+			if err := s.snapclientMgr.SetAlsaMute(soundcard, payload.Muted); err != nil {
+				op.fail("Failed to set mute", err)
+				return
+			}
+			if payload.Muted {
+				op.done("Output muted")
+			} else {
+				op.done("Output unmuted")
+			}
+			s.broadcastSnapclientStatus(payload.Instance)
+		}()
+
 	case MsgTypeSnapclientStartLogs:
 		log.Println("Received Snapclient start logs request")
+		instance := snapclientInstance(msg)
 		go func() {
 			// Stop any existing log stream for this client
 			c.logStopFuncMu.Lock()
@@ -588,7 +1135,7 @@ func (s *Server) handleMessage(c *client, msg *Message) {
 
 			// Start streaming logs (get last 100 lines initially)
 			ctx, cancel := context.WithCancel(context.Background())
-			logChan, stopFunc, err := s.snapclientMgr.StreamLogs(ctx, 100)
+			logChan, stopFunc, err := s.snapclientMgr.StreamLogs(ctx, instance, 100)
 			if err != nil {
 				cancel()
 				s.sendError(c, fmt.Sprintf("Failed to start log stream: %v", err))
@@ -605,7 +1152,7 @@ func (s *Server) handleMessage(c *client, msg *Message) {
 
 			// Stream logs to client
 			for line := range logChan {
-				logPayload := LogPayload{Line: line}
+				logPayload := LogPayload{Line: line, Instance: instance}
 				logBytes, err := json.Marshal(logPayload)
 				if err != nil {
 					log.Printf("Error marshaling log payload: %v", err)
@@ -651,23 +1198,42 @@ func (s *Server) handleMessage(c *client, msg *Message) {
 	}
 }
 
-func (s *Server) sendDevices(c *client) {
-	payload := DevicesPayload{
-		Devices:  s.adapter.GetDevices(),
-		Scanning: s.adapter.IsScanning(),
+// deviceInfos merges the devices with their stored metadata
+func (s *Server) deviceInfos(devices []*bluetooth.Device) []DeviceInfo {
+	metadata := s.metaStore.All()
+	infos := make([]DeviceInfo, 0, len(devices))
+	for _, device := range devices {
+		info := DeviceInfo{Device: device}
+		if meta, ok := metadata[strings.ToUpper(device.Address)]; ok {
+			info.Metadata = &meta
+		}
+		infos = append(infos, info)
 	}
-	payloadBytes, err := json.Marshal(payload)
+	return infos
+}
+
+func (s *Server) devicesPayload(devices []*bluetooth.Device) DevicesPayload {
+	return DevicesPayload{
+		Devices:     s.deviceInfos(devices),
+		Scanning:    s.adapter.IsScanning(),
+		ShowUnnamed: s.adapter.ShowUnnamed(),
+		HiddenCount: s.adapter.HiddenDeviceCount(),
+	}
+}
+
+func (s *Server) sendAdapter(c *client) {
+	payloadBytes, err := json.Marshal(s.adapter.GetAdapterInfo())
 	if err != nil {
-		log.Printf("Error marshaling devices payload: %v", err)
+		log.Printf("Error marshaling adapter payload: %v", err)
 		return
 	}
 	msg := Message{
-		Type:    MsgTypeDevices,
+		Type:    MsgTypeAdapter,
 		Payload: payloadBytes,
 	}
 	msgBytes, err := json.Marshal(msg)
 	if err != nil {
-		log.Printf("Error marshaling devices message: %v", err)
+		log.Printf("Error marshaling adapter message: %v", err)
 		return
 	}
 	c.mu.Lock()
@@ -675,6 +1241,89 @@ func (s *Server) sendDevices(c *client) {
 	c.mu.Unlock()
 }
 
+func (s *Server) broadcastAdapter(info bluetooth.AdapterInfo) {
+	payloadBytes, err := json.Marshal(info)
+	if err != nil {
+		log.Printf("Error marshaling broadcast adapter payload: %v", err)
+		return
+	}
+	msg := Message{
+		Type:    MsgTypeAdapter,
+		Payload: payloadBytes,
+	}
+	s.broadcast(&msg)
+}
+
+// applyAdapterSettings applies the requested adapter property changes.
+// The alias is applied first so that a rename is visible as soon as the
+// adapter becomes discoverable.
+func (s *Server) applyAdapterSettings(payload AdapterSetPayload) error {
+	if payload.Alias != nil {
+		if err := s.adapter.SetAdapterAlias(*payload.Alias); err != nil {
+			return err
+		}
+	}
+
+	if payload.Powered != nil {
+		if err := s.adapter.SetPowered(*payload.Powered); err != nil {
+			return err
+		}
+	}
+
+	if payload.Pairable != nil {
+		if err := s.adapter.SetPairable(*payload.Pairable); err != nil {
+			return err
+		}
+	}
+
+	// BlueZ keeps its default timeout unless one is given
+	if payload.DiscoverableTimeout != nil {
+		if err := s.adapter.SetDiscoverableTimeout(*payload.DiscoverableTimeout); err != nil {
+			return err
+		}
+	}
+
+	if payload.Discoverable != nil {
+		if err := s.adapter.SetDiscoverable(*payload.Discoverable); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// handleAdapterAPI serves the adapter state (GET) and applies changes (POST)
+func (s *Server) handleAdapterAPI(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var payload AdapterSetPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			http.Error(w, "Invalid adapter payload", http.StatusBadRequest)
+			return
+		}
+		if err := s.applyAdapterSettings(payload); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to update adapter: %v", err), http.StatusInternalServerError)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// The cached state only follows the changes once BlueZ signals them
+	info := s.adapter.GetAdapterInfo()
+	if r.Method == http.MethodPost {
+		info = s.adapter.RefreshAdapterInfo()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		log.Printf("Error encoding adapter response: %v", err)
+	}
+}
+
 func (s *Server) sendError(c *client, errMsg string) {
 	payload := ErrorPayload{Message: errMsg}
 	payloadBytes, err := json.Marshal(payload)
@@ -696,21 +1345,25 @@ func (s *Server) sendError(c *client, errMsg string) {
 	c.mu.Unlock()
 }
 
-func (s *Server) broadcastDevices(devices []*bluetooth.Device) {
-	payload := DevicesPayload{
-		Devices:  devices,
-		Scanning: s.adapter.IsScanning(),
-	}
-	payloadBytes, err := json.Marshal(payload)
-	if err != nil {
-		log.Printf("Error marshaling broadcast devices payload: %v", err)
-		return
+// handleDevicesChange is called by the adapter whenever a device changes
+func (s *Server) handleDevicesChange(devices []*bluetooth.Device) {
+	s.scheduleDeviceSync()
+
+	// Fall back to the next output when the routed device disconnects, and
+	// back to it when it reconnects
+	if s.connectionsChanged(devices) && s.hasFallbackChain() {
+		go s.applyFallbackChain()
 	}
-	msg := Message{
-		Type:    MsgTypeDevices,
-		Payload: payloadBytes,
+}
+
+// handleScanChange notifies clients when discovery starts or stops,
+// including when the last scan session times out
+func (s *Server) handleScanChange(scanning bool) {
+	if scanning {
+		s.broadcastStatus("Scanning for devices...", true)
+	} else {
+		s.broadcastStatus("Scan stopped", false)
 	}
-	s.broadcast(&msg)
 }
 
 func (s *Server) broadcastStatus(message string, scanning bool) {
@@ -730,8 +1383,19 @@ func (s *Server) broadcastStatus(message string, scanning bool) {
 	s.broadcast(&msg)
 }
 
-func (s *Server) sendSnapclientStatus(c *client) {
-	status, err := s.snapclientMgr.GetStatus()
+// broadcastSnapclientStatus sends the status of an instance to every client,
+// when its unit changes state
+func (s *Server) broadcastSnapclientStatus(instance string) {
+	status, err := s.snapclientMgr.GetStatus(instance)
+	if err != nil {
+		log.Printf("Error getting Snapclient status: %v", err)
+		return
+	}
+	s.broadcastPayload(MsgTypeSnapclientStatus, status)
+}
+
+func (s *Server) sendSnapclientStatus(c *client, instance string) {
+	status, err := s.snapclientMgr.GetStatus(instance)
 	if err != nil {
 		log.Printf("Error getting Snapclient status: %v", err)
 		return
@@ -917,12 +1581,30 @@ func (s *Server) broadcastAlsaConfig() {
 	s.broadcast(&msg)
 }
 
+// isAudioOutput reports whether audio can be routed to the device
+func isAudioOutput(device *bluetooth.Device) bool {
+	return audio.IsAudioDevice(device.Icon) || device.LEAudio
+}
+
+// routeAudio makes the device the default audio output. LE Audio devices go
+// through PipeWire when the stack supports it, everything else through bluez-alsa.
+func (s *Server) routeAudio(address string) error {
+	if s.adapter.LEAudioSupported() {
+		for _, device := range s.adapter.GetPairedDevices() {
+			if strings.EqualFold(device.Address, address) && device.LEAudio {
+				return s.audioMgr.SetDefaultLEAudioDevice(address)
+			}
+		}
+	}
+	return s.audioMgr.SetDefaultDevice(address)
+}
+
 func (s *Server) routeToFirstConnectedDevice() {
 	devices := s.adapter.GetDevices()
 	for _, device := range devices {
-		if device.Connected && audio.IsAudioDevice(device.Icon) {
+		if device.Connected && isAudioOutput(device) {
 			log.Printf("Auto-routing audio to first connected device: %s (%s)", device.Name, device.Address)
-			if err := s.audioMgr.SetDefaultDevice(device.Address); err != nil {
+			if err := s.routeAudio(device.Address); err != nil {
 				log.Printf("Failed to auto-route audio: %v", err)
 			} else {
 				s.broadcastAlsaConfig()
@@ -934,18 +1616,25 @@ func (s *Server) routeToFirstConnectedDevice() {
 }
 
 func (s *Server) handleDeviceConnected(address string) {
+	// The fallback chain decides which device plays, instead of auto-routing
+	chain := s.hasFallbackChain()
+	var restarted map[string]bool
+	if chain {
+		restarted = s.applyFallbackChain()
+	}
+
 	// Check if auto-routing is enabled and route if this is an audio device
 	s.alsaAutoRouteMu.RLock()
 	autoRoute := s.alsaAutoRoute
 	s.alsaAutoRouteMu.RUnlock()
 
-	if autoRoute {
+	if autoRoute && !chain {
 		// Get the device to check if it's an audio device
 		devices := s.adapter.GetDevices()
 		for _, device := range devices {
-			if device.Address == address && audio.IsAudioDevice(device.Icon) {
+			if device.Address == address && isAudioOutput(device) {
 				log.Printf("Auto-routing audio to newly connected device: %s", address)
-				if err := s.audioMgr.SetDefaultDevice(address); err != nil {
+				if err := s.routeAudio(address); err != nil {
 					log.Printf("Failed to auto-route audio: %v", err)
 				} else {
 					s.broadcastAlsaConfig()
@@ -957,11 +1646,26 @@ func (s *Server) handleDeviceConnected(address string) {
 
 	// Restart Snapclient service if enabled
 	if s.snapclientMgr != nil {
-		log.Println("Restarting Snapclient service after device connection...")
-		if err := s.snapclientMgr.RestartService(); err != nil {
-			log.Printf("Warning: Failed to restart Snapclient service: %v", err)
-		} else {
-			log.Println("Snapclient service restarted successfully")
+		s.restartSnapclientInstances(restarted)
+	}
+}
+
+// autoConnectDevices connects paired devices whose metadata asks for it
+func (s *Server) autoConnectDevices() {
+	for address, meta := range s.metaStore.All() {
+		if !meta.AutoConnect {
+			continue
+		}
+		for _, device := range s.adapter.GetPairedDevices() {
+			if !strings.EqualFold(device.Address, address) || device.Connected || device.Blocked {
+				continue
+			}
+			log.Printf("Auto-connecting device: %s", device.Address)
+			if err := s.adapter.Connect(context.Background(), device.Address); err != nil {
+				log.Printf("Failed to auto-connect %s: %v", device.Address, err)
+				continue
+			}
+			s.handleDeviceConnected(device.Address)
 		}
 	}
 }
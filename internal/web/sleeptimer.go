@@ -0,0 +1,366 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Ilshidur/bluepicast/internal/bluetooth"
+	"github.com/Ilshidur/bluepicast/internal/snapcast"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	sleepFadeDuration   = time.Minute      // The volume fades out over the last minute
+	sleepResyncInterval = 15 * time.Second // How often clients get the remaining time while counting down
+	maxSleepMinutes     = 12 * 60
+)
+
+// macAddressPattern validates MAC address format (XX:XX:XX:XX:XX:XX)
+var macAddressPattern = regexp.MustCompile(`^([0-9A-Fa-f]{2}:){5}[0-9A-Fa-f]{2}$`)
+
+// Actions of the sleep timer when it ends
+const (
+	SleepActionStop       = "stop"       // Stop a Snapclient instance
+	SleepActionDisconnect = "disconnect" // Disconnect a Bluetooth device
+)
+
+// SleepTimerPayload is the state of the sleep timer
+type SleepTimerPayload struct {
+	Active    bool   `json:"active"`
+	Action    string `json:"action,omitempty"`
+	Instance  string `json:"instance,omitempty"` // Instance stopped by SleepActionStop
+	Address   string `json:"address,omitempty"`  // Device disconnected by SleepActionDisconnect
+	Remaining int    `json:"remaining"`          // Seconds left
+	Fading    bool   `json:"fading"`
+}
+
+// SleepTimerSetPayload starts the sleep timer
+type SleepTimerSetPayload struct {
+	Minutes  int    `json:"minutes"`
+	Action   string `json:"action"`
+	Instance string `json:"instance,omitempty"`
+	Address  string `json:"address,omitempty"`
+}
+
+// sleepTimer stops the audio after a delay, fading the volume out first
+type sleepTimer struct {
+	mu     sync.Mutex
+	state  SleepTimerPayload
+	endsAt time.Time
+	cancel context.CancelFunc // Stops the running timer, nil when none runs
+	done   chan struct{}      // Closed when the last timer started has finished
+
+	fade     time.Duration
+	resync   time.Duration
+	volume   func(state SleepTimerPayload) (control volumeControl, onDevice, ok bool)
+	action   func(state SleepTimerPayload) (string, error)
+	onChange func()
+	onEnd    func(message string)
+}
+
+func newSleepTimer(volume func(SleepTimerPayload) (volumeControl, bool, bool), action func(SleepTimerPayload) (string, error), onChange func(), onEnd func(string)) *sleepTimer {
+	return &sleepTimer{
+		fade:     sleepFadeDuration,
+		resync:   sleepResyncInterval,
+		volume:   volume,
+		action:   action,
+		onChange: onChange,
+		onEnd:    onEnd,
+	}
+}
+
+// validate checks a sleep timer request
+func (p SleepTimerSetPayload) validate() error {
+	if p.Minutes < 1 || p.Minutes > maxSleepMinutes {
+		return fmt.Errorf("minutes must be between 1 and %d, got %d", maxSleepMinutes, p.Minutes)
+	}
+	switch p.Action {
+	case SleepActionStop:
+	case SleepActionDisconnect:
+		if p.Address == "" {
+			return errors.New("no device to disconnect")
+		}
+		if !macAddressPattern.MatchString(p.Address) {
+			return fmt.Errorf("invalid MAC address format: %s", p.Address)
+		}
+	default:
+		return fmt.Errorf("unknown sleep timer action %q", p.Action)
+	}
+	return nil
+}
+
+// startSleepTimer replaces the sleep timer with a new one
+func (s *Server) startSleepTimer(op *operation, payload SleepTimerSetPayload) {
+	if err := payload.validate(); err != nil {
+		op.fail("Failed to set the sleep timer", err)
+		return
+	}
+	// Check the target now rather than fail when the timer ends
+	if err := s.checkSleepTarget(payload); err != nil {
+		op.fail("Failed to set the sleep timer", err)
+		return
+	}
+
+	s.sleep.start(SleepTimerPayload{
+		Active:   true,
+		Action:   payload.Action,
+		Instance: payload.Instance,
+		Address:  payload.Address,
+	}, time.Duration(payload.Minutes)*time.Minute)
+
+	log.Printf("Sleep timer set: %s in %d minutes", payload.Action, payload.Minutes)
+	op.done(fmt.Sprintf("Sleep timer set for %d minutes", payload.Minutes))
+}
+
+// checkSleepTarget checks that the instance to stop or the device to
+// disconnect exists
+func (s *Server) checkSleepTarget(payload SleepTimerSetPayload) error {
+	if payload.Action == SleepActionStop {
+		if !s.snapclientMgr.IsEnabled() {
+			return errors.New("snapclient integration not enabled")
+		}
+		return s.snapclientMgr.CheckInstance(payload.Instance)
+	}
+	for _, device := range s.adapter.GetPairedDevices() {
+		if strings.EqualFold(device.Address, payload.Address) {
+			return nil
+		}
+	}
+	return bluetooth.ErrDeviceNotFound
+}
+
+// cancelSleepTimer stops the sleep timer. A fade in progress is undone.
+func (s *Server) cancelSleepTimer() bool {
+	if !s.sleep.stop() {
+		return false
+	}
+	log.Println("Sleep timer canceled")
+	return true
+}
+
+// sleepTimerEnded tells the clients what the sleep timer did
+func (s *Server) sleepTimerEnded(message string) {
+	s.broadcastStatus(message, s.adapter.IsScanning())
+}
+
+// start replaces the timer with one running state after duration
+func (t *sleepTimer) start(state SleepTimerPayload, duration time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	endsAt := time.Now().Add(duration)
+	done := make(chan struct{})
+
+	t.mu.Lock()
+	if t.cancel != nil {
+		t.cancel()
+	}
+	previous := t.done
+	t.cancel = cancel
+	t.endsAt = endsAt
+	t.state = state
+	t.done = done
+	t.mu.Unlock()
+
+	t.onChange()
+	go func() {
+		defer close(done)
+		// The replaced timer puts back the volume it faded before this one reads it
+		if previous != nil {
+			<-previous
+		}
+		t.run(ctx, endsAt)
+	}()
+}
+
+// stop cancels the running timer, returning false when none runs
+func (t *sleepTimer) stop() bool {
+	t.mu.Lock()
+	cancel := t.cancel
+	t.cancel = nil
+	t.state = SleepTimerPayload{}
+	t.mu.Unlock()
+
+	if cancel == nil {
+		return false
+	}
+	cancel()
+	t.onChange()
+	return true
+}
+
+// run counts down, fades the volume out over the last minute and stops the
+// audio
+func (t *sleepTimer) run(ctx context.Context, endsAt time.Time) {
+	ticker := time.NewTicker(t.resync)
+	defer ticker.Stop()
+	fade := time.NewTimer(time.Until(endsAt.Add(-t.fade)))
+	defer fade.Stop()
+
+wait:
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			t.onChange()
+		case <-fade.C:
+			break wait
+		}
+	}
+
+	t.mu.Lock()
+	if ctx.Err() != nil {
+		t.mu.Unlock()
+		return
+	}
+	t.state.Fading = true
+	state := t.state
+	t.mu.Unlock()
+	t.onChange()
+
+	// Fade out, then stop and put the volume back for the next time
+	control, onDevice, ok := t.volume(state)
+	var original int
+	if ok {
+		var err error
+		original, err = control.get()
+		ok = err == nil
+	}
+	if ok {
+		fadeErr := rampVolume(ctx, control, 0, time.Until(endsAt))
+		if ctx.Err() != nil {
+			if err := control.set(original); err != nil {
+				log.Printf("Warning: Failed to restore the volume after canceling the sleep timer: %v", err)
+			}
+			return
+		}
+		if fadeErr != nil {
+			log.Printf("Warning: Failed to fade out the volume: %v", fadeErr)
+		}
+	} else {
+		end := time.NewTimer(time.Until(endsAt))
+		defer end.Stop()
+		select {
+		case <-ctx.Done():
+			return
+		case <-end.C:
+		}
+	}
+
+	t.mu.Lock()
+	if ctx.Err() != nil {
+		t.mu.Unlock()
+		return
+	}
+	t.cancel = nil
+	t.state = SleepTimerPayload{}
+	t.mu.Unlock()
+
+	// A device cannot take its volume back once disconnected, so it gets it
+	// before; the audio is already silent by then
+	if ok && onDevice {
+		if err := control.set(original); err != nil {
+			log.Printf("Warning: Failed to restore the volume before the sleep timer disconnects: %v", err)
+		}
+	}
+	message, err := t.action(state)
+	if err != nil {
+		log.Printf("Warning: Sleep timer failed: %v", err)
+		message = fmt.Sprintf("Sleep timer failed: %v", err)
+	}
+	if ok && !onDevice {
+		if err := control.set(original); err != nil {
+			log.Printf("Warning: Failed to restore the volume after the sleep timer: %v", err)
+		}
+	}
+
+	t.onChange()
+	t.onEnd(message)
+}
+
+// sleepVolume returns the volume the sleep timer fades: the absolute volume
+// of the device it disconnects when it has one, otherwise the ALSA volume of
+// the instance it stops or of the default instance. onDevice is true for
+// the volume of the device.
+func (s *Server) sleepVolume(state SleepTimerPayload) (control volumeControl, onDevice, ok bool) {
+	instance := state.Instance
+	if state.Action == SleepActionDisconnect {
+		if _, err := s.adapter.Volume(state.Address); err == nil {
+			return s.bluetoothVolume(state.Address), true, true
+		}
+		instance = snapcast.DefaultInstance
+	}
+	if !s.snapclientMgr.IsEnabled() {
+		return volumeControl{}, false, false
+	}
+	soundcard, err := s.alsaSoundcard(instance)
+	if err != nil {
+		return volumeControl{}, false, false
+	}
+	return s.alsaVolume(soundcard), false, true
+}
+
+// sleepAction stops the audio when the sleep timer ends
+func (s *Server) sleepAction(state SleepTimerPayload) (string, error) {
+	switch state.Action {
+	case SleepActionStop:
+		if err := s.snapclientMgr.StopService(state.Instance); err != nil {
+			return "", err
+		}
+		s.broadcastSnapclientStatus(state.Instance)
+		return "Sleep timer: Snapclient stopped", nil
+	case SleepActionDisconnect:
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := s.adapter.Disconnect(ctx, state.Address); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Sleep timer: disconnected from %s", strings.ToUpper(state.Address)), nil
+	}
+	return "", fmt.Errorf("unknown sleep timer action %q", state.Action)
+}
+
+// sleepTimerPayload returns the state of the sleep timer with the time left
+func (s *Server) sleepTimerPayload() SleepTimerPayload {
+	return s.sleep.payload()
+}
+
+// payload returns the state of the timer with the time left
+func (t *sleepTimer) payload() SleepTimerPayload {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	state := t.state
+	if state.Active {
+		state.Remaining = int(time.Until(t.endsAt).Round(time.Second) / time.Second)
+		if state.Remaining < 0 {
+			state.Remaining = 0
+		}
+	}
+	return state
+}
+
+func (s *Server) sendSleepTimer(c *client) {
+	payloadBytes, err := json.Marshal(s.sleepTimerPayload())
+	if err != nil {
+		log.Printf("Error marshaling sleep timer payload: %v", err)
+		return
+	}
+	msgBytes, err := json.Marshal(Message{Type: MsgTypeSleepTimer, Payload: payloadBytes})
+	if err != nil {
+		log.Printf("Error marshaling sleep timer message: %v", err)
+		return
+	}
+	c.mu.Lock()
+	c.conn.WriteMessage(websocket.TextMessage, msgBytes)
+	c.mu.Unlock()
+}
+
+func (s *Server) broadcastSleepTimer() {
+	s.broadcastPayload(MsgTypeSleepTimer, s.sleepTimerPayload())
+}
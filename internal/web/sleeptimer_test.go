@@ -0,0 +1,215 @@
+package web
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeVolume is a volume recording the values it is set to
+type fakeVolume struct {
+	mu     sync.Mutex
+	volume int
+	set    []int
+}
+
+func (v *fakeVolume) control() volumeControl {
+	return volumeControl{
+		get: func() (int, error) {
+			v.mu.Lock()
+			defer v.mu.Unlock()
+			return v.volume, nil
+		},
+		set: func(volume int) error {
+			v.mu.Lock()
+			defer v.mu.Unlock()
+			v.volume = volume
+			v.set = append(v.set, volume)
+			return nil
+		},
+	}
+}
+
+func (v *fakeVolume) get() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.volume
+}
+
+func (v *fakeVolume) faded() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, volume := range v.set {
+		if volume == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// sleepTest is a sleep timer acting on a fake volume
+type sleepTest struct {
+	timer   *sleepTimer
+	volume  *fakeVolume
+	mu      sync.Mutex
+	actions []SleepTimerPayload
+	heard   []int // Volume when each action ran
+}
+
+func newSleepTest(onDevice bool, fade time.Duration) *sleepTest {
+	st := &sleepTest{volume: &fakeVolume{volume: 80}}
+	st.timer = newSleepTimer(
+		func(SleepTimerPayload) (volumeControl, bool, bool) {
+			return st.volume.control(), onDevice, true
+		},
+		func(state SleepTimerPayload) (string, error) {
+			st.mu.Lock()
+			defer st.mu.Unlock()
+			st.actions = append(st.actions, state)
+			st.heard = append(st.heard, st.volume.get())
+			return "done", nil
+		},
+		func() {},
+		func(string) {},
+	)
+	st.timer.fade = fade
+	st.timer.resync = 10 * time.Millisecond
+	return st
+}
+
+// wait returns once the last timer started has finished
+func (st *sleepTest) wait(t *testing.T) {
+	t.Helper()
+	st.timer.mu.Lock()
+	done := st.timer.done
+	st.timer.mu.Unlock()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Sleep timer did not finish")
+	}
+}
+
+func (st *sleepTest) waitFading(t *testing.T) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !st.timer.payload().Fading {
+		if time.Now().After(deadline) {
+			t.Fatal("Sleep timer did not start fading")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSleepTimerFadesThenStops(t *testing.T) {
+	st := newSleepTest(false, 100*time.Millisecond)
+	st.timer.start(SleepTimerPayload{Active: true, Action: SleepActionStop, Instance: "kitchen"}, 200*time.Millisecond)
+	if state := st.timer.payload(); !state.Active || state.Fading {
+		t.Errorf("State = %+v, want active and not fading", state)
+	}
+	st.wait(t)
+
+	if len(st.actions) != 1 || st.actions[0].Instance != "kitchen" {
+		t.Fatalf("Actions = %+v, want one stop of kitchen", st.actions)
+	}
+	if st.heard[0] != 0 {
+		t.Errorf("Volume when stopping = %d, want 0", st.heard[0])
+	}
+	if volume := st.volume.get(); volume != 80 {
+		t.Errorf("Volume after the timer = %d, want 80", volume)
+	}
+	if state := st.timer.payload(); state.Active {
+		t.Errorf("State after the timer = %+v, want inactive", state)
+	}
+}
+
+func TestSleepTimerRestoresDeviceVolumeBeforeDisconnecting(t *testing.T) {
+	st := newSleepTest(true, 100*time.Millisecond)
+	st.timer.start(SleepTimerPayload{Active: true, Action: SleepActionDisconnect, Address: "AA:BB:CC:DD:EE:FF"}, 200*time.Millisecond)
+	st.wait(t)
+
+	if len(st.actions) != 1 {
+		t.Fatalf("Actions = %+v, want one disconnect", st.actions)
+	}
+	if !st.volume.faded() {
+		t.Error("Volume was not faded out")
+	}
+	if st.heard[0] != 80 {
+		t.Errorf("Volume when disconnecting = %d, want 80", st.heard[0])
+	}
+}
+
+func TestSleepTimerCancelRestoresVolume(t *testing.T) {
+	st := newSleepTest(false, 10*time.Second)
+	st.timer.start(SleepTimerPayload{Active: true, Action: SleepActionStop}, 10*time.Second)
+	st.waitFading(t)
+
+	if !st.timer.stop() {
+		t.Fatal("stop found no timer")
+	}
+	st.wait(t)
+
+	if len(st.actions) != 0 {
+		t.Errorf("Actions = %+v, want none", st.actions)
+	}
+	if volume := st.volume.get(); volume != 80 {
+		t.Errorf("Volume after canceling = %d, want 80", volume)
+	}
+	if st.timer.stop() {
+		t.Error("stop found a timer after canceling")
+	}
+}
+
+func TestSleepTimerReplace(t *testing.T) {
+	st := newSleepTest(false, 10*time.Second)
+	st.timer.start(SleepTimerPayload{Active: true, Action: SleepActionStop, Instance: "first"}, 10*time.Second)
+	st.waitFading(t)
+	// The first step of the fade comes after a second
+	deadline := time.Now().Add(5 * time.Second)
+	for st.volume.get() == 80 {
+		if time.Now().After(deadline) {
+			t.Fatal("Volume did not fade")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	st.timer.start(SleepTimerPayload{Active: true, Action: SleepActionStop, Instance: "second"}, 200*time.Millisecond)
+	st.wait(t)
+
+	if len(st.actions) != 1 || st.actions[0].Instance != "second" {
+		t.Fatalf("Actions = %+v, want one stop of second", st.actions)
+	}
+	if st.heard[0] != 0 {
+		t.Errorf("Volume when stopping = %d, want 0", st.heard[0])
+	}
+	// The second timer reads the volume the first one put back
+	if volume := st.volume.get(); volume != 80 {
+		t.Errorf("Volume after the timer = %d, want 80", volume)
+	}
+}
+
+func TestSleepTimerSetPayloadValidate(t *testing.T) {
+	valid := []SleepTimerSetPayload{
+		{Minutes: 60, Action: SleepActionStop},
+		{Minutes: 60, Action: SleepActionStop, Instance: "kitchen"},
+		{Minutes: 1, Action: SleepActionDisconnect, Address: "aa:bb:cc:dd:ee:ff"},
+	}
+	for _, payload := range valid {
+		if err := payload.validate(); err != nil {
+			t.Errorf("validate(%+v) = %v, want nil", payload, err)
+		}
+	}
+
+	invalid := []SleepTimerSetPayload{
+		{Minutes: 0, Action: SleepActionStop},
+		{Minutes: maxSleepMinutes + 1, Action: SleepActionStop},
+		{Minutes: 60, Action: "pause"},
+		{Minutes: 60, Action: SleepActionDisconnect},
+		{Minutes: 60, Action: SleepActionDisconnect, Address: "AA:BB:CC:DD:EE"},
+	}
+	for _, payload := range invalid {
+		if err := payload.validate(); err == nil {
+			t.Errorf("validate(%+v) succeeded", payload)
+		}
+	}
+}
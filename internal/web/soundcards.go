@@ -0,0 +1,32 @@
+package web
+
+import (
+	"github.com/Ilshidur/bluepicast/internal/alsa"
+)
+
+// handleSoundcardChange pushes a soundcard plugged in or removed, with the
+// PCM devices and the availability of the configured soundcards it changes
+func (s *Server) handleSoundcardChange(event alsa.CardEvent) {
+	msgType := MsgTypeSoundcardRemoved
+	if event.Added {
+		msgType = MsgTypeSoundcardAdded
+	}
+	s.broadcastPayload(msgType, event.Card)
+
+	// A soundcard of the fallback chain may have come or gone
+	if s.hasFallbackChain() {
+		s.applyFallbackChain()
+	}
+	s.broadcastFallback()
+
+	if devices, err := s.snapclientMgr.ListPCMDevices(); err == nil {
+		s.broadcastPayload(MsgTypeSnapclientPCMDevices, devices)
+	}
+	instances, err := s.snapclientMgr.ListInstances()
+	if err != nil {
+		return
+	}
+	for _, instance := range instances {
+		s.broadcastSnapclientStatus(instance)
+	}
+}
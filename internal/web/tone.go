@@ -0,0 +1,133 @@
+package web
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/Ilshidur/bluepicast/internal/audio"
+	"github.com/Ilshidur/bluepicast/internal/bluetooth"
+)
+
+// TestTonePayload selects the output to identify: a Bluetooth device by
+// address, or an ALSA PCM such as "hw:CARD=Audio,DEV=0"
+type TestTonePayload struct {
+	Address   string `json:"address,omitempty"`
+	Soundcard string `json:"soundcard,omitempty"`
+}
+
+// toneOutput is where a test tone is played
+type toneOutput struct {
+	pcm     string   // PCM given to aplay
+	label   string   // Name shown to the user
+	holders []string // Soundcard settings of the snapclient instances to pause
+}
+
+// toneOutput resolves the output of a test tone request
+func (s *Server) toneOutput(payload TestTonePayload) (toneOutput, error) {
+	if payload.Soundcard != "" {
+		return toneOutput{pcm: payload.Soundcard, label: payload.Soundcard, holders: []string{payload.Soundcard}}, nil
+	}
+
+	for _, device := range s.adapter.GetPairedDevices() {
+		if !strings.EqualFold(device.Address, payload.Address) {
+			continue
+		}
+		current, _ := s.audioMgr.GetCurrentDevice()
+		return deviceToneOutput(device, s.adapter.LEAudioSupported(), current)
+	}
+	return toneOutput{}, bluetooth.ErrDeviceNotFound
+}
+
+// deviceToneOutput returns the output of a test tone played on a Bluetooth
+// device. current is the address of the device audio is routed to, if any.
+func deviceToneOutput(device *bluetooth.Device, leAudioSupported bool, current string) (toneOutput, error) {
+	if !device.Connected {
+		return toneOutput{}, fmt.Errorf("device is not connected")
+	}
+	pcm, err := audio.BluetoothPCM(device.Address, device.LEAudio && leAudioSupported)
+	if err != nil {
+		return toneOutput{}, err
+	}
+	output := toneOutput{pcm: pcm, label: device.Alias}
+	if output.label == "" {
+		output.label = device.Address
+	}
+	// Snapclient only holds the device when audio is routed to it
+	if strings.EqualFold(current, device.Address) {
+		output.holders = []string{"bluealsa", "default"}
+	}
+	return output, nil
+}
+
+// instanceController starts and stops snapclient instances, see snapcast.Manager
+type instanceController interface {
+	InstancesPlayingTo(soundcard string) ([]string, error)
+	StopService(instance string) error
+	StartService(instance string) error
+}
+
+// pauseInstances stops the snapclient instances playing to the holders and
+// returns the ones it stopped
+func pauseInstances(instances instanceController, holders []string) []string {
+	var paused []string
+	for _, holder := range holders {
+		playing, err := instances.InstancesPlayingTo(holder)
+		if err != nil {
+			log.Printf("Warning: Failed to find the Snapclient instances playing to %s: %v", holder, err)
+		}
+		for _, instance := range playing {
+			if err := instances.StopService(instance); err != nil {
+				log.Printf("Warning: Failed to pause Snapclient for the test tone: %v", err)
+				continue
+			}
+			paused = append(paused, instance)
+		}
+	}
+	return paused
+}
+
+// resumeInstances starts the snapclient instances paused by pauseInstances again
+func resumeInstances(instances instanceController, paused []string) {
+	for _, instance := range paused {
+		if err := instances.StartService(instance); err != nil {
+			log.Printf("Warning: Failed to resume Snapclient after the test tone: %v", err)
+		}
+	}
+}
+
+// playTestTone plays the identification chime on an output. The snapclient
+// instances playing to it are stopped meanwhile, as the PCM cannot be shared.
+func (s *Server) playTestTone(op *operation, payload TestTonePayload) {
+	if !s.toneMu.TryLock() {
+		op.fail("Failed to play test tone", errors.New("a test tone is already playing"))
+		return
+	}
+	defer s.toneMu.Unlock()
+
+	output, err := s.toneOutput(payload)
+	if err != nil {
+		op.fail("Failed to play test tone", err)
+		return
+	}
+
+	var paused []string
+	if s.snapclientMgr.IsEnabled() {
+		paused = pauseInstances(s.snapclientMgr, output.holders)
+	}
+	if len(paused) > 0 {
+		op.progress("Snapclient paused")
+	}
+
+	log.Printf("Playing test tone on %s (%s)", output.label, output.pcm)
+	err = s.audioMgr.PlayTone(op.ctx, output.pcm, audio.Chime())
+
+	resumeInstances(s.snapclientMgr, paused)
+
+	if err != nil {
+		op.fail(fmt.Sprintf("Failed to play test tone on %s", output.label), err)
+		return
+	}
+	op.done(fmt.Sprintf("Test tone played on %s", output.label))
+}
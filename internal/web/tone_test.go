@@ -0,0 +1,98 @@
+package web
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Ilshidur/bluepicast/internal/bluetooth"
+)
+
+func TestDeviceToneOutput(t *testing.T) {
+	speaker := &bluetooth.Device{Address: "AA:BB:CC:DD:EE:FF", Alias: "Kitchen", Connected: true}
+
+	output, err := deviceToneOutput(speaker, true, "")
+	if err != nil {
+		t.Fatalf("deviceToneOutput failed: %v", err)
+	}
+	want := toneOutput{pcm: "bluealsa:DEV=AA:BB:CC:DD:EE:FF,PROFILE=a2dp", label: "Kitchen"}
+	if !reflect.DeepEqual(output, want) {
+		t.Errorf("output = %+v, want %+v", output, want)
+	}
+
+	// Snapclient holds the device audio is routed to
+	if output, _ := deviceToneOutput(speaker, true, "aa:bb:cc:dd:ee:ff"); !reflect.DeepEqual(output.holders, []string{"bluealsa", "default"}) {
+		t.Errorf("holders = %q, want the bluealsa and default instances", output.holders)
+	}
+
+	// LE Audio devices play through PipeWire when the audio stack supports it
+	earbuds := &bluetooth.Device{Address: "11:22:33:44:55:66", Connected: true, LEAudio: true}
+	if output, _ := deviceToneOutput(earbuds, true, ""); output.pcm != "pipewire:NODE=bluez_output.11_22_33_44_55_66.1" || output.label != earbuds.Address {
+		t.Errorf("LE Audio output = %+v", output)
+	}
+	if output, _ := deviceToneOutput(earbuds, false, ""); output.pcm != "bluealsa:DEV=11:22:33:44:55:66,PROFILE=a2dp" {
+		t.Errorf("output without LE Audio support = %+v", output)
+	}
+
+	if _, err := deviceToneOutput(&bluetooth.Device{Address: speaker.Address}, true, ""); err == nil {
+		t.Error("a disconnected device should have no output")
+	}
+}
+
+func TestSoundcardToneOutput(t *testing.T) {
+	s := &Server{}
+	output, err := s.toneOutput(TestTonePayload{Soundcard: "hw:CARD=Audio,DEV=0"})
+	want := toneOutput{pcm: "hw:CARD=Audio,DEV=0", label: "hw:CARD=Audio,DEV=0", holders: []string{"hw:CARD=Audio,DEV=0"}}
+	if err != nil || !reflect.DeepEqual(output, want) {
+		t.Errorf("toneOutput() = %+v, %v, want %+v", output, err, want)
+	}
+}
+
+// fakeInstances are snapclient instances by the soundcard they play to
+type fakeInstances struct {
+	playing map[string][]string
+	failing map[string]bool // Instances that fail to stop
+	calls   []string
+}
+
+func (f *fakeInstances) InstancesPlayingTo(soundcard string) ([]string, error) {
+	if soundcard == "broken" {
+		return nil, errors.New("cannot list instances")
+	}
+	return f.playing[soundcard], nil
+}
+
+func (f *fakeInstances) StopService(instance string) error {
+	f.calls = append(f.calls, "stop "+instance)
+	if f.failing[instance] {
+		return errors.New("stop failed")
+	}
+	return nil
+}
+
+func (f *fakeInstances) StartService(instance string) error {
+	f.calls = append(f.calls, "start "+instance)
+	return nil
+}
+
+func TestPauseAndResumeInstances(t *testing.T) {
+	instances := &fakeInstances{
+		playing: map[string][]string{
+			"bluealsa": {"", "kitchen"},
+			"default":  {"garden"},
+		},
+		failing: map[string]bool{"garden": true},
+	}
+
+	paused := pauseInstances(instances, []string{"bluealsa", "broken", "default"})
+	if !reflect.DeepEqual(paused, []string{"", "kitchen"}) {
+		t.Errorf("paused %q, want the instances that stopped", paused)
+	}
+
+	// Only the instances that were paused are started again
+	resumeInstances(instances, paused)
+	want := []string{"stop ", "stop kitchen", "stop garden", "start ", "start kitchen"}
+	if !reflect.DeepEqual(instances.calls, want) {
+		t.Errorf("calls = %q, want %q", instances.calls, want)
+	}
+}
@@ -0,0 +1,67 @@
+package web
+
+import (
+	"context"
+	"time"
+)
+
+// volumeControl reads and sets a volume in percent, of a soundcard or of a
+// Bluetooth device
+type volumeControl struct {
+	get func() (int, error)
+	set func(volume int) error
+}
+
+// alsaVolume controls the ALSA volume of a soundcard
+func (s *Server) alsaVolume(soundcard string) volumeControl {
+	return volumeControl{
+		get: func() (int, error) { return s.snapclientMgr.GetAlsaVolume(soundcard) },
+		set: func(volume int) error { return s.snapclientMgr.SetAlsaVolume(soundcard, volume) },
+	}
+}
+
+// bluetoothVolume controls the absolute volume of a Bluetooth device
+func (s *Server) bluetoothVolume(address string) volumeControl {
+	return volumeControl{
+		get: func() (int, error) { return s.adapter.Volume(address) },
+		set: func(volume int) error { return s.adapter.SetVolume(address, volume) },
+	}
+}
+
+// rampVolume moves a volume to target in steps over duration, or at once
+// when duration is 0
+func rampVolume(ctx context.Context, control volumeControl, target int, duration time.Duration) error {
+	start, err := control.get()
+	if err != nil || duration <= 0 || start == target {
+		return control.set(target)
+	}
+
+	// One step per second, or per percent when that is slower
+	steps := int(duration / time.Second)
+	if distance := abs(target - start); steps > distance {
+		steps = distance
+	}
+	if steps < 1 {
+		steps = 1
+	}
+	interval := duration / time.Duration(steps)
+
+	for i := 1; i <= steps; i++ {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+		if err := control.set(start + (target-start)*i/steps); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -64,8 +64,25 @@ if systemctl is-enabled bluepicast.service &>/dev/null; then
     echo -e "  Disabled bluepicast service"
 fi
 
+# Use the data directory the service was configured with (--data-dir)
+DATA_DIR="/var/lib/bluepicast"
+if [ -f /etc/systemd/system/bluepicast.service ]; then
+    CONFIGURED_DATA_DIR=$(grep '^ExecStart=' /etc/systemd/system/bluepicast.service | \
+        sed -nE 's/.*--?data-dir[= ]+("([^"]*)"|([^ ]+)).*/\2\3/p')
+    if [ -n "$CONFIGURED_DATA_DIR" ]; then
+        DATA_DIR="$CONFIGURED_DATA_DIR"
+    fi
+fi
+
 rm -f /etc/systemd/system/bluepicast.service
 rm -f /usr/local/bin/bluepicast
+# Never remove a top-level directory given by mistake
+if [ -n "$DATA_DIR" ] && [ "$DATA_DIR" != "/" ] && [ "$(dirname "$DATA_DIR")" != "/" ]; then
+    rm -rf "$DATA_DIR"
+    echo -e "  Removed data directory ${DATA_DIR}"
+else
+    echo -e "${YELLOW}  Not removing data directory ${DATA_DIR}${NC}"
+fi
 echo -e "${GREEN}BluePiCast removed${NC}"
 
 # ============================================================================